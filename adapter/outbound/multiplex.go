@@ -0,0 +1,93 @@
+package outbound
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/Dreamacro/clash/component/dialer"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/transport/mux"
+)
+
+type MultiplexOption struct {
+	Enabled        bool   `proxy:"enabled,omitempty"`
+	Protocol       string `proxy:"protocol,omitempty"`
+	MaxConnections int    `proxy:"max-connections,omitempty"`
+	MinStreams     int    `proxy:"min-streams,omitempty"`
+	MaxStreams     int    `proxy:"max-streams,omitempty"`
+	Padding        bool   `proxy:"padding,omitempty"`
+}
+
+// Multiplex opens the TCP connections of a proxy as streams over a pool of
+// multiplexed connections, UDP goes through the proxy as it is
+type Multiplex struct {
+	C.ProxyAdapter
+	client *mux.Client
+}
+
+// DialContext implements C.ProxyAdapter
+func (m *Multiplex) DialContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (C.Conn, error) {
+	// the pooled connections are dialed without the options, a connection
+	// dialed with them carries only this stream
+	if len(opts) != 0 {
+		c, err := m.ProxyAdapter.DialContext(ctx, muxMetadata(), opts...)
+		if err != nil {
+			return nil, err
+		}
+		stream, err := m.client.StreamConn(c, serializesSocksAddr(metadata))
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("%s multiplex connect error: %w", m.Addr(), err)
+		}
+		return NewConn(stream, m), nil
+	}
+
+	c, err := m.client.DialContext(ctx, serializesSocksAddr(metadata))
+	if err != nil {
+		return nil, fmt.Errorf("%s multiplex connect error: %w", m.Addr(), err)
+	}
+	return NewConn(c, m), nil
+}
+
+// StreamConn implements C.ProxyAdapter
+func (m *Multiplex) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
+	c, err := m.ProxyAdapter.StreamConn(c, muxMetadata())
+	if err != nil {
+		return c, err
+	}
+	return m.client.StreamConn(c, serializesSocksAddr(metadata))
+}
+
+// muxMetadata is the destination of the multiplexed connections
+func muxMetadata() *C.Metadata {
+	return &C.Metadata{
+		NetWork: C.TCP,
+		Host:    mux.DestinationHost,
+		DstPort: mux.DestinationPort,
+	}
+}
+
+func NewMultiplex(option MultiplexOption, proxy C.ProxyAdapter) (*Multiplex, error) {
+	protocol, err := mux.ParseProtocol(option.Protocol)
+	if err != nil {
+		return nil, fmt.Errorf("%s multiplex initialize error: %w", proxy.Name(), err)
+	}
+
+	dial := func(ctx context.Context) (net.Conn, error) {
+		return proxy.DialContext(ctx, muxMetadata())
+	}
+
+	client, err := mux.NewClient(dial, mux.Options{
+		Protocol:       protocol,
+		MaxConnections: option.MaxConnections,
+		MinStreams:     option.MinStreams,
+		MaxStreams:     option.MaxStreams,
+		Padding:        option.Padding,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s multiplex initialize error: %w", proxy.Name(), err)
+	}
+
+	return &Multiplex{ProxyAdapter: proxy, client: client}, nil
+}
@@ -0,0 +1,74 @@
+package outbound
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/component/dialer"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/transport/mux"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// plainAdapter is a proxy protocol that doesn't wrap the connection
+type plainAdapter struct {
+	*Base
+}
+
+func (p *plainAdapter) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
+	return c, nil
+}
+
+func (p *plainAdapter) DialContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (C.Conn, error) {
+	c, err := dialer.DialContext(ctx, "tcp", p.addr, p.Base.DialOptions(opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(c, p), nil
+}
+
+// readMuxRequest accepts a connection and returns the first bytes of it, the
+// connection is kept open until the test ends
+func readMuxRequest(t *testing.T, l net.Listener) []byte {
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	request := make([]byte, 2)
+	_, err = io.ReadFull(conn, request)
+	require.NoError(t, err)
+	return request
+}
+
+func TestMultiplex_Streams(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	proxy, err := NewMultiplex(MultiplexOption{Enabled: true}, &plainAdapter{&Base{name: "plain", addr: l.Addr().String()}})
+	require.NoError(t, err)
+	metadata := &C.Metadata{NetWork: C.TCP, Host: "example.com", DstPort: 80}
+	expected := []byte{0, byte(mux.ProtocolSmux)}
+
+	// a relay wraps the connection of the previous proxy
+	c, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	conn, err := proxy.StreamConn(c, metadata)
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, expected, readMuxRequest(t, l))
+
+	// the connection dialed with the options is multiplexed as well
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dialed, err := proxy.DialContext(ctx, metadata, dialer.WithRoutingMark(0))
+	require.NoError(t, err)
+	defer dialed.Close()
+	assert.Equal(t, expected, readMuxRequest(t, l))
+}
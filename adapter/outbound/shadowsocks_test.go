@@ -0,0 +1,172 @@
+package outbound
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/sagernet/sing-shadowsocks/shadowaead_2022"
+	"github.com/sagernet/sing/common/buf"
+	"github.com/sagernet/sing/common/bufio"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ss2022Handler echoes the TCP and UDP payload
+type ss2022Handler struct{}
+
+func (ss2022Handler) NewConnection(ctx context.Context, conn net.Conn, metadata M.Metadata) error {
+	defer conn.Close()
+	_, err := io.Copy(conn, conn)
+	return err
+}
+
+func (ss2022Handler) NewPacketConnection(ctx context.Context, conn N.PacketConn, metadata M.Metadata) error {
+	for {
+		buffer := buf.NewPacket()
+		destination, err := conn.ReadPacket(buffer)
+		if err != nil {
+			buffer.Release()
+			return err
+		}
+
+		// the response header is written in front of the payload and the tag after it
+		response := buf.NewSize(1024 + buffer.Len() + 64)
+		response.Resize(1024, 0)
+		response.Write(buffer.Bytes())
+		buffer.Release()
+		if err := conn.WritePacket(response, destination); err != nil {
+			return err
+		}
+	}
+}
+
+func (ss2022Handler) NewError(ctx context.Context, err error) {}
+
+// ss2022Service is the service of shadowaead_2022
+type ss2022Service interface {
+	NewConnection(ctx context.Context, conn net.Conn, metadata M.Metadata) error
+	NewPacket(ctx context.Context, conn N.PacketConn, buffer *buf.Buffer, metadata M.Metadata) error
+}
+
+// startSS2022Server serves service on a TCP and a UDP port of the same number
+func startSS2022Server(t *testing.T, service ss2022Service) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go service.NewConnection(context.Background(), c, M.Metadata{Source: M.SocksaddrFromNet(c.RemoteAddr())})
+		}
+	}()
+
+	pc, err := net.ListenPacket("udp", l.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		conn := bufio.NewPacketConn(pc)
+		for {
+			buffer := buf.NewPacket()
+			source, err := conn.ReadPacket(buffer)
+			if err != nil {
+				buffer.Release()
+				return
+			}
+			service.NewPacket(context.Background(), conn, buffer, M.Metadata{Source: source})
+		}
+	}()
+
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func randomPSK(t *testing.T, size int) []byte {
+	psk := make([]byte, size)
+	_, err := rand.Read(psk)
+	require.NoError(t, err)
+	return psk
+}
+
+// TestShadowSocks_2022 talks to the server of sing-shadowsocks
+func TestShadowSocks_2022(t *testing.T) {
+	for _, tc := range []struct {
+		cipher string
+		server func(t *testing.T) (service ss2022Service, password string)
+	}{
+		{"2022-blake3-aes-128-gcm", func(t *testing.T) (ss2022Service, string) {
+			iPSK, uPSK := randomPSK(t, 16), randomPSK(t, 16)
+			service, err := shadowaead_2022.NewMultiService[int]("2022-blake3-aes-128-gcm", iPSK, 300, ss2022Handler{}, time.Now)
+			require.NoError(t, err)
+			require.NoError(t, service.UpdateUsers([]int{1}, [][]byte{uPSK}))
+			return service, base64.StdEncoding.EncodeToString(iPSK) + ":" + base64.StdEncoding.EncodeToString(uPSK)
+		}},
+		{"2022-blake3-aes-256-gcm", func(t *testing.T) (ss2022Service, string) {
+			psk := randomPSK(t, 32)
+			service, err := shadowaead_2022.NewService("2022-blake3-aes-256-gcm", psk, 300, ss2022Handler{}, time.Now)
+			require.NoError(t, err)
+			return service, base64.StdEncoding.EncodeToString(psk)
+		}},
+		{"2022-blake3-chacha20-poly1305", func(t *testing.T) (ss2022Service, string) {
+			psk := randomPSK(t, 32)
+			service, err := shadowaead_2022.NewService("2022-blake3-chacha20-poly1305", psk, 300, ss2022Handler{}, time.Now)
+			require.NoError(t, err)
+			return service, base64.StdEncoding.EncodeToString(psk)
+		}},
+	} {
+		t.Run(tc.cipher, func(t *testing.T) {
+			service, password := tc.server(t)
+			port := startSS2022Server(t, service)
+
+			proxy, err := NewShadowSocks(ShadowSocksOption{
+				Name:     "ss",
+				Server:   "127.0.0.1",
+				Port:     port,
+				Password: password,
+				Cipher:   tc.cipher,
+				UDP:      true,
+			})
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			conn, err := proxy.DialContext(ctx, &C.Metadata{NetWork: C.TCP, Host: "example.com", DstPort: 80})
+			require.NoError(t, err)
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			_, err = conn.Write([]byte("hello"))
+			require.NoError(t, err)
+			buf := make([]byte, 1024)
+			_, err = io.ReadFull(conn, buf[:5])
+			require.NoError(t, err)
+			assert.Equal(t, "hello", string(buf[:5]))
+
+			metadata := &C.Metadata{NetWork: C.UDP, DstIP: net.ParseIP("1.2.3.4"), DstPort: 53}
+			pc, err := proxy.ListenPacketContext(ctx, metadata)
+			require.NoError(t, err)
+			defer pc.Close()
+			pc.SetDeadline(time.Now().Add(5 * time.Second))
+
+			for _, payload := range []string{"world", "again"} {
+				_, err = pc.WriteTo([]byte(payload), metadata.UDPAddr())
+				require.NoError(t, err)
+				n, addr, err := pc.ReadFrom(buf)
+				require.NoError(t, err)
+				assert.Equal(t, payload, string(buf[:n]))
+				assert.Equal(t, "1.2.3.4:53", addr.String())
+			}
+		})
+	}
+}
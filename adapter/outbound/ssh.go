@@ -0,0 +1,315 @@
+package outbound
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/component/dialer"
+	C "github.com/Dreamacro/clash/constant"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/sync/singleflight"
+)
+
+// for auto gc
+type Ssh struct {
+	*sshProxy
+}
+
+type sshProxy struct {
+	*Base
+	config *ssh.ClientConfig
+
+	// client is shared by the connections dialed without options, it's reset once it fails
+	lock   sync.Mutex
+	client *ssh.Client
+	closed bool
+	// group connects the shared client outside the lock, once for the waiting dials
+	group singleflight.Group
+}
+
+type SshOption struct {
+	BasicOption
+	Name     string `proxy:"name"`
+	Server   string `proxy:"server"`
+	Port     int    `proxy:"port"`
+	UserName string `proxy:"username"`
+	Password string `proxy:"password,omitempty"`
+	// PrivateKey is the PEM encoded key or the path of it
+	PrivateKey           string   `proxy:"private-key,omitempty"`
+	PrivateKeyPassphrase string   `proxy:"private-key-passphrase,omitempty"`
+	HostKey              []string `proxy:"host-key,omitempty"`
+	HostKeyAlgorithms    []string `proxy:"host-key-algorithms,omitempty"`
+	// SkipHostKeyVerify trusts any host key if HostKey is empty, which allows a man-in-the-middle
+	SkipHostKeyVerify bool `proxy:"skip-host-key-verify,omitempty"`
+}
+
+// StreamConn implements C.ProxyAdapter, the SSH connection over c is closed with the returned conn
+func (s *Ssh) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), C.DefaultTLSTimeout)
+	defer cancel()
+
+	client, err := s.handshake(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("%s connect error: %w", s.addr, err)
+	}
+
+	conn, err := client.DialContext(ctx, "tcp", metadata.RemoteAddress())
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &sshClientConn{Conn: conn, client: client}, nil
+}
+
+// DialContext implements C.ProxyAdapter
+func (s *Ssh) DialContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (_ C.Conn, err error) {
+	// the shared client is dialed without the options
+	if len(opts) != 0 {
+		c, err := dialer.DialContext(ctx, "tcp", s.addr, s.Base.DialOptions(opts...)...)
+		if err != nil {
+			return nil, fmt.Errorf("%s connect error: %w", s.addr, err)
+		}
+		tcpKeepAlive(c)
+
+		defer func(c net.Conn) {
+			safeConnClose(c, err)
+		}(c)
+
+		c, err = s.StreamConn(c, metadata)
+		if err != nil {
+			return nil, err
+		}
+		return NewConn(c, s), nil
+	}
+
+	for retried := false; ; retried = true {
+		client, err := s.sharedClient(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%s connect error: %w", s.addr, err)
+		}
+
+		c, err := client.DialContext(ctx, "tcp", metadata.RemoteAddress())
+		if err == nil {
+			return NewConn(&sshConn{Conn: c, ssh: s}, s), nil
+		}
+
+		// the server refused the destination, otherwise the connection may be broken
+		var openErr *ssh.OpenChannelError
+		if errors.As(err, &openErr) || retried || ctx.Err() != nil {
+			return nil, err
+		}
+		s.resetClient(client)
+	}
+}
+
+// sharedClient returns the shared client, a new one is connected if there is none
+func (s *sshProxy) sharedClient(ctx context.Context) (*ssh.Client, error) {
+	s.lock.Lock()
+	client := s.client
+	s.lock.Unlock()
+	if client != nil {
+		return client, nil
+	}
+
+	ch := s.group.DoChan("client", func() (any, error) {
+		// the connection is shared by the waiting dials, so it isn't canceled with any of them
+		ctx, cancel := context.WithTimeout(context.Background(), C.DefaultTCPTimeout)
+		defer cancel()
+		return s.connectClient(ctx)
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result := <-ch:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.(*ssh.Client), nil
+	}
+}
+
+// connectClient connects a new shared client, the handshake is done without the lock
+func (s *sshProxy) connectClient(ctx context.Context) (*ssh.Client, error) {
+	c, err := dialer.DialContext(ctx, "tcp", s.addr, s.Base.DialOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	tcpKeepAlive(c)
+
+	client, err := s.handshake(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		client.Close()
+		return nil, net.ErrClosed
+	}
+	s.client = client
+
+	go func() {
+		client.Wait()
+		s.resetClient(client)
+	}()
+	return client, nil
+}
+
+func (s *sshProxy) resetClient(client *ssh.Client) {
+	s.lock.Lock()
+	if s.client == client {
+		s.client = nil
+	}
+	s.lock.Unlock()
+
+	client.Close()
+}
+
+// handshake starts a SSH connection over c, c is closed if it fails
+func (s *sshProxy) handshake(ctx context.Context, c net.Conn) (*ssh.Client, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+		defer c.SetDeadline(time.Time{})
+	}
+
+	conn, chans, reqs, err := ssh.NewClientConn(c, s.addr, s.config)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return ssh.NewClient(conn, chans, reqs), nil
+}
+
+// sshConn keeps the shared client open while the connection is in use
+type sshConn struct {
+	net.Conn
+	ssh *Ssh
+}
+
+// sshClientConn closes its own client with the connection
+type sshClientConn struct {
+	net.Conn
+	client *ssh.Client
+}
+
+func (c *sshClientConn) Close() error {
+	err := c.Conn.Close()
+	c.client.Close()
+	return err
+}
+
+func closeSsh(s *Ssh) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.client != nil {
+		s.client.Close()
+	}
+	s.closed = true
+}
+
+func parseSshSigner(key, passphrase string) (ssh.Signer, error) {
+	pemBytes := []byte(key)
+	if !strings.Contains(key, "PRIVATE KEY") {
+		var err error
+		if pemBytes, err = os.ReadFile(C.Path.Resolve(key)); err != nil {
+			return nil, err
+		}
+	}
+
+	if passphrase != "" {
+		return ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(passphrase))
+	}
+	return ssh.ParsePrivateKey(pemBytes)
+}
+
+// hostKeyAlgorithms returns the algorithms of the pinned keys, so that the server
+// presents one of them
+func hostKeyAlgorithms(keys []ssh.PublicKey) []string {
+	var algorithms []string
+	for _, key := range keys {
+		if key.Type() == ssh.KeyAlgoRSA {
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		algorithms = append(algorithms, key.Type())
+	}
+	return algorithms
+}
+
+func NewSsh(option SshOption) (*Ssh, error) {
+	addr := net.JoinHostPort(option.Server, strconv.Itoa(option.Port))
+
+	config := &ssh.ClientConfig{
+		User:              option.UserName,
+		HostKeyAlgorithms: option.HostKeyAlgorithms,
+	}
+
+	if option.Password != "" {
+		config.Auth = append(config.Auth, ssh.Password(option.Password))
+	}
+	if option.PrivateKey != "" {
+		signer, err := parseSshSigner(option.PrivateKey, option.PrivateKeyPassphrase)
+		if err != nil {
+			return nil, fmt.Errorf("ssh %s initialize error: invalid private-key: %w", addr, err)
+		}
+		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
+	}
+	if len(config.Auth) == 0 {
+		return nil, fmt.Errorf("ssh %s initialize error: missing password or private-key", addr)
+	}
+
+	switch {
+	case len(option.HostKey) == 0 && option.SkipHostKeyVerify:
+		config.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	case len(option.HostKey) == 0:
+		return nil, fmt.Errorf("ssh %s initialize error: missing host-key, set skip-host-key-verify to trust any host key", addr)
+	default:
+		var keys []ssh.PublicKey
+		for _, hostKey := range option.HostKey {
+			key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey))
+			if err != nil {
+				return nil, fmt.Errorf("ssh %s initialize error: invalid host-key: %w", addr, err)
+			}
+			keys = append(keys, key)
+		}
+
+		config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			for _, pinned := range keys {
+				if bytes.Equal(key.Marshal(), pinned.Marshal()) {
+					return nil
+				}
+			}
+			return fmt.Errorf("host key mismatch: %s", ssh.FingerprintSHA256(key))
+		}
+		if len(config.HostKeyAlgorithms) == 0 {
+			config.HostKeyAlgorithms = hostKeyAlgorithms(keys)
+		}
+	}
+
+	s := &sshProxy{
+		Base: &Base{
+			name:  option.Name,
+			addr:  addr,
+			tp:    C.Ssh,
+			iface: option.Interface,
+			rmark: option.RoutingMark,
+		},
+		config: config,
+	}
+
+	wrapper := &Ssh{s}
+	runtime.SetFinalizer(wrapper, closeSsh)
+	return wrapper, nil
+}
@@ -46,9 +46,11 @@ func (t *Trojan) plainStream(c net.Conn) (net.Conn, error) {
 	if t.option.Network == "ws" {
 		host, port, _ := net.SplitHostPort(t.addr)
 		wsOpts := &trojan.WebsocketOption{
-			Host: host,
-			Port: port,
-			Path: t.option.WSOpts.Path,
+			Host:                host,
+			Port:                port,
+			Path:                t.option.WSOpts.Path,
+			MaxEarlyData:        t.option.WSOpts.MaxEarlyData,
+			EarlyDataHeaderName: t.option.WSOpts.EarlyDataHeaderName,
 		}
 
 		if t.option.SNI != "" {
@@ -69,8 +71,8 @@ func (t *Trojan) plainStream(c net.Conn) (net.Conn, error) {
 	return t.instance.StreamConn(c)
 }
 
-// StreamConn implements C.ProxyAdapter
-func (t *Trojan) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
+// transportStream wraps c with the transport of the network, before the trojan header is written
+func (t *Trojan) transportStream(c net.Conn) (net.Conn, error) {
 	var err error
 	if t.transport != nil {
 		c, err = gun.StreamGunWithConn(c, t.gunTLSConfig, t.gunConfig)
@@ -81,6 +83,15 @@ func (t *Trojan) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error)
 	if err != nil {
 		return nil, fmt.Errorf("%s connect error: %w", t.addr, err)
 	}
+	return c, nil
+}
+
+// StreamConn implements C.ProxyAdapter
+func (t *Trojan) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
+	c, err := t.transportStream(c)
+	if err != nil {
+		return nil, err
+	}
 
 	err = t.instance.WriteHeader(c, trojan.CommandTCP, serializesSocksAddr(metadata))
 	return c, err
@@ -143,9 +154,9 @@ func (t *Trojan) ListenPacketContext(ctx context.Context, metadata *C.Metadata,
 			safeConnClose(c, err)
 		}(c)
 		tcpKeepAlive(c)
-		c, err = t.plainStream(c)
+		c, err = t.transportStream(c)
 		if err != nil {
-			return nil, fmt.Errorf("%s connect error: %w", t.addr, err)
+			return nil, err
 		}
 	}
 
@@ -161,6 +172,12 @@ func (t *Trojan) ListenPacketContext(ctx context.Context, metadata *C.Metadata,
 func NewTrojan(option TrojanOption) (*Trojan, error) {
 	addr := net.JoinHostPort(option.Server, strconv.Itoa(option.Port))
 
+	switch option.Network {
+	case "", "tcp", "ws", "grpc":
+	default:
+		return nil, fmt.Errorf("trojan %s initialize error: unsupported network %s", addr, option.Network)
+	}
+
 	tOption := &trojan.Option{
 		Password:       option.Password,
 		ALPN:           option.ALPN,
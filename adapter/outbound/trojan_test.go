@@ -0,0 +1,226 @@
+package outbound
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/component/dialer"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/transport/socks5"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveTrojan echoes the payload of a trojan session, the packets of a UDP
+// session are written back as they are
+func serveTrojan(r io.Reader, w io.Writer) error {
+	br := bufio.NewReader(r)
+	// hex(sha224(password)), CRLF and command
+	if _, err := br.Discard(56 + 2); err != nil {
+		return err
+	}
+	command, err := br.ReadByte()
+	if err != nil {
+		return err
+	}
+	if _, err := socks5.ReadAddr(br, make([]byte, socks5.MaxAddrLen)); err != nil {
+		return err
+	}
+	if _, err := br.Discard(2); err != nil {
+		return err
+	}
+
+	if command == 1 {
+		_, err := io.Copy(w, br)
+		return err
+	}
+
+	for {
+		addr, err := socks5.ReadAddr(br, make([]byte, socks5.MaxAddrLen))
+		if err != nil {
+			return err
+		}
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(br, header); err != nil {
+			return err
+		}
+		payload := make([]byte, binary.BigEndian.Uint16(header))
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return err
+		}
+		if _, err := w.Write(bytes.Join([][]byte{addr, header, payload}, nil)); err != nil {
+			return err
+		}
+	}
+}
+
+type wsReader struct {
+	conn   *websocket.Conn
+	reader io.Reader
+}
+
+func (r *wsReader) Read(b []byte) (int, error) {
+	for {
+		if r.reader != nil {
+			n, err := r.reader.Read(b)
+			if err != io.EOF {
+				return n, err
+			}
+		}
+		_, reader, err := r.conn.NextReader()
+		if err != nil {
+			return 0, err
+		}
+		r.reader = reader
+	}
+}
+
+type wsWriter struct{ conn *websocket.Conn }
+
+func (w wsWriter) Write(b []byte) (int, error) {
+	return len(b), w.conn.WriteMessage(websocket.BinaryMessage, b)
+}
+
+type gunReader struct {
+	br     *bufio.Reader
+	remain int
+}
+
+func (r *gunReader) Read(b []byte) (int, error) {
+	if r.remain == 0 {
+		// grpc header and protobuf tag
+		if _, err := r.br.Discard(6); err != nil {
+			return 0, err
+		}
+		length, err := binary.ReadUvarint(r.br)
+		if err != nil {
+			return 0, err
+		}
+		r.remain = int(length)
+	}
+
+	n, err := r.br.Read(b[:min(len(b), r.remain)])
+	r.remain -= n
+	return n, err
+}
+
+type gunWriter struct {
+	w http.ResponseWriter
+}
+
+func (w gunWriter) Write(b []byte) (int, error) {
+	message := binary.AppendUvarint([]byte{0x0A}, uint64(len(b)))
+	message = append(message, b...)
+	frame := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(message)))
+	if _, err := w.w.Write(append(frame, message...)); err != nil {
+		return 0, err
+	}
+	w.w.(http.Flusher).Flush()
+	return len(b), nil
+}
+
+// startTrojanServer serves trojan over websocket at /ws and over gun with the service name "Trojan"
+func startTrojanServer(t *testing.T) (string, int) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		var earlyData []byte
+		if protocol := r.Header.Get("Sec-WebSocket-Protocol"); protocol != "" {
+			earlyData, _ = base64.RawURLEncoding.DecodeString(protocol)
+		}
+
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serveTrojan(io.MultiReader(bytes.NewReader(earlyData), &wsReader{conn: conn}), wsWriter{conn})
+	})
+	mux.HandleFunc("/Trojan/Tun", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		serveTrojan(&gunReader{br: bufio.NewReader(r.Body)}, gunWriter{w})
+	})
+
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	return host, portNum
+}
+
+func TestTrojan_Transports(t *testing.T) {
+	host, port := startTrojanServer(t)
+	metadata := &C.Metadata{NetWork: C.TCP, Host: "example.com", DstPort: 443}
+
+	for _, option := range []TrojanOption{
+		{Network: "ws", WSOpts: WSOptions{Path: "/ws"}},
+		{Network: "ws", WSOpts: WSOptions{Path: "/ws", MaxEarlyData: 2048, EarlyDataHeaderName: "Sec-WebSocket-Protocol"}},
+		{Network: "ws", WSOpts: WSOptions{Path: "/ws?ed=2048"}},
+		{Network: "grpc", GrpcOpts: GrpcOptions{GrpcServiceName: "Trojan"}},
+	} {
+		option.Name = "trojan"
+		option.Server = host
+		option.Port = port
+		option.Password = "password"
+		option.SkipCertVerify = true
+		option.UDP = true
+
+		t.Run(option.Network+option.WSOpts.Path, func(t *testing.T) {
+			proxy, err := NewTrojan(option)
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			conn, err := proxy.DialContext(ctx, metadata)
+			require.NoError(t, err)
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			_, err = conn.Write([]byte("hello"))
+			require.NoError(t, err)
+			buf := make([]byte, 5)
+			_, err = io.ReadFull(conn, buf)
+			require.NoError(t, err)
+			assert.Equal(t, "hello", string(buf))
+
+			// with dialer options the session goes through a new connection instead of the shared one
+			for _, opts := range [][]dialer.Option{nil, {dialer.WithAddrReuse(false)}} {
+				pc, err := proxy.ListenPacketContext(ctx, &C.Metadata{NetWork: C.UDP, DstIP: net.IPv4(1, 1, 1, 1), DstPort: 53}, opts...)
+				require.NoError(t, err)
+				defer pc.Close()
+				pc.SetDeadline(time.Now().Add(5 * time.Second))
+
+				target := &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 53}
+				_, err = pc.WriteTo([]byte("world"), target)
+				require.NoError(t, err)
+				buf := make([]byte, 1024)
+				n, addr, err := pc.ReadFrom(buf)
+				require.NoError(t, err)
+				assert.Equal(t, "world", string(buf[:n]))
+				assert.Equal(t, target.String(), addr.String())
+			}
+		})
+	}
+}
+
+func TestNewTrojan_UnsupportedNetwork(t *testing.T) {
+	_, err := NewTrojan(TrojanOption{Name: "trojan", Server: "127.0.0.1", Port: 443, Network: "h2"})
+	assert.Error(t, err)
+}
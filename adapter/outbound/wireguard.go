@@ -0,0 +1,388 @@
+package outbound
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Dreamacro/clash/component/dialer"
+	"github.com/Dreamacro/clash/component/resolver"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+	"github.com/Dreamacro/clash/transport/wireguard"
+
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+const defaultWireGuardMTU = 1408
+
+// for auto gc
+type WireGuard struct {
+	*wireGuard
+}
+
+type wireGuard struct {
+	*Base
+	option  *WireGuardOption
+	localV4 netip.Addr
+	localV6 netip.Addr
+	peers   []wireGuardPeer
+
+	// the device is created on the first use, since the endpoints of the peers may need to be resolved
+	lock   sync.Mutex
+	device *device.Device
+	tnet   *netstack.Net
+}
+
+type WireGuardOption struct {
+	BasicOption
+	Name string `proxy:"name"`
+	// Server, Port, PublicKey, PreSharedKey and Reserved are the peer if Peers is empty
+	Server              string                `proxy:"server,omitempty"`
+	Port                int                   `proxy:"port,omitempty"`
+	IP                  string                `proxy:"ip,omitempty"`
+	IPv6                string                `proxy:"ipv6,omitempty"`
+	PrivateKey          string                `proxy:"private-key"`
+	PublicKey           string                `proxy:"public-key,omitempty"`
+	PreSharedKey        string                `proxy:"pre-shared-key,omitempty"`
+	Reserved            []int                 `proxy:"reserved,omitempty"`
+	MTU                 int                   `proxy:"mtu,omitempty"`
+	PersistentKeepalive int                   `proxy:"persistent-keepalive,omitempty"`
+	UDP                 bool                  `proxy:"udp,omitempty"`
+	Peers               []WireGuardPeerOption `proxy:"peers,omitempty"`
+}
+
+type WireGuardPeerOption struct {
+	Server       string   `proxy:"server"`
+	Port         int      `proxy:"port"`
+	PublicKey    string   `proxy:"public-key"`
+	PreSharedKey string   `proxy:"pre-shared-key,omitempty"`
+	Reserved     []int    `proxy:"reserved,omitempty"`
+	AllowedIPs   []string `proxy:"allowed-ips"`
+}
+
+type wireGuardPeer struct {
+	server       string
+	port         uint16
+	publicKey    string
+	preSharedKey string
+	reserved     *[3]byte
+	allowedIPs   []netip.Prefix
+}
+
+// DialContext implements C.ProxyAdapter, the options don't apply to the connections
+// inside the tunnel
+func (w *WireGuard) DialContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (C.Conn, error) {
+	tnet, err := w.init()
+	if err != nil {
+		return nil, err
+	}
+
+	ip, err := w.resolve(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := tnet.DialContextTCPAddrPort(ctx, netip.AddrPortFrom(ip, uint16(metadata.DstPort)))
+	if err != nil {
+		return nil, fmt.Errorf("%s connect error: %w", w.addr, err)
+	}
+	return NewConn(&wireGuardConn{Conn: c, wg: w}, w), nil
+}
+
+// ListenPacketContext implements C.ProxyAdapter, the packet conn only sends to the
+// address family of metadata
+func (w *WireGuard) ListenPacketContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (C.PacketConn, error) {
+	tnet, err := w.init()
+	if err != nil {
+		return nil, err
+	}
+
+	ip, err := w.resolve(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	local := w.localV4
+	if ip.Is6() {
+		local = w.localV6
+	}
+	pc, err := tnet.ListenUDPAddrPort(netip.AddrPortFrom(local, 0))
+	if err != nil {
+		return nil, fmt.Errorf("%s listen error: %w", w.addr, err)
+	}
+	return newPacketConn(&wireGuardPacketConn{PacketConn: pc, wg: w}, w), nil
+}
+
+// resolve returns the destination IP of metadata in the address families of the interface
+func (w *wireGuard) resolve(metadata *C.Metadata) (netip.Addr, error) {
+	var (
+		ip  net.IP
+		err error
+	)
+	switch {
+	case metadata.DstIP != nil:
+		ip = metadata.DstIP
+	case !w.localV6.IsValid():
+		ip, err = resolver.ResolveIPv4(metadata.Host)
+	case !w.localV4.IsValid():
+		ip, err = resolver.ResolveIPv6(metadata.Host)
+	default:
+		ip, err = resolver.ResolveIP(metadata.Host)
+	}
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("%s resolve error: %w", metadata.Host, err)
+	}
+
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Addr{}, fmt.Errorf("invalid destination ip: %s", ip)
+	}
+	addr = addr.Unmap()
+	if addr.Is4() && !w.localV4.IsValid() || addr.Is6() && !w.localV6.IsValid() {
+		return netip.Addr{}, fmt.Errorf("%s has no address of the family of %s", w.name, addr)
+	}
+	return addr, nil
+}
+
+// init creates and starts the device, it's retried on the next use if it fails
+func (w *wireGuard) init() (*netstack.Net, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.tnet != nil {
+		return w.tnet, nil
+	}
+
+	var local []netip.Addr
+	for _, addr := range []netip.Addr{w.localV4, w.localV6} {
+		if addr.IsValid() {
+			local = append(local, addr)
+		}
+	}
+
+	uapi := &strings.Builder{}
+	fmt.Fprintf(uapi, "private_key=%s\n", w.option.PrivateKey)
+	reserved := map[netip.AddrPort][3]byte{}
+	for _, peer := range w.peers {
+		ip, err := resolver.ResolveIP(peer.server)
+		if err != nil {
+			return nil, fmt.Errorf("%s resolve error: %w", peer.server, err)
+		}
+		addr, _ := netip.AddrFromSlice(ip)
+		endpoint := netip.AddrPortFrom(addr.Unmap(), peer.port)
+		if peer.reserved != nil {
+			reserved[endpoint] = *peer.reserved
+		}
+
+		fmt.Fprintf(uapi, "public_key=%s\n", peer.publicKey)
+		if peer.preSharedKey != "" {
+			fmt.Fprintf(uapi, "preshared_key=%s\n", peer.preSharedKey)
+		}
+		fmt.Fprintf(uapi, "endpoint=%s\n", endpoint)
+		if w.option.PersistentKeepalive != 0 {
+			fmt.Fprintf(uapi, "persistent_keepalive_interval=%d\n", w.option.PersistentKeepalive)
+		}
+		for _, prefix := range peer.allowedIPs {
+			fmt.Fprintf(uapi, "allowed_ip=%s\n", prefix)
+		}
+	}
+
+	mtu := w.option.MTU
+	if mtu == 0 {
+		mtu = defaultWireGuardMTU
+	}
+	tunDevice, tnet, err := netstack.CreateNetTUN(local, nil, mtu)
+	if err != nil {
+		return nil, fmt.Errorf("%s create netstack error: %w", w.name, err)
+	}
+
+	listen := func(port uint16) (net.PacketConn, error) {
+		return dialer.ListenPacket(context.Background(), "udp", ":"+strconv.Itoa(int(port)), w.Base.DialOptions()...)
+	}
+	logger := &device.Logger{
+		Verbosef: func(format string, args ...any) {
+			log.Debugln("[WireGuard] %s: "+format, append([]any{w.name}, args...)...)
+		},
+		Errorf: func(format string, args ...any) {
+			log.Warnln("[WireGuard] %s: "+format, append([]any{w.name}, args...)...)
+		},
+	}
+	dev := device.NewDevice(tunDevice, wireguard.NewBind(listen, reserved), logger)
+	if err := dev.IpcSet(uapi.String()); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("%s configure error: %w", w.name, err)
+	}
+	if err := dev.Up(); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("%s start error: %w", w.name, err)
+	}
+
+	w.device, w.tnet = dev, tnet
+	return tnet, nil
+}
+
+// wireGuardConn keeps the device open while the connection is in use
+type wireGuardConn struct {
+	net.Conn
+	wg *WireGuard
+}
+
+type wireGuardPacketConn struct {
+	net.PacketConn
+	wg *WireGuard
+}
+
+// WriteTo implements net.PacketConn, the netstack takes a 16 bytes IP as IPv6
+func (pc *wireGuardPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		if ip := udpAddr.IP.To4(); ip != nil {
+			addr = &net.UDPAddr{IP: ip, Port: udpAddr.Port}
+		}
+	}
+	return pc.PacketConn.WriteTo(b, addr)
+}
+
+func closeWireGuard(w *WireGuard) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.device != nil {
+		w.device.Close()
+	}
+}
+
+// parseWireGuardKey decodes a base64 key into the hex of UAPI
+func parseWireGuardKey(name, key string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s: %w", name, err)
+	}
+	if len(b) != 32 {
+		return "", fmt.Errorf("invalid %s: need 32 bytes", name)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func parseReserved(reserved []int) (*[3]byte, error) {
+	if len(reserved) == 0 {
+		return nil, nil
+	}
+	if len(reserved) != 3 {
+		return nil, errors.New("invalid reserved: need 3 bytes")
+	}
+
+	var b [3]byte
+	for i, v := range reserved {
+		if v < 0 || v > 255 {
+			return nil, fmt.Errorf("invalid reserved: %d", v)
+		}
+		b[i] = byte(v)
+	}
+	return &b, nil
+}
+
+// parseInterfaceAddr parses the address of the interface, the prefix length is ignored
+func parseInterfaceAddr(s string) (netip.Addr, error) {
+	if s == "" {
+		return netip.Addr{}, nil
+	}
+	if prefix, err := netip.ParsePrefix(s); err == nil {
+		return prefix.Addr(), nil
+	}
+	return netip.ParseAddr(s)
+}
+
+func parseWireGuardPeer(option WireGuardPeerOption) (wireGuardPeer, error) {
+	peer := wireGuardPeer{server: option.Server, port: uint16(option.Port)}
+
+	var err error
+	if peer.publicKey, err = parseWireGuardKey("public-key", option.PublicKey); err != nil {
+		return peer, err
+	}
+	if option.PreSharedKey != "" {
+		if peer.preSharedKey, err = parseWireGuardKey("pre-shared-key", option.PreSharedKey); err != nil {
+			return peer, err
+		}
+	}
+	if peer.reserved, err = parseReserved(option.Reserved); err != nil {
+		return peer, err
+	}
+	for _, allowedIP := range option.AllowedIPs {
+		prefix, err := netip.ParsePrefix(allowedIP)
+		if err != nil {
+			return peer, fmt.Errorf("invalid allowed-ips: %w", err)
+		}
+		peer.allowedIPs = append(peer.allowedIPs, prefix)
+	}
+	return peer, nil
+}
+
+func NewWireGuard(option WireGuardOption) (*WireGuard, error) {
+	w := &wireGuard{option: &option}
+
+	var err error
+	if option.PrivateKey, err = parseWireGuardKey("private-key", option.PrivateKey); err != nil {
+		return nil, fmt.Errorf("wireguard %s initialize error: %w", option.Name, err)
+	}
+	if w.localV4, err = parseInterfaceAddr(option.IP); err != nil || w.localV4.IsValid() && !w.localV4.Is4() {
+		return nil, fmt.Errorf("wireguard %s initialize error: invalid ip %s", option.Name, option.IP)
+	}
+	if w.localV6, err = parseInterfaceAddr(option.IPv6); err != nil || w.localV6.IsValid() && !w.localV6.Is6() {
+		return nil, fmt.Errorf("wireguard %s initialize error: invalid ipv6 %s", option.Name, option.IPv6)
+	}
+	if !w.localV4.IsValid() && !w.localV6.IsValid() {
+		return nil, fmt.Errorf("wireguard %s initialize error: missing ip or ipv6", option.Name)
+	}
+
+	peers := option.Peers
+	if len(peers) == 0 {
+		if option.Server == "" {
+			return nil, fmt.Errorf("wireguard %s initialize error: missing server or peers", option.Name)
+		}
+		peers = []WireGuardPeerOption{{
+			Server:       option.Server,
+			Port:         option.Port,
+			PublicKey:    option.PublicKey,
+			PreSharedKey: option.PreSharedKey,
+			Reserved:     option.Reserved,
+			AllowedIPs:   []string{"0.0.0.0/0", "::/0"},
+		}}
+	} else if len(option.Reserved) != 0 {
+		// the reserved bytes of the proxy are the default of the peers
+		for i := range peers {
+			if len(peers[i].Reserved) == 0 {
+				peers[i].Reserved = option.Reserved
+			}
+		}
+	}
+	for _, peerOption := range peers {
+		peer, err := parseWireGuardPeer(peerOption)
+		if err != nil {
+			return nil, fmt.Errorf("wireguard %s initialize error: peer %s: %w", option.Name, peerOption.Server, err)
+		}
+		w.peers = append(w.peers, peer)
+	}
+
+	w.Base = &Base{
+		name:  option.Name,
+		addr:  net.JoinHostPort(peers[0].Server, strconv.Itoa(peers[0].Port)),
+		tp:    C.WireGuard,
+		udp:   option.UDP,
+		iface: option.Interface,
+		rmark: option.RoutingMark,
+	}
+
+	wrapper := &WireGuard{w}
+	runtime.SetFinalizer(wrapper, closeWireGuard)
+	return wrapper, nil
+}
@@ -0,0 +1,206 @@
+package outbound
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/transport/wireguard"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+	"golang.org/x/crypto/curve25519"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+func newWireGuardKey(t *testing.T) (private, public []byte) {
+	private = make([]byte, curve25519.ScalarSize)
+	_, err := rand.Read(private)
+	require.NoError(t, err)
+	private[0] &= 248
+	private[31] = (private[31] & 127) | 64
+
+	public, err = curve25519.X25519(private, curve25519.Basepoint)
+	require.NoError(t, err)
+	return private, public
+}
+
+// reservedPacketConn records the reserved bytes of the messages received
+type reservedPacketConn struct {
+	net.PacketConn
+	reserved *atomic.String
+}
+
+func (pc *reservedPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := pc.PacketConn.ReadFrom(b)
+	if n > 3 {
+		pc.reserved.Store(hex.EncodeToString(b[1:4]))
+	}
+	return n, addr, err
+}
+
+// startWireGuardPeer starts a peer at ip that echoes TCP on port 80 and UDP on port 53
+func startWireGuardPeer(t *testing.T, ip netip.Addr, private, clientPublic, psk []byte) (int, *atomic.String) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	reserved := atomic.NewString("")
+	listen := func(port uint16) (net.PacketConn, error) {
+		return &reservedPacketConn{PacketConn: pc, reserved: reserved}, nil
+	}
+
+	tunDevice, tnet, err := netstack.CreateNetTUN([]netip.Addr{ip}, nil, 1420)
+	require.NoError(t, err)
+	dev := device.NewDevice(tunDevice, wireguard.NewBind(listen, nil), device.NewLogger(device.LogLevelSilent, ""))
+	t.Cleanup(dev.Close)
+	require.NoError(t, dev.IpcSet(fmt.Sprintf(
+		"private_key=%s\npublic_key=%s\npreshared_key=%s\nallowed_ip=10.0.0.2/32\n",
+		hex.EncodeToString(private), hex.EncodeToString(clientPublic), hex.EncodeToString(psk),
+	)))
+	require.NoError(t, dev.Up())
+
+	l, err := tnet.ListenTCPAddrPort(netip.AddrPortFrom(ip, 80))
+	require.NoError(t, err)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+
+	udp, err := tnet.ListenUDPAddrPort(netip.AddrPortFrom(ip, 53))
+	require.NoError(t, err)
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			udp.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	return pc.LocalAddr().(*net.UDPAddr).Port, reserved
+}
+
+func TestWireGuard_Peer(t *testing.T) {
+	serverPrivate, serverPublic := newWireGuardKey(t)
+	otherPrivate, otherPublic := newWireGuardKey(t)
+	clientPrivate, clientPublic := newWireGuardKey(t)
+	psk := make([]byte, 32)
+	rand.Read(psk)
+	port, reserved := startWireGuardPeer(t, netip.MustParseAddr("10.0.0.1"), serverPrivate, clientPublic, psk)
+	otherPort, _ := startWireGuardPeer(t, netip.MustParseAddr("10.0.1.1"), otherPrivate, clientPublic, psk)
+
+	proxy, err := NewWireGuard(WireGuardOption{
+		Name:       "wg",
+		IP:         "10.0.0.2/32",
+		PrivateKey: base64.StdEncoding.EncodeToString(clientPrivate),
+		Reserved:   []int{1, 2, 3},
+		MTU:        1280,
+		UDP:        true,
+		Peers: []WireGuardPeerOption{{
+			Server:       "127.0.0.1",
+			Port:         port,
+			PublicKey:    base64.StdEncoding.EncodeToString(serverPublic),
+			PreSharedKey: base64.StdEncoding.EncodeToString(psk),
+			AllowedIPs:   []string{"10.0.0.0/24"},
+		}, {
+			Server:       "127.0.0.1",
+			Port:         otherPort,
+			PublicKey:    base64.StdEncoding.EncodeToString(otherPublic),
+			PreSharedKey: base64.StdEncoding.EncodeToString(psk),
+			AllowedIPs:   []string{"10.0.1.0/24"},
+		}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, C.WireGuard, proxy.Type())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, err := proxy.DialContext(ctx, &C.Metadata{NetWork: C.TCP, DstIP: net.ParseIP("10.0.0.1"), DstPort: 80})
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+	assert.Equal(t, "010203", reserved.Load())
+
+	pc, err := proxy.ListenPacketContext(ctx, &C.Metadata{NetWork: C.UDP, DstIP: net.ParseIP("10.0.0.1"), DstPort: 53})
+	require.NoError(t, err)
+	defer pc.Close()
+	pc.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// a 16 bytes IPv4 address like the one of metadata.UDPAddr
+	target := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 53}
+	_, err = pc.WriteTo([]byte("world"), target)
+	require.NoError(t, err)
+	n, addr, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(buf[:n]))
+	assert.Equal(t, "10.0.0.1:53", addr.String())
+
+	// the other peer is picked by its allowed ips
+	other, err := proxy.DialContext(ctx, &C.Metadata{NetWork: C.TCP, DstIP: net.ParseIP("10.0.1.1"), DstPort: 80})
+	require.NoError(t, err)
+	defer other.Close()
+	other.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = other.Write([]byte("other"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(other, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "other", string(buf))
+
+	// the allowed ips of the peers don't cover the destination
+	ctx, cancel = context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	_, err = proxy.DialContext(ctx, &C.Metadata{NetWork: C.TCP, DstIP: net.ParseIP("10.0.2.1"), DstPort: 80})
+	assert.Error(t, err)
+}
+
+func TestNewWireGuard_InvalidOption(t *testing.T) {
+	_, public := newWireGuardKey(t)
+	key := base64.StdEncoding.EncodeToString(public)
+	valid := WireGuardOption{Name: "wg", Server: "127.0.0.1", Port: 51820, IP: "10.0.0.2", PrivateKey: key, PublicKey: key}
+
+	_, err := NewWireGuard(valid)
+	require.NoError(t, err)
+
+	for name, modify := range map[string]func(o *WireGuardOption){
+		"private-key": func(o *WireGuardOption) { o.PrivateKey = "short" },
+		"ip":          func(o *WireGuardOption) { o.IP = "fd00::2" },
+		"no ip":       func(o *WireGuardOption) { o.IP = "" },
+		"reserved":    func(o *WireGuardOption) { o.Reserved = []int{1, 256, 0} },
+		"no server":   func(o *WireGuardOption) { o.Server = "" },
+		"allowed-ips": func(o *WireGuardOption) {
+			o.Peers = []WireGuardPeerOption{{Server: "127.0.0.1", Port: 51820, PublicKey: key, AllowedIPs: []string{"10.0.0.0"}}}
+		},
+	} {
+		option := valid
+		modify(&option)
+		_, err := NewWireGuard(option)
+		assert.Error(t, err, name)
+	}
+}
@@ -80,6 +80,20 @@ func ParseProxy(mapping map[string]any) (C.Proxy, error) {
 			break
 		}
 		proxy, err = outbound.NewTrojan(*trojanOption)
+	case "wireguard":
+		wgOption := &outbound.WireGuardOption{}
+		err = decoder.Decode(mapping, wgOption)
+		if err != nil {
+			break
+		}
+		proxy, err = outbound.NewWireGuard(*wgOption)
+	case "ssh":
+		sshOption := &outbound.SshOption{}
+		err = decoder.Decode(mapping, sshOption)
+		if err != nil {
+			break
+		}
+		proxy, err = outbound.NewSsh(*sshOption)
 	default:
 		return nil, fmt.Errorf("unsupport proxy type: %s", proxyType)
 	}
@@ -88,5 +102,24 @@ func ParseProxy(mapping map[string]any) (C.Proxy, error) {
 		return nil, err
 	}
 
+	muxOption := &struct {
+		Multiplex outbound.MultiplexOption `proxy:"multiplex,omitempty"`
+	}{}
+	if err := decoder.Decode(mapping, muxOption); err != nil {
+		return nil, err
+	}
+	if muxOption.Multiplex.Enabled {
+		// the multiplexed connections are opened as streams of the proxy protocol
+		switch proxyType {
+		case "ss", "vmess", "vless", "trojan", "snell":
+		default:
+			return nil, fmt.Errorf("proxy %s: multiplex isn't supported by %s", proxy.Name(), proxyType)
+		}
+		proxy, err = outbound.NewMultiplex(muxOption.Multiplex, proxy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return NewProxy(proxy), nil
 }
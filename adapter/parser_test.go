@@ -0,0 +1,48 @@
+package adapter
+
+import (
+	"testing"
+
+	"github.com/Dreamacro/clash/adapter/outbound"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProxy_Multiplex(t *testing.T) {
+	mapping := func(multiplex map[string]any) map[string]any {
+		return map[string]any{
+			"name":      "ss",
+			"type":      "ss",
+			"server":    "127.0.0.1",
+			"port":      8388,
+			"cipher":    "aes-128-gcm",
+			"password":  "password",
+			"multiplex": multiplex,
+		}
+	}
+
+	proxy, err := ParseProxy(mapping(map[string]any{"enabled": true, "protocol": "yamux", "max-connections": 8, "padding": true}))
+	require.NoError(t, err)
+	assert.IsType(t, &outbound.Multiplex{}, proxy.(*Proxy).ProxyAdapter)
+	assert.Equal(t, "ss", proxy.Name())
+
+	proxy, err = ParseProxy(mapping(map[string]any{"enabled": false}))
+	require.NoError(t, err)
+	assert.IsType(t, &outbound.ShadowSocks{}, proxy.(*Proxy).ProxyAdapter)
+
+	_, err = ParseProxy(mapping(map[string]any{"enabled": true, "protocol": "mplex"}))
+	assert.Error(t, err)
+
+	_, err = ParseProxy(mapping(map[string]any{"enabled": true, "max-streams": 4, "min-streams": 2}))
+	assert.Error(t, err)
+
+	_, err = ParseProxy(map[string]any{
+		"name":      "socks",
+		"type":      "socks5",
+		"server":    "127.0.0.1",
+		"port":      1080,
+		"multiplex": map[string]any{"enabled": true},
+	})
+	assert.EqualError(t, err, "proxy socks: multiplex isn't supported by socks5")
+}
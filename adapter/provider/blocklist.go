@@ -0,0 +1,198 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/Dreamacro/clash/component/trie"
+	types "github.com/Dreamacro/clash/constant/provider"
+
+	"go.uber.org/atomic"
+)
+
+// hostnames of the loopback entries that hosts files start with
+var localHostnames = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+}
+
+// domainSet is the compiled form of a blocklist
+type domainSet struct {
+	block *trie.DomainTrie
+	allow *trie.DomainTrie
+	count int
+}
+
+// for auto gc
+type BlocklistProvider struct {
+	*blocklistProvider
+}
+
+type blocklistProvider struct {
+	*fetcher
+	set *atomic.Pointer[domainSet]
+}
+
+func (bp *blocklistProvider) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"name":        bp.Name(),
+		"type":        bp.Type().String(),
+		"vehicleType": bp.VehicleType().String(),
+		"domainCount": bp.current().count,
+		"updatedAt":   bp.updatedAt,
+	})
+}
+
+func (bp *blocklistProvider) Type() types.ProviderType {
+	return types.Blocklist
+}
+
+func (bp *blocklistProvider) Initial() error {
+	elm, err := bp.fetcher.Initial()
+	if err != nil {
+		return err
+	}
+
+	bp.onUpdate(elm)
+	return nil
+}
+
+func (bp *blocklistProvider) Update() error {
+	elm, same, err := bp.fetcher.Update()
+	if err == nil && !same {
+		bp.onUpdate(elm)
+	}
+	return err
+}
+
+// Blocked implements types.BlocklistProvider
+func (bp *blocklistProvider) Blocked(domain string) bool {
+	return bp.current().block.Search(domain) != nil
+}
+
+// Allowed implements types.BlocklistProvider
+func (bp *blocklistProvider) Allowed(domain string) bool {
+	return bp.current().allow.Search(domain) != nil
+}
+
+func (bp *blocklistProvider) current() *domainSet {
+	if set := bp.set.Load(); set != nil {
+		return set
+	}
+	return &domainSet{block: trie.New(), allow: trie.New()}
+}
+
+// parseBlocklist reads hosts files, plain domains and the domain rules of adblock
+// filters, other lines such as cosmetic filters are skipped
+func parseBlocklist(buf []byte) (any, error) {
+	set := &domainSet{block: trie.New(), allow: trie.New()}
+	insert := func(tree *trie.DomainTrie, domain string) {
+		if tree.Insert(strings.ToLower(domain), struct{}{}) == nil {
+			set.count++
+		}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == '!' || line[0] == '[' {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "@@||"):
+			if domain, ok := parseAdblockRule(line[4:]); ok {
+				insert(set.allow, "+."+domain)
+			}
+		case strings.HasPrefix(line, "||"):
+			if domain, ok := parseAdblockRule(line[2:]); ok {
+				insert(set.block, "+."+domain)
+			}
+		default:
+			fields := strings.Fields(line)
+			// drop the trailing comment
+			for idx, field := range fields {
+				if field[0] == '#' {
+					fields = fields[:idx]
+					break
+				}
+			}
+			if len(fields) == 0 {
+				continue
+			}
+
+			// 0.0.0.0 ads.example.com tracker.example.com
+			if net.ParseIP(fields[0]) != nil {
+				for _, host := range fields[1:] {
+					if !localHostnames[host] {
+						insert(set.block, host)
+					}
+				}
+				continue
+			}
+
+			if len(fields) == 1 && isDomainPattern(fields[0]) {
+				insert(set.block, fields[0])
+			}
+		}
+	}
+
+	return set, scanner.Err()
+}
+
+// parseAdblockRule returns the domain of "example.com^", a rule with a path or
+// options other than $important doesn't apply to the whole domain
+func parseAdblockRule(rule string) (string, bool) {
+	rule, options, _ := strings.Cut(rule, "$")
+	if options != "" && options != "important" {
+		return "", false
+	}
+
+	domain, found := strings.CutSuffix(rule, "^")
+	if !found || domain == "" || !isDomainPattern(domain) || strings.ContainsAny(domain, "*+") {
+		return "", false
+	}
+	return domain, true
+}
+
+// isDomainPattern filters out adblock rules such as "example.com##.ad"
+func isDomainPattern(s string) bool {
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '.', c == '_', c == '*', c == '+':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func stopBlocklistProvider(bp *BlocklistProvider) {
+	bp.fetcher.Destroy()
+}
+
+// NewBlocklistProvider returns a blocklist, its domains are loaded on Initial and refreshed every interval
+func NewBlocklistProvider(name string, interval time.Duration, vehicle types.Vehicle) *BlocklistProvider {
+	bp := &blocklistProvider{
+		set: atomic.NewPointer[domainSet](nil),
+	}
+
+	onUpdate := func(elm any) {
+		bp.set.Store(elm.(*domainSet))
+	}
+
+	bp.fetcher = newFetcher(name, interval, vehicle, parseBlocklist, onUpdate)
+	wrapper := &BlocklistProvider{bp}
+	runtime.SetFinalizer(wrapper, stopBlocklistProvider)
+	return wrapper
+}
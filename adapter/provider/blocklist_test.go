@@ -0,0 +1,51 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlocklistProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist")
+	require.NoError(t, os.WriteFile(path, []byte(`
+[Adblock Plus 2.0]
+! adblock
+||ads.example.com^
+||tracker.org^$important
+||cdn.example.com^$third-party
+||example.net/banner^
+@@||good.tracker.org^
+example.org##.banner
+
+# hosts
+127.0.0.1 localhost
+0.0.0.0 Hosts.Example.COM hosts2.example.com # inline comment
+
+# plain domains
+plain.example.com
++.wildcard.org
+`), fileMode))
+
+	bp := NewBlocklistProvider("test", 0, NewFileVehicle(path))
+	require.NoError(t, bp.Initial())
+
+	for _, domain := range []string{
+		"ads.example.com", "sub.ads.example.com", "tracker.org", "good.tracker.org",
+		"hosts.example.com", "hosts2.example.com", "plain.example.com", "a.wildcard.org",
+	} {
+		assert.True(t, bp.Blocked(domain), domain)
+	}
+	for _, domain := range []string{
+		"cdn.example.com", "example.net", "example.org", "localhost", "sub.plain.example.com",
+	} {
+		assert.False(t, bp.Blocked(domain), domain)
+	}
+
+	assert.True(t, bp.Allowed("good.tracker.org"))
+	assert.False(t, bp.Allowed("tracker.org"))
+	assert.Equal(t, 7, bp.current().count)
+}
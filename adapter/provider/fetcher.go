@@ -126,9 +126,14 @@ func (f *fetcher) Update() (any, bool, error) {
 	return proxies, false, nil
 }
 
+// Destroy stops the updates, it may be called more than once and before Initial
 func (f *fetcher) Destroy() error {
 	if f.ticker != nil {
-		f.done <- struct{}{}
+		f.ticker.Stop()
+		select {
+		case f.done <- struct{}{}:
+		default:
+		}
 	}
 	return nil
 }
@@ -142,11 +147,11 @@ func (f *fetcher) pullLoop(immediately bool) {
 		}
 
 		if same {
-			log.Debugln("[Provider] %s's proxies doesn't change", f.Name())
+			log.Debugln("[Provider] %s doesn't change", f.Name())
 			return
 		}
 
-		log.Infoln("[Provider] %s's proxies update", f.Name())
+		log.Infoln("[Provider] %s update", f.Name())
 		if f.onUpdate != nil {
 			f.onUpdate(elm)
 		}
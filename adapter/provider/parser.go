@@ -0,0 +1,102 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Dreamacro/clash/common/structure"
+	C "github.com/Dreamacro/clash/constant"
+	types "github.com/Dreamacro/clash/constant/provider"
+)
+
+var errSubPath = errors.New("path is not subpath of home directory")
+
+type ruleProviderSchema struct {
+	Type     string `provider:"type"`
+	Behavior string `provider:"behavior"`
+	Path     string `provider:"path"`
+	URL      string `provider:"url,omitempty"`
+	Interval int    `provider:"interval,omitempty"`
+	Format   string `provider:"format,omitempty"`
+}
+
+// ParseRuleProvider builds a rule provider from its config mapping, Initial is left to the caller
+func ParseRuleProvider(name string, mapping map[string]any) (types.RuleProvider, error) {
+	decoder := structure.NewDecoder(structure.Option{TagName: "provider", WeaklyTypedInput: true})
+
+	schema := &ruleProviderSchema{}
+	if err := decoder.Decode(mapping, schema); err != nil {
+		return nil, err
+	}
+
+	var behavior types.RuleType
+	switch schema.Behavior {
+	case "domain":
+		behavior = types.Domain
+	case "ipcidr":
+		behavior = types.IPCIDR
+	case "classical":
+		behavior = types.Classical
+	default:
+		return nil, fmt.Errorf("unsupported behavior: %s", schema.Behavior)
+	}
+
+	var format RuleFormat
+	switch schema.Format {
+	case "", "yaml":
+		format = YamlRule
+	case "text":
+		format = TextRule
+	default:
+		return nil, fmt.Errorf("unsupported format: %s", schema.Format)
+	}
+
+	vehicle, err := newVehicle(schema.Type, schema.Path, schema.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := time.Duration(uint(schema.Interval)) * time.Second
+	return NewRuleSetProvider(name, behavior, format, interval, vehicle), nil
+}
+
+type blocklistProviderSchema struct {
+	Type     string `provider:"type"`
+	Path     string `provider:"path"`
+	URL      string `provider:"url,omitempty"`
+	Interval int    `provider:"interval,omitempty"`
+}
+
+// ParseBlocklistProvider builds a blocklist from its config mapping, Initial is left to the caller
+func ParseBlocklistProvider(name string, mapping map[string]any) (types.BlocklistProvider, error) {
+	decoder := structure.NewDecoder(structure.Option{TagName: "provider", WeaklyTypedInput: true})
+
+	schema := &blocklistProviderSchema{}
+	if err := decoder.Decode(mapping, schema); err != nil {
+		return nil, err
+	}
+
+	vehicle, err := newVehicle(schema.Type, schema.Path, schema.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := time.Duration(uint(schema.Interval)) * time.Second
+	return NewBlocklistProvider(name, interval, vehicle), nil
+}
+
+func newVehicle(vehicleType string, path string, url string) (types.Vehicle, error) {
+	path = C.Path.Resolve(path)
+	switch vehicleType {
+	case "file":
+		return NewFileVehicle(path), nil
+	case "http":
+		if !C.Path.IsSubPath(path) {
+			return nil, fmt.Errorf("%w: %s", errSubPath, path)
+		}
+		return NewHTTPVehicle(url, path), nil
+	default:
+		return nil, fmt.Errorf("unsupported vehicle type: %s", vehicleType)
+	}
+}
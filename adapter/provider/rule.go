@@ -0,0 +1,269 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/Dreamacro/clash/component/cidr"
+	"github.com/Dreamacro/clash/component/trie"
+	C "github.com/Dreamacro/clash/constant"
+	types "github.com/Dreamacro/clash/constant/provider"
+	R "github.com/Dreamacro/clash/rule"
+
+	"go.uber.org/atomic"
+	"gopkg.in/yaml.v3"
+)
+
+// RuleFormat is the encoding of a rule provider's content
+type RuleFormat int
+
+const (
+	YamlRule RuleFormat = iota
+	TextRule
+)
+
+type RuleSchema struct {
+	Payload []string `yaml:"payload"`
+}
+
+// ruleStrategy is the compiled form of a rule provider's payload
+type ruleStrategy interface {
+	Match(metadata *C.Metadata) bool
+	Count() int
+	ShouldResolveIP() bool
+	ShouldFindProcess() bool
+}
+
+type domainStrategy struct {
+	trie  *trie.DomainTrie
+	count int
+}
+
+func (ds *domainStrategy) Match(metadata *C.Metadata) bool {
+	return metadata.Host != "" && ds.trie.Search(metadata.Host) != nil
+}
+
+func (ds *domainStrategy) Count() int              { return ds.count }
+func (ds *domainStrategy) ShouldResolveIP() bool   { return false }
+func (ds *domainStrategy) ShouldFindProcess() bool { return false }
+
+type ipcidrStrategy struct {
+	set   *cidr.IPCIDRSet
+	count int
+}
+
+func (is *ipcidrStrategy) Match(metadata *C.Metadata) bool {
+	return is.set.Contains(metadata.DstIP)
+}
+
+func (is *ipcidrStrategy) Count() int              { return is.count }
+func (is *ipcidrStrategy) ShouldResolveIP() bool   { return true }
+func (is *ipcidrStrategy) ShouldFindProcess() bool { return false }
+
+type classicalStrategy struct {
+	rules             []C.Rule
+	shouldResolveIP   bool
+	shouldFindProcess bool
+}
+
+func (cs *classicalStrategy) Match(metadata *C.Metadata) bool {
+	for _, rule := range cs.rules {
+		if rule.Match(metadata) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cs *classicalStrategy) Count() int              { return len(cs.rules) }
+func (cs *classicalStrategy) ShouldResolveIP() bool   { return cs.shouldResolveIP }
+func (cs *classicalStrategy) ShouldFindProcess() bool { return cs.shouldFindProcess }
+
+func newDomainStrategy(payload []string) (ruleStrategy, error) {
+	tree := trie.New()
+	for idx, domain := range payload {
+		if err := tree.Insert(strings.ToLower(domain), struct{}{}); err != nil {
+			return nil, fmt.Errorf("payload[%d]: invalid domain '%s'", idx, domain)
+		}
+	}
+	return &domainStrategy{trie: tree, count: len(payload)}, nil
+}
+
+func newIPCIDRStrategy(payload []string) (ruleStrategy, error) {
+	builder := &cidr.Builder{}
+	for idx, item := range payload {
+		if err := builder.AddString(item); err != nil {
+			return nil, fmt.Errorf("payload[%d]: %w", idx, err)
+		}
+	}
+
+	set, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	return &ipcidrStrategy{set: set, count: len(payload)}, nil
+}
+
+func newClassicalStrategy(payload []string) (ruleStrategy, error) {
+	strategy := &classicalStrategy{}
+	for idx, line := range payload {
+		parts := R.SplitRule(line)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("payload[%d]: format invalid '%s'", idx, line)
+		}
+
+		rule, err := R.ParseRule(parts[0], parts[1], "", parts[2:], nil)
+		if err != nil {
+			return nil, fmt.Errorf("payload[%d]: %w", idx, err)
+		}
+
+		strategy.shouldResolveIP = strategy.shouldResolveIP || rule.ShouldResolveIP()
+		strategy.shouldFindProcess = strategy.shouldFindProcess || rule.ShouldFindProcess()
+		strategy.rules = append(strategy.rules, rule)
+	}
+	return strategy, nil
+}
+
+// for auto gc
+type RuleSetProvider struct {
+	*ruleSetProvider
+}
+
+type ruleSetProvider struct {
+	*fetcher
+	behavior types.RuleType
+	format   RuleFormat
+	strategy *atomic.Pointer[ruleStrategy]
+}
+
+func (rp *ruleSetProvider) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"name":        rp.Name(),
+		"type":        rp.Type().String(),
+		"vehicleType": rp.VehicleType().String(),
+		"behavior":    rp.Behavior().String(),
+		"ruleCount":   rp.current().Count(),
+		"updatedAt":   rp.updatedAt,
+	})
+}
+
+func (rp *ruleSetProvider) Type() types.ProviderType {
+	return types.Rule
+}
+
+func (rp *ruleSetProvider) Behavior() types.RuleType {
+	return rp.behavior
+}
+
+func (rp *ruleSetProvider) Initial() error {
+	elm, err := rp.fetcher.Initial()
+	if err != nil {
+		return err
+	}
+
+	rp.onUpdate(elm)
+	return nil
+}
+
+func (rp *ruleSetProvider) Update() error {
+	elm, same, err := rp.fetcher.Update()
+	if err == nil && !same {
+		rp.onUpdate(elm)
+	}
+	return err
+}
+
+func (rp *ruleSetProvider) Match(metadata *C.Metadata) bool {
+	return rp.current().Match(metadata)
+}
+
+func (rp *ruleSetProvider) ShouldResolveIP() bool {
+	return rp.current().ShouldResolveIP()
+}
+
+func (rp *ruleSetProvider) ShouldFindProcess() bool {
+	return rp.current().ShouldFindProcess()
+}
+
+// AsRule is declared on the wrapper so the returned rule keeps the provider alive
+func (rp *RuleSetProvider) AsRule(adaptor string) C.Rule {
+	return R.NewRuleSet(rp, adaptor, false)
+}
+
+func (rp *ruleSetProvider) current() ruleStrategy {
+	if strategy := rp.strategy.Load(); strategy != nil {
+		return *strategy
+	}
+	return &classicalStrategy{}
+}
+
+func (rp *ruleSetProvider) parse(buf []byte) (any, error) {
+	payload, err := decodeRulePayload(buf, rp.format)
+	if err != nil {
+		return nil, err
+	}
+
+	switch rp.behavior {
+	case types.Domain:
+		return newDomainStrategy(payload)
+	case types.IPCIDR:
+		return newIPCIDRStrategy(payload)
+	case types.Classical:
+		return newClassicalStrategy(payload)
+	default:
+		return nil, fmt.Errorf("unsupported behavior: %s", rp.behavior)
+	}
+}
+
+func decodeRulePayload(buf []byte, format RuleFormat) ([]string, error) {
+	if format == YamlRule {
+		schema := &RuleSchema{}
+		if err := yaml.Unmarshal(buf, schema); err != nil {
+			return nil, err
+		}
+		if schema.Payload == nil {
+			return nil, errors.New("file must have a `payload` field")
+		}
+		return schema.Payload, nil
+	}
+
+	payload := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		payload = append(payload, line)
+	}
+	return payload, scanner.Err()
+}
+
+func stopRuleSetProvider(rp *RuleSetProvider) {
+	rp.fetcher.Destroy()
+}
+
+// NewRuleSetProvider returns a rule provider, its rules are loaded on Initial and refreshed every interval
+func NewRuleSetProvider(name string, behavior types.RuleType, format RuleFormat, interval time.Duration, vehicle types.Vehicle) *RuleSetProvider {
+	rp := &ruleSetProvider{
+		behavior: behavior,
+		format:   format,
+		strategy: atomic.NewPointer[ruleStrategy](nil),
+	}
+
+	onUpdate := func(elm any) {
+		strategy := elm.(ruleStrategy)
+		rp.strategy.Store(&strategy)
+	}
+
+	rp.fetcher = newFetcher(name, interval, vehicle, rp.parse, onUpdate)
+	wrapper := &RuleSetProvider{rp}
+	runtime.SetFinalizer(wrapper, stopRuleSetProvider)
+	return wrapper
+}
@@ -0,0 +1,117 @@
+package provider
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+	types "github.com/Dreamacro/clash/constant/provider"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRuleSetProvider(t *testing.T, behavior types.RuleType, format RuleFormat, content string) *RuleSetProvider {
+	path := filepath.Join(t.TempDir(), "rules")
+	require.NoError(t, os.WriteFile(path, []byte(content), fileMode))
+
+	rp := NewRuleSetProvider("test", behavior, format, 0, NewFileVehicle(path))
+	require.NoError(t, rp.Initial())
+	return rp
+}
+
+func TestRuleSetProvider_Domain(t *testing.T) {
+	rp := newTestRuleSetProvider(t, types.Domain, YamlRule, `
+payload:
+  - example.com
+  - +.google.com
+  - '*.test.org'
+`)
+
+	assert.True(t, rp.Match(&C.Metadata{Host: "example.com"}))
+	assert.True(t, rp.Match(&C.Metadata{Host: "www.google.com"}))
+	assert.True(t, rp.Match(&C.Metadata{Host: "google.com"}))
+	assert.True(t, rp.Match(&C.Metadata{Host: "a.test.org"}))
+	assert.False(t, rp.Match(&C.Metadata{Host: "sub.example.com"}))
+	assert.False(t, rp.Match(&C.Metadata{DstIP: net.ParseIP("1.1.1.1")}))
+	assert.False(t, rp.ShouldResolveIP())
+}
+
+func TestRuleSetProvider_IPCIDR(t *testing.T) {
+	rp := newTestRuleSetProvider(t, types.IPCIDR, TextRule, `
+# comment
+10.0.0.0/8
+
+2001:db8::/32
+`)
+
+	assert.True(t, rp.Match(&C.Metadata{DstIP: net.ParseIP("10.1.2.3")}))
+	assert.True(t, rp.Match(&C.Metadata{DstIP: net.ParseIP("2001:db8::1")}))
+	assert.False(t, rp.Match(&C.Metadata{DstIP: net.ParseIP("11.0.0.1")}))
+	assert.False(t, rp.Match(&C.Metadata{Host: "example.com"}))
+	assert.True(t, rp.ShouldResolveIP())
+}
+
+func TestRuleSetProvider_Classical(t *testing.T) {
+	rp := newTestRuleSetProvider(t, types.Classical, YamlRule, `
+payload:
+  - DOMAIN-SUFFIX,example.com
+  - IP-CIDR,192.168.0.0/16,no-resolve
+  - DST-PORT,8443
+`)
+
+	assert.True(t, rp.Match(&C.Metadata{Host: "www.example.com"}))
+	assert.True(t, rp.Match(&C.Metadata{DstIP: net.ParseIP("192.168.1.1")}))
+	assert.True(t, rp.Match(&C.Metadata{Host: "other.com", DstPort: 8443}))
+	assert.False(t, rp.Match(&C.Metadata{Host: "other.com", DstPort: 443}))
+	assert.False(t, rp.ShouldResolveIP())
+
+	rule := rp.AsRule("DIRECT")
+	assert.Equal(t, C.RuleSet, rule.RuleType())
+	assert.Equal(t, "test", rule.Payload())
+	assert.Equal(t, "DIRECT", rule.Adapter())
+}
+
+func TestRuleSetProvider_Update(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules")
+	require.NoError(t, os.WriteFile(path, []byte("payload:\n  - example.com\n"), fileMode))
+
+	rp := NewRuleSetProvider("test", types.Domain, YamlRule, 0, NewFileVehicle(path))
+	require.NoError(t, rp.Initial())
+	assert.False(t, rp.Match(&C.Metadata{Host: "example.org"}))
+
+	require.NoError(t, os.WriteFile(path, []byte("payload:\n  - example.org\n"), fileMode))
+	require.NoError(t, rp.Update())
+	assert.True(t, rp.Match(&C.Metadata{Host: "example.org"}))
+	assert.False(t, rp.Match(&C.Metadata{Host: "example.com"}))
+}
+
+func TestRuleSetProvider_Destroy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules")
+	require.NoError(t, os.WriteFile(path, []byte("payload:\n  - example.com\n"), fileMode))
+
+	// a provider may be destroyed before Initial, and more than once
+	rp := NewRuleSetProvider("test", types.Domain, YamlRule, time.Hour, NewFileVehicle(path))
+	require.NoError(t, rp.Destroy())
+	require.NoError(t, rp.Destroy())
+
+	rp = NewRuleSetProvider("test", types.Domain, YamlRule, time.Hour, NewFileVehicle(path))
+	require.NoError(t, rp.Initial())
+	require.NoError(t, rp.Destroy())
+	require.NoError(t, rp.Destroy())
+}
+
+func TestParseRuleProvider_Error(t *testing.T) {
+	_, err := ParseRuleProvider("test", map[string]any{"type": "file", "behavior": "unknown", "path": "rules.yaml"})
+	assert.EqualError(t, err, "unsupported behavior: unknown")
+
+	_, err = ParseRuleProvider("test", map[string]any{"type": "ftp", "behavior": "domain", "path": "rules.yaml"})
+	assert.EqualError(t, err, "unsupported vehicle type: ftp")
+
+	rp := NewRuleSetProvider("test", types.Domain, YamlRule, 0, NewFileVehicle(filepath.Join(t.TempDir(), "rules")))
+	require.NoError(t, os.WriteFile(rp.fetcher.vehicle.Path(), []byte("payload:\n  - 'a..b'\n"), fileMode))
+	assert.EqualError(t, rp.Initial(), "payload[0]: invalid domain 'a..b'")
+}
@@ -163,6 +163,23 @@ func (c *LruCache) CloneTo(n *LruCache) {
 	}
 }
 
+// Range calls f on a snapshot of the elements from the least recently used,
+// it stops if f returns false. Expired elements are included.
+func (c *LruCache) Range(f func(key any, value any, expires time.Time) bool) {
+	c.mu.Lock()
+	entries := make([]entry, 0, c.lru.Len())
+	for le := c.lru.Front(); le != nil; le = le.Next() {
+		entries = append(entries, *le.Value.(*entry))
+	}
+	c.mu.Unlock()
+
+	for _, e := range entries {
+		if !f(e.key, e.value, time.Unix(e.expires, 0)) {
+			return
+		}
+	}
+}
+
 func (c *LruCache) get(key any) *entry {
 	c.mu.Lock()
 	defer c.mu.Unlock()
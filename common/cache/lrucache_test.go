@@ -181,3 +181,22 @@ func TestCloneTo(t *testing.T) {
 	n.Set("5", 5)
 	assert.False(t, n.Exist("1"))
 }
+
+func TestRange(t *testing.T) {
+	c := New(WithStale(true))
+	expires := time.Unix(time.Now().Unix()-10, 0)
+	c.SetWithExpire("1", 1, expires)
+	c.Set("2", 2)
+	c.Set("3", 3)
+	c.Get("1")
+
+	keys := []any{}
+	c.Range(func(key any, value any, exp time.Time) bool {
+		if key == "1" {
+			assert.Equal(t, expires, exp)
+		}
+		keys = append(keys, key)
+		return len(keys) < 2
+	})
+	assert.Equal(t, []any{"2", "3"}, keys)
+}
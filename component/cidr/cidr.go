@@ -0,0 +1,57 @@
+package cidr
+
+import (
+	"net"
+	"net/netip"
+
+	"go4.org/netipx"
+)
+
+// IPCIDRSet is an immutable set of CIDRs with O(log n) lookup
+type IPCIDRSet struct {
+	set *netipx.IPSet
+}
+
+// Contains returns true if ip is covered by any CIDR in the set
+func (s *IPCIDRSet) Contains(ip net.IP) bool {
+	if s == nil || ip == nil {
+		return false
+	}
+
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	return s.set.Contains(addr.Unmap())
+}
+
+// Builder collects CIDRs for an IPCIDRSet
+type Builder struct {
+	builder netipx.IPSetBuilder
+}
+
+// AddString adds a CIDR in string form, e.g. 10.0.0.0/8 or 2001:db8::/32
+func (b *Builder) AddString(s string) error {
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return err
+	}
+	b.builder.AddPrefix(prefix.Masked())
+	return nil
+}
+
+// AddIPNet adds a *net.IPNet
+func (b *Builder) AddIPNet(ipnet *net.IPNet) {
+	if prefix, ok := netipx.FromStdIPNet(ipnet); ok {
+		b.builder.AddPrefix(prefix.Masked())
+	}
+}
+
+// Build returns the IPCIDRSet of all added CIDRs
+func (b *Builder) Build() (*IPCIDRSet, error) {
+	set, err := b.builder.IPSet()
+	if err != nil {
+		return nil, err
+	}
+	return &IPCIDRSet{set: set}, nil
+}
@@ -0,0 +1,31 @@
+package cidr
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPCIDRSet(t *testing.T) {
+	b := &Builder{}
+	require.NoError(t, b.AddString("10.0.0.0/8"))
+	require.NoError(t, b.AddString("192.168.1.1/24"))
+	require.NoError(t, b.AddString("2001:db8::/32"))
+	_, ipnet, _ := net.ParseCIDR("172.16.0.0/12")
+	b.AddIPNet(ipnet)
+	assert.Error(t, b.AddString("not-a-cidr"))
+
+	set, err := b.Build()
+	require.NoError(t, err)
+
+	assert.True(t, set.Contains(net.ParseIP("10.1.2.3")))
+	assert.True(t, set.Contains(net.ParseIP("192.168.1.254")))
+	assert.True(t, set.Contains(net.ParseIP("172.31.0.1")))
+	assert.True(t, set.Contains(net.ParseIP("2001:db8::1")))
+	assert.True(t, set.Contains(net.ParseIP("10.0.0.1").To16()))
+	assert.False(t, set.Contains(net.ParseIP("192.168.2.1")))
+	assert.False(t, set.Contains(net.ParseIP("2001:db9::1")))
+	assert.False(t, set.Contains(nil))
+}
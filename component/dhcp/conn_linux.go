@@ -0,0 +1,33 @@
+package dhcp
+
+import (
+	"context"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// listenDHCPClient listens on the DHCP client port of the interface, the socket
+// is bound to the device so that broadcasts go out of and are received on it only
+func listenDHCPClient(ctx context.Context, ifaceName string) (net.PacketConn, error) {
+	lc := &net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var innerErr error
+			err := c.Control(func(fd uintptr) {
+				for _, opt := range []int{unix.SO_REUSEADDR, unix.SO_REUSEPORT, unix.SO_BROADCAST} {
+					if innerErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, opt, 1); innerErr != nil {
+						return
+					}
+				}
+				innerErr = unix.BindToDevice(int(fd), ifaceName)
+			})
+			if innerErr != nil {
+				return innerErr
+			}
+			return err
+		},
+	}
+
+	return lc.ListenPacket(ctx, "udp4", "0.0.0.0:68")
+}
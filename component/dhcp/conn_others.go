@@ -0,0 +1,13 @@
+//go:build !linux
+
+package dhcp
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+func listenDHCPClient(_ context.Context, _ string) (net.PacketConn, error) {
+	return nil, errors.New("DHCP client is not supported on this platform")
+}
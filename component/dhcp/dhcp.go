@@ -0,0 +1,99 @@
+package dhcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/Dreamacro/clash/component/iface"
+)
+
+// retransmitInterval is the time to wait for a reply before the request is sent again
+var retransmitInterval = 2 * time.Second
+
+var broadcastAddr = &net.UDPAddr{IP: net.IPv4bcast, Port: 67}
+
+// ResolveDNSFromDHCP asks the DHCP server of the interface for its DNS servers (option 6).
+// A DHCPINFORM is sent if the interface has an IPv4 address, a DHCPDISCOVER is sent
+// as well if the server doesn't answer the first one. The lease time is zero if the
+// server doesn't provide one, which is common for DHCPINFORM.
+func ResolveDNSFromDHCP(ctx context.Context, ifaceName string) ([]netip.Addr, time.Duration, error) {
+	ifaceObj, err := iface.ResolveInterface(ifaceName)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(ifaceObj.HardwareAddr) == 0 || len(ifaceObj.HardwareAddr) > 16 {
+		return nil, 0, fmt.Errorf("interface %s has no hardware address", ifaceName)
+	}
+
+	var ciaddr netip.Addr
+	if ipNet, err := ifaceObj.PickIPv4Addr(nil); err == nil {
+		ciaddr, _ = netip.AddrFromSlice(ipNet.IP.To4())
+	}
+
+	conn, err := listenDHCPClient(ctx, ifaceName)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+
+	xid := rand.Uint32()
+	buf := make([]byte, 1500)
+	for attempt := 0; ; attempt++ {
+		requests := [][]byte{}
+		if ciaddr.IsValid() {
+			requests = append(requests, newRequest(typeInform, xid, ifaceObj.HardwareAddr, ciaddr))
+		}
+		if !ciaddr.IsValid() || attempt > 0 {
+			requests = append(requests, newRequest(typeDiscover, xid, ifaceObj.HardwareAddr, netip.Addr{}))
+		}
+		for _, request := range requests {
+			if _, err := conn.WriteTo(request, broadcastAddr); err != nil {
+				return nil, 0, err
+			}
+		}
+
+		deadline := time.Now().Add(retransmitInterval)
+		if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return nil, 0, err
+		}
+
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, 0, ctx.Err()
+				}
+				if isTimeout(err) {
+					break
+				}
+				return nil, 0, err
+			}
+
+			r, err := parseReply(buf[:n], xid)
+			if err != nil || (r.msgType != typeOffer && r.msgType != typeAck) {
+				continue
+			}
+			if len(r.dns) == 0 {
+				return nil, 0, fmt.Errorf("DHCP server of %s doesn't provide DNS servers", ifaceName)
+			}
+			return r.dns, r.leaseTime, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, 0, ctx.Err()
+		}
+	}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
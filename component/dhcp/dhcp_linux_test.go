@@ -0,0 +1,163 @@
+package dhcp
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/component/iface"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// withVeth runs fn in a fresh network namespace that has the veth dhcp0, its peer
+// dhcp1 is moved to the namespace server, where the fake DHCP server should listen
+func withVeth(t *testing.T, fn func(client netlink.Link, server netns.NsHandle)) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origin, err := netns.Get()
+	require.NoError(t, err)
+	defer origin.Close()
+
+	server, err := netns.New()
+	if err != nil {
+		t.Skipf("create network namespace: %s", err)
+	}
+	defer server.Close()
+
+	ns, err := netns.New()
+	require.NoError(t, err)
+	defer func() {
+		netns.Set(origin)
+		ns.Close()
+		iface.FlushCache()
+	}()
+
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: "dhcp0", HardwareAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1}},
+		PeerName:  "dhcp1",
+	}
+	require.NoError(t, netlink.LinkAdd(veth))
+	client, err := netlink.LinkByName("dhcp0")
+	require.NoError(t, err)
+	require.NoError(t, netlink.LinkSetUp(client))
+
+	peer, err := netlink.LinkByName("dhcp1")
+	require.NoError(t, err)
+	require.NoError(t, netlink.LinkSetNsFd(peer, int(server)))
+
+	iface.FlushCache()
+	fn(client, server)
+}
+
+// serveDHCP answers the requests received on dhcp1 of the namespace ns with the DNS
+// servers, the message types of the requests are sent to received
+func serveDHCP(t *testing.T, ns netns.NsHandle, addr string, leaseTime time.Duration, dns []netip.Addr, received chan<- messageType) {
+	current, err := netns.Get()
+	require.NoError(t, err)
+	defer current.Close()
+	require.NoError(t, netns.Set(ns))
+	defer netns.Set(current)
+
+	link, err := netlink.LinkByName("dhcp1")
+	require.NoError(t, err)
+	require.NoError(t, netlink.LinkSetUp(link))
+	if addr != "" {
+		ipNet, err := netlink.ParseAddr(addr)
+		require.NoError(t, err)
+		require.NoError(t, netlink.AddrAdd(link, ipNet))
+	}
+
+	lc := &net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			return c.Control(func(fd uintptr) {
+				unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_BROADCAST, 1)
+				unix.BindToDevice(int(fd), "dhcp1")
+			})
+		},
+	}
+	conn, err := lc.ListenPacket(context.Background(), "udp4", "0.0.0.0:67")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			request := buf[:n]
+			if len(request) < headerLen+7 || request[0] != opRequest {
+				continue
+			}
+
+			msgType := messageType(request[headerLen+6])
+			received <- msgType
+			replyType := typeAck
+			if msgType == typeDiscover {
+				replyType = typeOffer
+			}
+			reply := newTestReply(request, replyType, leaseTime, dns...)
+			conn.WriteTo(reply, &net.UDPAddr{IP: net.IPv4bcast, Port: 68})
+		}
+	}()
+}
+
+func TestResolveDNSFromDHCP_Discover(t *testing.T) {
+	withVeth(t, func(client netlink.Link, server netns.NsHandle) {
+		dns := []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2")}
+		received := make(chan messageType, 8)
+		serveDHCP(t, server, "", time.Hour, dns, received)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		servers, leaseTime, err := ResolveDNSFromDHCP(ctx, client.Attrs().Name)
+		require.NoError(t, err)
+		assert.Equal(t, dns, servers)
+		assert.Equal(t, time.Hour, leaseTime)
+		assert.Equal(t, typeDiscover, <-received)
+	})
+}
+
+func TestResolveDNSFromDHCP_Inform(t *testing.T) {
+	withVeth(t, func(client netlink.Link, server netns.NsHandle) {
+		addr, err := netlink.ParseAddr("192.168.77.2/24")
+		require.NoError(t, err)
+		require.NoError(t, netlink.AddrAdd(client, addr))
+
+		dns := []netip.Addr{netip.MustParseAddr("192.168.77.1")}
+		received := make(chan messageType, 8)
+		serveDHCP(t, server, "192.168.77.1/24", 0, dns, received)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		servers, leaseTime, err := ResolveDNSFromDHCP(ctx, client.Attrs().Name)
+		require.NoError(t, err)
+		assert.Equal(t, dns, servers)
+		assert.Zero(t, leaseTime)
+		assert.Equal(t, typeInform, <-received)
+	})
+}
+
+func TestResolveDNSFromDHCP_Timeout(t *testing.T) {
+	withVeth(t, func(client netlink.Link, _ netns.NsHandle) {
+		origin := retransmitInterval
+		retransmitInterval = 100 * time.Millisecond
+		defer func() { retransmitInterval = origin }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		_, _, err := ResolveDNSFromDHCP(ctx, client.Attrs().Name)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
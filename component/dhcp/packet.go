@@ -0,0 +1,134 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// https://datatracker.ietf.org/doc/html/rfc2131#section-2
+const (
+	headerLen = 236
+	// minMessageLen is the minimal BOOTP message, some servers drop shorter ones
+	minMessageLen = 300
+
+	opRequest = 1
+	opReply   = 2
+
+	htypeEthernet = 1
+	flagBroadcast = 0x8000
+)
+
+var magicCookie = []byte{99, 130, 83, 99}
+
+// https://datatracker.ietf.org/doc/html/rfc2132
+const (
+	optionPad          = 0
+	optionRouter       = 3
+	optionDNS          = 6
+	optionLeaseTime    = 51
+	optionMessageType  = 53
+	optionParamRequest = 55
+	optionEnd          = 255
+)
+
+type messageType byte
+
+const (
+	typeDiscover messageType = 1
+	typeOffer    messageType = 2
+	typeAck      messageType = 5
+	typeNak      messageType = 6
+	typeInform   messageType = 8
+)
+
+var (
+	errNotReply    = errors.New("not a DHCP reply")
+	errXIDMismatch = errors.New("transaction id mismatch")
+)
+
+// reply is the part of a DHCPOFFER or DHCPACK that we care about
+type reply struct {
+	msgType   messageType
+	dns       []netip.Addr
+	leaseTime time.Duration
+}
+
+// newRequest encodes a DHCPDISCOVER or DHCPINFORM, ciaddr is the address of the
+// interface for DHCPINFORM and unspecified for DHCPDISCOVER
+func newRequest(msgType messageType, xid uint32, hwAddr net.HardwareAddr, ciaddr netip.Addr) []byte {
+	buf := make([]byte, headerLen, minMessageLen)
+	buf[0] = opRequest
+	buf[1] = htypeEthernet
+	buf[2] = byte(len(hwAddr))
+	binary.BigEndian.PutUint32(buf[4:8], xid)
+	if msgType == typeDiscover {
+		// the client has no address to receive an unicast reply
+		binary.BigEndian.PutUint16(buf[10:12], flagBroadcast)
+	}
+	if ciaddr.Is4() {
+		ip := ciaddr.As4()
+		copy(buf[12:16], ip[:])
+	}
+	copy(buf[28:44], hwAddr)
+
+	buf = append(buf, magicCookie...)
+	buf = append(buf, optionMessageType, 1, byte(msgType))
+	buf = append(buf, optionParamRequest, 3, optionRouter, optionDNS, optionLeaseTime)
+	buf = append(buf, optionEnd)
+	for len(buf) < minMessageLen {
+		buf = append(buf, optionPad)
+	}
+	return buf
+}
+
+// parseReply decodes a reply to the request of xid
+func parseReply(buf []byte, xid uint32) (*reply, error) {
+	if len(buf) < headerLen+len(magicCookie) || buf[0] != opReply ||
+		string(buf[headerLen:headerLen+len(magicCookie)]) != string(magicCookie) {
+		return nil, errNotReply
+	}
+	if binary.BigEndian.Uint32(buf[4:8]) != xid {
+		return nil, errXIDMismatch
+	}
+
+	r := &reply{}
+	options := buf[headerLen+len(magicCookie):]
+	for len(options) > 0 {
+		code := options[0]
+		if code == optionEnd {
+			break
+		}
+		if code == optionPad {
+			options = options[1:]
+			continue
+		}
+		if len(options) < 2 || len(options) < 2+int(options[1]) {
+			return nil, errors.New("truncated DHCP option")
+		}
+		value := options[2 : 2+options[1]]
+		options = options[2+options[1]:]
+
+		switch code {
+		case optionMessageType:
+			if len(value) == 1 {
+				r.msgType = messageType(value[0])
+			}
+		case optionDNS:
+			for ; len(value) >= 4; value = value[4:] {
+				r.dns = append(r.dns, netip.AddrFrom4([4]byte(value[:4])))
+			}
+		case optionLeaseTime:
+			if len(value) == 4 {
+				r.leaseTime = time.Duration(binary.BigEndian.Uint32(value)) * time.Second
+			}
+		}
+	}
+
+	if r.msgType == 0 {
+		return nil, errNotReply
+	}
+	return r, nil
+}
@@ -0,0 +1,71 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestReply answers request with msgType, the lease time is omitted if it's zero
+func newTestReply(request []byte, msgType messageType, leaseTime time.Duration, dns ...netip.Addr) []byte {
+	buf := make([]byte, headerLen)
+	copy(buf, request[:headerLen])
+	buf[0] = opReply
+
+	buf = append(buf, magicCookie...)
+	buf = append(buf, optionMessageType, 1, byte(msgType))
+	if len(dns) != 0 {
+		buf = append(buf, optionDNS, byte(4*len(dns)))
+		for _, addr := range dns {
+			ip := addr.As4()
+			buf = append(buf, ip[:]...)
+		}
+	}
+	if leaseTime != 0 {
+		buf = append(buf, optionLeaseTime, 4)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(leaseTime/time.Second))
+	}
+	return append(buf, optionEnd)
+}
+
+func TestNewRequest(t *testing.T) {
+	hwAddr := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+
+	discover := newRequest(typeDiscover, 0x12345678, hwAddr, netip.Addr{})
+	assert.Len(t, discover, minMessageLen)
+	assert.Equal(t, uint32(0x12345678), binary.BigEndian.Uint32(discover[4:8]))
+	assert.Equal(t, uint16(flagBroadcast), binary.BigEndian.Uint16(discover[10:12]))
+	assert.Equal(t, []byte(hwAddr), discover[28:34])
+	assert.Equal(t, []byte{optionMessageType, 1, byte(typeDiscover)}, discover[headerLen+4:headerLen+7])
+
+	inform := newRequest(typeInform, 1, hwAddr, netip.MustParseAddr("192.168.1.2"))
+	assert.Equal(t, uint16(0), binary.BigEndian.Uint16(inform[10:12]))
+	assert.Equal(t, []byte{192, 168, 1, 2}, inform[12:16])
+	assert.Equal(t, byte(typeInform), inform[headerLen+6])
+}
+
+func TestParseReply(t *testing.T) {
+	request := newRequest(typeDiscover, 1, net.HardwareAddr{2, 0, 0, 0, 0, 1}, netip.Addr{})
+	dns := []netip.Addr{netip.MustParseAddr("192.168.1.1"), netip.MustParseAddr("8.8.8.8")}
+
+	r, err := parseReply(newTestReply(request, typeOffer, time.Hour, dns...), 1)
+	require.NoError(t, err)
+	assert.Equal(t, typeOffer, r.msgType)
+	assert.Equal(t, dns, r.dns)
+	assert.Equal(t, time.Hour, r.leaseTime)
+
+	_, err = parseReply(newTestReply(request, typeOffer, 0), 2)
+	assert.ErrorIs(t, err, errXIDMismatch)
+
+	_, err = parseReply(request, 1)
+	assert.ErrorIs(t, err, errNotReply)
+
+	truncated := newTestReply(request, typeAck, 0, dns...)
+	_, err = parseReply(truncated[:headerLen+10], 1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,83 @@
+package fakeip
+
+import (
+	"net"
+
+	"github.com/Dreamacro/clash/component/profile/cachefile"
+)
+
+var (
+	// the prefixes keep ip keys and hosts of IPv6 pools apart from hosts
+	// of IPv4 pools, a host never contains ':'
+	ipKeyPrefix    = []byte("ip:")
+	host6KeyPrefix = []byte("ip6:")
+)
+
+type cachefileStore struct {
+	cache *cachefile.CacheFile
+	// ipv6 is set for the pool of an IPv6 range, which shares the cache file
+	ipv6 bool
+}
+
+func ipKey(ip net.IP) []byte {
+	return append(append([]byte{}, ipKeyPrefix...), ip.To16()...)
+}
+
+func (c *cachefileStore) hostKey(host string) []byte {
+	if c.ipv6 {
+		return append(append([]byte{}, host6KeyPrefix...), host...)
+	}
+	return []byte(host)
+}
+
+// GetByHost implements store.GetByHost
+func (c *cachefileStore) GetByHost(host string) (net.IP, bool) {
+	elm := c.cache.GetFakeip(c.hostKey(host))
+	if elm == nil {
+		return nil, false
+	}
+	return net.IP(elm), true
+}
+
+// PutByHost implements store.PutByHost
+func (c *cachefileStore) PutByHost(host string, ip net.IP) {
+	c.cache.PutFakeip(c.hostKey(host), ip.To16())
+}
+
+// GetByIP implements store.GetByIP
+func (c *cachefileStore) GetByIP(ip net.IP) (string, bool) {
+	elm := c.cache.GetFakeip(ipKey(ip))
+	if elm == nil {
+		return "", false
+	}
+	return string(elm), true
+}
+
+// PutByIP implements store.PutByIP
+func (c *cachefileStore) PutByIP(ip net.IP, host string) {
+	c.cache.PutFakeip(ipKey(ip), []byte(host))
+}
+
+// DelByIP implements store.DelByIP
+func (c *cachefileStore) DelByIP(ip net.IP) {
+	key := ipKey(ip)
+	var hostKey []byte
+	// the host may have been assigned another ip
+	if host := c.cache.GetFakeip(key); host != nil {
+		hostKey = c.hostKey(string(host))
+		if !ip.Equal(c.cache.GetFakeip(hostKey)) {
+			hostKey = nil
+		}
+	}
+	c.cache.DelFakeipPair(key, hostKey)
+}
+
+// Exist implements store.Exist
+func (c *cachefileStore) Exist(ip net.IP) bool {
+	_, exist := c.GetByIP(ip)
+	return exist
+}
+
+// CloneTo implements store.CloneTo
+// the cache file is shared by every pool, so there is nothing to clone
+func (c *cachefileStore) CloneTo(store store) {}
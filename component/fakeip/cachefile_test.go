@@ -0,0 +1,62 @@
+package fakeip
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/Dreamacro/clash/component/profile/cachefile"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCachefilePool(t *testing.T, cf *cachefile.CacheFile, cidr string) *Pool {
+	_, ipnet, _ := net.ParseCIDR(cidr)
+	pool, err := New(Options{IPNet: ipnet, Size: 10})
+	require.NoError(t, err)
+	pool.store = &cachefileStore{cache: cf}
+	return pool
+}
+
+func TestPool_Cachefile(t *testing.T) {
+	cf, err := cachefile.Open(filepath.Join(t.TempDir(), "cache.db"))
+	require.NoError(t, err)
+	defer cf.Close()
+
+	pool := newCachefilePool(t, cf, "192.168.0.1/29")
+	first := pool.Lookup("foo.com")
+	second := pool.Lookup("a.bc")
+	assert.Equal(t, net.IP{192, 168, 0, 2}, first)
+	assert.Equal(t, net.IP{192, 168, 0, 3}, second)
+
+	// a new pool, e.g. after a restart, keeps the mapping
+	pool = newCachefilePool(t, cf, "192.168.0.1/29")
+	assert.Equal(t, first, pool.Lookup("foo.com"))
+	host, exist := pool.LookBack(second)
+	assert.True(t, exist)
+	assert.Equal(t, "a.bc", host)
+	assert.True(t, pool.Exist(first))
+
+	// mappings of another range are ignored
+	pool = newCachefilePool(t, cf, "10.0.0.1/29")
+	assert.Equal(t, net.IP{10, 0, 0, 2}, pool.Lookup("foo.com"))
+}
+
+func TestPool_CachefileCycle(t *testing.T) {
+	cf, err := cachefile.Open(filepath.Join(t.TempDir(), "cache.db"))
+	require.NoError(t, err)
+	defer cf.Close()
+
+	// 192.168.0.2 - 192.168.0.3
+	pool := newCachefilePool(t, cf, "192.168.0.1/30")
+	first := pool.Lookup("foo.com")
+	pool.Lookup("bar.com")
+	assert.Equal(t, first, pool.Lookup("baz.com"))
+
+	host, exist := pool.LookBack(first)
+	assert.True(t, exist)
+	assert.Equal(t, "baz.com", host)
+	_, exist = pool.store.GetByHost("foo.com")
+	assert.False(t, exist)
+}
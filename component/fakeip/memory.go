@@ -16,7 +16,7 @@ func (m *memoryStore) GetByHost(host string) (net.IP, bool) {
 		ip := elm.(net.IP)
 
 		// ensure ip --> host on head of linked list
-		m.cache.Get(ipToUint(ip.To4()))
+		m.cache.Get(ipToAddr(ip))
 		return ip, true
 	}
 
@@ -30,7 +30,7 @@ func (m *memoryStore) PutByHost(host string, ip net.IP) {
 
 // GetByIP implements store.GetByIP
 func (m *memoryStore) GetByIP(ip net.IP) (string, bool) {
-	if elm, exist := m.cache.Get(ipToUint(ip.To4())); exist {
+	if elm, exist := m.cache.Get(ipToAddr(ip)); exist {
 		host := elm.(string)
 
 		// ensure host --> ip on head of linked list
@@ -43,21 +43,21 @@ func (m *memoryStore) GetByIP(ip net.IP) (string, bool) {
 
 // PutByIP implements store.PutByIP
 func (m *memoryStore) PutByIP(ip net.IP, host string) {
-	m.cache.Set(ipToUint(ip.To4()), host)
+	m.cache.Set(ipToAddr(ip), host)
 }
 
 // DelByIP implements store.DelByIP
 func (m *memoryStore) DelByIP(ip net.IP) {
-	ipNum := ipToUint(ip.To4())
-	if elm, exist := m.cache.Get(ipNum); exist {
+	addr := ipToAddr(ip)
+	if elm, exist := m.cache.Get(addr); exist {
 		m.cache.Delete(elm.(string))
 	}
-	m.cache.Delete(ipNum)
+	m.cache.Delete(addr)
 }
 
 // Exist implements store.Exist
 func (m *memoryStore) Exist(ip net.IP) bool {
-	return m.cache.Exist(ipToUint(ip.To4()))
+	return m.cache.Exist(ipToAddr(ip))
 }
 
 // CloneTo implements store.CloneTo
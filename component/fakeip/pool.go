@@ -1,12 +1,17 @@
 package fakeip
 
 import (
+	"encoding/binary"
 	"errors"
+	"math"
+	"math/bits"
 	"net"
+	"net/netip"
 	"strings"
 	"sync"
 
 	"github.com/Dreamacro/clash/common/cache"
+	"github.com/Dreamacro/clash/component/profile/cachefile"
 	"github.com/Dreamacro/clash/component/trie"
 )
 
@@ -20,16 +25,18 @@ type store interface {
 	CloneTo(store)
 }
 
-// Pool is an implementation about fake ip generator without storage
+// Pool is an implementation about fake ip generator without storage,
+// it allocates addresses of either IPv4 or IPv6 range
 type Pool struct {
-	max     uint32
-	min     uint32
-	gateway uint32
-	offset  uint32
-	mux     sync.Mutex
-	host    *trie.DomainTrie
-	ipnet   *net.IPNet
-	store   store
+	min     netip.Addr
+	gateway netip.Addr
+	// size is the number of addresses from min, offset is where get starts
+	size   uint64
+	offset uint64
+	mux    sync.Mutex
+	host   *trie.DomainTrie
+	ipnet  *net.IPNet
+	store  store
 }
 
 // Lookup return a fake ip with host
@@ -39,8 +46,9 @@ func (p *Pool) Lookup(host string) net.IP {
 
 	// RFC4343: DNS Case Insensitive, we SHOULD return result with all cases.
 	host = strings.ToLower(host)
-	if ip, exist := p.store.GetByHost(host); exist {
-		return ip
+	// a persisted ip may come from another fake-ip-range
+	if ip, exist := p.store.GetByHost(host); exist && p.ipnet.Contains(ip) {
+		return addrToIP(ipToAddr(ip))
 	}
 
 	ip := p.get(host)
@@ -53,7 +61,7 @@ func (p *Pool) LookBack(ip net.IP) (string, bool) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
 
-	if ip = ip.To4(); ip == nil {
+	if !p.ipnet.Contains(ip) {
 		return "", false
 	}
 
@@ -73,7 +81,7 @@ func (p *Pool) Exist(ip net.IP) bool {
 	p.mux.Lock()
 	defer p.mux.Unlock()
 
-	if ip = ip.To4(); ip == nil {
+	if !p.ipnet.Contains(ip) {
 		return false
 	}
 
@@ -82,7 +90,7 @@ func (p *Pool) Exist(ip net.IP) bool {
 
 // Gateway return gateway ip
 func (p *Pool) Gateway() net.IP {
-	return uintToIP(p.gateway)
+	return addrToIP(p.gateway)
 }
 
 // IPNet return raw ipnet
@@ -98,35 +106,50 @@ func (p *Pool) CloneFrom(o *Pool) {
 func (p *Pool) get(host string) net.IP {
 	current := p.offset
 	for {
-		ip := uintToIP(p.min + p.offset)
+		ip := p.ipAt(p.offset)
 		if !p.store.Exist(ip) {
 			break
 		}
 
-		p.offset = (p.offset + 1) % (p.max - p.min)
+		p.offset = (p.offset + 1) % p.size
 		// Avoid infinite loops
 		if p.offset == current {
-			p.offset = (p.offset + 1) % (p.max - p.min)
-			ip := uintToIP(p.min + p.offset)
-			p.store.DelByIP(ip)
+			p.offset = (p.offset + 1) % p.size
+			p.store.DelByIP(p.ipAt(p.offset))
 			break
 		}
 	}
-	ip := uintToIP(p.min + p.offset)
+	ip := p.ipAt(p.offset)
 	p.store.PutByIP(ip, host)
 	return ip
 }
 
-func ipToUint(ip net.IP) uint32 {
-	v := uint32(ip[0]) << 24
-	v += uint32(ip[1]) << 16
-	v += uint32(ip[2]) << 8
-	v += uint32(ip[3])
-	return v
+func (p *Pool) ipAt(offset uint64) net.IP {
+	return addrToIP(addAddr(p.min, offset))
 }
 
-func uintToIP(v uint32) net.IP {
-	return net.IP{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+func ipToAddr(ip net.IP) netip.Addr {
+	addr, _ := netip.AddrFromSlice(ip)
+	return addr.Unmap()
+}
+
+// addrToIP returns a 4 bytes net.IP for IPv4
+func addrToIP(addr netip.Addr) net.IP {
+	return addr.AsSlice()
+}
+
+// addAddr returns addr + n in 128-bit arithmetic
+func addAddr(addr netip.Addr, n uint64) netip.Addr {
+	b := addr.As16()
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo, carry := bits.Add64(binary.BigEndian.Uint64(b[8:]), n, 0)
+	binary.BigEndian.PutUint64(b[:8], hi+carry)
+	binary.BigEndian.PutUint64(b[8:], lo)
+
+	if addr.Is4() {
+		return netip.AddrFrom16(b).Unmap()
+	}
+	return netip.AddrFrom16(b)
 }
 
 type Options struct {
@@ -136,29 +159,44 @@ type Options struct {
 	// Size sets the maximum number of entries in memory
 	// and does not work if Persistence is true
 	Size int
+
+	// Persistence will save the data to disk.
+	// Size will not work and record will be fully stored.
+	Persistence bool
 }
 
 // New return Pool instance
 func New(options Options) (*Pool, error) {
-	min := ipToUint(options.IPNet.IP) + 2
-
-	ones, bits := options.IPNet.Mask.Size()
-	total := 1<<uint(bits-ones) - 2
-
-	if total <= 0 {
+	ones, size := options.IPNet.Mask.Size()
+	if size-ones < 2 {
 		return nil, errors.New("ipnet don't have valid ip")
 	}
 
-	max := min + uint32(total) - 1
+	// the network and gateway addresses are skipped, a /64 or larger IPv6
+	// range is capped by the uint64 offset
+	total := uint64(math.MaxUint64 - 1)
+	if size-ones < 64 {
+		total = 1<<uint(size-ones) - 2
+	}
+
+	gateway := addAddr(ipToAddr(options.IPNet.IP), 1)
 	pool := &Pool{
-		min:     min,
-		max:     max,
-		gateway: min - 1,
+		min:     gateway.Next(),
+		gateway: gateway,
+		size:    total,
 		host:    options.Host,
 		ipnet:   options.IPNet,
 	}
-	pool.store = &memoryStore{
-		cache: cache.New(cache.WithSize(options.Size * 2)),
+	// fall back to memory if the cache file can't be opened, e.g. it's locked by another instance
+	if options.Persistence && cachefile.Cache().DB != nil {
+		pool.store = &cachefileStore{
+			cache: cachefile.Cache(),
+			ipv6:  gateway.Is6(),
+		}
+	} else {
+		pool.store = &memoryStore{
+			cache: cache.New(cache.WithSize(options.Size * 2)),
+		}
 	}
 
 	return pool, nil
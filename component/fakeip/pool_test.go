@@ -0,0 +1,72 @@
+package fakeip
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPool_IPv6(t *testing.T) {
+	_, ipnet, _ := net.ParseCIDR("fdfe:dcba:9876::/64")
+	pool, err := New(Options{IPNet: ipnet, Size: 10})
+	require.NoError(t, err)
+
+	assert.Equal(t, net.ParseIP("fdfe:dcba:9876::1"), pool.Gateway())
+
+	first := pool.Lookup("foo.com")
+	second := pool.Lookup("bar.com")
+	assert.Equal(t, net.ParseIP("fdfe:dcba:9876::2"), first)
+	assert.Equal(t, net.ParseIP("fdfe:dcba:9876::3"), second)
+	assert.Equal(t, first, pool.Lookup("FOO.com"))
+
+	host, exist := pool.LookBack(second)
+	assert.True(t, exist)
+	assert.Equal(t, "bar.com", host)
+	assert.True(t, pool.Exist(first))
+
+	_, exist = pool.LookBack(net.IPv4(198, 18, 0, 2))
+	assert.False(t, exist)
+}
+
+func TestPool_IPv6Last(t *testing.T) {
+	for cidr, expected := range map[string]string{
+		"fdfe:dcba:9876::/64": "fdfe:dcba:9876:0:ffff:ffff:ffff:ffff",
+		"fdfe:dcba:9800::/56": "fdfe:dcba:9800:0:ffff:ffff:ffff:ffff",
+	} {
+		_, ipnet, _ := net.ParseCIDR(cidr)
+		pool, err := New(Options{IPNet: ipnet, Size: 10})
+		require.NoError(t, err)
+
+		last := pool.ipAt(pool.size - 1)
+		assert.True(t, ipnet.Contains(last), cidr)
+		assert.Equal(t, net.ParseIP(expected), last, cidr)
+	}
+}
+
+func TestPool_CycleUsed(t *testing.T) {
+	// 192.168.0.2 - 192.168.0.7
+	_, ipnet, _ := net.ParseCIDR("192.168.0.1/29")
+	pool, err := New(Options{IPNet: ipnet, Size: 10})
+	require.NoError(t, err)
+
+	assert.Equal(t, net.IP{192, 168, 0, 1}, pool.Gateway())
+	first := pool.Lookup("foo.com")
+	assert.Equal(t, net.IP{192, 168, 0, 2}, first)
+	for _, host := range []string{"a.com", "b.com", "c.com", "d.com"} {
+		pool.Lookup(host)
+	}
+	assert.Equal(t, net.IP{192, 168, 0, 7}, pool.Lookup("e.com"))
+
+	// the pool is exhausted, the first ip is reused
+	assert.Equal(t, first, pool.Lookup("g.com"))
+	host, exist := pool.LookBack(first)
+	assert.True(t, exist)
+	assert.Equal(t, "g.com", host)
+}
+
+func TestAddAddr(t *testing.T) {
+	assert.Equal(t, "fdfe:0:0:1::", addAddr(ipToAddr(net.ParseIP("fdfe::ffff:ffff:ffff:ffff")), 1).String())
+	assert.Equal(t, "10.0.1.0", addAddr(ipToAddr(net.IPv4(10, 0, 0, 255)), 1).String())
+}
@@ -0,0 +1,90 @@
+//go:build linux
+
+package ipset
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/Dreamacro/clash/common/cache"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// results are cached briefly, a rule may be evaluated many times for the same connection burst
+const cacheAge = 10
+
+var (
+	testCache   = cache.New(cache.WithAge(cacheAge), cache.WithSize(4096))
+	familyCache = cache.New(cache.WithAge(cacheAge), cache.WithSize(256))
+)
+
+// Test returns true if ip is in the ipset setName
+func Test(setName string, ip net.IP) (bool, error) {
+	if ip == nil {
+		return false, nil
+	}
+
+	key := setName + "/" + ip.String()
+	if exist, ok := testCache.Get(key); ok {
+		return exist.(bool), nil
+	}
+
+	family, err := setFamily(setName)
+	if err != nil {
+		return false, err
+	}
+
+	// the kernel rejects addresses of the other family as a protocol error
+	ipv4 := ip.To4()
+	if (family == unix.NFPROTO_IPV4) != (ipv4 != nil) {
+		testCache.Set(key, false)
+		return false, nil
+	}
+	if ipv4 != nil {
+		ip = ipv4
+	}
+
+	exist, err := netlink.IpsetTest(setName, &netlink.IPSetEntry{IP: ip})
+	if err != nil {
+		return false, wrapError(setName, err)
+	}
+
+	testCache.Set(key, exist)
+	return exist, nil
+}
+
+// Verify returns an error if the ipset setName doesn't exist or can't be read
+func Verify(setName string) error {
+	_, err := setFamily(setName)
+	return err
+}
+
+func setFamily(setName string) (uint8, error) {
+	if family, ok := familyCache.Get(setName); ok {
+		return family.(uint8), nil
+	}
+
+	result, err := netlink.IpsetList(setName)
+	if err != nil {
+		return 0, wrapError(setName, err)
+	}
+
+	familyCache.Set(setName, result.Family)
+	return result.Family, nil
+}
+
+func wrapError(setName string, err error) error {
+	switch {
+	case errors.Is(err, unix.ENOENT):
+		return fmt.Errorf("ipset %s doesn't exist", setName)
+	case errors.Is(err, unix.EPERM):
+		return fmt.Errorf("ipset %s: %w, CAP_NET_ADMIN is required", setName, err)
+	case errors.Is(err, unix.EPROTONOSUPPORT):
+		return fmt.Errorf("ipset %s: %w, is the ip_set kernel module loaded?", setName, err)
+	default:
+		return fmt.Errorf("ipset %s: %w", setName, err)
+	}
+}
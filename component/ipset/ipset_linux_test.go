@@ -0,0 +1,96 @@
+//go:build linux
+
+package ipset
+
+import (
+	"net"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// withNetns runs fn in a fresh network namespace, so the ipsets it creates don't leak to the host
+func withNetns(t *testing.T, fn func()) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origin, err := netns.Get()
+	require.NoError(t, err)
+	defer origin.Close()
+
+	ns, err := netns.New()
+	if err != nil {
+		t.Skipf("create network namespace: %s", err)
+	}
+	defer func() {
+		netns.Set(origin)
+		ns.Close()
+	}()
+
+	if err := netlink.IpsetCreate("probe", "hash:ip", netlink.IpsetCreateOptions{}); err != nil {
+		t.Skipf("ipset unavailable: %s", err)
+	}
+
+	fn()
+}
+
+func TestIPSet(t *testing.T) {
+	withNetns(t, func() {
+		require.NoError(t, netlink.IpsetCreate("test4", "hash:net", netlink.IpsetCreateOptions{}))
+		require.NoError(t, netlink.IpsetCreate("test6", "hash:ip", netlink.IpsetCreateOptions{Family: unix.NFPROTO_IPV6}))
+		require.NoError(t, netlink.IpsetAdd("test4", &netlink.IPSetEntry{IP: net.IPv4(10, 0, 0, 0).To4(), CIDR: 8}))
+		require.NoError(t, netlink.IpsetAdd("test6", &netlink.IPSetEntry{IP: net.ParseIP("2001:db8::1")}))
+
+		assert.NoError(t, Verify("test4"))
+		assert.EqualError(t, Verify("missing"), "ipset missing doesn't exist")
+
+		testCases := []struct {
+			set   string
+			ip    string
+			exist bool
+		}{
+			{"test4", "10.1.2.3", true},
+			{"test4", "11.1.2.3", false},
+			{"test4", "::ffff:10.0.0.1", true},
+			{"test4", "2001:db8::1", false},
+			{"test6", "2001:db8::1", true},
+			{"test6", "2001:db8::2", false},
+			{"test6", "10.1.2.3", false},
+		}
+
+		for _, tc := range testCases {
+			exist, err := Test(tc.set, net.ParseIP(tc.ip))
+			require.NoError(t, err)
+			assert.Equal(t, tc.exist, exist, "%s %s", tc.set, tc.ip)
+		}
+
+		exist, err := Test("test4", nil)
+		assert.NoError(t, err)
+		assert.False(t, exist)
+
+		_, err = Test("missing", net.ParseIP("10.1.2.3"))
+		assert.EqualError(t, err, "ipset missing doesn't exist")
+	})
+}
+
+func TestIPSet_Cache(t *testing.T) {
+	withNetns(t, func() {
+		require.NoError(t, netlink.IpsetCreate("cached", "hash:ip", netlink.IpsetCreateOptions{}))
+		require.NoError(t, netlink.IpsetAdd("cached", &netlink.IPSetEntry{IP: net.IPv4(1, 1, 1, 1).To4()}))
+
+		exist, err := Test("cached", net.IPv4(1, 1, 1, 1))
+		require.NoError(t, err)
+		assert.True(t, exist)
+
+		// the result is served from the cache until it expires
+		require.NoError(t, netlink.IpsetDel("cached", &netlink.IPSetEntry{IP: net.IPv4(1, 1, 1, 1).To4()}))
+		exist, err = Test("cached", net.IPv4(1, 1, 1, 1))
+		require.NoError(t, err)
+		assert.True(t, exist)
+	})
+}
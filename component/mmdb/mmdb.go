@@ -0,0 +1,105 @@
+package mmdb
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+var ErrNotLoaded = errors.New("mmdb not loaded")
+
+var (
+	reader *maxminddb.Reader
+	mux    sync.RWMutex
+	once   sync.Once
+)
+
+// Country is the part of a GeoIP2/GeoLite2 country record used by clash
+type Country struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// Load opens the mmdb at path and replaces the shared reader
+func Load(path string) error {
+	instance, err := maxminddb.Open(path)
+	if err != nil {
+		return err
+	}
+
+	replace(instance)
+	return nil
+}
+
+// LoadFromBytes replaces the shared reader with a mmdb in memory
+func LoadFromBytes(buffer []byte) error {
+	instance, err := maxminddb.FromBytes(buffer)
+	if err != nil {
+		return err
+	}
+
+	replace(instance)
+	return nil
+}
+
+// Reload reopens the mmdb at C.Path.MMDB(), e.g. after it has been updated on disk
+func Reload() error {
+	return Load(C.Path.MMDB())
+}
+
+// Verify checks if the file at path is a valid mmdb
+func Verify(path string) bool {
+	instance, err := maxminddb.Open(path)
+	if err == nil {
+		instance.Close()
+	}
+	return err == nil
+}
+
+// LookupCode returns the ISO country code of ip, the shared reader
+// is lazily loaded from C.Path.MMDB() on first use
+func LookupCode(ip net.IP) (string, error) {
+	once.Do(func() {
+		mux.RLock()
+		loaded := reader != nil
+		mux.RUnlock()
+		if loaded {
+			return
+		}
+
+		if err := Reload(); err != nil {
+			log.Errorln("Can't load mmdb: %s", err.Error())
+		}
+	})
+
+	mux.RLock()
+	defer mux.RUnlock()
+
+	if reader == nil {
+		return "", ErrNotLoaded
+	}
+
+	record := Country{}
+	if err := reader.Lookup(ip, &record); err != nil {
+		return "", err
+	}
+	return record.Country.ISOCode, nil
+}
+
+func replace(instance *maxminddb.Reader) {
+	mux.Lock()
+	old := reader
+	reader = instance
+	mux.Unlock()
+
+	// no lookup holds the old reader once the write lock has been acquired
+	if old != nil {
+		old.Close()
+	}
+}
@@ -0,0 +1,55 @@
+package mmdb
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Dreamacro/clash/component/mmdb/mmdbtest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupCode(t *testing.T) {
+	buf := mmdbtest.Build(t, map[string]string{
+		"1.0.1.0/24":     "CN",
+		"8.8.8.0/24":     "US",
+		"2400:cb00::/32": "US",
+	})
+	require.NoError(t, LoadFromBytes(buf))
+
+	code, err := LookupCode(net.ParseIP("1.0.1.1"))
+	require.NoError(t, err)
+	assert.Equal(t, "CN", code)
+
+	code, err = LookupCode(net.ParseIP("2400:cb00::1"))
+	require.NoError(t, err)
+	assert.Equal(t, "US", code)
+
+	code, err = LookupCode(net.ParseIP("9.9.9.9"))
+	require.NoError(t, err)
+	assert.Empty(t, code)
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Country.mmdb")
+	require.NoError(t, os.WriteFile(path, mmdbtest.Build(t, map[string]string{"8.8.8.0/24": "US"}), 0o644))
+	require.True(t, Verify(path))
+	require.NoError(t, Load(path))
+
+	code, err := LookupCode(net.ParseIP("8.8.8.8"))
+	require.NoError(t, err)
+	assert.Equal(t, "US", code)
+
+	// reload replaces the shared reader
+	require.NoError(t, os.WriteFile(path, mmdbtest.Build(t, map[string]string{"8.8.8.0/24": "JP"}), 0o644))
+	require.NoError(t, Load(path))
+
+	code, err = LookupCode(net.ParseIP("8.8.8.8"))
+	require.NoError(t, err)
+	assert.Equal(t, "JP", code)
+
+	assert.False(t, Verify(filepath.Join(t.TempDir(), "missing.mmdb")))
+}
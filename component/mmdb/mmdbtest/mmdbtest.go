@@ -0,0 +1,33 @@
+// Package mmdbtest builds GeoLite2-Country databases for tests.
+package mmdbtest
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/require"
+)
+
+// Build returns a country database that maps every CIDR of networks to its ISO code
+func Build(t testing.TB, networks map[string]string) []byte {
+	t.Helper()
+
+	writer, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: "GeoLite2-Country", RecordSize: 24})
+	require.NoError(t, err)
+
+	for cidr, code := range networks {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		require.NoError(t, writer.Insert(ipnet, mmdbtype.Map{
+			"country": mmdbtype.Map{"iso_code": mmdbtype.String(code)},
+		}))
+	}
+
+	buf := &bytes.Buffer{}
+	_, err = writer.WriteTo(buf)
+	require.NoError(t, err)
+	return buf.Bytes()
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package process
+
+import (
+	"net/netip"
+)
+
+func findProcess(network string, from netip.AddrPort, to netip.AddrPort) (*Process, error) {
+	path, err := findProcessPath(network, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Process{Path: path}, nil
+}
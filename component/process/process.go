@@ -19,3 +19,16 @@ const (
 func FindProcessPath(network string, from netip.AddrPort, to netip.AddrPort) (string, error) {
 	return findProcessPath(network, from, to)
 }
+
+// Process is the owner of a local socket
+type Process struct {
+	// Path is empty if the executable can't be found, e.g. the process has exited
+	Path string
+	// UID is nil on platforms without socket owner lookup
+	UID *uint32
+}
+
+// FindProcess looks up the owner of the local socket once for both process and UID rules
+func FindProcess(network string, from netip.AddrPort, to netip.AddrPort) (*Process, error) {
+	return findProcess(network, from, to)
+}
@@ -0,0 +1,247 @@
+package process
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/Dreamacro/clash/common/cache"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// sizeof(struct inet_diag_req_v2)
+	sizeOfDiagRequest = 56
+	// sizeof(struct inet_diag_msg)
+	sizeOfDiagMessage = 72
+
+	// results are cached briefly, a connection is matched once but a UDP socket is matched per NAT entry
+	cacheAge = 5
+)
+
+var (
+	socketCache = cache.New(cache.WithAge(cacheAge), cache.WithSize(1024))
+	inodeCache  = cache.New(cache.WithAge(cacheAge), cache.WithSize(1024))
+)
+
+type socketInfo struct {
+	inode uint32
+	uid   uint32
+}
+
+// inetDiagRequest is struct inet_diag_req_v2 of linux/inet_diag.h
+type inetDiagRequest struct {
+	family   uint8
+	protocol uint8
+	src      netip.AddrPort
+	dst      netip.AddrPort
+}
+
+func (r *inetDiagRequest) Len() int { return sizeOfDiagRequest }
+
+func (r *inetDiagRequest) Serialize() []byte {
+	b := make([]byte, sizeOfDiagRequest)
+	b[0] = r.family
+	b[1] = r.protocol
+	// all states
+	binary.NativeEndian.PutUint32(b[4:8], 0xffffffff)
+
+	// struct inet_diag_sockid, ports and addresses are in network byte order
+	binary.BigEndian.PutUint16(b[8:10], r.src.Port())
+	binary.BigEndian.PutUint16(b[10:12], r.dst.Port())
+	if r.family == unix.AF_INET {
+		src, dst := r.src.Addr().As4(), r.dst.Addr().As4()
+		copy(b[12:28], src[:])
+		copy(b[28:44], dst[:])
+	} else {
+		src, dst := r.src.Addr().As16(), r.dst.Addr().As16()
+		copy(b[12:28], src[:])
+		copy(b[28:44], dst[:])
+	}
+	binary.NativeEndian.PutUint32(b[48:52], nl.TCPDIAG_NOCOOKIE)
+	binary.NativeEndian.PutUint32(b[52:56], nl.TCPDIAG_NOCOOKIE)
+	return b
+}
+
+func findProcessPath(network string, from netip.AddrPort, to netip.AddrPort) (string, error) {
+	socket, err := resolveSocket(network, from, to)
+	if err != nil {
+		return "", err
+	}
+
+	return resolveProcessPath(socket)
+}
+
+func findProcess(network string, from netip.AddrPort, to netip.AddrPort) (*Process, error) {
+	socket, err := resolveSocket(network, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	uid := socket.uid
+	path, err := resolveProcessPath(socket)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	return &Process{Path: path, UID: &uid}, nil
+}
+
+func resolveSocket(network string, from netip.AddrPort, to netip.AddrPort) (*socketInfo, error) {
+	var protocol uint8
+	switch network {
+	case TCP:
+		protocol = unix.IPPROTO_TCP
+	case UDP:
+		protocol = unix.IPPROTO_UDP
+	default:
+		return nil, ErrInvalidNetwork
+	}
+
+	key := network + from.String() + to.String()
+	if socket, ok := socketCache.Get(key); ok {
+		return socket.(*socketInfo), nil
+	}
+
+	from = netip.AddrPortFrom(from.Addr().Unmap(), from.Port())
+	to = netip.AddrPortFrom(to.Addr().Unmap(), to.Port())
+
+	// the kernel looks up UDP sockets from the receiving side
+	// see also https://www.mail-archive.com/netdev@vger.kernel.org/msg248638.html
+	src, dst := from, to
+	if protocol == unix.IPPROTO_UDP {
+		src, dst = to, from
+	}
+
+	// an IPv4 connection may belong to a dual stack IPv6 socket
+	families := []uint8{unix.AF_INET6}
+	if from.Addr().Is4() {
+		families = []uint8{unix.AF_INET, unix.AF_INET6}
+	}
+
+	// a failure of one family is reported only if no family has the socket
+	var firstErr error
+	for _, family := range families {
+		request := &inetDiagRequest{
+			family:   family,
+			protocol: protocol,
+			src:      src,
+			dst:      dst,
+		}
+		if family == unix.AF_INET6 && from.Addr().Is4() {
+			request.src = netip.AddrPortFrom(netip.AddrFrom16(src.Addr().As16()), src.Port())
+			request.dst = netip.AddrPortFrom(netip.AddrFrom16(dst.Addr().As16()), dst.Port())
+		}
+
+		socket, err := querySocket(request)
+		if err != nil {
+			if firstErr == nil && !errors.Is(err, ErrNotFound) {
+				firstErr = err
+			}
+			continue
+		}
+
+		socketCache.Set(key, socket)
+		return socket, nil
+	}
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return nil, ErrNotFound
+}
+
+func querySocket(request *inetDiagRequest) (*socketInfo, error) {
+	req := nl.NewNetlinkRequest(nl.SOCK_DIAG_BY_FAMILY, 0)
+	req.AddData(request)
+
+	msgs, err := req.Execute(unix.NETLINK_INET_DIAG, nl.SOCK_DIAG_BY_FAMILY)
+	if errors.Is(err, unix.ENOENT) {
+		// the kernel has no socket of the addresses
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, msg := range msgs {
+		if len(msg) < sizeOfDiagMessage {
+			continue
+		}
+
+		return &socketInfo{
+			uid:   binary.NativeEndian.Uint32(msg[64:68]),
+			inode: binary.NativeEndian.Uint32(msg[68:72]),
+		}, nil
+	}
+
+	return nil, ErrNotFound
+}
+
+func resolveProcessPath(socket *socketInfo) (string, error) {
+	if path, ok := inodeCache.Get(socket.inode); ok {
+		return path.(string), nil
+	}
+
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return "", err
+	}
+
+	// the processes of the socket owner are scanned first, but a socket may be held
+	// by a process of another owner, e.g. a setuid process or a daemon that drops
+	// its privileges after opening the socket
+	var owned, others []string
+	for _, proc := range procs {
+		if !proc.IsDir() || !isPid(proc.Name()) {
+			continue
+		}
+
+		info, err := proc.Info()
+		if err != nil {
+			continue
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Uid == socket.uid {
+			owned = append(owned, proc.Name())
+		} else {
+			others = append(others, proc.Name())
+		}
+	}
+
+	target := fmt.Sprintf("socket:[%d]", socket.inode)
+	for _, pid := range append(owned, others...) {
+		processPath := filepath.Join("/proc", pid)
+		fds, err := os.ReadDir(filepath.Join(processPath, "fd"))
+		if err != nil {
+			continue
+		}
+
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(processPath, "fd", fd.Name()))
+			if err != nil || link != target {
+				continue
+			}
+
+			path, err := os.Readlink(filepath.Join(processPath, "exe"))
+			if err != nil {
+				return "", err
+			}
+
+			inodeCache.Set(socket.inode, path)
+			return path, nil
+		}
+	}
+
+	return "", ErrNotFound
+}
+
+func isPid(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 32)
+	return err == nil
+}
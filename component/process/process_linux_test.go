@@ -0,0 +1,87 @@
+package process
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dialTCP returns both sides of a TCP connection to l
+func dialTCP(t *testing.T, l net.Listener, network string) (client, server net.Conn) {
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	client, err := net.Dial(network, net.JoinHostPort("127.0.0.1", port))
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	server, err = l.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+	return client, server
+}
+
+func TestResolveSocket_IPv4MappedIPv6(t *testing.T) {
+	// a dual stack socket sees IPv4 peers as IPv4-mapped IPv6 addresses
+	l, err := net.Listen("tcp", "[::]:0")
+	if err != nil {
+		t.Skip("dual stack socket is not supported:", err)
+	}
+	defer l.Close()
+
+	_, server := dialTCP(t, l, "tcp4")
+	from, to := server.LocalAddr().(*net.TCPAddr).AddrPort(), server.RemoteAddr().(*net.TCPAddr).AddrPort()
+	require.True(t, from.Addr().Unmap().Is4())
+
+	socket, err := resolveSocket(TCP, from, to)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(os.Getuid()), socket.uid)
+
+	path, err := resolveProcessPath(socket)
+	require.NoError(t, err)
+	exePath, err := os.Executable()
+	require.NoError(t, err)
+	assert.Equal(t, exePath, path)
+
+	// there is no socket of either family
+	_, err = resolveSocket(TCP, netip.MustParseAddrPort("127.0.0.1:1"), netip.MustParseAddrPort("127.0.0.1:2"))
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestResolveProcessPath_OtherOwner(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	client, _ := dialTCP(t, l, "tcp")
+	socket, err := resolveSocket(TCP, client.LocalAddr().(*net.TCPAddr).AddrPort(), client.RemoteAddr().(*net.TCPAddr).AddrPort())
+	require.NoError(t, err)
+
+	// the process is found even if it isn't owned by the socket owner, as a
+	// daemon that drops its privileges after opening the socket
+	path, err := resolveProcessPath(&socketInfo{inode: socket.inode, uid: socket.uid + 1})
+	require.NoError(t, err)
+	exePath, err := os.Executable()
+	require.NoError(t, err)
+	assert.Equal(t, exePath, path)
+}
+
+func TestFindProcess(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	conn, _ := dialTCP(t, l, "tcp")
+	process, err := FindProcess(TCP, conn.LocalAddr().(*net.TCPAddr).AddrPort(), conn.RemoteAddr().(*net.TCPAddr).AddrPort())
+	require.NoError(t, err)
+
+	exePath, err := os.Executable()
+	require.NoError(t, err)
+
+	assert.Equal(t, exePath, process.Path)
+	require.NotNil(t, process.UID)
+	assert.Equal(t, uint32(os.Getuid()), *process.UID)
+}
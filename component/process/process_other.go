@@ -1,4 +1,4 @@
-//go:build !darwin && !windows && !freebsd
+//go:build !darwin && !windows && !freebsd && !linux
 
 package process
 
@@ -0,0 +1,184 @@
+package cachefile
+
+import (
+	"encoding/binary"
+	"os"
+	"sync"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	initOnce     sync.Once
+	fileMode     os.FileMode = 0o666
+	defaultCache *CacheFile
+
+	bucketDNS    = []byte("dns")
+	bucketFakeip = []byte("fakeip")
+)
+
+// CacheFile store and update the cache file
+type CacheFile struct {
+	DB *bbolt.DB
+}
+
+// Open opens the cache file at path, it's created if not exists
+func Open(path string) (*CacheFile, error) {
+	db, err := bbolt.Open(path, fileMode, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &CacheFile{DB: db}, nil
+}
+
+// DNSRecord is a cached DNS answer, Msg is the packed message
+type DNSRecord struct {
+	Key     string
+	Expires time.Time
+	Msg     []byte
+}
+
+// DNSCache returns the DNS answers saved by SetDNSCache
+func (c *CacheFile) DNSCache() []DNSRecord {
+	if c.DB == nil {
+		return nil
+	}
+
+	records := []DNSRecord{}
+	c.DB.View(func(t *bbolt.Tx) error {
+		bucket := t.Bucket(bucketDNS)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			if len(v) < 8 {
+				return nil
+			}
+			records = append(records, DNSRecord{
+				Key:     string(k),
+				Expires: time.Unix(int64(binary.BigEndian.Uint64(v)), 0),
+				Msg:     append([]byte{}, v[8:]...),
+			})
+			return nil
+		})
+	})
+	return records
+}
+
+// SetDNSCache replaces the saved DNS answers with records
+func (c *CacheFile) SetDNSCache(records []DNSRecord) error {
+	if c.DB == nil {
+		return nil
+	}
+
+	return c.DB.Update(func(t *bbolt.Tx) error {
+		if t.Bucket(bucketDNS) != nil {
+			if err := t.DeleteBucket(bucketDNS); err != nil {
+				return err
+			}
+		}
+		bucket, err := t.CreateBucket(bucketDNS)
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			value := binary.BigEndian.AppendUint64(make([]byte, 0, 8+len(record.Msg)), uint64(record.Expires.Unix()))
+			if err := bucket.Put([]byte(record.Key), append(value, record.Msg...)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetFakeip returns the value of key in the fake-ip bucket, it's nil if not exists
+func (c *CacheFile) GetFakeip(key []byte) []byte {
+	if c.DB == nil {
+		return nil
+	}
+
+	var value []byte
+	c.DB.View(func(t *bbolt.Tx) error {
+		if bucket := t.Bucket(bucketFakeip); bucket != nil {
+			if v := bucket.Get(key); v != nil {
+				value = append([]byte{}, v...)
+			}
+		}
+		return nil
+	})
+	return value
+}
+
+// PutFakeip saves a mapping of host to ip or ip to host
+func (c *CacheFile) PutFakeip(key, value []byte) error {
+	if c.DB == nil {
+		return nil
+	}
+
+	err := c.DB.Update(func(t *bbolt.Tx) error {
+		bucket, err := t.CreateBucketIfNotExists(bucketFakeip)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, value)
+	})
+	if err != nil {
+		log.Warnln("[CacheFile] write cache to %s failed: %s", c.DB.Path(), err.Error())
+	}
+	return err
+}
+
+// DelFakeipPair deletes the mapping of ip and host, host is skipped if it's empty
+func (c *CacheFile) DelFakeipPair(ip, host []byte) error {
+	if c.DB == nil {
+		return nil
+	}
+
+	err := c.DB.Update(func(t *bbolt.Tx) error {
+		bucket, err := t.CreateBucketIfNotExists(bucketFakeip)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Delete(ip); err != nil {
+			return err
+		}
+		if len(host) != 0 {
+			return bucket.Delete(host)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Warnln("[CacheFile] write cache to %s failed: %s", c.DB.Path(), err.Error())
+	}
+	return err
+}
+
+// Close closes the underlying database
+func (c *CacheFile) Close() error {
+	if c.DB == nil {
+		return nil
+	}
+	return c.DB.Close()
+}
+
+func initCache() {
+	cache, err := Open(C.Path.Cache())
+	if err != nil {
+		log.Warnln("[CacheFile] can't open cache file: %s", err.Error())
+		cache = &CacheFile{}
+	}
+	defaultCache = cache
+}
+
+// Cache return singleton of CacheFile at C.Path.Cache(), its methods are
+// no-op if the file can't be opened
+func Cache() *CacheFile {
+	initOnce.Do(initCache)
+
+	return defaultCache
+}
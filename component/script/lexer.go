@@ -0,0 +1,118 @@
+package script
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenInt
+	tokenString
+	tokenOperator
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value any
+	pos   int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of script"
+	}
+	return fmt.Sprintf("'%s'", t.text)
+}
+
+var singleQuoteReplacer = strings.NewReplacer(`\'`, `'`, `"`, `\"`)
+
+// operators are sorted by length so that the longest one wins
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "!", "<", ">", "(", ")", "[", "]", ",", "?", ":"}
+
+func isIdentByte(c byte, first bool) bool {
+	switch {
+	case c == '_', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		return true
+	case '0' <= c && c <= '9':
+		return !first
+	}
+	return false
+}
+
+func tokenize(code string) ([]token, error) {
+	tokens := []token{}
+	i := 0
+
+	for i < len(code) {
+		c := code[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#':
+			// comment until end of line
+			for i < len(code) && code[i] != '\n' {
+				i++
+			}
+		case isIdentByte(c, true):
+			start := i
+			for i < len(code) && isIdentByte(code[i], false) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: code[start:i], pos: start})
+		case '0' <= c && c <= '9':
+			start := i
+			for i < len(code) && '0' <= code[i] && code[i] <= '9' {
+				i++
+			}
+			n, err := strconv.ParseInt(code[start:i], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("offset %d: invalid number '%s'", start, code[start:i])
+			}
+			tokens = append(tokens, token{kind: tokenInt, text: code[start:i], value: n, pos: start})
+		case c == '"' || c == '\'':
+			start := i
+			i++
+			for i < len(code) && code[i] != c {
+				if code[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(code) {
+				return nil, fmt.Errorf("offset %d: unterminated string", start)
+			}
+			i++
+
+			literal := code[start:i]
+			if c == '\'' {
+				literal = `"` + singleQuoteReplacer.Replace(literal[1:len(literal)-1]) + `"`
+			}
+			s, err := strconv.Unquote(literal)
+			if err != nil {
+				return nil, fmt.Errorf("offset %d: invalid string %s", start, code[start:i])
+			}
+			tokens = append(tokens, token{kind: tokenString, text: code[start:i], value: s, pos: start})
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(code[i:], op) {
+					tokens = append(tokens, token{kind: tokenOperator, text: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("offset %d: unexpected character '%c'", i, c)
+			}
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(code)}), nil
+}
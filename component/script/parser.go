@@ -0,0 +1,386 @@
+package script
+
+import (
+	"fmt"
+
+	C "github.com/Dreamacro/clash/constant"
+)
+
+type kind int
+
+const (
+	kindBool kind = iota
+	kindInt
+	kindString
+	kindList
+)
+
+func (k kind) String() string {
+	switch k {
+	case kindBool:
+		return "bool"
+	case kindInt:
+		return "int"
+	case kindString:
+		return "string"
+	default:
+		return "list"
+	}
+}
+
+// node is a type checked expression, compiled into a closure over the metadata
+type node struct {
+	kind kind
+	// elem is the element kind of a list
+	elem kind
+	// constant holds the value of a literal, used by helpers that compile their arguments
+	constant any
+	// literals are the string literals the expression may evaluate to
+	literals []string
+	eval     func(m *C.Metadata) any
+}
+
+type parser struct {
+	tokens   []token
+	pos      int
+	compiler *compiler
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isOperator(op string) bool {
+	t := p.peek()
+	return t.kind == tokenOperator && t.text == op
+}
+
+func (p *parser) expect(op string) error {
+	t := p.next()
+	if t.kind != tokenOperator || t.text != op {
+		return fmt.Errorf("offset %d: expect '%s', got %s", t.pos, op, t)
+	}
+	return nil
+}
+
+func (p *parser) parse() (*node, error) {
+	n, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("offset %d: unexpected %s", t.pos, t)
+	}
+	return n, nil
+}
+
+// ternary := or ('?' ternary ':' ternary)?
+func (p *parser) parseTernary() (*node, error) {
+	pos := p.peek().pos
+	cond, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.isOperator("?") {
+		return cond, nil
+	}
+	p.next()
+
+	if cond.kind != kindBool {
+		return nil, fmt.Errorf("offset %d: condition must be bool, got %s", pos, cond.kind)
+	}
+
+	then, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+
+	if then.kind != otherwise.kind || then.elem != otherwise.elem {
+		return nil, fmt.Errorf("offset %d: mismatched types %s and %s", pos, then.kind, otherwise.kind)
+	}
+
+	return &node{
+		kind:     then.kind,
+		elem:     then.elem,
+		literals: append(append([]string{}, then.literals...), otherwise.literals...),
+		eval: func(m *C.Metadata) any {
+			if cond.eval(m).(bool) {
+				return then.eval(m)
+			}
+			return otherwise.eval(m)
+		},
+	}, nil
+}
+
+// or := and ('||' and)*
+func (p *parser) parseOr() (*node, error) {
+	return p.parseLogical("||", p.parseAnd)
+}
+
+// and := comparison ('&&' comparison)*
+func (p *parser) parseAnd() (*node, error) {
+	return p.parseLogical("&&", p.parseComparison)
+}
+
+func (p *parser) parseLogical(op string, operand func() (*node, error)) (*node, error) {
+	pos := p.peek().pos
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOperator(op) {
+		p.next()
+		rightPos := p.peek().pos
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+
+		if left.kind != kindBool {
+			return nil, fmt.Errorf("offset %d: operand of '%s' must be bool, got %s", pos, op, left.kind)
+		}
+		if right.kind != kindBool {
+			return nil, fmt.Errorf("offset %d: operand of '%s' must be bool, got %s", rightPos, op, right.kind)
+		}
+
+		l, r := left.eval, right.eval
+		if op == "&&" {
+			left = &node{kind: kindBool, eval: func(m *C.Metadata) any { return l(m).(bool) && r(m).(bool) }}
+		} else {
+			left = &node{kind: kindBool, eval: func(m *C.Metadata) any { return l(m).(bool) || r(m).(bool) }}
+		}
+	}
+
+	return left, nil
+}
+
+// comparison := unary (('==' | '!=' | '<' | '<=' | '>' | '>=' | 'in' | 'not' 'in') unary)?
+func (p *parser) parseComparison() (*node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	op := ""
+	switch {
+	case t.kind == tokenOperator:
+		switch t.text {
+		case "==", "!=", "<", "<=", ">", ">=":
+			op = t.text
+		}
+	case t.kind == tokenIdent && t.text == "in":
+		op = "in"
+	case t.kind == tokenIdent && t.text == "not":
+		p.next()
+		if nt := p.peek(); nt.kind != tokenIdent || nt.text != "in" {
+			return nil, fmt.Errorf("offset %d: expect 'in', got %s", nt.pos, nt)
+		}
+		op = "not in"
+	}
+	if op == "" {
+		return left, nil
+	}
+	p.next()
+
+	right, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	return compare(t.pos, op, left, right)
+}
+
+func compare(pos int, op string, left, right *node) (*node, error) {
+	l, r := left.eval, right.eval
+
+	switch op {
+	case "in", "not in":
+		if right.kind != kindList {
+			return nil, fmt.Errorf("offset %d: right operand of '%s' must be list, got %s", pos, op, right.kind)
+		}
+		if left.kind != right.elem {
+			return nil, fmt.Errorf("offset %d: mismatched types %s and list of %s", pos, left.kind, right.elem)
+		}
+
+		negate := op == "not in"
+		return &node{kind: kindBool, eval: func(m *C.Metadata) any {
+			v := l(m)
+			for _, item := range r(m).([]any) {
+				if item == v {
+					return !negate
+				}
+			}
+			return negate
+		}}, nil
+	case "==", "!=":
+		if left.kind != right.kind || left.kind == kindList {
+			return nil, fmt.Errorf("offset %d: mismatched types %s and %s", pos, left.kind, right.kind)
+		}
+
+		negate := op == "!="
+		return &node{kind: kindBool, eval: func(m *C.Metadata) any {
+			return (l(m) == r(m)) != negate
+		}}, nil
+	}
+
+	if left.kind != kindInt || right.kind != kindInt {
+		return nil, fmt.Errorf("offset %d: operands of '%s' must be int, got %s and %s", pos, op, left.kind, right.kind)
+	}
+
+	var cmp func(a, b int64) bool
+	switch op {
+	case "<":
+		cmp = func(a, b int64) bool { return a < b }
+	case "<=":
+		cmp = func(a, b int64) bool { return a <= b }
+	case ">":
+		cmp = func(a, b int64) bool { return a > b }
+	default:
+		cmp = func(a, b int64) bool { return a >= b }
+	}
+	return &node{kind: kindBool, eval: func(m *C.Metadata) any {
+		return cmp(l(m).(int64), r(m).(int64))
+	}}, nil
+}
+
+// unary := '!' unary | primary
+func (p *parser) parseUnary() (*node, error) {
+	if !p.isOperator("!") {
+		return p.parsePrimary()
+	}
+
+	t := p.next()
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if operand.kind != kindBool {
+		return nil, fmt.Errorf("offset %d: operand of '!' must be bool, got %s", t.pos, operand.kind)
+	}
+
+	eval := operand.eval
+	return &node{kind: kindBool, eval: func(m *C.Metadata) any { return !eval(m).(bool) }}, nil
+}
+
+// primary := int | string | 'true' | 'false' | ident | ident '(' args ')' | '(' ternary ')' | '[' list ']'
+func (p *parser) parsePrimary() (*node, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokenInt:
+		return constantNode(kindInt, t.value), nil
+	case tokenString:
+		return constantNode(kindString, t.value), nil
+	case tokenIdent:
+		switch t.text {
+		case "true":
+			return constantNode(kindBool, true), nil
+		case "false":
+			return constantNode(kindBool, false), nil
+		}
+
+		if p.isOperator("(") {
+			p.next()
+			args, err := p.parseList(")")
+			if err != nil {
+				return nil, err
+			}
+			return p.compiler.call(t, args)
+		}
+		return p.compiler.variable(t)
+	case tokenOperator:
+		switch t.text {
+		case "(":
+			n, err := p.parseTernary()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return n, nil
+		case "[":
+			items, err := p.parseList("]")
+			if err != nil {
+				return nil, err
+			}
+			return listNode(t.pos, items)
+		}
+	}
+
+	return nil, fmt.Errorf("offset %d: unexpected %s", t.pos, t)
+}
+
+// parseList parses comma separated expressions until the closing operator
+func (p *parser) parseList(closing string) ([]*node, error) {
+	items := []*node{}
+	if p.isOperator(closing) {
+		p.next()
+		return items, nil
+	}
+
+	for {
+		item, err := p.parseTernary()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+
+		if p.isOperator(",") {
+			p.next()
+			continue
+		}
+		if err := p.expect(closing); err != nil {
+			return nil, err
+		}
+		return items, nil
+	}
+}
+
+func constantNode(k kind, value any) *node {
+	n := &node{kind: k, constant: value, eval: func(*C.Metadata) any { return value }}
+	if s, ok := value.(string); ok {
+		n.literals = []string{s}
+	}
+	return n
+}
+
+func listNode(pos int, items []*node) (*node, error) {
+	if len(items) == 0 {
+		return nil, fmt.Errorf("offset %d: empty list", pos)
+	}
+
+	elem := items[0].kind
+	for _, item := range items {
+		if item.kind != elem || item.kind == kindList {
+			return nil, fmt.Errorf("offset %d: list items must be the same scalar type", pos)
+		}
+	}
+
+	return &node{kind: kindList, elem: elem, eval: func(m *C.Metadata) any {
+		values := make([]any, len(items))
+		for i, item := range items {
+			values[i] = item.eval(m)
+		}
+		return values
+	}}, nil
+}
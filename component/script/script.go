@@ -0,0 +1,235 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/Dreamacro/clash/component/mmdb"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/constant/provider"
+)
+
+// Program is a compiled script, it has no loops and no side effects
+// so it always terminates and is safe for concurrent use
+type Program struct {
+	root        *node
+	resolveIP   bool
+	findProcess bool
+	ruleSets    []provider.RuleProvider
+}
+
+// Run evaluates the script, the result is either a bool or a string
+func (p *Program) Run(metadata *C.Metadata) any {
+	return p.root.eval(metadata)
+}
+
+// Literals returns the string literals the script may return, a script may
+// also return a string computed from the connection
+func (p *Program) Literals() []string {
+	return p.root.literals
+}
+
+// ShouldResolveIP returns true if the script reads the destination IP
+func (p *Program) ShouldResolveIP() bool {
+	if p.resolveIP {
+		return true
+	}
+	for _, rp := range p.ruleSets {
+		if rp.ShouldResolveIP() {
+			return true
+		}
+	}
+	return false
+}
+
+// ShouldFindProcess returns true if the script reads the process
+func (p *Program) ShouldFindProcess() bool {
+	if p.findProcess {
+		return true
+	}
+	for _, rp := range p.ruleSets {
+		if rp.ShouldFindProcess() {
+			return true
+		}
+	}
+	return false
+}
+
+// Compile parses and type checks code, rule_set() may refer to any of ruleProviders
+func Compile(code string, ruleProviders map[string]provider.RuleProvider) (*Program, error) {
+	tokens, err := tokenize(code)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &compiler{
+		program:       &Program{},
+		ruleProviders: ruleProviders,
+	}
+	p := &parser{tokens: tokens, compiler: c}
+	root, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	if root.kind != kindBool && root.kind != kindString {
+		return nil, fmt.Errorf("script must return bool or string, got %s", root.kind)
+	}
+
+	c.program.root = root
+	return c.program, nil
+}
+
+type compiler struct {
+	program       *Program
+	ruleProviders map[string]provider.RuleProvider
+}
+
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+func (c *compiler) variable(t token) (*node, error) {
+	var (
+		k    kind
+		eval func(m *C.Metadata) any
+	)
+
+	switch t.text {
+	case "network":
+		k, eval = kindString, func(m *C.Metadata) any { return m.NetWork.String() }
+	case "type":
+		k, eval = kindString, func(m *C.Metadata) any { return m.Type.String() }
+	case "host":
+		k, eval = kindString, func(m *C.Metadata) any { return m.Host }
+	case "src_ip":
+		k, eval = kindString, func(m *C.Metadata) any { return ipString(m.SrcIP) }
+	case "dst_ip":
+		c.program.resolveIP = true
+		k, eval = kindString, func(m *C.Metadata) any { return ipString(m.DstIP) }
+	case "src_port":
+		k, eval = kindInt, func(m *C.Metadata) any { return int64(m.SrcPort) }
+	case "dst_port":
+		k, eval = kindInt, func(m *C.Metadata) any { return int64(m.DstPort) }
+	case "process_path":
+		c.program.findProcess = true
+		k, eval = kindString, func(m *C.Metadata) any { return m.ProcessPath }
+	case "process_name":
+		c.program.findProcess = true
+		k, eval = kindString, func(m *C.Metadata) any {
+			if m.ProcessPath == "" {
+				return ""
+			}
+			return filepath.Base(m.ProcessPath)
+		}
+	default:
+		return nil, fmt.Errorf("offset %d: unknown variable '%s'", t.pos, t.text)
+	}
+
+	return &node{kind: k, eval: eval}, nil
+}
+
+func (c *compiler) call(t token, args []*node) (*node, error) {
+	builtin, ok := builtins[t.text]
+	if !ok {
+		return nil, fmt.Errorf("offset %d: unknown function '%s'", t.pos, t.text)
+	}
+
+	if len(args) != len(builtin.args) {
+		return nil, fmt.Errorf("offset %d: %s() takes %d arguments, got %d", t.pos, t.text, len(builtin.args), len(args))
+	}
+	for i, arg := range args {
+		if arg.kind != builtin.args[i] {
+			return nil, fmt.Errorf("offset %d: argument %d of %s() must be %s, got %s", t.pos, i+1, t.text, builtin.args[i], arg.kind)
+		}
+	}
+
+	n, err := builtin.compile(c, args)
+	if err != nil {
+		return nil, fmt.Errorf("offset %d: %s(): %w", t.pos, t.text, err)
+	}
+	return n, nil
+}
+
+type builtin struct {
+	args    []kind
+	compile func(c *compiler, args []*node) (*node, error)
+}
+
+var builtins = map[string]builtin{
+	// geoip(ip) returns the ISO country code of ip, or "" if unknown
+	"geoip": {
+		args: []kind{kindString},
+		compile: func(c *compiler, args []*node) (*node, error) {
+			ip := args[0].eval
+			return &node{kind: kindString, eval: func(m *C.Metadata) any {
+				parsed := net.ParseIP(ip(m).(string))
+				if parsed == nil {
+					return ""
+				}
+				code, err := mmdb.LookupCode(parsed)
+				if err != nil {
+					return ""
+				}
+				return code
+			}}, nil
+		},
+	},
+	// cidr(ip, "10.0.0.0/8") returns true if ip is in the CIDR
+	"cidr": {
+		args: []kind{kindString, kindString},
+		compile: func(c *compiler, args []*node) (*node, error) {
+			s, ok := args[1].constant.(string)
+			if !ok {
+				return nil, errors.New("CIDR must be a string literal")
+			}
+			_, ipnet, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, err
+			}
+
+			ip := args[0].eval
+			return &node{kind: kindBool, eval: func(m *C.Metadata) any {
+				parsed := net.ParseIP(ip(m).(string))
+				return parsed != nil && ipnet.Contains(parsed)
+			}}, nil
+		},
+	},
+	// suffix(host, "example.com") matches example.com and all its subdomains
+	"suffix": {
+		args: []kind{kindString, kindString},
+		compile: func(c *compiler, args []*node) (*node, error) {
+			host, suffix := args[0].eval, args[1].eval
+			return &node{kind: kindBool, eval: func(m *C.Metadata) any {
+				h := strings.ToLower(host(m).(string))
+				s := strings.ToLower(suffix(m).(string))
+				return h == s || strings.HasSuffix(h, "."+s)
+			}}, nil
+		},
+	},
+	// rule_set("name") returns true if the rule provider matches the connection
+	"rule_set": {
+		args: []kind{kindString},
+		compile: func(c *compiler, args []*node) (*node, error) {
+			name, ok := args[0].constant.(string)
+			if !ok {
+				return nil, errors.New("rule provider name must be a string literal")
+			}
+			rp, ok := c.ruleProviders[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown rule provider '%s'", name)
+			}
+
+			c.program.ruleSets = append(c.program.ruleSets, rp)
+			return &node{kind: kindBool, eval: func(m *C.Metadata) any {
+				return rp.Match(m)
+			}}, nil
+		},
+	},
+}
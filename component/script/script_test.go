@@ -0,0 +1,129 @@
+package script
+
+import (
+	"net"
+	"testing"
+
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/constant/provider"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockRuleProvider struct {
+	provider.RuleProvider
+	host string
+}
+
+func (m *mockRuleProvider) Match(metadata *C.Metadata) bool { return metadata.Host == m.host }
+func (m *mockRuleProvider) ShouldResolveIP() bool           { return false }
+func (m *mockRuleProvider) ShouldFindProcess() bool         { return true }
+
+func TestRun(t *testing.T) {
+	metadata := &C.Metadata{
+		NetWork:     C.UDP,
+		Type:        C.SOCKS5,
+		SrcIP:       net.ParseIP("10.1.2.3"),
+		DstIP:       net.ParseIP("1.1.1.1"),
+		SrcPort:     51234,
+		DstPort:     443,
+		Host:        "www.Example.com",
+		ProcessPath: "/usr/bin/chrome",
+	}
+	ruleProviders := map[string]provider.RuleProvider{
+		"ads": &mockRuleProvider{host: "www.Example.com"},
+	}
+
+	testCases := []struct {
+		code     string
+		expected any
+	}{
+		{`network == "udp" && dst_port == 443 && cidr(src_ip, "10.0.0.0/8") && process_name != "chrome"`, false},
+		{`network == "udp" && dst_port == 443 && cidr(src_ip, "10.0.0.0/8")`, true},
+		{`!(dst_port < 1024) || src_port >= 50000`, true},
+		{`dst_port in [80, 443] && type == 'Socks5'`, true},
+		{`host not in ["a.com", "b.com"]`, true},
+		{`suffix(host, "example.com") ? "JP" : ""`, "JP"},
+		{`suffix(host, "ample.com") ? "JP" : "DIRECT"`, "DIRECT"},
+		{`process_path == "/usr/bin/chrome"`, true},
+		{`rule_set("ads")`, true},
+		{`geoip(dst_ip) == ""`, true},
+		{"# comment\ndst_ip == \"1.1.1.1\"", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.code, func(t *testing.T) {
+			program, err := Compile(tc.code, ruleProviders)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, program.Run(metadata))
+		})
+	}
+}
+
+func TestShould(t *testing.T) {
+	ruleProviders := map[string]provider.RuleProvider{
+		"ads": &mockRuleProvider{},
+	}
+
+	program, err := Compile(`host == "example.com"`, nil)
+	require.NoError(t, err)
+	assert.False(t, program.ShouldResolveIP())
+	assert.False(t, program.ShouldFindProcess())
+
+	program, err = Compile(`dst_ip == "1.1.1.1" && process_name == "curl"`, nil)
+	require.NoError(t, err)
+	assert.True(t, program.ShouldResolveIP())
+	assert.True(t, program.ShouldFindProcess())
+
+	program, err = Compile(`rule_set("ads")`, ruleProviders)
+	require.NoError(t, err)
+	assert.False(t, program.ShouldResolveIP())
+	assert.True(t, program.ShouldFindProcess())
+}
+
+func TestLiterals(t *testing.T) {
+	testCases := []struct {
+		code     string
+		expected []string
+	}{
+		{`dst_port == 443 ? (network == "udp" ? "JP" : "HK") : ""`, []string{"JP", "HK", ""}},
+		{`"DIRECT"`, []string{"DIRECT"}},
+		{`host == "example.com" ? "JP" : host`, []string{"JP"}},
+		{`host == "example.com"`, nil},
+	}
+
+	for _, tc := range testCases {
+		program, err := Compile(tc.code, nil)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, program.Literals(), tc.code)
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	testCases := []struct {
+		code     string
+		expected string
+	}{
+		{`dst_port == "443"`, "offset 9: mismatched types int and string"},
+		{`dst_port`, "script must return bool or string, got int"},
+		{`host && true`, "offset 0: operand of '&&' must be bool, got string"},
+		{`unknown == 1`, "offset 0: unknown variable 'unknown'"},
+		{`exec("rm")`, "offset 0: unknown function 'exec'"},
+		{`cidr(src_ip, host)`, "offset 0: cidr(): CIDR must be a string literal"},
+		{`cidr(src_ip)`, "offset 0: cidr() takes 2 arguments, got 1"},
+		{`rule_set("ads")`, "offset 0: rule_set(): unknown rule provider 'ads'"},
+		{`dst_port in [80, "443"]`, "offset 12: list items must be the same scalar type"},
+		{`true ? "JP" : false`, "offset 0: mismatched types string and bool"},
+		{`(true`, "offset 5: expect ')', got end of script"},
+		{`host == "a`, "offset 8: unterminated string"},
+		{`host = "a"`, "offset 5: unexpected character '='"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.code, func(t *testing.T) {
+			_, err := Compile(tc.code, nil)
+			assert.EqualError(t, err, tc.expected)
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package sniffer
+
+import (
+	"bytes"
+	"net"
+	"strings"
+)
+
+var methods = [...]string{"GET", "POST", "HEAD", "PUT", "DELETE", "OPTIONS", "CONNECT", "PATCH", "TRACE"}
+
+// SniffHTTP returns the Host header of an HTTP/1.x request
+func SniffHTTP(b []byte) (string, error) {
+	method, _, found := bytes.Cut(b, []byte{' '})
+	if !found {
+		for _, m := range methods {
+			if strings.HasPrefix(m, string(b)) {
+				return "", ErrIncomplete
+			}
+		}
+		return "", ErrNotMatch
+	}
+
+	known := false
+	for _, m := range methods {
+		if string(method) == m {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return "", ErrNotMatch
+	}
+
+	header, _, complete := bytes.Cut(b, []byte("\r\n\r\n"))
+	lines := strings.Split(string(header), "\r\n")
+	// the last line may be cut in the middle
+	if !complete {
+		lines = lines[:len(lines)-1]
+	}
+
+	for _, line := range lines[min(1, len(lines)):] {
+		key, value, found := strings.Cut(line, ":")
+		if !found || !strings.EqualFold(strings.TrimSpace(key), "Host") {
+			continue
+		}
+
+		host := strings.TrimSpace(value)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.ToLower(strings.Trim(host, "[]"))
+		if host == "" || net.ParseIP(host) != nil {
+			return "", ErrNoHost
+		}
+		return host, nil
+	}
+
+	if !complete {
+		return "", ErrIncomplete
+	}
+	return "", ErrNoHost
+}
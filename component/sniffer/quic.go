@@ -0,0 +1,286 @@
+package sniffer
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"sort"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	quicVersion1 = 0x00000001
+	quicVersion2 = 0x6b3343cf
+
+	// the largest ClientHello that is reassembled
+	maxCryptoLength = 64 * 1024
+
+	frameTypePadding = 0x00
+	frameTypePing    = 0x01
+	frameTypeAck     = 0x02
+	frameTypeAckECN  = 0x03
+	frameTypeCrypto  = 0x06
+)
+
+type quicVersion struct {
+	salt        []byte
+	initialType byte
+	keyLabel    string
+	ivLabel     string
+	hpLabel     string
+}
+
+// initial salts and labels of RFC 9001 and RFC 9369
+var quicVersions = map[uint32]quicVersion{
+	quicVersion1: {
+		salt:        []byte{0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17, 0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a},
+		initialType: 0b00,
+		keyLabel:    "quic key",
+		ivLabel:     "quic iv",
+		hpLabel:     "quic hp",
+	},
+	quicVersion2: {
+		salt:        []byte{0x0d, 0xed, 0xe3, 0xde, 0xf7, 0x00, 0xa6, 0xdb, 0x81, 0x93, 0x81, 0xbe, 0x6e, 0x26, 0x9d, 0xcb, 0xf9, 0xbd, 0x2e, 0xd9},
+		initialType: 0b01,
+		keyLabel:    "quicv2 key",
+		ivLabel:     "quicv2 iv",
+		hpLabel:     "quicv2 hp",
+	},
+}
+
+type cryptoFrame struct {
+	offset uint64
+	data   []byte
+}
+
+// QUICSniffer reassembles the ClientHello carried by the Initial packets of a
+// flow, the ClientHello of a post-quantum key share spans several packets.
+type QUICSniffer struct {
+	frames []cryptoFrame
+}
+
+// Sniff decrypts an Initial packet of the client and returns the SNI once enough
+// CRYPTO frames are collected, ErrIncomplete asks for the next packet.
+func (s *QUICSniffer) Sniff(packet []byte) (string, error) {
+	frames, err := decryptInitial(packet)
+	if err != nil {
+		return "", err
+	}
+	for _, frame := range frames {
+		if frame.offset+uint64(len(frame.data)) > maxCryptoLength {
+			return "", ErrNotMatch
+		}
+	}
+	// a flow is sniffed from the start of its handshake
+	if len(s.frames) == 0 && !hasOffsetZero(frames) {
+		return "", ErrNotMatch
+	}
+	s.frames = append(s.frames, frames...)
+
+	return parseClientHello(s.assemble())
+}
+
+// assemble returns the contiguous CRYPTO stream from offset 0
+func (s *QUICSniffer) assemble() []byte {
+	sort.SliceStable(s.frames, func(i, j int) bool {
+		return s.frames[i].offset < s.frames[j].offset
+	})
+
+	buf := []byte{}
+	for _, frame := range s.frames {
+		end := frame.offset + uint64(len(frame.data))
+		if frame.offset > uint64(len(buf)) {
+			break
+		}
+		if end > uint64(len(buf)) {
+			buf = append(buf, frame.data[uint64(len(buf))-frame.offset:]...)
+		}
+	}
+	return buf
+}
+
+func hasOffsetZero(frames []cryptoFrame) bool {
+	for _, frame := range frames {
+		if frame.offset == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// SniffQUIC returns the SNI of a ClientHello that fits in one Initial packet
+func SniffQUIC(packet []byte) (string, error) {
+	return (&QUICSniffer{}).Sniff(packet)
+}
+
+// decryptInitial removes the protection of the Initial packet at the start of
+// a datagram and returns its CRYPTO frames, the packet is not modified.
+func decryptInitial(packet []byte) ([]cryptoFrame, error) {
+	r := reader(packet)
+	first, ok := r.uint8()
+	if !ok {
+		return nil, ErrNotMatch
+	}
+	// long header with the fixed bit
+	if first&0xc0 != 0xc0 || len(r) < 4 {
+		return nil, ErrNotMatch
+	}
+	version, ok := quicVersions[binary.BigEndian.Uint32(r)]
+	if !ok || (first&0x30)>>4 != version.initialType {
+		return nil, ErrNotMatch
+	}
+	r.skip(4)
+
+	dcid, ok := r.vector8()
+	if !ok || len(dcid) > 20 {
+		return nil, ErrNotMatch
+	}
+	if scid, ok := r.vector8(); !ok || len(scid) > 20 {
+		return nil, ErrNotMatch
+	}
+	tokenLength, ok := r.varint()
+	if !ok || tokenLength > uint64(len(r)) {
+		return nil, ErrNotMatch
+	}
+	r.skip(int(tokenLength))
+	length, ok := r.varint()
+	if !ok || uint64(len(r)) < length || length < 4+16 {
+		return nil, ErrNotMatch
+	}
+	pnOffset := len(packet) - len(r)
+
+	key, iv, hp := initialKeys(version, dcid)
+
+	// header protection, the sample starts 4 bytes after the packet number
+	block, err := aes.NewCipher(hp)
+	if err != nil {
+		return nil, err
+	}
+	mask := make([]byte, aes.BlockSize)
+	block.Encrypt(mask, packet[pnOffset+4:pnOffset+4+16])
+
+	header := append([]byte{}, packet[:pnOffset+4]...)
+	header[0] ^= mask[0] & 0x0f
+	pnLength := int(header[0]&0x03) + 1
+	header = header[:pnOffset+pnLength]
+	var pn uint64
+	for i := 0; i < pnLength; i++ {
+		header[pnOffset+i] ^= mask[1+i]
+		pn = pn<<8 | uint64(header[pnOffset+i])
+	}
+
+	// the client starts from packet number 0, so the truncated number is the full one
+	nonce := append([]byte{}, iv...)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * i))
+	}
+
+	block, err = aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := aead.Open(nil, nonce, packet[pnOffset+pnLength:pnOffset+int(length)], header)
+	if err != nil {
+		return nil, ErrNotMatch
+	}
+
+	return parseCryptoFrames(payload)
+}
+
+func initialKeys(version quicVersion, dcid []byte) (key, iv, hp []byte) {
+	initialSecret := hkdf.Extract(crypto.SHA256.New, dcid, version.salt)
+	clientSecret := expandLabel(initialSecret, "client in", crypto.SHA256.Size())
+	return expandLabel(clientSecret, version.keyLabel, 16),
+		expandLabel(clientSecret, version.ivLabel, 12),
+		expandLabel(clientSecret, version.hpLabel, 16)
+}
+
+// expandLabel is HKDF-Expand-Label of TLS 1.3 with an empty context
+func expandLabel(secret []byte, label string, length int) []byte {
+	label = "tls13 " + label
+	info := make([]byte, 0, 4+len(label))
+	info = binary.BigEndian.AppendUint16(info, uint16(length))
+	info = append(info, byte(len(label)))
+	info = append(info, label...)
+	info = append(info, 0)
+
+	out := make([]byte, length)
+	hkdf.Expand(crypto.SHA256.New, secret, info).Read(out)
+	return out
+}
+
+// parseCryptoFrames walks the frames allowed in an Initial packet
+func parseCryptoFrames(payload []byte) ([]cryptoFrame, error) {
+	r := reader(payload)
+	frames := []cryptoFrame{}
+	for len(r) > 0 {
+		frameType, ok := r.varint()
+		if !ok {
+			return nil, ErrNotMatch
+		}
+		switch frameType {
+		case frameTypePadding, frameTypePing:
+		case frameTypeAck, frameTypeAckECN:
+			// largest acknowledged, ack delay, range count, first range
+			values := make([]uint64, 4)
+			for i := range values {
+				v, ok := r.varint()
+				if !ok {
+					return nil, ErrNotMatch
+				}
+				values[i] = v
+			}
+			skip := values[2] * 2
+			if frameType == frameTypeAckECN {
+				skip += 3
+			}
+			for i := uint64(0); i < skip; i++ {
+				if _, ok := r.varint(); !ok {
+					return nil, ErrNotMatch
+				}
+			}
+		case frameTypeCrypto:
+			offset, ok := r.varint()
+			if !ok {
+				return nil, ErrNotMatch
+			}
+			length, ok := r.varint()
+			if !ok || uint64(len(r)) < length {
+				return nil, ErrNotMatch
+			}
+			frames = append(frames, cryptoFrame{offset: offset, data: r[:length]})
+			r.skip(int(length))
+		default:
+			// CONNECTION_CLOSE or a frame not allowed in Initial packets
+			return nil, ErrNotMatch
+		}
+	}
+
+	if len(frames) == 0 {
+		return nil, ErrNotMatch
+	}
+	return frames, nil
+}
+
+// varint reads a variable-length integer of RFC 9000
+func (r *reader) varint() (uint64, bool) {
+	if len(*r) < 1 {
+		return 0, false
+	}
+	length := 1 << ((*r)[0] >> 6)
+	if len(*r) < length {
+		return 0, false
+	}
+	v := uint64((*r)[0] & 0x3f)
+	for _, b := range (*r)[1:length] {
+		v = v<<8 | uint64(b)
+	}
+	*r = (*r)[length:]
+	return v, true
+}
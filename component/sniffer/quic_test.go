@@ -0,0 +1,143 @@
+package sniffer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func cryptoFrameBytes(offset int, data []byte) []byte {
+	b := []byte{frameTypeCrypto}
+	b = binary.BigEndian.AppendUint16(b, 0x4000|uint16(offset))
+	b = binary.BigEndian.AppendUint16(b, 0x4000|uint16(len(data)))
+	return append(b, data...)
+}
+
+// sealInitial protects payload as a client Initial packet with a 2 bytes packet number
+func sealInitial(t *testing.T, v uint32, dcid []byte, pn uint16, payload []byte) []byte {
+	version := quicVersions[v]
+	key, iv, hp := initialKeys(version, dcid)
+
+	header := []byte{0xc0 | version.initialType<<4 | 0x01}
+	header = binary.BigEndian.AppendUint32(header, v)
+	header = append(header, byte(len(dcid)))
+	header = append(header, dcid...)
+	header = append(header, 0, 0) // empty scid and token
+	header = binary.BigEndian.AppendUint16(header, 0x4000|uint16(2+len(payload)+16))
+	pnOffset := len(header)
+	header = binary.BigEndian.AppendUint16(header, pn)
+
+	nonce := append([]byte{}, iv...)
+	nonce[len(nonce)-1] ^= byte(pn)
+	nonce[len(nonce)-2] ^= byte(pn >> 8)
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	aead, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+	packet := aead.Seal(header, nonce, payload, header)
+
+	block, err = aes.NewCipher(hp)
+	require.NoError(t, err)
+	mask := make([]byte, aes.BlockSize)
+	block.Encrypt(mask, packet[pnOffset+4:pnOffset+4+16])
+	packet[0] ^= mask[0] & 0x0f
+	packet[pnOffset] ^= mask[1]
+	packet[pnOffset+1] ^= mask[2]
+	return packet
+}
+
+func TestQUIC_InitialKeys(t *testing.T) {
+	// RFC 9001 A.1 and RFC 9369 A.1
+	dcid, _ := hex.DecodeString("8394c8f03e515708")
+
+	key, iv, hp := initialKeys(quicVersions[quicVersion1], dcid)
+	assert.Equal(t, "1f369613dd76d5467730efcbe3b1a22d", hex.EncodeToString(key))
+	assert.Equal(t, "fa044b2f42a3fd3b46fb255c", hex.EncodeToString(iv))
+	assert.Equal(t, "9f50449e04a0e810283a1e9933adedd2", hex.EncodeToString(hp))
+
+	key, iv, hp = initialKeys(quicVersions[quicVersion2], dcid)
+	assert.Equal(t, "8b1a0bc121284290a29e0971b5cd045d", hex.EncodeToString(key))
+	assert.Equal(t, "91f73e2351d8fa91660e909f", hex.EncodeToString(iv))
+	assert.Equal(t, "45b95e15235d6f45a6b19cbcb0294ba9", hex.EncodeToString(hp))
+}
+
+func TestSniffQUIC(t *testing.T) {
+	hello := clientHello(t, "www.example.com")[5:]
+	dcid := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	// frames out of order with PING and PADDING between them, like chrome does
+	payload := cryptoFrameBytes(100, hello[100:])
+	payload = append(payload, frameTypePing)
+	payload = append(payload, cryptoFrameBytes(0, hello[:100])...)
+	payload = append(payload, make([]byte, 64)...)
+
+	for _, version := range []uint32{quicVersion1, quicVersion2} {
+		packet := sealInitial(t, version, dcid, 0, payload)
+		origin := append([]byte{}, packet...)
+
+		host, err := SniffQUIC(packet)
+		require.NoError(t, err)
+		assert.Equal(t, "www.example.com", host)
+		assert.Equal(t, origin, packet, "packet must not be modified")
+
+		packet[len(packet)-1] ^= 0xff
+		_, err = SniffQUIC(packet)
+		assert.ErrorIs(t, err, ErrNotMatch)
+	}
+
+	_, err := SniffQUIC([]byte{0x40, 0x01, 0x02})
+	assert.ErrorIs(t, err, ErrNotMatch)
+}
+
+func TestQUICSniffer_Split(t *testing.T) {
+	hello := clientHello(t, "www.example.com")[5:]
+	dcid := []byte{8, 7, 6, 5, 4, 3, 2, 1}
+
+	first := sealInitial(t, quicVersion1, dcid, 0, cryptoFrameBytes(0, hello[:40]))
+	second := sealInitial(t, quicVersion1, dcid, 1, cryptoFrameBytes(40, hello[40:]))
+
+	s := &QUICSniffer{}
+	_, err := s.Sniff(first)
+	assert.ErrorIs(t, err, ErrIncomplete)
+	host, err := s.Sniff(second)
+	require.NoError(t, err)
+	assert.Equal(t, "www.example.com", host)
+
+	// the rest of a ClientHello doesn't start a flow
+	_, err = (&QUICSniffer{}).Sniff(second)
+	assert.ErrorIs(t, err, ErrNotMatch)
+}
+
+func TestDispatcher_UDPSession(t *testing.T) {
+	dispatcher := NewDispatcher(&Config{
+		Enable: true,
+		Ports:  map[Type][]PortRange{QUIC: {{Start: 443, End: 443}}},
+	})
+	require.NotNil(t, dispatcher)
+	assert.Nil(t, dispatcher.NewUDPSession(&C.Metadata{DstPort: 53}))
+
+	metadata := &C.Metadata{DstPort: 443}
+	session := dispatcher.NewUDPSession(metadata)
+	require.NotNil(t, session)
+
+	hello := clientHello(t, "www.example.com")[5:]
+	dcid := []byte{1, 1, 2, 2}
+	assert.ErrorIs(t, session.Sniff(sealInitial(t, quicVersion2, dcid, 0, cryptoFrameBytes(0, hello[:40])), metadata), ErrIncomplete)
+	require.NoError(t, session.Sniff(sealInitial(t, quicVersion2, dcid, 1, cryptoFrameBytes(40, hello[40:])), metadata))
+	assert.Equal(t, "www.example.com", metadata.Host)
+
+	// gives up once too many packets are held
+	session = dispatcher.NewUDPSession(metadata)
+	packet := sealInitial(t, quicVersion1, dcid, 0, cryptoFrameBytes(0, hello[:40]))
+	for i := 1; i < maxQUICPackets; i++ {
+		assert.ErrorIs(t, session.Sniff(packet, metadata), ErrIncomplete)
+	}
+	assert.ErrorIs(t, session.Sniff(packet, metadata), ErrNoHost)
+}
@@ -0,0 +1,237 @@
+package sniffer
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	N "github.com/Dreamacro/clash/common/net"
+	C "github.com/Dreamacro/clash/constant"
+)
+
+const (
+	DefaultTimeout = 100 * time.Millisecond
+
+	// maxQUICPackets bounds the Initial packets held for one ClientHello
+	maxQUICPackets = 8
+)
+
+// Type is a sniffable protocol
+type Type int
+
+const (
+	TLS Type = iota
+	HTTP
+	QUIC
+)
+
+func (t Type) String() string {
+	switch t {
+	case TLS:
+		return "TLS"
+	case HTTP:
+		return "HTTP"
+	case QUIC:
+		return "QUIC"
+	default:
+		return "Unknown"
+	}
+}
+
+// ParseType parses a protocol name in config files
+func ParseType(s string) (Type, error) {
+	switch strings.ToLower(s) {
+	case "tls":
+		return TLS, nil
+	case "http":
+		return HTTP, nil
+	case "quic":
+		return QUIC, nil
+	default:
+		return 0, fmt.Errorf("unsupported sniffer type: %s", s)
+	}
+}
+
+// PortRange is an inclusive range of ports
+type PortRange struct {
+	Start uint16
+	End   uint16
+}
+
+// ParsePortRange parses a port or a range like 8000-9000
+func ParsePortRange(s string) (PortRange, error) {
+	start, end, isRange := strings.Cut(strings.TrimSpace(s), "-")
+	if !isRange {
+		end = start
+	}
+
+	from, err := strconv.ParseUint(strings.TrimSpace(start), 10, 16)
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid port '%s'", s)
+	}
+	to, err := strconv.ParseUint(strings.TrimSpace(end), 10, 16)
+	if err != nil || to < from {
+		return PortRange{}, fmt.Errorf("invalid port '%s'", s)
+	}
+	return PortRange{Start: uint16(from), End: uint16(to)}, nil
+}
+
+func (r PortRange) Contains(port uint16) bool {
+	return r.Start <= port && port <= r.End
+}
+
+// Config decides which connections are sniffed
+type Config struct {
+	Enable bool
+	// Timeout bounds the wait for the first bytes sent by the client,
+	// or for the rest of a ClientHello split across QUIC packets
+	Timeout time.Duration
+	// OverrideDestination dials the sniffed host instead of the original IP
+	OverrideDestination bool
+	// Ports enables each protocol on the destination ports
+	Ports map[Type][]PortRange
+}
+
+type Dispatcher struct {
+	timeout             time.Duration
+	overrideDestination bool
+	ports               map[Type][]PortRange
+}
+
+// NewDispatcher returns nil if sniffing is disabled
+func NewDispatcher(cfg *Config) *Dispatcher {
+	if cfg == nil || !cfg.Enable || len(cfg.Ports) == 0 {
+		return nil
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	return &Dispatcher{
+		timeout:             timeout,
+		overrideDestination: cfg.OverrideDestination,
+		ports:               cfg.Ports,
+	}
+}
+
+// Timeout returns how long a flow may wait for sniffing
+func (d *Dispatcher) Timeout() time.Duration {
+	return d.timeout
+}
+
+// types returns the TCP protocols enabled on port
+func (d *Dispatcher) types(port uint16) []Type {
+	types := []Type{}
+	for _, tp := range []Type{TLS, HTTP} {
+		for _, r := range d.ports[tp] {
+			if r.Contains(port) {
+				types = append(types, tp)
+				break
+			}
+		}
+	}
+	return types
+}
+
+// SniffTCP peeks the first bytes of conn and fills metadata.Host, the peeked bytes
+// stay in conn. It returns false if nothing is sniffed.
+func (d *Dispatcher) SniffTCP(conn *N.BufferedConn, metadata *C.Metadata) bool {
+	types := d.types(uint16(metadata.DstPort))
+	if len(types) == 0 {
+		return false
+	}
+
+	host, err := d.sniffTCP(conn, types)
+	if err != nil {
+		return false
+	}
+
+	d.apply(host, metadata)
+	return true
+}
+
+func (d *Dispatcher) sniffTCP(conn *N.BufferedConn, types []Type) (string, error) {
+	conn.SetReadDeadline(time.Now().Add(d.timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	size := 1
+	for {
+		// the buffer may be filled by several TCP segments, peek as much as has arrived
+		buf, err := conn.Peek(size)
+		if err != nil {
+			return "", err
+		}
+		buf, _ = conn.Peek(conn.Buffered())
+
+		incomplete := false
+		for _, tp := range types {
+			var host string
+			switch tp {
+			case TLS:
+				host, err = SniffTLS(buf)
+			case HTTP:
+				host, err = SniffHTTP(buf)
+			}
+
+			if err == nil {
+				return host, nil
+			}
+			if errors.Is(err, ErrIncomplete) {
+				incomplete = true
+			}
+		}
+
+		if !incomplete || len(buf) >= conn.Reader().Size() {
+			return "", ErrNoHost
+		}
+		size = len(buf) + 1
+	}
+}
+
+// UDPSession sniffs the first packets of a UDP flow
+type UDPSession struct {
+	dispatcher *Dispatcher
+	quic       QUICSniffer
+	packets    int
+}
+
+// NewUDPSession returns nil if no UDP protocol is enabled on the destination port
+func (d *Dispatcher) NewUDPSession(metadata *C.Metadata) *UDPSession {
+	for _, r := range d.ports[QUIC] {
+		if r.Contains(uint16(metadata.DstPort)) {
+			return &UDPSession{dispatcher: d}
+		}
+	}
+	return nil
+}
+
+// Sniff feeds the next packet of the flow and fills metadata.Host once sniffed,
+// ErrIncomplete means the packet should be held until the next one arrives.
+func (s *UDPSession) Sniff(packet []byte, metadata *C.Metadata) error {
+	s.packets++
+	host, err := s.quic.Sniff(packet)
+	if err != nil {
+		if errors.Is(err, ErrIncomplete) && s.packets >= maxQUICPackets {
+			return ErrNoHost
+		}
+		return err
+	}
+
+	s.dispatcher.apply(host, metadata)
+	return nil
+}
+
+// apply records the sniffed host. Without override-destination the original IP
+// is kept for dialing, like a redir-host mapping.
+func (d *Dispatcher) apply(host string, metadata *C.Metadata) {
+	metadata.Host = host
+	if d.overrideDestination {
+		metadata.DstIP = nil
+		return
+	}
+	metadata.DNSMode = C.DNSMapping
+}
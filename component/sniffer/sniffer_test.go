@@ -0,0 +1,138 @@
+package sniffer
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	N "github.com/Dreamacro/clash/common/net"
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clientHello returns the first TLS record sent by crypto/tls
+func clientHello(t *testing.T, serverName string) []byte {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		tls.Client(client, &tls.Config{ServerName: serverName}).Handshake()
+		client.Close()
+	}()
+
+	header := make([]byte, 5)
+	_, err := io.ReadFull(server, header)
+	require.NoError(t, err)
+	body := make([]byte, binary.BigEndian.Uint16(header[3:]))
+	_, err = io.ReadFull(server, body)
+	require.NoError(t, err)
+
+	return append(header, body...)
+}
+
+func TestSniffTLS(t *testing.T) {
+	hello := clientHello(t, "www.example.com")
+
+	host, err := SniffTLS(hello)
+	require.NoError(t, err)
+	assert.Equal(t, "www.example.com", host)
+
+	_, err = SniffTLS(hello[:20])
+	assert.ErrorIs(t, err, ErrIncomplete)
+
+	_, err = SniffTLS([]byte("GET / HTTP/1.1\r\n"))
+	assert.ErrorIs(t, err, ErrNotMatch)
+
+	// crypto/tls omits the SNI extension for IP addresses
+	_, err = SniffTLS(clientHello(t, "1.1.1.1"))
+	assert.ErrorIs(t, err, ErrNoHost)
+}
+
+func TestSniffHTTP(t *testing.T) {
+	testCases := []struct {
+		request  string
+		expected string
+		err      error
+	}{
+		{"GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", "example.com", nil},
+		{"POST /a HTTP/1.1\r\nUser-Agent: curl\r\nhost: Example.com:8080\r\n\r\n", "example.com", nil},
+		{"GET / HTTP/1.1\r\nHost: [::1]:80\r\n\r\n", "", ErrNoHost},
+		{"GET / HTTP/1.1\r\nHost: 10.0.0.1\r\n\r\n", "", ErrNoHost},
+		{"GET / HTTP/1.1\r\nUser-Agent: curl\r\n", "", ErrIncomplete},
+		{"GE", "", ErrIncomplete},
+		{"\x16\x03\x01\x00", "", ErrNotMatch},
+	}
+
+	for _, tc := range testCases {
+		host, err := SniffHTTP([]byte(tc.request))
+		if tc.err != nil {
+			assert.ErrorIs(t, err, tc.err, tc.request)
+			continue
+		}
+		require.NoError(t, err, tc.request)
+		assert.Equal(t, tc.expected, host)
+	}
+}
+
+func TestParsePortRange(t *testing.T) {
+	r, err := ParsePortRange("8000-9000")
+	require.NoError(t, err)
+	assert.True(t, r.Contains(8000))
+	assert.True(t, r.Contains(9000))
+	assert.False(t, r.Contains(9001))
+
+	for _, s := range []string{"", "abc", "70000", "9000-8000"} {
+		_, err := ParsePortRange(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestDispatcher_SniffTCP(t *testing.T) {
+	assert.Nil(t, NewDispatcher(&Config{Enable: false}))
+
+	dispatcher := NewDispatcher(&Config{
+		Enable: true,
+		Ports: map[Type][]PortRange{
+			TLS:  {{Start: 443, End: 443}},
+			HTTP: {{Start: 80, End: 80}},
+		},
+	})
+	require.NotNil(t, dispatcher)
+
+	hello := clientHello(t, "www.example.com")
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		// split the record to make the dispatcher peek again
+		client.Write(hello[:10])
+		client.Write(hello[10:])
+	}()
+
+	conn := N.NewBufferedConn(server)
+	metadata := &C.Metadata{DstIP: net.ParseIP("93.184.216.34"), DstPort: 443}
+	require.True(t, dispatcher.SniffTCP(conn, metadata))
+	assert.Equal(t, "www.example.com", metadata.Host)
+	assert.Equal(t, C.DNSMapping, metadata.DNSMode)
+	assert.NotNil(t, metadata.DstIP)
+
+	// peeked bytes are relayed
+	buf := make([]byte, len(hello))
+	_, err := io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, hello, buf)
+
+	// nothing is sent on a server-first protocol
+	metadata = &C.Metadata{DstIP: net.ParseIP("93.184.216.34"), DstPort: 80}
+	start := time.Now()
+	assert.False(t, dispatcher.SniffTCP(conn, metadata))
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Empty(t, metadata.Host)
+
+	// port not enabled
+	assert.False(t, dispatcher.SniffTCP(conn, &C.Metadata{DstPort: 22}))
+}
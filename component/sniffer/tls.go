@@ -0,0 +1,161 @@
+package sniffer
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	ErrNotMatch   = errors.New("protocol not match")
+	ErrIncomplete = errors.New("incomplete data")
+	ErrNoHost     = errors.New("no host found")
+)
+
+const (
+	recordTypeHandshake      = 0x16
+	handshakeTypeClientHello = 0x01
+	extensionServerName      = 0x0000
+	serverNameTypeHostName   = 0x00
+)
+
+// SniffTLS returns the SNI of a TLS ClientHello record
+func SniffTLS(b []byte) (string, error) {
+	if len(b) < 5 {
+		return "", ErrIncomplete
+	}
+	if b[0] != recordTypeHandshake || b[1] != 0x03 {
+		return "", ErrNotMatch
+	}
+
+	length := int(binary.BigEndian.Uint16(b[3:5]))
+	if len(b) < 5+length {
+		// the server name may still be in the part we have
+		return parseClientHello(b[5:])
+	}
+	return parseClientHello(b[5 : 5+length])
+}
+
+// parseClientHello returns the server name of a ClientHello handshake message,
+// it's shared by TLS over TCP and the CRYPTO frames of QUIC
+func parseClientHello(b []byte) (string, error) {
+	r := reader(b)
+
+	msgType, ok := r.uint8()
+	if !ok {
+		return "", ErrIncomplete
+	}
+	if msgType != handshakeTypeClientHello {
+		return "", ErrNotMatch
+	}
+
+	// length(3) + legacy_version(2) + random(32)
+	if !r.skip(3 + 2 + 32) {
+		return "", ErrIncomplete
+	}
+
+	// legacy_session_id, cipher_suites, legacy_compression_methods
+	if !r.skipVector8() || !r.skipVector16() || !r.skipVector8() {
+		return "", ErrIncomplete
+	}
+
+	if len(r) < 2 {
+		return "", ErrIncomplete
+	}
+	extensions, ok := r.vector16()
+	if !ok {
+		// a truncated extensions block may still hold the server name
+		extensions = r[2:]
+	}
+	truncated := !ok
+
+	for len(extensions) >= 4 {
+		extType := binary.BigEndian.Uint16(extensions[0:2])
+		extLength := int(binary.BigEndian.Uint16(extensions[2:4]))
+		if len(extensions) < 4+extLength {
+			return "", ErrIncomplete
+		}
+		data := extensions[4 : 4+extLength]
+		extensions = extensions[4+extLength:]
+
+		if extType != extensionServerName {
+			continue
+		}
+
+		sni := reader(data)
+		list, ok := sni.vector16()
+		if !ok {
+			return "", ErrNotMatch
+		}
+		for len(list) >= 3 {
+			nameType := list[0]
+			nameLength := int(binary.BigEndian.Uint16(list[1:3]))
+			if len(list) < 3+nameLength {
+				return "", ErrNotMatch
+			}
+			name := string(list[3 : 3+nameLength])
+			list = list[3+nameLength:]
+
+			if nameType == serverNameTypeHostName && name != "" {
+				return name, nil
+			}
+		}
+		return "", ErrNoHost
+	}
+
+	if truncated || len(extensions) != 0 {
+		return "", ErrIncomplete
+	}
+	return "", ErrNoHost
+}
+
+type reader []byte
+
+func (r *reader) skip(n int) bool {
+	if len(*r) < n {
+		return false
+	}
+	*r = (*r)[n:]
+	return true
+}
+
+func (r *reader) uint8() (uint8, bool) {
+	if len(*r) < 1 {
+		return 0, false
+	}
+	v := (*r)[0]
+	*r = (*r)[1:]
+	return v, true
+}
+
+func (r *reader) vector8() ([]byte, bool) {
+	n, ok := r.uint8()
+	if !ok || len(*r) < int(n) {
+		return nil, false
+	}
+	v := (*r)[:n]
+	*r = (*r)[n:]
+	return v, true
+}
+
+func (r *reader) vector16() ([]byte, bool) {
+	if len(*r) < 2 {
+		return nil, false
+	}
+	n := int(binary.BigEndian.Uint16(*r))
+	if len(*r) < 2+n {
+		return nil, false
+	}
+	v := (*r)[2 : 2+n]
+	*r = (*r)[2+n:]
+	return v, true
+}
+
+func (r *reader) skipVector8() bool {
+	_, ok := r.vector8()
+	return ok
+}
+
+func (r *reader) skipVector16() bool {
+	_, ok := r.vector16()
+	return ok
+}
@@ -0,0 +1,140 @@
+package system_dns
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	resolvConfPath = "/etc/resolv.conf"
+	// systemd-resolved points /etc/resolv.conf to its stub listener, the servers it forwards to are listed here
+	resolvedConfPath = "/run/systemd/resolve/resolv.conf"
+
+	watchInterval = 5 * time.Second
+)
+
+var resolvedStubs = []netip.Addr{
+	netip.AddrFrom4([4]byte{127, 0, 0, 53}),
+	netip.AddrFrom4([4]byte{127, 0, 0, 54}),
+}
+
+// ResolveServers returns the nameservers of /etc/resolv.conf, the interface name is
+// ignored because resolv.conf isn't per interface
+func ResolveServers(_ string) ([]string, error) {
+	return resolveServers(resolvConfPath, resolvedConfPath)
+}
+
+// Watch calls onChange when resolv.conf is modified until stop is called
+func Watch(_ string, onChange func()) (stop func()) {
+	return watchFiles([]string{resolvConfPath, resolvedConfPath}, watchInterval, onChange)
+}
+
+func resolveServers(path, upstreamPath string) ([]string, error) {
+	servers, err := parseResolvConf(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no nameserver found in %s", path)
+	}
+
+	// the stub listener of systemd-resolved may be the DNS server of clash itself,
+	// prefer its upstream servers
+	isStub := func(server string) bool {
+		addr, err := netip.ParseAddr(server)
+		return err == nil && slices.Contains(resolvedStubs, addr)
+	}
+	if slices.IndexFunc(servers, func(server string) bool { return !isStub(server) }) == -1 {
+		if upstreams, err := parseResolvConf(upstreamPath); err == nil && len(upstreams) != 0 {
+			return upstreams, nil
+		}
+	}
+
+	return servers, nil
+}
+
+// parseResolvConf returns the addresses of the nameserver lines, the zone of
+// link-local IPv6 addresses is kept
+func parseResolvConf(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	servers := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.IndexAny(line, "#;"); idx != -1 {
+			line = line[:idx]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+
+		addr, err := netip.ParseAddr(fields[1])
+		if err != nil {
+			continue
+		}
+		servers = append(servers, addr.String())
+	}
+
+	return servers, scanner.Err()
+}
+
+// fileStamp changes once a file is written, replaced or removed
+type fileStamp struct {
+	exist   bool
+	modTime int64
+	size    int64
+}
+
+func stampFiles(paths []string) []fileStamp {
+	stamps := make([]fileStamp, 0, len(paths))
+	for _, path := range paths {
+		// os.Stat follows the symlink, which is how resolv.conf is usually managed
+		stat, err := os.Stat(path)
+		if err != nil {
+			stamps = append(stamps, fileStamp{})
+			continue
+		}
+		stamps = append(stamps, fileStamp{exist: true, modTime: stat.ModTime().UnixNano(), size: stat.Size()})
+	}
+	return stamps
+}
+
+// watchFiles polls the stat of paths since inotify loses track of a file that's
+// replaced by rename, which is what resolvconf and NetworkManager do
+func watchFiles(paths []string, interval time.Duration, onChange func()) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		last := stampFiles(paths)
+		for {
+			select {
+			case <-ticker.C:
+				if current := stampFiles(paths); !slices.Equal(current, last) {
+					last = current
+					onChange()
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return sync.OnceFunc(func() {
+		close(done)
+	})
+}
@@ -0,0 +1,74 @@
+package system_dns
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestResolveServers(t *testing.T) {
+	dir := t.TempDir()
+	resolvConf := filepath.Join(dir, "resolv.conf")
+	upstreamConf := filepath.Join(dir, "upstream.conf")
+
+	require.NoError(t, os.WriteFile(resolvConf, []byte(`# generated by NetworkManager
+search lan
+nameserver 192.168.1.1 # router
+nameserver fe80::1%eth0
+;nameserver 1.1.1.1
+nameserver invalid
+options edns0
+`), 0o644))
+	servers, err := resolveServers(resolvConf, upstreamConf)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"192.168.1.1", "fe80::1%eth0"}, servers)
+
+	// the stub of systemd-resolved without the upstream file
+	require.NoError(t, os.WriteFile(resolvConf, []byte("nameserver 127.0.0.53\noptions edns0 trust-ad\n"), 0o644))
+	servers, err = resolveServers(resolvConf, upstreamConf)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.53"}, servers)
+
+	require.NoError(t, os.WriteFile(upstreamConf, []byte("nameserver 10.0.0.1\nnameserver 10.0.0.2\n"), 0o644))
+	servers, err = resolveServers(resolvConf, upstreamConf)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, servers)
+
+	require.NoError(t, os.WriteFile(resolvConf, []byte("search lan\n"), 0o644))
+	_, err = resolveServers(resolvConf, upstreamConf)
+	assert.Error(t, err)
+
+	_, err = resolveServers(filepath.Join(dir, "missing.conf"), upstreamConf)
+	assert.Error(t, err)
+}
+
+func TestWatchFiles(t *testing.T) {
+	dir := t.TempDir()
+	resolvConf := filepath.Join(dir, "resolv.conf")
+	require.NoError(t, os.WriteFile(resolvConf, []byte("nameserver 192.168.1.1\n"), 0o644))
+
+	changed := atomic.NewInt32(0)
+	stop := watchFiles([]string{resolvConf, filepath.Join(dir, "missing.conf")}, 10*time.Millisecond, func() {
+		changed.Inc()
+	})
+	defer stop()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(0), changed.Load())
+
+	// replaced by rename like resolvconf does
+	tmp := filepath.Join(dir, "resolv.conf.tmp")
+	require.NoError(t, os.WriteFile(tmp, []byte("nameserver 192.168.1.1\nnameserver 192.168.1.2\n"), 0o644))
+	require.NoError(t, os.Rename(tmp, resolvConf))
+	assert.Eventually(t, func() bool { return changed.Load() == 1 }, time.Second, 10*time.Millisecond)
+
+	stop()
+	require.NoError(t, os.Remove(resolvConf))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(1), changed.Load())
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package system_dns
+
+// Watch is a no-op, the system nameservers are resolved again after an exchange fails
+func Watch(_ string, _ func()) (stop func()) {
+	return func() {}
+}
@@ -1,18 +1,34 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Dreamacro/clash/adapter"
+	"github.com/Dreamacro/clash/adapter/outbound"
+	"github.com/Dreamacro/clash/adapter/outboundgroup"
+	"github.com/Dreamacro/clash/adapter/provider"
+	"github.com/Dreamacro/clash/common/structure"
 	"github.com/Dreamacro/clash/component/auth"
 	"github.com/Dreamacro/clash/component/fakeip"
+	"github.com/Dreamacro/clash/component/sniffer"
 	"github.com/Dreamacro/clash/component/trie"
 	C "github.com/Dreamacro/clash/constant"
 	providerTypes "github.com/Dreamacro/clash/constant/provider"
 	"github.com/Dreamacro/clash/dns"
 	"github.com/Dreamacro/clash/log"
+	R "github.com/Dreamacro/clash/rule"
 	T "github.com/Dreamacro/clash/tunnel"
-	"net"
-	"net/url"
-	"strings"
+
+	"github.com/samber/lo"
+	"gopkg.in/yaml.v3"
 )
 
 // General config
@@ -48,16 +64,34 @@ type LegacyInbound struct {
 type DNS struct {
 	Enable            bool             `yaml:"enable"`
 	IPv6              bool             `yaml:"ipv6"`
+	Strategy          dns.Strategy     `yaml:"strategy"`
 	NameServer        []dns.NameServer `yaml:"nameserver"`
 	Fallback          []dns.NameServer `yaml:"fallback"`
 	FallbackFilter    FallbackFilter   `yaml:"fallback-filter"`
 	Listen            string           `yaml:"listen"`
+	ListenTCP         bool             `yaml:"listen-tcp"`
+	ListenTLS         string           `yaml:"listen-tls"`
+	ListenHTTPS       string           `yaml:"listen-https"`
+	HTTPSPath         string           `yaml:"https-path"`
+	Certificate       string           `yaml:"certificate"`
+	PrivateKey        string           `yaml:"private-key"`
 	EnhancedMode      C.DNSMode        `yaml:"enhanced-mode"`
 	DefaultNameserver []dns.NameServer `yaml:"default-nameserver"`
 	FakeIPRange       *fakeip.Pool
+	FakeIPRange6      *fakeip.Pool
 	Hosts             *trie.DomainTrie
-	NameServerPolicy  map[string]dns.NameServer
+	NameServerPolicy  map[string]dns.Policy
 	SearchDomains     []string
+	Cache             DNSCache `yaml:"cache"`
+	Blocklist         *dns.Blocklist
+}
+
+// DNSCache config, a zero MaxStale is dns.DefaultMaxStale
+type DNSCache struct {
+	Persist           bool          `yaml:"persist"`
+	Prefetch          bool          `yaml:"prefetch"`
+	DisableServeStale bool          `yaml:"disable-serve-stale"`
+	MaxStale          time.Duration `yaml:"max-stale"`
 }
 
 // FallbackFilter config
@@ -81,33 +115,84 @@ type Experimental struct {
 
 // Config is clash config manager
 type Config struct {
-	General      *General
-	DNS          *DNS
-	Experimental *Experimental
-	Hosts        *trie.DomainTrie
-	Profile      *Profile
-	Inbounds     []C.Inbound
-	Rules        []C.Rule
-	Users        []auth.AuthUser
-	Proxies      map[string]C.Proxy
-	Providers    map[string]providerTypes.ProxyProvider
-	Tunnels      []Tunnel
+	General       *General
+	DNS           *DNS
+	Experimental  *Experimental
+	Hosts         *trie.DomainTrie
+	Profile       *Profile
+	Inbounds      []C.Inbound
+	Rules         []C.Rule
+	Users         []auth.AuthUser
+	Proxies       map[string]C.Proxy
+	Providers     map[string]providerTypes.ProxyProvider
+	RuleProviders map[string]providerTypes.RuleProvider
+	Tunnels       []Tunnel
+	Sniffer       *sniffer.Config
 }
 
 type RawDNS struct {
-	Enable            bool              `yaml:"enable"`
-	IPv6              *bool             `yaml:"ipv6"`
-	UseHosts          bool              `yaml:"use-hosts"`
-	NameServer        []string          `yaml:"nameserver"`
-	Fallback          []string          `yaml:"fallback"`
-	FallbackFilter    RawFallbackFilter `yaml:"fallback-filter"`
-	Listen            string            `yaml:"listen"`
-	EnhancedMode      C.DNSMode         `yaml:"enhanced-mode"`
-	FakeIPRange       string            `yaml:"fake-ip-range"`
-	FakeIPFilter      []string          `yaml:"fake-ip-filter"`
-	DefaultNameserver []string          `yaml:"default-nameserver"`
-	NameServerPolicy  map[string]string `yaml:"nameserver-policy"`
-	SearchDomains     []string          `yaml:"search-domains"`
+	Enable            bool                           `yaml:"enable"`
+	IPv6              *bool                          `yaml:"ipv6"`
+	Strategy          string                         `yaml:"strategy"`
+	UseHosts          bool                           `yaml:"use-hosts"`
+	NameServer        []string                       `yaml:"nameserver"`
+	Fallback          []string                       `yaml:"fallback"`
+	FallbackFilter    RawFallbackFilter              `yaml:"fallback-filter"`
+	Listen            string                         `yaml:"listen"`
+	ListenTCP         bool                           `yaml:"listen-tcp"`
+	ListenTLS         string                         `yaml:"listen-tls"`
+	ListenHTTPS       string                         `yaml:"listen-https"`
+	HTTPSPath         string                         `yaml:"https-path"`
+	Certificate       string                         `yaml:"certificate"`
+	PrivateKey        string                         `yaml:"private-key"`
+	EnhancedMode      C.DNSMode                      `yaml:"enhanced-mode"`
+	FakeIPRange       string                         `yaml:"fake-ip-range"`
+	FakeIPRange6      string                         `yaml:"fake-ip-range6"`
+	FakeIPFilter      []string                       `yaml:"fake-ip-filter"`
+	DefaultNameserver []string                       `yaml:"default-nameserver"`
+	NameServerPolicy  map[string]RawNameServerPolicy `yaml:"nameserver-policy"`
+	SearchDomains     []string                       `yaml:"search-domains"`
+	Cache             RawDNSCache                    `yaml:"cache"`
+	Blocklist         RawBlocklist                   `yaml:"blocklist"`
+}
+
+type rawNameServerPolicy struct {
+	NameServer string `yaml:"nameserver"`
+	Strategy   string `yaml:"strategy"`
+}
+
+// RawNameServerPolicy is a nameserver, or a mapping of the nameserver and the strategy
+type RawNameServerPolicy rawNameServerPolicy
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (p *RawNameServerPolicy) UnmarshalYAML(unmarshal func(any) error) error {
+	var server string
+	if err := unmarshal(&server); err != nil {
+		var inner rawNameServerPolicy
+		if err := unmarshal(&inner); err != nil {
+			return err
+		}
+
+		*p = RawNameServerPolicy(inner)
+		return nil
+	}
+
+	*p = RawNameServerPolicy{NameServer: server}
+	return nil
+}
+
+type RawDNSCache struct {
+	Persist    bool `yaml:"persist"`
+	Prefetch   bool `yaml:"prefetch"`
+	ServeStale bool `yaml:"serve-stale"`
+	// MaxStale is in seconds, 0 is the default of 24 hours
+	MaxStale int `yaml:"max-stale"`
+}
+
+type RawBlocklist struct {
+	Mode      string                    `yaml:"mode"`
+	Allowlist []string                  `yaml:"allowlist"`
+	Lists     map[string]map[string]any `yaml:"lists"`
 }
 
 type RawFallbackFilter struct {
@@ -126,67 +211,984 @@ type tunnel struct {
 
 type Tunnel tunnel
 
-func ParseNameServer(servers []string) ([]dns.NameServer, error) {
+// UnmarshalYAML implements yaml.Unmarshaler
+func (t *Tunnel) UnmarshalYAML(unmarshal func(any) error) error {
+	var tp string
+	if err := unmarshal(&tp); err != nil {
+		var inner tunnel
+		if err := unmarshal(&inner); err != nil {
+			return err
+		}
+
+		*t = Tunnel(inner)
+		return nil
+	}
+
+	// parse udp/tcp,address,target,proxy
+	parts := trimArr(strings.Split(tp, ","))
+	if len(parts) != 4 {
+		return fmt.Errorf("invalid tunnel config %s", tp)
+	}
+	network := strings.Split(parts[0], "/")
+
+	// validate network
+	for _, n := range network {
+		switch n {
+		case "tcp", "udp":
+		default:
+			return fmt.Errorf("invalid tunnel network %s", n)
+		}
+	}
+
+	// validate address and target
+	address := parts[1]
+	target := parts[2]
+	for _, addr := range []string{address, target} {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("invalid tunnel target or address %s", addr)
+		}
+	}
+
+	*t = Tunnel(tunnel{
+		Network: network,
+		Address: address,
+		Target:  target,
+		Proxy:   parts[3],
+	})
+	return nil
+}
+
+type RawSniffer struct {
+	Enable              bool                           `yaml:"enable"`
+	Timeout             int                            `yaml:"timeout"`
+	OverrideDestination bool                           `yaml:"override-destination"`
+	Sniff               map[string]RawSniffingProtocol `yaml:"sniff"`
+}
+
+type RawSniffingProtocol struct {
+	Ports []string `yaml:"ports"`
+}
+
+// RawScript holds named scripts referred to by SCRIPT rules
+type RawScript struct {
+	Shortcuts map[string]string `yaml:"shortcuts"`
+}
+
+// RawConfig is the raw yaml schema of a clash config file
+type RawConfig struct {
+	Port               int          `yaml:"port"`
+	SocksPort          int          `yaml:"socks-port"`
+	RedirPort          int          `yaml:"redir-port"`
+	TProxyPort         int          `yaml:"tproxy-port"`
+	MixedPort          int          `yaml:"mixed-port"`
+	Authentication     []string     `yaml:"authentication"`
+	AllowLan           bool         `yaml:"allow-lan"`
+	BindAddress        string       `yaml:"bind-address"`
+	Mode               T.TunnelMode `yaml:"mode"`
+	LogLevel           log.LogLevel `yaml:"log-level"`
+	IPv6               bool         `yaml:"ipv6"`
+	ExternalController string       `yaml:"external-controller"`
+	ExternalUI         string       `yaml:"external-ui"`
+	Secret             string       `yaml:"secret"`
+	Interface          string       `yaml:"interface-name"`
+	RoutingMark        int          `yaml:"routing-mark"`
+	Tunnels            []Tunnel     `yaml:"tunnels"`
+
+	Hosts        map[string]string         `yaml:"hosts"`
+	Inbounds     []C.Inbound               `yaml:"inbounds"`
+	DNS          RawDNS                    `yaml:"dns"`
+	Experimental Experimental              `yaml:"experimental"`
+	Profile      Profile                   `yaml:"profile"`
+	Proxy        []map[string]any          `yaml:"proxies"`
+	ProxyGroup   []map[string]any          `yaml:"proxy-groups"`
+	RuleProvider map[string]map[string]any `yaml:"rule-providers"`
+	Sniffer      RawSniffer                `yaml:"sniffer"`
+	Script       RawScript                 `yaml:"script"`
+	Rule         []string                  `yaml:"rules"`
+}
+
+// Parse config
+func Parse(buf []byte) (*Config, error) {
+	rawCfg, err := UnmarshalRawConfig(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseRawConfig(rawCfg)
+}
+
+// UnmarshalRawConfig unmarshal yaml into RawConfig with default value
+func UnmarshalRawConfig(buf []byte) (*RawConfig, error) {
+	// config with default value
+	rawCfg := &RawConfig{
+		AllowLan:       false,
+		BindAddress:    "*",
+		Mode:           T.Rule,
+		Authentication: []string{},
+		LogLevel:       log.INFO,
+		Hosts:          map[string]string{},
+		Rule:           []string{},
+		Proxy:          []map[string]any{},
+		ProxyGroup:     []map[string]any{},
+		DNS: RawDNS{
+			Enable:      false,
+			UseHosts:    true,
+			FakeIPRange: "198.18.0.1/16",
+			FallbackFilter: RawFallbackFilter{
+				GeoIP:     true,
+				GeoIPCode: "CN",
+				IPCIDR:    []string{},
+			},
+			DefaultNameserver: []string{
+				"114.114.114.114",
+				"8.8.8.8",
+			},
+			Cache: RawDNSCache{
+				ServeStale: true,
+			},
+		},
+		Profile: Profile{
+			StoreSelected: true,
+		},
+	}
+
+	if err := yaml.Unmarshal(buf, rawCfg); err != nil {
+		return nil, err
+	}
+
+	return rawCfg, nil
+}
+
+// ParseRawConfig builds a ready Config from RawConfig, the providers are initialized
+// once the whole config is parsed and destroyed if it fails
+func ParseRawConfig(rawCfg *RawConfig) (_ *Config, err error) {
+	config := &Config{}
+	defer func() {
+		if err != nil {
+			destroyProviders(config)
+		}
+	}()
+
+	config.Experimental = &rawCfg.Experimental
+	config.Profile = &rawCfg.Profile
+
+	general, err := parseGeneral(rawCfg)
+	if err != nil {
+		return nil, err
+	}
+	config.General = general
+
+	config.Inbounds = rawCfg.Inbounds
+
+	proxies, providers, err := parseProxies(rawCfg)
+	if err != nil {
+		return nil, err
+	}
+	config.Proxies = proxies
+	config.Providers = providers
+
+	ruleProviders, err := parseRuleProviders(rawCfg)
+	if err != nil {
+		return nil, err
+	}
+	config.RuleProviders = ruleProviders
+
+	rules, err := parseRules(rawCfg, proxies, ruleProviders)
+	if err != nil {
+		return nil, err
+	}
+	config.Rules = rules
+
+	hosts, err := parseHosts(rawCfg)
+	if err != nil {
+		return nil, err
+	}
+	config.Hosts = hosts
+
+	dnsCfg, err := parseDNS(rawCfg, hosts, proxies)
+	if err != nil {
+		return nil, err
+	}
+	config.DNS = dnsCfg
+
+	users, err := parseAuthentication(rawCfg.Authentication)
+	if err != nil {
+		return nil, err
+	}
+	config.Users = users
+
+	snifferCfg, err := parseSniffer(rawCfg.Sniffer)
+	if err != nil {
+		return nil, err
+	}
+	config.Sniffer = snifferCfg
+
+	config.Tunnels = rawCfg.Tunnels
+	// verify tunnels
+	for idx, t := range config.Tunnels {
+		if _, ok := config.Proxies[t.Proxy]; !ok {
+			return nil, fmt.Errorf("tunnels[%d].proxy: unknown proxy '%s'", idx, t.Proxy)
+		}
+	}
+
+	if err := initialProviders(config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// initialProviders loads the providers of a parsed config and starts their updates
+func initialProviders(config *Config) error {
+	// sorted for a stable order of initialization
+	names := lo.Keys(config.RuleProviders)
+	sort.Strings(names)
+	for _, name := range names {
+		log.Infoln("Start initial rule provider %s", name)
+		if err := config.RuleProviders[name].Initial(); err != nil {
+			return fmt.Errorf("rule-providers.%s: %w", name, err)
+		}
+	}
+
+	if config.DNS.Blocklist != nil {
+		for _, list := range config.DNS.Blocklist.Lists {
+			log.Infoln("Start initial blocklist %s", list.Name())
+			if err := list.Initial(); err != nil {
+				return fmt.Errorf("dns.blocklist.lists.%s: %w", list.Name(), err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// destroyProviders stops the updates of the providers of config, whether they're initialized or not
+func destroyProviders(config *Config) {
+	providers := []providerTypes.Provider{}
+	for _, rp := range config.RuleProviders {
+		providers = append(providers, rp)
+	}
+	if config.DNS != nil && config.DNS.Blocklist != nil {
+		for _, list := range config.DNS.Blocklist.Lists {
+			providers = append(providers, list)
+		}
+	}
+
+	for _, p := range providers {
+		if d, ok := p.(interface{ Destroy() error }); ok {
+			d.Destroy()
+		}
+	}
+}
+
+func parseSniffer(cfg RawSniffer) (*sniffer.Config, error) {
+	snifferCfg := &sniffer.Config{
+		Enable:              cfg.Enable,
+		Timeout:             time.Duration(cfg.Timeout) * time.Millisecond,
+		OverrideDestination: cfg.OverrideDestination,
+		Ports:               map[sniffer.Type][]sniffer.PortRange{},
+	}
+
+	if cfg.Timeout < 0 {
+		return nil, fmt.Errorf("sniffer.timeout: invalid timeout %d", cfg.Timeout)
+	}
+
+	for name, protocol := range cfg.Sniff {
+		tp, err := sniffer.ParseType(name)
+		if err != nil {
+			return nil, fmt.Errorf("sniffer.sniff.%s: %w", name, err)
+		}
+
+		ranges := make([]sniffer.PortRange, 0, len(protocol.Ports))
+		for idx, port := range protocol.Ports {
+			r, err := sniffer.ParsePortRange(port)
+			if err != nil {
+				return nil, fmt.Errorf("sniffer.sniff.%s.ports[%d]: %w", name, idx, err)
+			}
+			ranges = append(ranges, r)
+		}
+		snifferCfg.Ports[tp] = ranges
+	}
+
+	return snifferCfg, nil
+}
+
+func parseGeneral(cfg *RawConfig) (*General, error) {
+	externalUI := cfg.ExternalUI
+
+	// checkout externalUI exist
+	if externalUI != "" {
+		externalUI = C.Path.Resolve(externalUI)
+
+		if _, err := os.Stat(externalUI); os.IsNotExist(err) {
+			return nil, fmt.Errorf("external-ui: %s not exist", externalUI)
+		}
+	}
+
+	return &General{
+		LegacyInbound: LegacyInbound{
+			Port:        cfg.Port,
+			SocksPort:   cfg.SocksPort,
+			RedirPort:   cfg.RedirPort,
+			TProxyPort:  cfg.TProxyPort,
+			MixedPort:   cfg.MixedPort,
+			AllowLan:    cfg.AllowLan,
+			BindAddress: cfg.BindAddress,
+		},
+		Controller: Controller{
+			ExternalController: cfg.ExternalController,
+			ExternalUI:         externalUI,
+			Secret:             cfg.Secret,
+		},
+		Authentication: cfg.Authentication,
+		Mode:           cfg.Mode,
+		LogLevel:       cfg.LogLevel,
+		IPv6:           cfg.IPv6,
+		Interface:      cfg.Interface,
+		RoutingMark:    cfg.RoutingMark,
+	}, nil
+}
+
+func parseProxies(cfg *RawConfig) (proxies map[string]C.Proxy, providersMap map[string]providerTypes.ProxyProvider, err error) {
+	proxies = make(map[string]C.Proxy)
+	providersMap = make(map[string]providerTypes.ProxyProvider)
+	proxyList := []string{}
+	proxiesConfig := cfg.Proxy
+	groupsConfig := cfg.ProxyGroup
+
+	proxies["DIRECT"] = adapter.NewProxy(outbound.NewDirect())
+	proxies["REJECT"] = adapter.NewProxy(outbound.NewReject())
+	proxyList = append(proxyList, "DIRECT", "REJECT")
+
+	// parse proxy
+	for idx, mapping := range proxiesConfig {
+		proxy, err := adapter.ParseProxy(mapping)
+		if err != nil {
+			return nil, nil, fmt.Errorf("proxies[%d]: %w", idx, err)
+		}
+
+		if _, exist := proxies[proxy.Name()]; exist {
+			return nil, nil, fmt.Errorf("proxies[%d].name: duplicate name '%s'", idx, proxy.Name())
+		}
+		proxies[proxy.Name()] = proxy
+		proxyList = append(proxyList, proxy.Name())
+	}
+
+	// keep the original order and index of ProxyGroups in config file
+	groupIndex := map[string]int{}
+	for idx, mapping := range groupsConfig {
+		groupName, existName := mapping["name"].(string)
+		if !existName {
+			return nil, nil, fmt.Errorf("proxy-groups[%d].name: missing name", idx)
+		}
+		if _, exist := proxies[groupName]; exist {
+			return nil, nil, fmt.Errorf("proxy-groups[%d].name: duplicate name '%s'", idx, groupName)
+		}
+		if _, exist := groupIndex[groupName]; exist {
+			return nil, nil, fmt.Errorf("proxy-groups[%d].name: duplicate name '%s'", idx, groupName)
+		}
+		groupIndex[groupName] = idx
+		proxyList = append(proxyList, groupName)
+	}
+
+	// every proxy referenced by a ProxyGroup should be defined
+	decoder := structure.NewDecoder(structure.Option{TagName: "group", WeaklyTypedInput: true})
+	for idx, mapping := range groupsConfig {
+		option := &outboundgroup.GroupCommonOption{}
+		if err := decoder.Decode(mapping, option); err != nil {
+			return nil, nil, fmt.Errorf("proxy-groups[%d]: %w", idx, err)
+		}
+
+		// there is no proxy-providers section to define the providers
+		if len(option.Use) != 0 {
+			return nil, nil, fmt.Errorf("proxy-groups[%d].use: proxy providers are not supported", idx)
+		}
+
+		for _, name := range option.Proxies {
+			_, isProxy := proxies[name]
+			_, isGroup := groupIndex[name]
+			if !isProxy && !isGroup {
+				return nil, nil, fmt.Errorf("proxy-groups[%d].proxies: unknown proxy '%s'", idx, name)
+			}
+		}
+	}
+
+	// check if any loop exists and sort the ProxyGroups
+	if err := proxyGroupsDagSort(groupsConfig); err != nil {
+		return nil, nil, fmt.Errorf("proxy-groups: %w", err)
+	}
+
+	// parse proxy group
+	for _, mapping := range groupsConfig {
+		idx := groupIndex[mapping["name"].(string)]
+		group, err := outboundgroup.ParseProxyGroup(mapping, proxies, providersMap)
+		if err != nil {
+			return nil, nil, fmt.Errorf("proxy-groups[%d]: %w", idx, err)
+		}
+
+		proxies[group.Name()] = adapter.NewProxy(group)
+	}
+
+	// initial compatible provider
+	for _, pd := range providersMap {
+		if pd.VehicleType() != providerTypes.Compatible {
+			continue
+		}
+
+		log.Infoln("Start initial compatible provider %s", pd.Name())
+		if err := pd.Initial(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	ps := []C.Proxy{}
+	for _, v := range proxyList {
+		ps = append(ps, proxies[v])
+	}
+	hc := provider.NewHealthCheck(ps, "", 0, true)
+	pd, _ := provider.NewCompatibleProvider(provider.ReservedName, ps, hc)
+	providersMap[provider.ReservedName] = pd
+
+	global := outboundgroup.NewSelector(
+		&outboundgroup.GroupCommonOption{
+			Name: "GLOBAL",
+		},
+		[]providerTypes.ProxyProvider{pd},
+	)
+	proxies["GLOBAL"] = adapter.NewProxy(global)
+	return proxies, providersMap, nil
+}
+
+func parseRuleProviders(cfg *RawConfig) (map[string]providerTypes.RuleProvider, error) {
+	ruleProviders := map[string]providerTypes.RuleProvider{}
+
+	for name, mapping := range cfg.RuleProvider {
+		rp, err := provider.ParseRuleProvider(name, mapping)
+		if err != nil {
+			return nil, fmt.Errorf("rule-providers.%s: %w", name, err)
+		}
+		ruleProviders[name] = rp
+	}
+
+	return ruleProviders, nil
+}
+
+func parseRules(cfg *RawConfig, proxies map[string]C.Proxy, ruleProviders map[string]providerTypes.RuleProvider) ([]C.Rule, error) {
+	rules := []C.Rule{}
+	rulesConfig := cfg.Rule
+
+	// parse rules
+	for idx, line := range rulesConfig {
+		rule := R.SplitRule(line)
+		var (
+			payload string
+			target  string
+			params  []string
+		)
+
+		switch l := len(rule); {
+		case l == 2:
+			target = rule[1]
+		case l == 3:
+			payload = rule[1]
+			target = rule[2]
+		case l >= 4:
+			payload = rule[1]
+			target = rule[2]
+			params = rule[3:]
+		default:
+			return nil, fmt.Errorf("rules[%d]: format invalid '%s'", idx, line)
+		}
+
+		if _, ok := proxies[target]; !ok {
+			return nil, fmt.Errorf("rules[%d]: unknown proxy '%s'", idx, target)
+		}
+
+		// SCRIPT rules refer to a script by its shortcut name
+		if C.RuleConfig(rule[0]) == C.RuleConfigScript {
+			code, ok := cfg.Script.Shortcuts[payload]
+			if !ok {
+				return nil, fmt.Errorf("rules[%d]: unknown script shortcut '%s'", idx, payload)
+			}
+
+			parsed, parseErr := R.ParseRule(rule[0], code, target, params, ruleProviders)
+			if parseErr != nil {
+				return nil, fmt.Errorf("rules[%d]: script.shortcuts.%s: %w", idx, payload, parseErr)
+			}
+
+			// an unknown adapter returned by the script would be skipped silently when matching
+			for _, adapter := range parsed.(*R.Script).Adapters() {
+				if _, ok := proxies[adapter]; !ok {
+					return nil, fmt.Errorf("rules[%d]: script.shortcuts.%s: unknown proxy '%s'", idx, payload, adapter)
+				}
+			}
+
+			rules = append(rules, parsed)
+			continue
+		}
+
+		parsed, parseErr := R.ParseRule(rule[0], payload, target, params, ruleProviders)
+		if parseErr != nil {
+			return nil, fmt.Errorf("rules[%d]: %w", idx, parseErr)
+		}
+
+		rules = append(rules, parsed)
+	}
+
+	return rules, nil
+}
+
+func parseHosts(cfg *RawConfig) (*trie.DomainTrie, error) {
+	tree := trie.New()
+
+	// add default hosts
+	if err := tree.Insert("localhost", net.IP{127, 0, 0, 1}); err != nil {
+		log.Errorln("insert localhost to host error: %s", err.Error())
+	}
+
+	for domain, ipStr := range cfg.Hosts {
+		ip := net.ParseIP(ipStr)
+		if ip == nil {
+			return nil, fmt.Errorf("hosts.%s: invalid IP '%s'", domain, ipStr)
+		}
+		if err := tree.Insert(domain, ip); err != nil {
+			return nil, fmt.Errorf("hosts.%s: %w", domain, err)
+		}
+	}
+
+	return tree, nil
+}
+
+func parseNameServers(field string, servers []string, proxies map[string]C.Proxy) ([]dns.NameServer, error) {
 	nameservers := []dns.NameServer{}
 
 	for idx, server := range servers {
-		// parse without scheme .e.g 8.8.8.8:53
-		if !strings.Contains(server, "://") {
-			server = "udp://" + server
+		nameserver, err := parseNameServer(server, proxies)
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d]: %w", field, idx, err)
 		}
-		u, err := url.Parse(server)
+		nameservers = append(nameservers, nameserver)
+	}
+	return nameservers, nil
+}
+
+func parseNameServerPolicy(nsPolicy map[string]RawNameServerPolicy, proxies map[string]C.Proxy) (map[string]dns.Policy, error) {
+	policy := map[string]dns.Policy{}
+
+	for domain, raw := range nsPolicy {
+		if _, valid := trie.ValidAndSplitDomain(domain); !valid {
+			return nil, fmt.Errorf("dns.nameserver-policy.%s: %w", domain, trie.ErrInvalidDomain)
+		}
+
+		nameserver, err := parseNameServer(raw.NameServer, proxies)
 		if err != nil {
-			return nil, fmt.Errorf("DNS NameServer[%d] format error: %s", idx, err.Error())
-		}
-
-		// parse with specific interface
-		// .e.g 10.0.0.1#en0
-		interfaceName := u.Fragment
-
-		var addr, dnsNetType string
-		switch u.Scheme {
-		case "udp":
-			addr, err = hostWithDefaultPort(u.Host, "53")
-			dnsNetType = "" // UDP
-		case "tcp":
-			addr, err = hostWithDefaultPort(u.Host, "53")
-			dnsNetType = "tcp" // TCP
-		case "tls":
-			addr, err = hostWithDefaultPort(u.Host, "853")
-			dnsNetType = "tcp-tls" // DNS over TLS
-		case "https":
-			clearURL := url.URL{Scheme: "https", Host: u.Host, Path: u.Path, User: u.User}
-			addr = clearURL.String()
-			dnsNetType = "https" // DNS over HTTPS
-		case "dhcp":
-			addr = u.Host
-			dnsNetType = "dhcp" // UDP from DHCP
-		case "system":
-			addr = u.Host
-			dnsNetType = "system" // UDP from System
-		case "fake-ip":
-			addr = u.Host
-			dnsNetType = "fake-ip" // Fake Ip
-		default:
-			return nil, fmt.Errorf("DNS NameServer[%d] unsupport scheme: %s", idx, u.Scheme)
+			return nil, fmt.Errorf("dns.nameserver-policy.%s: %w", domain, err)
 		}
 
+		strategy, err := parseStrategy(raw.Strategy)
 		if err != nil {
-			return nil, fmt.Errorf("DNS NameServer[%d] format error: %s", idx, err.Error())
+			return nil, fmt.Errorf("dns.nameserver-policy.%s.strategy: %w", domain, err)
 		}
+		policy[domain] = dns.Policy{NameServer: nameserver, Strategy: strategy}
+	}
 
-		nameservers = append(
-			nameservers,
-			dns.NameServer{
-				Net:       dnsNetType,
-				Addr:      addr,
-				Interface: interfaceName,
-			},
-		)
+	return policy, nil
+}
+
+// parseStrategy returns StrategyDefault for an empty strategy
+func parseStrategy(strategy string) (dns.Strategy, error) {
+	if strategy == "" {
+		return dns.StrategyDefault, nil
+	}
+
+	s, ok := dns.StrategyMapping[strategy]
+	if !ok {
+		return dns.StrategyDefault, fmt.Errorf("unsupported strategy: %s", strategy)
+	}
+	return s, nil
+}
+
+func parseFallbackIPCIDR(ips []string) ([]*net.IPNet, error) {
+	ipNets := []*net.IPNet{}
+
+	for idx, ip := range ips {
+		_, ipnet, err := net.ParseCIDR(ip)
+		if err != nil {
+			return nil, fmt.Errorf("dns.fallback-filter.ipcidr[%d]: %w", idx, err)
+		}
+		ipNets = append(ipNets, ipnet)
+	}
+
+	return ipNets, nil
+}
+
+func parseDNS(rawCfg *RawConfig, hosts *trie.DomainTrie, proxies map[string]C.Proxy) (*DNS, error) {
+	cfg := rawCfg.DNS
+	if cfg.Enable && len(cfg.NameServer) == 0 {
+		return nil, errors.New("dns.nameserver: should have at least one nameserver when dns is enabled")
+	}
+
+	dnsCfg := &DNS{
+		Enable:       cfg.Enable,
+		Listen:       cfg.Listen,
+		ListenTCP:    cfg.ListenTCP,
+		ListenTLS:    cfg.ListenTLS,
+		ListenHTTPS:  cfg.ListenHTTPS,
+		HTTPSPath:    cfg.HTTPSPath,
+		IPv6:         lo.FromPtrOr(cfg.IPv6, rawCfg.IPv6),
+		EnhancedMode: cfg.EnhancedMode,
+		FallbackFilter: FallbackFilter{
+			IPCIDR: []*net.IPNet{},
+		},
+	}
+
+	if cfg.ListenTLS != "" || cfg.ListenHTTPS != "" {
+		if cfg.Certificate == "" || cfg.PrivateKey == "" {
+			return nil, errors.New("dns.certificate: certificate and private-key are required by listen-tls and listen-https")
+		}
+		dnsCfg.Certificate = C.Path.Resolve(cfg.Certificate)
+		dnsCfg.PrivateKey = C.Path.Resolve(cfg.PrivateKey)
+	}
+	if cfg.HTTPSPath != "" && !strings.HasPrefix(cfg.HTTPSPath, "/") {
+		return nil, errors.New("dns.https-path: should start with '/'")
+	}
+
+	var err error
+	if dnsCfg.Strategy, err = parseStrategy(cfg.Strategy); err != nil {
+		return nil, fmt.Errorf("dns.strategy: %w", err)
+	}
+
+	if dnsCfg.NameServer, err = parseNameServers("dns.nameserver", cfg.NameServer, proxies); err != nil {
+		return nil, err
+	}
+
+	if dnsCfg.Fallback, err = parseNameServers("dns.fallback", cfg.Fallback, proxies); err != nil {
+		return nil, err
+	}
+
+	if dnsCfg.NameServerPolicy, err = parseNameServerPolicy(cfg.NameServerPolicy, proxies); err != nil {
+		return nil, err
+	}
+
+	if len(cfg.DefaultNameserver) == 0 {
+		return nil, errors.New("dns.default-nameserver: should have at least one nameserver")
+	}
+	// default nameservers resolve the servers of proxies, so they can't be dialed through a proxy
+	if dnsCfg.DefaultNameserver, err = parseNameServers("dns.default-nameserver", cfg.DefaultNameserver, nil); err != nil {
+		return nil, err
+	}
+	// check default nameserver is pure ip addr
+	for idx, ns := range dnsCfg.DefaultNameserver {
+		host, _, err := net.SplitHostPort(ns.Addr)
+		if err != nil || net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("dns.default-nameserver[%d]: should be pure IP", idx)
+		}
+	}
+
+	// the fake-ip nameserver scheme shares the pool with the fake-ip enhanced mode
+	usesFakeIPServer := lo.ContainsBy(append(dnsCfg.NameServer, dnsCfg.Fallback...), func(ns dns.NameServer) bool {
+		return ns.Net == "fake-ip"
+	})
+	if cfg.EnhancedMode == C.DNSFakeIP || usesFakeIPServer {
+		_, ipnet, err := net.ParseCIDR(cfg.FakeIPRange)
+		if err != nil {
+			return nil, fmt.Errorf("dns.fake-ip-range: %w", err)
+		}
+		if ipnet.IP.To4() == nil {
+			return nil, errors.New("dns.fake-ip-range: should be an IPv4 range")
+		}
+
+		var host *trie.DomainTrie
+		// fake ip skip host filter
+		if len(cfg.FakeIPFilter) != 0 {
+			host = trie.New()
+			for idx, domain := range cfg.FakeIPFilter {
+				if err := host.Insert(domain, true); err != nil {
+					return nil, fmt.Errorf("dns.fake-ip-filter[%d]: %w", idx, err)
+				}
+			}
+		}
+
+		pool, err := fakeip.New(fakeip.Options{
+			IPNet:       ipnet,
+			Size:        1000,
+			Host:        host,
+			Persistence: rawCfg.Profile.StoreFakeIP,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("dns.fake-ip-range: %w", err)
+		}
+
+		dnsCfg.FakeIPRange = pool
+
+		if cfg.FakeIPRange6 != "" {
+			_, ipnet6, err := net.ParseCIDR(cfg.FakeIPRange6)
+			if err != nil {
+				return nil, fmt.Errorf("dns.fake-ip-range6: %w", err)
+			}
+			if ipnet6.IP.To4() != nil {
+				return nil, errors.New("dns.fake-ip-range6: should be an IPv6 range")
+			}
+
+			pool6, err := fakeip.New(fakeip.Options{
+				IPNet:       ipnet6,
+				Size:        1000,
+				Host:        host,
+				Persistence: rawCfg.Profile.StoreFakeIP,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("dns.fake-ip-range6: %w", err)
+			}
+
+			dnsCfg.FakeIPRange6 = pool6
+		}
+	}
+
+	if len(cfg.Fallback) != 0 {
+		dnsCfg.FallbackFilter.GeoIP = cfg.FallbackFilter.GeoIP
+		dnsCfg.FallbackFilter.GeoIPCode = cfg.FallbackFilter.GeoIPCode
+		if dnsCfg.FallbackFilter.IPCIDR, err = parseFallbackIPCIDR(cfg.FallbackFilter.IPCIDR); err != nil {
+			return nil, err
+		}
+		for idx, domain := range cfg.FallbackFilter.Domain {
+			if _, valid := trie.ValidAndSplitDomain(domain); !valid {
+				return nil, fmt.Errorf("dns.fallback-filter.domain[%d]: %w", idx, trie.ErrInvalidDomain)
+			}
+		}
+		dnsCfg.FallbackFilter.Domain = cfg.FallbackFilter.Domain
+	}
+
+	if cfg.UseHosts {
+		dnsCfg.Hosts = hosts
+	}
+
+	for idx, domain := range cfg.SearchDomains {
+		if strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+			return nil, fmt.Errorf("dns.search-domains[%d]: should not start or end with '.'", idx)
+		}
+		if strings.Contains(domain, ":") {
+			return nil, fmt.Errorf("dns.search-domains[%d]: should not contain ports", idx)
+		}
+	}
+	dnsCfg.SearchDomains = cfg.SearchDomains
+
+	if cfg.Cache.MaxStale < 0 {
+		return nil, fmt.Errorf("dns.cache.max-stale: invalid duration %d", cfg.Cache.MaxStale)
+	}
+	dnsCfg.Cache = DNSCache{
+		Persist:           cfg.Cache.Persist,
+		Prefetch:          cfg.Cache.Prefetch,
+		DisableServeStale: !cfg.Cache.ServeStale,
+		MaxStale:          time.Duration(cfg.Cache.MaxStale) * time.Second,
+	}
+
+	if dnsCfg.Blocklist, err = parseBlocklist(cfg.Blocklist); err != nil {
+		return nil, err
+	}
+
+	return dnsCfg, nil
+}
+
+func parseBlocklist(cfg RawBlocklist) (*dns.Blocklist, error) {
+	if len(cfg.Lists) == 0 {
+		return nil, nil
+	}
+
+	blocklist := &dns.Blocklist{}
+	switch cfg.Mode {
+	case "", "nxdomain":
+		blocklist.Mode = dns.BlockNXDomain
+	case "null":
+		blocklist.Mode = dns.BlockNull
+	case "refused":
+		blocklist.Mode = dns.BlockRefused
+	default:
+		return nil, fmt.Errorf("dns.blocklist.mode: unsupported mode: %s", cfg.Mode)
+	}
+
+	if len(cfg.Allowlist) != 0 {
+		blocklist.Allowlist = trie.New()
+		for idx, domain := range cfg.Allowlist {
+			if err := blocklist.Allowlist.Insert(strings.ToLower(domain), struct{}{}); err != nil {
+				return nil, fmt.Errorf("dns.blocklist.allowlist[%d]: %w", idx, err)
+			}
+		}
+	}
+
+	// sorted for a stable order of initialization
+	names := lo.Keys(cfg.Lists)
+	sort.Strings(names)
+	for _, name := range names {
+		list, err := provider.ParseBlocklistProvider(name, cfg.Lists[name])
+		if err != nil {
+			return nil, fmt.Errorf("dns.blocklist.lists.%s: %w", name, err)
+		}
+		blocklist.Lists = append(blocklist.Lists, list)
+	}
+
+	return blocklist, nil
+}
+
+func parseAuthentication(rawRecords []string) ([]auth.AuthUser, error) {
+	users := []auth.AuthUser{}
+	for idx, line := range rawRecords {
+		user, pass, found := strings.Cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("authentication[%d]: format invalid, should be user:pass", idx)
+		}
+		users = append(users, auth.AuthUser{User: user, Pass: pass})
+	}
+	return users, nil
+}
+
+func ParseNameServer(servers []string) ([]dns.NameServer, error) {
+	nameservers := []dns.NameServer{}
+
+	for idx, server := range servers {
+		nameserver, err := parseNameServer(server, nil)
+		if err != nil {
+			return nil, fmt.Errorf("DNS NameServer[%d] %w", idx, err)
+		}
+		nameservers = append(nameservers, nameserver)
 	}
 	return nameservers, nil
 }
 
+func parseNameServer(server string, proxies map[string]C.Proxy) (dns.NameServer, error) {
+	// parse without scheme .e.g 8.8.8.8:53
+	if !strings.Contains(server, "://") {
+		server = "udp://" + server
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return dns.NameServer{}, fmt.Errorf("format error: %s", err.Error())
+	}
+
+	// parse with specific interface, proxy or ecs
+	// .e.g 10.0.0.1#en0, tls://1.1.1.1#proxy=JP or 8.8.8.8#interface=en0&proxy=JP&ecs=1.2.3.0/24
+	options, err := parseNameServerFragment(u.Fragment)
+	if err != nil {
+		return dns.NameServer{}, err
+	}
+
+	var proxy C.Proxy
+	if options.proxy != "" {
+		p, ok := proxies[options.proxy]
+		if !ok {
+			return dns.NameServer{}, fmt.Errorf("unknown proxy '%s'", options.proxy)
+		}
+		proxy = p
+	}
+
+	var ecs *dns.ECS
+	if options.ecs != "" {
+		if ecs, err = parseECS(options.ecs); err != nil {
+			return dns.NameServer{}, err
+		}
+	}
+
+	var addr, dnsNetType string
+	switch u.Scheme {
+	case "udp":
+		addr, err = hostWithDefaultPort(u.Host, "53")
+		dnsNetType = "" // UDP
+	case "tcp":
+		addr, err = hostWithDefaultPort(u.Host, "53")
+		dnsNetType = "tcp" // TCP
+	case "tls":
+		addr, err = hostWithDefaultPort(u.Host, "853")
+		dnsNetType = "tcp-tls" // DNS over TLS
+	case "https":
+		clearURL := url.URL{Scheme: "https", Host: u.Host, Path: u.Path, User: u.User}
+		addr = clearURL.String()
+		dnsNetType = "https" // DNS over HTTPS
+	case "h3":
+		clearURL := url.URL{Scheme: "https", Host: u.Host, Path: u.Path, User: u.User}
+		addr = clearURL.String()
+		dnsNetType = "h3" // DNS over HTTPS over HTTP/3
+	case "quic":
+		addr, err = hostWithDefaultPort(u.Host, "853")
+		dnsNetType = "quic" // DNS over QUIC
+	case "dhcp":
+		addr = u.Host
+		dnsNetType = "dhcp" // UDP from DHCP
+	case "system":
+		addr = u.Host
+		dnsNetType = "system" // UDP from System
+	case "fake-ip":
+		addr = u.Host
+		dnsNetType = "fake-ip" // Fake Ip
+	default:
+		return dns.NameServer{}, fmt.Errorf("unsupport scheme: %s", u.Scheme)
+	}
+
+	if err != nil {
+		return dns.NameServer{}, fmt.Errorf("format error: %s", err.Error())
+	}
+
+	switch dnsNetType {
+	case "dhcp", "system", "fake-ip":
+		if proxy != nil {
+			return dns.NameServer{}, fmt.Errorf("%s nameserver can't be dialed through a proxy", u.Scheme)
+		}
+	}
+	if dnsNetType == "fake-ip" && ecs != nil {
+		return dns.NameServer{}, errors.New("fake-ip nameserver doesn't support ecs")
+	}
+
+	return dns.NameServer{
+		Net:       dnsNetType,
+		Addr:      addr,
+		Interface: options.iface,
+		Proxy:     proxy,
+		ECS:       ecs,
+	}, nil
+}
+
+// nameServerOptions are the options in the fragment of a nameserver
+type nameServerOptions struct {
+	iface string
+	proxy string
+	ecs   string
+}
+
+// parseNameServerFragment returns the options of a nameserver, a fragment
+// without '=' is the name of an interface
+func parseNameServerFragment(fragment string) (nameServerOptions, error) {
+	if !strings.Contains(fragment, "=") {
+		return nameServerOptions{iface: fragment}, nil
+	}
+
+	options := nameServerOptions{}
+	for _, option := range strings.Split(fragment, "&") {
+		key, value, _ := strings.Cut(option, "=")
+		switch key {
+		case "interface":
+			options.iface = value
+		case "proxy":
+			options.proxy = value
+		case "ecs":
+			options.ecs = value
+		default:
+			return nameServerOptions{}, fmt.Errorf("unsupported option: %s", key)
+		}
+	}
+	return options, nil
+}
+
+// parseECS parses a subnet such as 1.2.3.0/24, or "client" for the subnet of the client
+func parseECS(value string) (*dns.ECS, error) {
+	if value == "client" {
+		return &dns.ECS{FromClient: true}, nil
+	}
+
+	subnet, err := netip.ParsePrefix(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ecs '%s': %w", value, err)
+	}
+	return &dns.ECS{Subnet: subnet.Masked()}, nil
+}
+
 func hostWithDefaultPort(host string, defPort string) (string, error) {
 	if !strings.Contains(host, ":") {
 		host += ":"
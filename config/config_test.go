@@ -0,0 +1,645 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/component/sniffer"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/dns"
+	T "github.com/Dreamacro/clash/tunnel"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse([]byte(`
+mixed-port: 7890
+mode: rule
+log-level: debug
+authentication:
+  - "user:pass"
+hosts:
+  example.com: 10.0.0.1
+proxies:
+  - name: HK
+    type: ss
+    server: 127.0.0.1
+    port: 8388
+    cipher: aes-128-gcm
+    password: password
+proxy-groups:
+  - name: Auto
+    type: select
+    proxies: [Select, DIRECT]
+  - name: Select
+    type: select
+    proxies: [HK, REJECT]
+rules:
+  - DOMAIN-SUFFIX,example.com,Auto
+  - IP-CIDR,10.0.0.0/8,DIRECT,no-resolve
+  - AND,((DOMAIN-SUFFIX,example.org),(NOT,((DST-PORT,80)))),Select
+  - MATCH,HK
+dns:
+  enable: true
+  listen: 127.0.0.1:5353
+  enhanced-mode: fake-ip
+  nameserver:
+    - 1.1.1.1
+    - tls://dns.example.com
+    - quic://dns.example.com
+  fallback:
+    - https://dns.example.com/dns-query
+    - h3://dns.example.com/dns-query
+tunnels:
+  - tcp/udp,127.0.0.1:6553,8.8.8.8:53,HK
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, 7890, cfg.General.MixedPort)
+	assert.Equal(t, T.Rule, cfg.General.Mode)
+	assert.Len(t, cfg.Users, 1)
+	assert.Equal(t, "pass", cfg.Users[0].Pass)
+
+	for _, name := range []string{"DIRECT", "REJECT", "GLOBAL", "HK", "Auto", "Select"} {
+		assert.Contains(t, cfg.Proxies, name)
+	}
+
+	require.Len(t, cfg.Rules, 4)
+	assert.Equal(t, C.DomainSuffix, cfg.Rules[0].RuleType())
+	assert.Equal(t, "Auto", cfg.Rules[0].Adapter())
+	assert.False(t, cfg.Rules[1].ShouldResolveIP())
+	assert.Equal(t, C.AND, cfg.Rules[2].RuleType())
+	assert.Equal(t, "Select", cfg.Rules[2].Adapter())
+	assert.Equal(t, "DomainSuffix(example.org) && !DstPort(80)", cfg.Rules[2].Payload())
+
+	node := cfg.Hosts.Search("example.com")
+	require.NotNil(t, node)
+	assert.Equal(t, net.ParseIP("10.0.0.1"), node.Data.(net.IP))
+
+	assert.True(t, cfg.DNS.Enable)
+	assert.Equal(t, C.DNSFakeIP, cfg.DNS.EnhancedMode)
+	assert.NotNil(t, cfg.DNS.FakeIPRange)
+	require.Len(t, cfg.DNS.NameServer, 3)
+	assert.Equal(t, "1.1.1.1:53", cfg.DNS.NameServer[0].Addr)
+	assert.Equal(t, "tcp-tls", cfg.DNS.NameServer[1].Net)
+	assert.Equal(t, "dns.example.com:853", cfg.DNS.NameServer[1].Addr)
+	assert.Equal(t, "quic", cfg.DNS.NameServer[2].Net)
+	assert.Equal(t, "dns.example.com:853", cfg.DNS.NameServer[2].Addr)
+	require.Len(t, cfg.DNS.Fallback, 2)
+	assert.Equal(t, "h3", cfg.DNS.Fallback[1].Net)
+	assert.Equal(t, "https://dns.example.com/dns-query", cfg.DNS.Fallback[1].Addr)
+
+	require.Len(t, cfg.Tunnels, 1)
+	assert.Equal(t, []string{"tcp", "udp"}, cfg.Tunnels[0].Network)
+	assert.Equal(t, "HK", cfg.Tunnels[0].Proxy)
+}
+
+func TestParseRuleProviders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ads.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("payload:\n  - +.ads.example.com\n"), 0o644))
+
+	cfg, err := Parse([]byte(fmt.Sprintf(`
+rule-providers:
+  ads:
+    type: file
+    behavior: domain
+    path: %s
+rules:
+  - RULE-SET,ads,REJECT
+  - MATCH,DIRECT
+`, path)))
+	require.NoError(t, err)
+
+	require.Contains(t, cfg.RuleProviders, "ads")
+	require.Len(t, cfg.Rules, 2)
+	assert.Equal(t, C.RuleSet, cfg.Rules[0].RuleType())
+	assert.Equal(t, "ads", cfg.Rules[0].Payload())
+	assert.True(t, cfg.Rules[0].Match(&C.Metadata{Host: "track.ads.example.com"}))
+	assert.False(t, cfg.Rules[0].Match(&C.Metadata{Host: "example.com"}))
+}
+
+func TestParseProvidersInitial(t *testing.T) {
+	dir := t.TempDir()
+	config := fmt.Sprintf(`
+rule-providers:
+  ads:
+    type: file
+    behavior: domain
+    path: %s
+rules:
+  - RULE-SET,ads,REJECT
+dns:
+  enable: true
+  nameserver: [1.1.1.1]
+  blocklist:
+    lists:
+      trackers:
+        type: file
+        path: %s
+`, filepath.Join(dir, "ads.yaml"), filepath.Join(dir, "trackers.txt"))
+
+	// the providers aren't loaded if the config is invalid
+	_, err := Parse([]byte(config + `
+tunnels:
+  - tcp,127.0.0.1:6553,8.8.8.8:53,HK
+`))
+	assert.EqualError(t, err, "tunnels[0].proxy: unknown proxy 'HK'")
+
+	_, err = Parse([]byte(config))
+	assert.ErrorContains(t, err, "rule-providers.ads: ")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ads.yaml"), []byte("payload: [ads.com]\n"), 0o644))
+	_, err = Parse([]byte(config))
+	assert.ErrorContains(t, err, "dns.blocklist.lists.trackers: ")
+}
+
+func TestParseScript(t *testing.T) {
+	cfg, err := Parse([]byte(`
+proxies:
+  - name: JP
+    type: socks5
+    server: 127.0.0.1
+    port: 1080
+script:
+  shortcuts:
+    quic: network == "udp" && dst_port == 443 && cidr(src_ip, "10.0.0.0/8") && process_name != "chrome"
+rules:
+  - SCRIPT,quic,JP
+  - MATCH,DIRECT
+`))
+	require.NoError(t, err)
+
+	require.Len(t, cfg.Rules, 2)
+	rule := cfg.Rules[0]
+	assert.Equal(t, C.Script, rule.RuleType())
+	assert.Equal(t, "JP", rule.Adapter())
+	assert.True(t, rule.ShouldFindProcess())
+	assert.False(t, rule.ShouldResolveIP())
+
+	metadata := &C.Metadata{NetWork: C.UDP, SrcIP: net.ParseIP("10.0.0.2"), DstPort: 443, ProcessPath: "/usr/bin/curl"}
+	assert.True(t, rule.Match(metadata))
+	metadata.ProcessPath = "/usr/bin/chrome"
+	assert.False(t, rule.Match(metadata))
+}
+
+func TestParseSniffer(t *testing.T) {
+	cfg, err := Parse([]byte(`
+sniffer:
+  enable: true
+  timeout: 200
+  override-destination: true
+  sniff:
+    tls:
+      ports: [443, 8443-8444]
+    http:
+      ports: ["80"]
+    quic:
+      ports: [443]
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, &sniffer.Config{
+		Enable:              true,
+		Timeout:             200 * time.Millisecond,
+		OverrideDestination: true,
+		Ports: map[sniffer.Type][]sniffer.PortRange{
+			sniffer.TLS:  {{Start: 443, End: 443}, {Start: 8443, End: 8444}},
+			sniffer.HTTP: {{Start: 80, End: 80}},
+			sniffer.QUIC: {{Start: 443, End: 443}},
+		},
+	}, cfg.Sniffer)
+}
+
+func TestParseDNSCache(t *testing.T) {
+	cfg, err := Parse([]byte(`
+dns:
+  enable: true
+  nameserver: [1.1.1.1]
+`))
+	require.NoError(t, err)
+	assert.Equal(t, DNSCache{}, cfg.DNS.Cache)
+
+	cfg, err = Parse([]byte(`
+dns:
+  enable: true
+  nameserver: [1.1.1.1]
+  cache:
+    persist: true
+    prefetch: true
+    max-stale: 3600
+`))
+	require.NoError(t, err)
+	assert.Equal(t, DNSCache{Persist: true, Prefetch: true, MaxStale: time.Hour}, cfg.DNS.Cache)
+
+	cfg, err = Parse([]byte(`
+dns:
+  enable: true
+  nameserver: [1.1.1.1]
+  cache:
+    serve-stale: false
+`))
+	require.NoError(t, err)
+	assert.Equal(t, DNSCache{DisableServeStale: true}, cfg.DNS.Cache)
+}
+
+func TestParseFakeIPRange6(t *testing.T) {
+	cfg, err := Parse([]byte(`
+dns:
+  enable: true
+  nameserver: [1.1.1.1]
+  enhanced-mode: fake-ip
+  fake-ip-range6: fdfe:dcba:9876::/64
+`))
+	require.NoError(t, err)
+	require.NotNil(t, cfg.DNS.FakeIPRange6)
+	assert.Equal(t, "fdfe:dcba:9876::/64", cfg.DNS.FakeIPRange6.IPNet().String())
+}
+
+func TestParseBlocklist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ads.txt")
+	require.NoError(t, os.WriteFile(path, []byte("||ads.com^\n"), 0o644))
+
+	cfg, err := Parse([]byte(fmt.Sprintf(`
+dns:
+  enable: true
+  nameserver: [1.1.1.1]
+  blocklist:
+    mode: refused
+    allowlist: [ok.ads.com]
+    lists:
+      ads:
+        type: file
+        path: %s
+`, path)))
+	require.NoError(t, err)
+	require.NotNil(t, cfg.DNS.Blocklist)
+	assert.Equal(t, dns.BlockRefused, cfg.DNS.Blocklist.Mode)
+	assert.True(t, cfg.DNS.Blocklist.Blocked("www.ads.com"))
+	assert.False(t, cfg.DNS.Blocklist.Blocked("ok.ads.com"))
+}
+
+func TestParseNameServerProxy(t *testing.T) {
+	cfg, err := Parse([]byte(`
+proxies:
+  - name: JP
+    type: socks5
+    server: 127.0.0.1
+    port: 1080
+dns:
+  enable: true
+  nameserver:
+    - tls://1.1.1.1#proxy=JP
+    - 8.8.8.8#interface=en0&proxy=JP
+    - 9.9.9.9#en0
+`))
+	require.NoError(t, err)
+
+	ns := cfg.DNS.NameServer
+	require.Len(t, ns, 3)
+	require.NotNil(t, ns[0].Proxy)
+	assert.Equal(t, "JP", ns[0].Proxy.Name())
+	assert.Equal(t, "tcp-tls", ns[0].Net)
+	assert.Equal(t, "en0", ns[1].Interface)
+	assert.Equal(t, "JP", ns[1].Proxy.Name())
+	assert.Equal(t, "en0", ns[2].Interface)
+	assert.Nil(t, ns[2].Proxy)
+}
+
+func TestParseDNSStrategyAndECS(t *testing.T) {
+	cfg, err := Parse([]byte(`
+dns:
+  enable: true
+  strategy: prefer-ipv6
+  nameserver:
+    - 8.8.8.8#ecs=1.2.3.4/24
+    - https://1.1.1.1/dns-query#ecs=client&interface=en0
+  nameserver-policy:
+    "+.cn": 223.5.5.5
+    "+.example.com":
+      nameserver: tls://8.8.8.8#ecs=2001:db8::/56
+      strategy: ipv4-only
+`))
+	require.NoError(t, err)
+	assert.Equal(t, dns.StrategyPreferIPv6, cfg.DNS.Strategy)
+
+	ns := cfg.DNS.NameServer
+	require.Len(t, ns, 2)
+	assert.Equal(t, &dns.ECS{Subnet: netip.MustParsePrefix("1.2.3.0/24")}, ns[0].ECS)
+	assert.Equal(t, &dns.ECS{FromClient: true}, ns[1].ECS)
+	assert.Equal(t, "en0", ns[1].Interface)
+
+	policy := cfg.DNS.NameServerPolicy
+	require.Len(t, policy, 2)
+	assert.Equal(t, dns.StrategyDefault, policy["+.cn"].Strategy)
+	assert.Equal(t, "223.5.5.5:53", policy["+.cn"].NameServer.Addr)
+	assert.Nil(t, policy["+.cn"].NameServer.ECS)
+	assert.Equal(t, dns.StrategyIPv4Only, policy["+.example.com"].Strategy)
+	assert.Equal(t, "tcp-tls", policy["+.example.com"].NameServer.Net)
+	assert.Equal(t, &dns.ECS{Subnet: netip.MustParsePrefix("2001:db8::/56")}, policy["+.example.com"].NameServer.ECS)
+}
+
+func TestParseError(t *testing.T) {
+	proxies := `
+proxies:
+  - name: JP
+    type: socks5
+    server: 127.0.0.1
+    port: 1080
+`
+
+	testCases := []struct {
+		name     string
+		config   string
+		expected string
+	}{
+		{
+			name: "unknown proxy in group",
+			config: proxies + `
+proxy-groups:
+  - name: A
+    type: select
+    proxies: [JP]
+  - name: B
+    type: select
+    proxies: [A, HK]
+`,
+			expected: "proxy-groups[1].proxies: unknown proxy 'HK'",
+		},
+		{
+			name: "proxy provider in group",
+			config: proxies + `
+proxy-groups:
+  - name: A
+    type: select
+    proxies: [JP]
+    use: [subscription]
+`,
+			expected: "proxy-groups[0].use: proxy providers are not supported",
+		},
+		{
+			name: "duplicate group name",
+			config: proxies + `
+proxy-groups:
+  - name: JP
+    type: select
+    proxies: [DIRECT]
+`,
+			expected: "proxy-groups[0].name: duplicate name 'JP'",
+		},
+		{
+			name: "unsupported proxy type",
+			config: `
+proxies:
+  - name: X
+    type: unknown
+`,
+			expected: "proxies[0]: unsupport proxy type: unknown",
+		},
+		{
+			name: "unknown proxy in rule",
+			config: proxies + `
+rules:
+  - MATCH,JP
+  - DOMAIN,example.com,HK
+`,
+			expected: "rules[1]: unknown proxy 'HK'",
+		},
+		{
+			name: "invalid rule format",
+			config: `
+rules:
+  - MATCH
+`,
+			expected: "rules[0]: format invalid 'MATCH'",
+		},
+		{
+			name: "unknown rule provider",
+			config: `
+rules:
+  - RULE-SET,ads,REJECT
+`,
+			expected: "rules[0]: unknown rule provider 'ads'",
+		},
+		{
+			name: "invalid rule provider behavior",
+			config: `
+rule-providers:
+  ads:
+    type: file
+    behavior: unknown
+    path: ads.yaml
+`,
+			expected: "rule-providers.ads: unsupported behavior: unknown",
+		},
+		{
+			name: "unknown script shortcut",
+			config: `
+rules:
+  - SCRIPT,quic,DIRECT
+`,
+			expected: "rules[0]: unknown script shortcut 'quic'",
+		},
+		{
+			name: "invalid script",
+			config: `
+script:
+  shortcuts:
+    quic: dst_port == "443"
+rules:
+  - SCRIPT,quic,DIRECT
+`,
+			expected: "rules[0]: script.shortcuts.quic: offset 9: mismatched types int and string",
+		},
+		{
+			name: "unknown proxy returned by script",
+			config: proxies + `
+script:
+  shortcuts:
+    quic: 'dst_port == 443 ? (network == "udp" ? "JP" : "HK") : ""'
+rules:
+  - SCRIPT,quic,DIRECT
+`,
+			expected: "rules[0]: script.shortcuts.quic: unknown proxy 'HK'",
+		},
+		{
+			name: "invalid host",
+			config: `
+hosts:
+  example.com: not-an-ip
+`,
+			expected: "hosts.example.com: invalid IP 'not-an-ip'",
+		},
+		{
+			name: "unsupported nameserver scheme",
+			config: `
+dns:
+  enable: true
+  nameserver:
+    - 1.1.1.1
+    - ftp://1.1.1.1
+`,
+			expected: "dns.nameserver[1]: unsupport scheme: ftp",
+		},
+		{
+			name: "invalid fallback filter domain",
+			config: `
+dns:
+  enable: true
+  nameserver: [1.1.1.1]
+  fallback: [8.8.8.8]
+  fallback-filter:
+    domain: ["+.google.com", "a..b"]
+`,
+			expected: "dns.fallback-filter.domain[1]: invalid domain",
+		},
+		{
+			name: "dns over tls without certificate",
+			config: `
+dns:
+  enable: true
+  nameserver: [1.1.1.1]
+  listen-tls: 0.0.0.0:853
+`,
+			expected: "dns.certificate: certificate and private-key are required by listen-tls and listen-https",
+		},
+		{
+			name: "unsupported dns strategy",
+			config: `
+dns:
+  enable: true
+  nameserver: [1.1.1.1]
+  strategy: ipv5-only
+`,
+			expected: "dns.strategy: unsupported strategy: ipv5-only",
+		},
+		{
+			name: "unsupported nameserver-policy strategy",
+			config: `
+dns:
+  enable: true
+  nameserver: [1.1.1.1]
+  nameserver-policy:
+    "+.cn":
+      nameserver: 223.5.5.5
+      strategy: ipv5-only
+`,
+			expected: "dns.nameserver-policy.+.cn.strategy: unsupported strategy: ipv5-only",
+		},
+		{
+			name: "invalid nameserver ecs",
+			config: `
+dns:
+  enable: true
+  nameserver: [1.1.1.1#ecs=1.2.3.4]
+`,
+			expected: "dns.nameserver[0]: invalid ecs '1.2.3.4': netip.ParsePrefix(\"1.2.3.4\"): no '/'",
+		},
+		{
+			name: "fake-ip nameserver with ecs",
+			config: `
+dns:
+  enable: true
+  nameserver: [fake-ip://#ecs=client]
+`,
+			expected: "dns.nameserver[0]: fake-ip nameserver doesn't support ecs",
+		},
+		{
+			name: "negative dns max-stale",
+			config: `
+dns:
+  enable: true
+  nameserver: [1.1.1.1]
+  cache:
+    max-stale: -1
+`,
+			expected: "dns.cache.max-stale: invalid duration -1",
+		},
+		{
+			name: "ipv4 fake-ip-range6",
+			config: `
+dns:
+  enable: true
+  nameserver: [1.1.1.1]
+  enhanced-mode: fake-ip
+  fake-ip-range6: 198.18.0.1/16
+`,
+			expected: "dns.fake-ip-range6: should be an IPv6 range",
+		},
+		{
+			name: "unsupported blocklist mode",
+			config: `
+dns:
+  enable: true
+  nameserver: [1.1.1.1]
+  blocklist:
+    mode: drop
+    lists:
+      ads:
+        type: file
+        path: ads.txt
+`,
+			expected: "dns.blocklist.mode: unsupported mode: drop",
+		},
+		{
+			name: "unknown nameserver proxy",
+			config: proxies + `
+dns:
+  enable: true
+  nameserver: [1.1.1.1, tls://8.8.8.8#proxy=HK]
+`,
+			expected: "dns.nameserver[1]: unknown proxy 'HK'",
+		},
+		{
+			name: "system nameserver through proxy",
+			config: proxies + `
+dns:
+  enable: true
+  nameserver: [system://#proxy=JP]
+`,
+			expected: "dns.nameserver[0]: system nameserver can't be dialed through a proxy",
+		},
+		{
+			name: "unknown tunnel proxy",
+			config: `
+tunnels:
+  - tcp,127.0.0.1:6553,8.8.8.8:53,HK
+`,
+			expected: "tunnels[0].proxy: unknown proxy 'HK'",
+		},
+		{
+			name: "unsupported sniffer type",
+			config: `
+sniffer:
+  sniff:
+    ftp:
+      ports: [21]
+`,
+			expected: "sniffer.sniff.ftp: unsupported sniffer type: ftp",
+		},
+		{
+			name: "invalid sniffer port",
+			config: `
+sniffer:
+  sniff:
+    tls:
+      ports: [443, 9000-8000]
+`,
+			expected: "sniffer.sniff.tls.ports[1]: invalid port '9000-8000'",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse([]byte(tc.config))
+			require.Error(t, err)
+			assert.EqualError(t, err, tc.expected)
+		})
+	}
+}
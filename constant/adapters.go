@@ -22,6 +22,8 @@ const (
 	Vless
 	Vmess
 	Trojan
+	WireGuard
+	Ssh
 
 	Relay
 	Selector
@@ -147,6 +149,10 @@ func (at AdapterType) String() string {
 		return "Vmess"
 	case Trojan:
 		return "Trojan"
+	case WireGuard:
+		return "WireGuard"
+	case Ssh:
+		return "Ssh"
 
 	case Relay:
 		return "Relay"
@@ -74,6 +74,7 @@ type Metadata struct {
 	Host         string  `json:"host"`
 	DNSMode      DNSMode `json:"dnsMode"`
 	ProcessPath  string  `json:"processPath"`
+	UID          *uint32 `json:"uid,omitempty"`
 	SpecialProxy string  `json:"specialProxy"`
 
 	OriginDst netip.AddrPort `json:"-"`
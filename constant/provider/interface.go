@@ -37,6 +37,7 @@ type Vehicle interface {
 const (
 	Proxy ProviderType = iota
 	Rule
+	Blocklist
 )
 
 // ProviderType defined
@@ -48,6 +49,8 @@ func (pt ProviderType) String() string {
 		return "Proxy"
 	case Rule:
 		return "Rule"
+	case Blocklist:
+		return "Blocklist"
 	default:
 		return "Unknown"
 	}
@@ -101,5 +104,15 @@ type RuleProvider interface {
 	Behavior() RuleType
 	Match(*constant.Metadata) bool
 	ShouldResolveIP() bool
+	ShouldFindProcess() bool
 	AsRule(adaptor string) constant.Rule
 }
+
+// BlocklistProvider interface
+type BlocklistProvider interface {
+	Provider
+	// Blocked returns if domain is blocked by the list
+	Blocked(domain string) bool
+	// Allowed returns if domain is excepted by the list, exceptions override every list
+	Allowed(domain string) bool
+}
@@ -13,9 +13,14 @@ const (
 	RuleConfigInboundPort   RuleConfig = "INBOUND-PORT"
 	RuleConfigProcessName   RuleConfig = "PROCESS-NAME"
 	RuleConfigProcessPath   RuleConfig = "PROCESS-PATH"
+	RuleConfigProcessUID    RuleConfig = "PROCESS-UID"
+	RuleConfigUID           RuleConfig = "UID"
 	RuleConfigIPSet         RuleConfig = "IPSET"
 	RuleConfigRuleSet       RuleConfig = "RULE-SET"
 	RuleConfigScript        RuleConfig = "SCRIPT"
+	RuleConfigAND           RuleConfig = "AND"
+	RuleConfigOR            RuleConfig = "OR"
+	RuleConfigNOT           RuleConfig = "NOT"
 	RuleConfigMatch         RuleConfig = "MATCH"
 )
 
@@ -35,7 +40,13 @@ const (
 	InboundPort
 	Process
 	ProcessPath
+	ProcessUID
 	IPSet
+	RuleSet
+	Script
+	AND
+	OR
+	NOT
 	MATCH
 )
 
@@ -65,8 +76,20 @@ func (rt RuleType) String() string {
 		return "Process"
 	case ProcessPath:
 		return "ProcessPath"
+	case ProcessUID:
+		return "ProcessUID"
 	case IPSet:
 		return "IPSet"
+	case RuleSet:
+		return "RuleSet"
+	case Script:
+		return "Script"
+	case AND:
+		return "AND"
+	case OR:
+		return "OR"
+	case NOT:
+		return "NOT"
 	case MATCH:
 		return "Match"
 	default:
@@ -82,3 +105,9 @@ type Rule interface {
 	ShouldResolveIP() bool
 	ShouldFindProcess() bool
 }
+
+// AdapterRule is implemented by rules that choose the adapter while matching, e.g. SCRIPT
+type AdapterRule interface {
+	Rule
+	MatchAdapter(metadata *Metadata) (adapter string, matched bool)
+}
@@ -37,3 +37,18 @@ func (c *ConnContext) Metadata() *C.Metadata {
 func (c *ConnContext) Conn() net.Conn {
 	return c.conn
 }
+
+type wrappedConnContext struct {
+	C.ConnContext
+	conn net.Conn
+}
+
+// Conn implement C.ConnContext Conn
+func (c *wrappedConnContext) Conn() net.Conn {
+	return c.conn
+}
+
+// WithConn returns a copy of ctx whose Conn is conn, e.g. a buffered conn wrapping the original one
+func WithConn(ctx C.ConnContext, conn net.Conn) C.ConnContext {
+	return &wrappedConnContext{ConnContext: ctx, conn: conn}
+}
@@ -1,6 +1,8 @@
 package context
 
 import (
+	"net/netip"
+
 	"github.com/gofrs/uuid/v5"
 	"github.com/miekg/dns"
 )
@@ -9,12 +11,14 @@ const (
 	DNSTypeHost   = "host"
 	DNSTypeFakeIP = "fakeip"
 	DNSTypeRaw    = "raw"
+	DNSTypeBlock  = "block"
 )
 
 type DNSContext struct {
-	id  uuid.UUID
-	msg *dns.Msg
-	tp  string
+	id         uuid.UUID
+	msg        *dns.Msg
+	tp         string
+	clientAddr netip.Addr
 }
 
 func NewDNSContext(msg *dns.Msg) *DNSContext {
@@ -39,3 +43,13 @@ func (c *DNSContext) SetType(tp string) {
 func (c *DNSContext) Type() string {
 	return c.tp
 }
+
+// SetClientAddr set the address of the client that sent the query
+func (c *DNSContext) SetClientAddr(addr netip.Addr) {
+	c.clientAddr = addr
+}
+
+// ClientAddr return the address of the client, it's invalid if the query isn't from the DNS server
+func (c *DNSContext) ClientAddr() netip.Addr {
+	return c.clientAddr
+}
@@ -0,0 +1,86 @@
+package dns
+
+import (
+	"net"
+	"strings"
+
+	"github.com/Dreamacro/clash/component/trie"
+	types "github.com/Dreamacro/clash/constant/provider"
+	"github.com/Dreamacro/clash/context"
+	"github.com/Dreamacro/clash/log"
+
+	D "github.com/miekg/dns"
+)
+
+// BlockMode is how the query of a blocked domain is answered
+type BlockMode int
+
+const (
+	// BlockNXDomain answers NXDOMAIN
+	BlockNXDomain BlockMode = iota
+	// BlockNull answers 0.0.0.0 and ::, other types get an empty answer
+	BlockNull
+	// BlockRefused answers REFUSED
+	BlockRefused
+)
+
+// Blocklist answers the queries of blocked domains without forwarding them
+type Blocklist struct {
+	Mode  BlockMode
+	Lists []types.BlocklistProvider
+	// Allowlist overrides every list
+	Allowlist *trie.DomainTrie
+}
+
+// Blocked returns if domain is blocked by a list and not allowed by any list or the allowlist
+func (b *Blocklist) Blocked(domain string) bool {
+	if b.Allowlist != nil && b.Allowlist.Search(domain) != nil {
+		return false
+	}
+
+	blocked := false
+	for _, list := range b.Lists {
+		if list.Allowed(domain) {
+			return false
+		}
+		blocked = blocked || list.Blocked(domain)
+	}
+	return blocked
+}
+
+func (b *Blocklist) answer(r *D.Msg) *D.Msg {
+	msg := &D.Msg{}
+	switch b.Mode {
+	case BlockRefused:
+		msg.SetRcode(r, D.RcodeRefused)
+	case BlockNull:
+		msg.SetRcode(r, D.RcodeSuccess)
+		q := r.Question[0]
+		hdr := D.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: D.ClassINET, Ttl: dnsDefaultTTL}
+		switch q.Qtype {
+		case D.TypeA:
+			msg.Answer = []D.RR{&D.A{Hdr: hdr, A: net.IPv4zero}}
+		case D.TypeAAAA:
+			msg.Answer = []D.RR{&D.AAAA{Hdr: hdr, AAAA: net.IPv6zero}}
+		}
+	default:
+		msg.SetRcode(r, D.RcodeNameError)
+	}
+	msg.RecursionAvailable = true
+	return msg
+}
+
+func withBlocklist(blocklist *Blocklist) middleware {
+	return func(next handler) handler {
+		return func(ctx *context.DNSContext, r *D.Msg) (*D.Msg, error) {
+			host := strings.ToLower(strings.TrimRight(r.Question[0].Name, "."))
+			if !blocklist.Blocked(host) {
+				return next(ctx, r)
+			}
+
+			log.Debugln("[DNS] %s is blocked", host)
+			ctx.SetType(context.DNSTypeBlock)
+			return blocklist.answer(r), nil
+		}
+	}
+}
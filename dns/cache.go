@@ -0,0 +1,224 @@
+package dns
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/component/profile/cachefile"
+	"github.com/Dreamacro/clash/component/resolver"
+	"github.com/Dreamacro/clash/log"
+
+	D "github.com/miekg/dns"
+	"go.uber.org/atomic"
+)
+
+const (
+	// staleTTL is the TTL of stale answers recommended by RFC 8767
+	staleTTL uint32 = 30
+	// staleAnswerTimeout is the client response timer of RFC 8767, a stale answer
+	// is served if the refresh doesn't finish in time
+	staleAnswerTimeout = 1800 * time.Millisecond
+
+	// an entry hit prefetchHits times is refreshed once less than 1/prefetchRatio of its TTL remains
+	prefetchHits  = 2
+	prefetchRatio = 10
+
+	persistInterval = 5 * time.Minute
+
+	// DefaultMaxStale is how long an expired answer may be served by default
+	DefaultMaxStale = 24 * time.Hour
+)
+
+var (
+	persistMux sync.Mutex
+	// persistResolver is the resolver that saves the cache file
+	persistResolver *Resolver
+)
+
+// cacheEntry is the value of Resolver.lruCache
+type cacheEntry struct {
+	msg  *D.Msg
+	ttl  uint32
+	hits atomic.Uint32
+	// prefetching makes sure an entry is prefetched once
+	prefetching atomic.Bool
+}
+
+// CacheStats counts the lookups of the DNS cache
+type CacheStats struct {
+	Hits       uint64 `json:"hits"`
+	Misses     uint64 `json:"misses"`
+	StaleHits  uint64 `json:"staleHits"`
+	Prefetches uint64 `json:"prefetches"`
+}
+
+type cacheCounter struct {
+	hits       atomic.Uint64
+	misses     atomic.Uint64
+	staleHits  atomic.Uint64
+	prefetches atomic.Uint64
+}
+
+// CacheStats returns the counters of the DNS cache
+func (r *Resolver) CacheStats() CacheStats {
+	return CacheStats{
+		Hits:       r.counter.hits.Load(),
+		Misses:     r.counter.misses.Load(),
+		StaleHits:  r.counter.staleHits.Load(),
+		Prefetches: r.counter.prefetches.Load(),
+	}
+}
+
+// exchangeFromCache answers m from the cache entry of key, ok is false on a cache miss
+func (r *Resolver) exchangeFromCache(ctx context.Context, key string, m *D.Msg) (msg *D.Msg, ok bool) {
+	item, expireTime, hit := r.lruCache.GetWithExpire(key)
+	entry, isEntry := item.(*cacheEntry)
+	if !hit || !isEntry {
+		return nil, false
+	}
+
+	now := time.Now()
+	if expireTime.After(now) {
+		r.counter.hits.Inc()
+		msg = copyMsgFromCache(m, entry.msg)
+		remaining := uint32(expireTime.Sub(now).Seconds())
+		// updating TTL by subtracting common delta time from each DNS record
+		updateMsgTTL(msg, remaining)
+
+		if r.prefetch && entry.hits.Inc() >= prefetchHits && remaining*prefetchRatio < entry.ttl &&
+			entry.prefetching.CompareAndSwap(false, true) {
+			r.counter.prefetches.Inc()
+			go r.refresh(ctx, m)
+		}
+		return msg, true
+	}
+
+	if now.Sub(expireTime) > r.maxStale {
+		r.lruCache.Delete(key)
+		return nil, false
+	}
+
+	// https://datatracker.ietf.org/doc/html/rfc8767#section-5
+	// the stale answer is served only if the refresh doesn't finish in time
+	ch := make(chan *D.Msg, 1)
+	go func() {
+		defer close(ch)
+		if msg, err := r.refresh(ctx, m); err == nil && msg.Rcode != D.RcodeServerFailure {
+			ch <- msg
+		}
+	}()
+
+	timer := time.NewTimer(staleAnswerTimeout)
+	defer timer.Stop()
+	select {
+	case fresh, ok := <-ch:
+		if ok {
+			r.counter.misses.Inc()
+			return fresh, true
+		}
+	case <-timer.C:
+	}
+
+	r.counter.staleHits.Inc()
+	msg = copyMsgFromCache(m, entry.msg)
+	setMsgTTL(msg, staleTTL)
+	return msg, true
+}
+
+// refresh updates the cache entry of m, it isn't canceled with the query which
+// is answered from the cache before the refresh finishes
+func (r *Resolver) refresh(ctx context.Context, m *D.Msg) (*D.Msg, error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), resolver.DefaultDNSTimeout)
+	defer cancel()
+	return r.exchangeWithoutCache(ctx, m)
+}
+
+// loadCache restores the DNS cache saved in the cache file
+func (r *Resolver) loadCache() {
+	now := time.Now()
+	count := 0
+	for _, record := range r.cacheFile.DNSCache() {
+		if record.Expires.Add(r.maxStale).Before(now) {
+			continue
+		}
+
+		msg := &D.Msg{}
+		if err := msg.Unpack(record.Msg); err != nil {
+			continue
+		}
+		r.lruCache.SetWithExpire(record.Key, &cacheEntry{
+			msg: msg,
+			ttl: minimalTTL(append(append(msg.Answer, msg.Ns...), msg.Extra...)),
+		}, record.Expires)
+		count++
+	}
+	log.Debugln("[DNS] %d cache entries restored", count)
+}
+
+// saveCache writes the DNS cache that isn't beyond max-stale to the cache file
+func (r *Resolver) saveCache() error {
+	now := time.Now()
+	records := []cachefile.DNSRecord{}
+	r.lruCache.Range(func(key any, value any, expires time.Time) bool {
+		entry, ok := value.(*cacheEntry)
+		if !ok || expires.Add(r.maxStale).Before(now) {
+			return true
+		}
+
+		buf, err := entry.msg.Pack()
+		if err != nil {
+			return true
+		}
+		records = append(records, cachefile.DNSRecord{Key: key.(string), Expires: expires, Msg: buf})
+		return true
+	})
+
+	return r.cacheFile.SetDNSCache(records)
+}
+
+// startPersist restores the cache from cacheFile and saves it periodically, the
+// resolver saving the same file before is stopped first, so that it doesn't run
+// forever if it's replaced without Close
+func (r *Resolver) startPersist(cacheFile *cachefile.CacheFile) {
+	persistMux.Lock()
+	defer persistMux.Unlock()
+
+	if persistResolver != nil {
+		if err := persistResolver.stopPersist(); err != nil {
+			log.Warnln("[DNS] save cache failed: %s", err.Error())
+		}
+	}
+	persistResolver = r
+
+	r.cacheFile = cacheFile
+	r.done = make(chan struct{})
+	r.loadCache()
+	go r.persistLoop()
+}
+
+// stopPersist stops persistLoop and saves the cache for the last time
+func (r *Resolver) stopPersist() error {
+	var err error
+	r.persistOnce.Do(func() {
+		close(r.done)
+		err = r.saveCache()
+	})
+	return err
+}
+
+func (r *Resolver) persistLoop() {
+	ticker := time.NewTicker(persistInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.saveCache(); err != nil {
+				log.Warnln("[DNS] save cache failed: %s", err.Error())
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/common/cache"
+	"github.com/Dreamacro/clash/component/profile/cachefile"
+
+	D "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestQuery(host string) *D.Msg {
+	m := &D.Msg{}
+	m.SetQuestion(D.Fqdn(host), D.TypeA)
+	return m
+}
+
+// putTestEntry caches an answer of host with a TTL of ttl seconds that expires at expires
+func putTestEntry(r *Resolver, host string, ip string, ttl uint32, expires time.Time) {
+	m := newTestQuery(host)
+	msg := &D.Msg{}
+	msg.SetReply(m)
+	msg.Answer = []D.RR{&D.A{
+		Hdr: D.RR_Header{Name: m.Question[0].Name, Rrtype: D.TypeA, Class: D.ClassINET, Ttl: ttl},
+		A:   net.ParseIP(ip),
+	}}
+	r.lruCache.SetWithExpire(m.Question[0].String(), &cacheEntry{msg: msg, ttl: ttl}, expires)
+}
+
+func TestResolver_CacheHit(t *testing.T) {
+	client := &stubClient{ip: "1.1.1.1"}
+	r := &Resolver{
+		main:     []dnsClient{client},
+		lruCache: cache.New(cache.WithStale(true)),
+	}
+
+	for i := 0; i < 3; i++ {
+		msg, err := r.ExchangeContext(context.Background(), newTestQuery("example.com"))
+		require.NoError(t, err)
+		assert.Equal(t, "1.1.1.1", msg.Answer[0].(*D.A).A.String())
+	}
+
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 1}, r.CacheStats())
+}
+
+func TestResolver_Prefetch(t *testing.T) {
+	client := &stubClient{ip: "1.1.1.1"}
+	r := &Resolver{
+		main:     []dnsClient{client},
+		lruCache: cache.New(cache.WithStale(true)),
+		prefetch: true,
+	}
+	putTestEntry(r, "example.com", "2.2.2.2", 60, time.Now().Add(3*time.Second))
+
+	for i := 0; i < prefetchHits+1; i++ {
+		msg, err := r.ExchangeContext(context.Background(), newTestQuery("example.com"))
+		require.NoError(t, err)
+		assert.Equal(t, "2.2.2.2", msg.Answer[0].(*D.A).A.String())
+	}
+
+	assert.Eventually(t, client.called.Load, time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint64(1), r.CacheStats().Prefetches)
+}
+
+func TestResolver_ServeStale(t *testing.T) {
+	client := &stubClient{err: errors.New("timeout")}
+	r := &Resolver{
+		main:     []dnsClient{client},
+		lruCache: cache.New(cache.WithStale(true)),
+		maxStale: time.Minute,
+	}
+	putTestEntry(r, "example.com", "2.2.2.2", 60, time.Now().Add(-10*time.Second))
+
+	msg, err := r.ExchangeContext(context.Background(), newTestQuery("example.com"))
+	require.NoError(t, err)
+	assert.Equal(t, "2.2.2.2", msg.Answer[0].(*D.A).A.String())
+	assert.Equal(t, staleTTL, msg.Answer[0].Header().Ttl)
+	assert.True(t, client.called.Load())
+	assert.Equal(t, uint64(1), r.CacheStats().StaleHits)
+
+	// beyond max-stale
+	r.maxStale = 5 * time.Second
+	_, err = r.ExchangeContext(context.Background(), newTestQuery("example.com"))
+	assert.Error(t, err)
+	assert.Equal(t, uint64(1), r.CacheStats().StaleHits)
+}
+
+func TestNewResolver_MaxStale(t *testing.T) {
+	assert.Equal(t, DefaultMaxStale, NewResolver(Config{}).maxStale)
+
+	client := &stubClient{err: errors.New("timeout")}
+	r := NewResolver(Config{DisableServeStale: true, MaxStale: time.Hour})
+	r.main = []dnsClient{client}
+	putTestEntry(r, "example.com", "2.2.2.2", 60, time.Now().Add(-time.Second))
+
+	_, err := r.ExchangeContext(context.Background(), newTestQuery("example.com"))
+	assert.Error(t, err)
+	assert.Equal(t, uint64(0), r.CacheStats().StaleHits)
+}
+
+func TestResolver_PersistCache(t *testing.T) {
+	cacheFile, err := cachefile.Open(filepath.Join(t.TempDir(), "cache.db"))
+	require.NoError(t, err)
+	defer cacheFile.Close()
+
+	r := &Resolver{
+		lruCache:  cache.New(cache.WithStale(true)),
+		maxStale:  time.Minute,
+		cacheFile: cacheFile,
+		done:      make(chan struct{}),
+	}
+	expires := time.Now().Add(time.Minute).Truncate(time.Second)
+	putTestEntry(r, "example.com", "2.2.2.2", 60, expires)
+	putTestEntry(r, "expired.com", "3.3.3.3", 60, time.Now().Add(-2*time.Minute))
+	require.NoError(t, r.Close())
+
+	restored := &Resolver{
+		lruCache:  cache.New(cache.WithStale(true)),
+		maxStale:  time.Minute,
+		cacheFile: cacheFile,
+	}
+	restored.loadCache()
+
+	item, expireTime, ok := restored.lruCache.GetWithExpire(newTestQuery("example.com").Question[0].String())
+	require.True(t, ok)
+	assert.Equal(t, expires, expireTime)
+	assert.Equal(t, "2.2.2.2", item.(*cacheEntry).msg.Answer[0].(*D.A).A.String())
+	assert.Equal(t, uint32(60), item.(*cacheEntry).ttl)
+
+	_, _, ok = restored.lruCache.GetWithExpire(newTestQuery("expired.com").Question[0].String())
+	assert.False(t, ok)
+}
+
+func TestResolver_ReplacePersistCache(t *testing.T) {
+	cacheFile, err := cachefile.Open(filepath.Join(t.TempDir(), "cache.db"))
+	require.NoError(t, err)
+	defer cacheFile.Close()
+
+	old := &Resolver{lruCache: cache.New(cache.WithStale(true)), maxStale: time.Minute}
+	old.startPersist(cacheFile)
+	putTestEntry(old, "example.com", "2.2.2.2", 60, time.Now().Add(time.Minute))
+
+	// the replaced resolver saves its cache and stops persistLoop
+	r := &Resolver{lruCache: cache.New(cache.WithStale(true)), maxStale: time.Minute}
+	r.startPersist(cacheFile)
+	defer r.Close()
+
+	select {
+	case <-old.done:
+	default:
+		assert.Fail(t, "persistLoop of the replaced resolver is running")
+	}
+	_, _, ok := r.lruCache.GetWithExpire(newTestQuery("example.com").Question[0].String())
+	assert.True(t, ok)
+
+	require.NoError(t, old.Close())
+	assert.Same(t, r, persistResolver)
+}
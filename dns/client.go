@@ -23,6 +23,9 @@ type client struct {
 	host      string
 	iface     string
 	getDialer func() (C.Proxy, error)
+	// proxy dials UDP queries as well if set, they are sent over TCP
+	// if the proxy doesn't support UDP
+	proxy C.Proxy
 }
 
 func (c *client) GetServers() []string {
@@ -54,7 +57,7 @@ func (c *client) ExchangeContext(ctx context.Context, m *D.Msg) (*D.Msg, error)
 	}
 
 	network := C.UDP
-	if strings.HasPrefix(c.Client.Net, "tcp") {
+	if strings.HasPrefix(c.Client.Net, "tcp") || (c.proxy != nil && !c.proxy.SupportUDP()) {
 		network = C.TCP
 	}
 
@@ -68,26 +71,37 @@ func (c *client) ExchangeContext(ctx context.Context, m *D.Msg) (*D.Msg, error)
 	if err != nil {
 		return nil, err
 	}
-	var conn net.Conn
-	connDial, err := c.getDialer()
-	if err != nil {
-		return nil, err
-	}
-	if network == C.TCP {
-		conn, err = connDial.DialContext(ctx, &C.Metadata{
-			NetWork: network,
-			SrcIP:   nil,
-			DstIP:   ip,
-			SrcPort: 0,
-			DstPort: C.Port(numPort),
-			Host:    "",
-		}, options...)
-	} else {
-		conn, err = dialer.DialContext(ctx, "udp", net.JoinHostPort(ip.String(), c.port), options...)
+	metadata := &C.Metadata{
+		NetWork: network,
+		SrcIP:   nil,
+		DstIP:   ip,
+		SrcPort: 0,
+		DstPort: C.Port(numPort),
+		Host:    "",
 	}
 
-	if err != nil {
-		return nil, err
+	var conn net.Conn
+	switch {
+	case network == C.TCP:
+		connDial, err := c.getDialer()
+		if err != nil {
+			return nil, err
+		}
+		conn, err = connDial.DialContext(ctx, metadata, options...)
+		if err != nil {
+			return nil, err
+		}
+	case c.proxy != nil:
+		pc, err := c.proxy.ListenPacketContext(ctx, metadata, options...)
+		if err != nil {
+			return nil, err
+		}
+		conn = &packetConn{PacketConn: pc, rAddr: &net.UDPAddr{IP: ip, Port: numPort}}
+	default:
+		conn, err = dialer.DialContext(ctx, "udp", net.JoinHostPort(ip.String(), c.port), options...)
+		if err != nil {
+			return nil, err
+		}
 	}
 	defer conn.Close()
 
@@ -120,3 +134,23 @@ func (c *client) ExchangeContext(ctx context.Context, m *D.Msg) (*D.Msg, error)
 		return ret.msg, ret.err
 	}
 }
+
+// packetConn binds the PacketConn of a proxy to the nameserver, it's still a
+// net.PacketConn so that miekg/dns reads messages without the length prefix of TCP
+type packetConn struct {
+	net.PacketConn
+	rAddr net.Addr
+}
+
+func (pc *packetConn) Read(b []byte) (int, error) {
+	n, _, err := pc.ReadFrom(b)
+	return n, err
+}
+
+func (pc *packetConn) Write(b []byte) (int, error) {
+	return pc.WriteTo(b, pc.rAddr)
+}
+
+func (pc *packetConn) RemoteAddr() net.Addr {
+	return pc.rAddr
+}
@@ -0,0 +1,98 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/Dreamacro/clash/adapter"
+	"github.com/Dreamacro/clash/adapter/outbound"
+	"github.com/Dreamacro/clash/component/dialer"
+	C "github.com/Dreamacro/clash/constant"
+
+	D "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+// recordProxy is a direct proxy that records how it's dialed
+type recordProxy struct {
+	C.Proxy
+	udp     bool
+	dials   atomic.Int32
+	listens atomic.Int32
+}
+
+func (p *recordProxy) SupportUDP() bool {
+	return p.udp
+}
+
+func (p *recordProxy) DialContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (C.Conn, error) {
+	p.dials.Inc()
+	return p.Proxy.DialContext(ctx, metadata, opts...)
+}
+
+func (p *recordProxy) ListenPacketContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (C.PacketConn, error) {
+	p.listens.Inc()
+	return p.Proxy.ListenPacketContext(ctx, metadata, opts...)
+}
+
+// startPlainServer serves A queries over UDP and TCP, the answer is the network of the query
+func startPlainServer(t *testing.T) string {
+	addr := freeAddr(t)
+	handler := D.HandlerFunc(func(w D.ResponseWriter, r *D.Msg) {
+		ip := net.IPv4(1, 1, 1, 1)
+		if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+			ip = net.IPv4(2, 2, 2, 2)
+		}
+		msg := &D.Msg{}
+		msg.SetReply(r)
+		msg.Answer = []D.RR{&D.A{
+			Hdr: D.RR_Header{Name: r.Question[0].Name, Rrtype: D.TypeA, Class: D.ClassINET, Ttl: 60},
+			A:   ip,
+		}}
+		w.WriteMsg(msg)
+	})
+
+	for _, network := range []string{"udp", "tcp"} {
+		started := make(chan struct{})
+		server := &D.Server{Addr: addr, Net: network, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+		go server.ListenAndServe()
+		<-started
+		t.Cleanup(func() { server.Shutdown() })
+	}
+	return addr
+}
+
+func TestClient_Proxy(t *testing.T) {
+	addr := startPlainServer(t)
+	query := &D.Msg{}
+	query.SetQuestion("example.com.", D.TypeA)
+
+	testCases := []struct {
+		name     string
+		net      string
+		udp      bool
+		expected string
+		dials    int32
+		listens  int32
+	}{
+		{name: "udp", udp: true, expected: "1.1.1.1", listens: 1},
+		{name: "udp fallback to tcp", udp: false, expected: "2.2.2.2", dials: 1},
+		{name: "tcp", net: "tcp", udp: true, expected: "2.2.2.2", dials: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			proxy := &recordProxy{Proxy: adapter.NewProxy(outbound.NewDirect()), udp: tc.udp}
+			clients := transform([]NameServer{{Net: tc.net, Addr: addr, Proxy: proxy}}, nil, nil, nil)
+
+			msg, err := clients[0].ExchangeContext(context.Background(), query)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, msg.Answer[0].(*D.A).A.String())
+			assert.Equal(t, tc.dials, proxy.dials.Load())
+			assert.Equal(t, tc.listens, proxy.listens.Load())
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/component/dhcp"
+	"github.com/Dreamacro/clash/component/resolver"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+
+	D "github.com/miekg/dns"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	dhcpTimeout = 10 * time.Second
+	// dhcpDefaultTTL is how long the DNS servers are used if the DHCP server doesn't lease them
+	dhcpDefaultTTL = time.Hour
+	// dhcpRetryInterval is how long the previous DNS servers are kept if the DHCP server doesn't answer
+	dhcpRetryInterval = time.Minute
+)
+
+// dhcpClient exchanges with the DNS servers that the DHCP server of an interface provides
+type dhcpClient struct {
+	ifaceName string
+	getDialer func() (C.Proxy, error)
+
+	lock      sync.Mutex
+	clients   []dnsClient
+	expiresAt time.Time
+	// group makes the concurrent exchanges wait for one DHCP exchange
+	group singleflight.Group
+}
+
+func (d *dhcpClient) GetServers() []string {
+	d.lock.Lock()
+	clients := d.clients
+	d.lock.Unlock()
+
+	var servers []string
+	for _, c := range clients {
+		servers = append(servers, c.GetServers()...)
+	}
+	return servers
+}
+
+func (d *dhcpClient) Exchange(m *D.Msg) (msg *D.Msg, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), resolver.DefaultDNSTimeout)
+	defer cancel()
+
+	return d.ExchangeContext(ctx, m)
+}
+
+func (d *dhcpClient) ExchangeContext(ctx context.Context, m *D.Msg) (msg *D.Msg, err error) {
+	clients, err := d.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err = batchExchange(ctx, clients, m)
+	if err != nil {
+		// the network may have changed, ask the DHCP server again on the next exchange
+		d.lock.Lock()
+		d.expiresAt = time.Time{}
+		d.lock.Unlock()
+	}
+	return msg, err
+}
+
+// resolve returns the clients of the DNS servers, they are discovered again once the lease expires
+func (d *dhcpClient) resolve(ctx context.Context) ([]dnsClient, error) {
+	d.lock.Lock()
+	clients, expiresAt := d.clients, d.expiresAt
+	d.lock.Unlock()
+
+	if len(clients) != 0 && time.Now().Before(expiresAt) {
+		return clients, nil
+	}
+
+	ch := d.group.DoChan("", func() (any, error) {
+		return d.refresh(ctx)
+	})
+	select {
+	case result := <-ch:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.([]dnsClient), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// refresh asks the DHCP server for the DNS servers, the previous ones are kept if it fails
+func (d *dhcpClient) refresh(ctx context.Context) ([]dnsClient, error) {
+	ctx, cancel := context.WithTimeout(ctx, dhcpTimeout)
+	defer cancel()
+	servers, leaseTime, err := dhcp.ResolveDNSFromDHCP(ctx, d.ifaceName)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if err != nil {
+		if len(d.clients) != 0 {
+			log.Warnln("[DNS] DHCP of %s failed, keep using the previous servers: %s", d.ifaceName, err)
+			d.expiresAt = time.Now().Add(dhcpRetryInterval)
+			return d.clients, nil
+		}
+		return nil, err
+	}
+	log.Infoln("[DNS] DHCP of %s provides %v", d.ifaceName, servers)
+
+	nameserver := make([]NameServer, 0, len(servers))
+	for _, server := range servers {
+		nameserver = append(nameserver, NameServer{
+			Addr:      net.JoinHostPort(server.String(), "53"),
+			Interface: d.ifaceName,
+		})
+	}
+
+	if leaseTime <= 0 {
+		leaseTime = dhcpDefaultTTL
+	}
+	d.clients = transform(nameserver, d.getDialer, nil, nil)
+	d.expiresAt = time.Now().Add(leaseTime)
+	return d.clients, nil
+}
+
+func newDHCPClient(ifaceName string, getDialer func() (C.Proxy, error)) *dhcpClient {
+	return &dhcpClient{ifaceName: ifaceName, getDialer: getDialer}
+}
@@ -0,0 +1,41 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	D "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDHCPClient_Lease(t *testing.T) {
+	client := &stubClient{ip: "1.1.1.1"}
+	d := &dhcpClient{
+		ifaceName: "dhcp-missing",
+		clients:   []dnsClient{client},
+		expiresAt: time.Now().Add(time.Hour),
+	}
+
+	msg, err := d.ExchangeContext(context.Background(), newTestQuery("example.com"))
+	require.NoError(t, err)
+	assert.Equal(t, "1.1.1.1", msg.Answer[0].(*D.A).A.String())
+	assert.Equal(t, []string{"stub"}, d.GetServers())
+
+	// a failed exchange expires the lease, the previous servers are kept if DHCP fails
+	client.err = errors.New("timeout")
+	_, err = d.ExchangeContext(context.Background(), newTestQuery("example.com"))
+	assert.Error(t, err)
+	assert.True(t, d.expiresAt.IsZero())
+
+	clients, err := d.resolve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []dnsClient{client}, clients)
+	assert.True(t, d.expiresAt.After(time.Now()))
+
+	// no servers to fall back to
+	_, err = (&dhcpClient{ifaceName: "dhcp-missing"}).resolve(context.Background())
+	assert.Error(t, err)
+}
@@ -4,13 +4,18 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
-	C "github.com/Dreamacro/clash/constant"
+	"encoding/base64"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 
+	C "github.com/Dreamacro/clash/constant"
+
 	D "github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
 )
 
 const (
@@ -20,7 +25,9 @@ const (
 
 type dohClient struct {
 	url       string
-	transport *http.Transport
+	transport http.RoundTripper
+	// early sends standard queries with GET in 0-RTT, it's only supported by HTTP/3
+	early bool
 }
 
 func (dc *dohClient) GetServers() []string {
@@ -56,6 +63,24 @@ func (dc *dohClient) newRequest(m *D.Msg) (*http.Request, error) {
 		return nil, err
 	}
 
+	// GET requests are safe to replay, so that they can be sent in 0-RTT
+	if dc.early && m.Opcode == D.OpcodeQuery {
+		u, err := url.Parse(dc.url)
+		if err != nil {
+			return nil, err
+		}
+		query := u.Query()
+		query.Set("dns", base64.RawURLEncoding.EncodeToString(buf))
+		u.RawQuery = query.Encode()
+
+		req, err := http.NewRequest(http3.MethodGet0RTT, u.String(), nil)
+		if err != nil {
+			return req, err
+		}
+		req.Header.Set("accept", dotMimeType)
+		return req, nil
+	}
+
 	req, err := http.NewRequest(http.MethodPost, dc.url, bytes.NewReader(buf))
 	if err != nil {
 		return req, err
@@ -122,3 +147,22 @@ func newDoHClient(url string, getDialer func() (C.Proxy, error)) *dohClient {
 		},
 	}
 }
+
+// newDoH3Client returns a DNS over HTTPS client over HTTP/3, connections are reused by the transport
+func newDoH3Client(url string, iface string, getDialer func() (C.Proxy, error)) *dohClient {
+	return &dohClient{
+		url:   url,
+		early: true,
+		transport: &http3.Transport{
+			TLSClientConfig: &tls.Config{
+				ClientSessionCache: tls.NewLRUClientSessionCache(16),
+			},
+			QUICConfig: &quic.Config{
+				MaxIdleTimeout: quicIdleTimeout,
+			},
+			Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+				return dialQUIC(ctx, addr, iface, getDialer, tlsCfg, cfg)
+			},
+		},
+	}
+}
@@ -0,0 +1,82 @@
+package dns
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/Dreamacro/clash/log"
+
+	D "github.com/miekg/dns"
+)
+
+// DefaultDoHPath is the path of DNS over HTTPS queries if none is configured
+const DefaultDoHPath = "/dns-query"
+
+// dohHandler serves the GET and POST queries of RFC 8484
+type dohHandler struct {
+	server *Server
+}
+
+func (h *dohHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf []byte
+	switch r.Method {
+	case http.MethodGet:
+		var err error
+		buf, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(r.URL.Query().Get("dns"), "="))
+		if err != nil || len(buf) == 0 {
+			http.Error(w, "invalid dns parameter", http.StatusBadRequest)
+			return
+		}
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != dotMimeType {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		var err error
+		buf, err = io.ReadAll(io.LimitReader(r.Body, D.MaxMsgSize+1))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(buf) > D.MaxMsgSize {
+			http.Error(w, "message too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := &D.Msg{}
+	if err := req.Unpack(buf); err != nil {
+		http.Error(w, "invalid dns message", http.StatusBadRequest)
+		return
+	}
+
+	clientAddr, _ := netip.ParseAddrPort(r.RemoteAddr)
+	msg, err := handlerWithContext(h.server.handler, req, clientAddr.Addr())
+	if err != nil {
+		log.Debugln("[DNS] DoH query from %s failed: %s", r.RemoteAddr, err.Error())
+		msg = &D.Msg{}
+		msg.SetRcode(req, D.RcodeServerFailure)
+	}
+	msg.Compress = true
+
+	out, err := msg.Pack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", dotMimeType)
+	if len(msg.Answer) != 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", minimalTTL(msg.Answer)))
+	}
+	w.Write(out)
+}
@@ -0,0 +1,225 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/component/dialer"
+	"github.com/Dreamacro/clash/component/resolver"
+	C "github.com/Dreamacro/clash/constant"
+
+	D "github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	// doqNoError is the DOQ_NO_ERROR code of RFC 9250
+	doqNoError = 0x0
+
+	quicIdleTimeout = 30 * time.Second
+)
+
+// doqClient implements DNS over QUIC of RFC 9250, every query is a stream of one
+// shared connection. Standard queries are sent in 0-RTT once a session ticket is cached.
+type doqClient struct {
+	addr       string
+	iface      string
+	getDialer  func() (C.Proxy, error)
+	tlsConfig  *tls.Config
+	quicConfig *quic.Config
+
+	conn *quic.Conn
+	mux  sync.Mutex
+}
+
+func (dc *doqClient) GetServers() []string {
+	return []string{dc.addr}
+}
+
+func (dc *doqClient) Exchange(m *D.Msg) (msg *D.Msg, err error) {
+	return dc.ExchangeContext(context.Background(), m)
+}
+
+func (dc *doqClient) ExchangeContext(ctx context.Context, m *D.Msg) (msg *D.Msg, err error) {
+	// https://datatracker.ietf.org/doc/html/rfc9250#section-4.2.1
+	// the DNS Message ID MUST be set to 0
+	newM := *m
+	newM.Id = 0
+	buf, err := newM.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	// 0-RTT data can be replayed, only standard queries are idempotent
+	early := m.Opcode == D.OpcodeQuery
+
+	msg, err = dc.exchange(ctx, buf, early, false)
+	if err != nil && ctx.Err() == nil {
+		// the shared connection may have been closed by the server, e.g. on idle timeout
+		msg, err = dc.exchange(ctx, buf, early, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	msg.Id = m.Id
+	return msg, nil
+}
+
+func (dc *doqClient) exchange(ctx context.Context, buf []byte, early bool, fresh bool) (*D.Msg, error) {
+	conn, err := dc.getConn(ctx, fresh)
+	if err != nil {
+		return nil, err
+	}
+
+	if !early {
+		select {
+		case <-conn.HandshakeComplete():
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.CancelRead(doqNoError)
+
+	stop := context.AfterFunc(ctx, func() {
+		stream.CancelWrite(doqNoError)
+		stream.CancelRead(doqNoError)
+	})
+	defer stop()
+
+	// messages are prefixed with a 2-octet length field, the client sends
+	// a STREAM FIN after its query
+	packet := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(buf)), uint16(len(buf)))
+	if _, err := stream.Write(append(packet, buf...)); err != nil {
+		return nil, err
+	}
+	if err := stream.Close(); err != nil {
+		return nil, err
+	}
+
+	return readPrefixedMsg(stream)
+}
+
+func (dc *doqClient) getConn(ctx context.Context, fresh bool) (*quic.Conn, error) {
+	dc.mux.Lock()
+	defer dc.mux.Unlock()
+
+	if dc.conn != nil {
+		select {
+		case <-dc.conn.Context().Done():
+		default:
+			if !fresh {
+				return dc.conn, nil
+			}
+			dc.conn.CloseWithError(doqNoError, "")
+		}
+		dc.conn = nil
+	}
+
+	conn, err := dialQUIC(ctx, dc.addr, dc.iface, dc.getDialer, dc.tlsConfig, dc.quicConfig)
+	if err != nil {
+		return nil, err
+	}
+	dc.conn = conn
+	return conn, nil
+}
+
+func readPrefixedMsg(r io.Reader) (*D.Msg, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+
+	msg := &D.Msg{}
+	if err := msg.Unpack(buf); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// dialQUIC dials addr in early mode over a PacketConn of the dialer, the socket is
+// closed with the connection
+func dialQUIC(ctx context.Context, addr string, iface string, getDialer func() (C.Proxy, error), tlsConfig *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	numPort, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		if ip, err = resolver.ResolveIP(host); err != nil {
+			return nil, err
+		}
+	}
+
+	options := []dialer.Option{}
+	if iface != "" {
+		options = append(options, dialer.WithInterface(iface))
+	}
+
+	proxy, err := getDialer()
+	if err != nil {
+		return nil, err
+	}
+	if !proxy.SupportUDP() {
+		return nil, fmt.Errorf("proxy %s doesn't support UDP", proxy.Name())
+	}
+	pc, err := proxy.ListenPacketContext(ctx, &C.Metadata{
+		NetWork: C.UDP,
+		DstIP:   ip,
+		DstPort: C.Port(numPort),
+	}, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := quic.DialEarly(ctx, pc, &net.UDPAddr{IP: ip, Port: numPort}, tlsConfig, cfg)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+
+	go func() {
+		<-conn.Context().Done()
+		pc.Close()
+	}()
+	return conn, nil
+}
+
+func newDoQClient(addr string, iface string, getDialer func() (C.Proxy, error)) *doqClient {
+	host, _, _ := net.SplitHostPort(addr)
+	return &doqClient{
+		addr:      addr,
+		iface:     iface,
+		getDialer: getDialer,
+		tlsConfig: &tls.Config{
+			ServerName:         host,
+			NextProtos:         []string{"doq"},
+			ClientSessionCache: tls.NewLRUClientSessionCache(16),
+		},
+		quicConfig: &quic.Config{
+			MaxIdleTimeout: quicIdleTimeout,
+		},
+	}
+}
@@ -0,0 +1,145 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/Dreamacro/clash/adapter"
+	"github.com/Dreamacro/clash/adapter/outbound"
+	C "github.com/Dreamacro/clash/constant"
+	icontext "github.com/Dreamacro/clash/context"
+
+	D "github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func testTLSConfig(t *testing.T, protos ...string) *tls.Config {
+	cert, err := tls.LoadX509KeyPair(writeCertificate(t))
+	require.NoError(t, err)
+	return &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: protos}
+}
+
+func answerA(r *D.Msg, ip string) *D.Msg {
+	msg := &D.Msg{}
+	msg.SetReply(r)
+	msg.Answer = append(msg.Answer, &D.A{
+		Hdr: D.RR_Header{Name: r.Question[0].Name, Rrtype: D.TypeA, Class: D.ClassINET, Ttl: 60},
+		A:   net.ParseIP(ip),
+	})
+	return msg
+}
+
+// doqServer answers every query with 1.1.1.1 and counts the connections
+type doqServer struct {
+	listener *quic.EarlyListener
+	conns    atomic.Int32
+	early    atomic.Int32
+	badID    atomic.Bool
+}
+
+func (s *doqServer) serve() {
+	for {
+		conn, err := s.listener.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		s.conns.Inc()
+		if conn.ConnectionState().Used0RTT {
+			s.early.Inc()
+		}
+
+		go func() {
+			for {
+				stream, err := conn.AcceptStream(context.Background())
+				if err != nil {
+					return
+				}
+				go func() {
+					defer stream.Close()
+					r, err := readPrefixedMsg(stream)
+					if err != nil {
+						return
+					}
+					if r.Id != 0 {
+						s.badID.Store(true)
+					}
+					buf, _ := answerA(r, "1.1.1.1").Pack()
+					stream.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(buf))), buf...))
+				}()
+			}
+		}()
+	}
+}
+
+func TestDoQClient(t *testing.T) {
+	listener, err := quic.ListenAddrEarly("127.0.0.1:0", testTLSConfig(t, "doq"), &quic.Config{Allow0RTT: true})
+	require.NoError(t, err)
+	defer listener.Close()
+	server := &doqServer{listener: listener}
+	go server.serve()
+
+	proxy := &recordProxy{Proxy: adapter.NewProxy(outbound.NewDirect()), udp: true}
+	client := newDoQClient(listener.Addr().String(), "", func() (C.Proxy, error) { return proxy, nil })
+	client.tlsConfig.InsecureSkipVerify = true
+
+	query := &D.Msg{}
+	query.SetQuestion("example.com.", D.TypeA)
+	for i := 0; i < 3; i++ {
+		msg, err := client.Exchange(query)
+		require.NoError(t, err)
+		assert.Equal(t, query.Id, msg.Id)
+		require.Len(t, msg.Answer, 1)
+		assert.Equal(t, "1.1.1.1", msg.Answer[0].(*D.A).A.String())
+	}
+	assert.EqualValues(t, 1, server.conns.Load(), "connection should be reused")
+	assert.False(t, server.badID.Load(), "message id should be 0")
+
+	// a closed connection is dialed again and resumed in 0-RTT
+	client.conn.CloseWithError(doqNoError, "")
+	_, err = client.Exchange(query)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, server.conns.Load())
+	assert.EqualValues(t, 1, server.early.Load())
+	assert.EqualValues(t, 2, proxy.listens.Load(), "connections should be dialed by the dialer")
+}
+
+func TestDoH3Client(t *testing.T) {
+	s := &Server{handler: func(ctx *icontext.DNSContext, r *D.Msg) (*D.Msg, error) {
+		return answerA(r, "1.1.1.1"), nil
+	}}
+	mux := http.NewServeMux()
+	mux.Handle(DefaultDoHPath, &dohHandler{server: s})
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	h3 := &http3.Server{
+		Handler:    mux,
+		TLSConfig:  http3.ConfigureTLSConfig(testTLSConfig(t)),
+		QUICConfig: &quic.Config{Allow0RTT: true},
+	}
+	go h3.Serve(pc)
+	defer h3.Close()
+
+	proxy := &recordProxy{Proxy: adapter.NewProxy(outbound.NewDirect()), udp: true}
+	client := newDoH3Client("https://"+pc.LocalAddr().String()+DefaultDoHPath, "", func() (C.Proxy, error) { return proxy, nil })
+	client.transport.(*http3.Transport).TLSClientConfig.InsecureSkipVerify = true
+
+	query := &D.Msg{}
+	query.SetQuestion("example.com.", D.TypeA)
+	for i := 0; i < 2; i++ {
+		msg, err := client.Exchange(query)
+		require.NoError(t, err)
+		assert.Equal(t, query.Id, msg.Id)
+		require.Len(t, msg.Answer, 1)
+		assert.Equal(t, "1.1.1.1", msg.Answer[0].(*D.A).A.String())
+	}
+	assert.EqualValues(t, 1, proxy.listens.Load())
+}
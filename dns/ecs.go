@@ -0,0 +1,166 @@
+package dns
+
+import (
+	"context"
+	"io"
+	"net/netip"
+	"strings"
+
+	D "github.com/miekg/dns"
+	"github.com/samber/lo"
+)
+
+// the source prefix lengths recommended by RFC 7871 section 11.1
+const (
+	ecsPrefixIPv4 = 24
+	ecsPrefixIPv6 = 56
+)
+
+// ECS is the EDNS Client Subnet option a nameserver sends, see RFC 7871
+type ECS struct {
+	// Subnet is sent with every query unless FromClient is set
+	Subnet netip.Prefix
+	// FromClient forwards the subnet of the query from the client, or derives
+	// one from the address of the client if it's public
+	FromClient bool
+}
+
+// subnet returns the subnet that is sent with m, it's invalid if there is none
+func (e *ECS) subnet(ctx context.Context, m *D.Msg) netip.Prefix {
+	if !e.FromClient {
+		return e.Subnet
+	}
+
+	if subnet := msgSubnet(m); subnet.IsValid() {
+		return subnet
+	}
+
+	addr, _ := ctx.Value(clientAddrKey{}).(netip.Addr)
+	return clientSubnet(addr)
+}
+
+// clientSubnet returns the subnet of addr with the recommended prefix length,
+// it's invalid if addr isn't a public address
+func clientSubnet(addr netip.Addr) netip.Prefix {
+	addr = addr.Unmap()
+	if !addr.IsValid() || !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return netip.Prefix{}
+	}
+
+	bits := ecsPrefixIPv4
+	if addr.Is6() {
+		bits = ecsPrefixIPv6
+	}
+	subnet, _ := addr.Prefix(bits)
+	return subnet
+}
+
+type clientAddrKey struct{}
+
+// clientContext carries the address of the client that sent the query to the DNS server
+func clientContext(addr netip.Addr) context.Context {
+	ctx := context.Background()
+	if !addr.IsValid() {
+		return ctx
+	}
+	return context.WithValue(ctx, clientAddrKey{}, addr)
+}
+
+// msgSubnet returns the subnet of the ECS option of m
+func msgSubnet(m *D.Msg) netip.Prefix {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return netip.Prefix{}
+	}
+
+	for _, option := range opt.Option {
+		ecs, ok := option.(*D.EDNS0_SUBNET)
+		if !ok {
+			continue
+		}
+
+		addr, ok := netip.AddrFromSlice(ecs.Address)
+		if !ok {
+			return netip.Prefix{}
+		}
+		subnet, err := addr.Unmap().Prefix(int(ecs.SourceNetmask))
+		if err != nil {
+			return netip.Prefix{}
+		}
+		return subnet
+	}
+	return netip.Prefix{}
+}
+
+// setECS replaces the ECS option of m with subnet, m should be a copy
+func setECS(m *D.Msg, subnet netip.Prefix) {
+	opt := m.IsEdns0()
+	if opt == nil {
+		m.SetEdns0(4096, false)
+		opt = m.IsEdns0()
+	}
+
+	family := uint16(1)
+	if subnet.Addr().Is6() {
+		family = 2
+	}
+	opt.Option = append(lo.Filter(opt.Option, func(option D.EDNS0, _ int) bool {
+		return option.Option() != D.EDNS0SUBNET
+	}), &D.EDNS0_SUBNET{
+		Code:          D.EDNS0SUBNET,
+		Family:        family,
+		SourceNetmask: uint8(subnet.Bits()),
+		Address:       subnet.Masked().Addr().AsSlice(),
+	})
+}
+
+// ecsClient adds the ECS option to the queries of a nameserver
+type ecsClient struct {
+	dnsClient
+	ecs *ECS
+}
+
+func (c *ecsClient) Exchange(m *D.Msg) (*D.Msg, error) {
+	return c.ExchangeContext(context.Background(), m)
+}
+
+func (c *ecsClient) ExchangeContext(ctx context.Context, m *D.Msg) (*D.Msg, error) {
+	if subnet := c.ecs.subnet(ctx, m); subnet.IsValid() {
+		m = m.Copy()
+		setECS(m, subnet)
+	}
+	return c.dnsClient.ExchangeContext(ctx, m)
+}
+
+// Close implements io.Closer
+func (c *ecsClient) Close() error {
+	if closer, ok := c.dnsClient.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// cacheKey is the question of m, plus the subnets that the nameservers which
+// may answer m send, so that the answers for different subnets are cached apart
+func (r *Resolver) cacheKey(ctx context.Context, m *D.Msg) string {
+	key := m.Question[0].String()
+
+	clients := r.matchPolicy(m)
+	if len(clients) == 0 {
+		clients = append(r.main[:len(r.main):len(r.main)], r.fallback...)
+	}
+
+	subnets := []string{}
+	for _, c := range clients {
+		if ecs, ok := c.(*ecsClient); ok {
+			if subnet := ecs.ecs.subnet(ctx, m); subnet.IsValid() {
+				subnets = append(subnets, subnet.Masked().String())
+			}
+		}
+	}
+	if len(subnets) == 0 {
+		return key
+	}
+
+	return key + " ecs=" + strings.Join(lo.Uniq(subnets), ",")
+}
@@ -0,0 +1,120 @@
+package dns
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/Dreamacro/clash/common/cache"
+
+	D "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+// subnetClient answers the question with the subnet it receives as the address
+type subnetClient struct {
+	calls atomic.Int32
+}
+
+func (c *subnetClient) GetServers() []string {
+	return []string{"subnet"}
+}
+
+func (c *subnetClient) Exchange(m *D.Msg) (*D.Msg, error) {
+	return c.ExchangeContext(context.Background(), m)
+}
+
+func (c *subnetClient) ExchangeContext(ctx context.Context, m *D.Msg) (*D.Msg, error) {
+	c.calls.Inc()
+	msg := &D.Msg{}
+	msg.SetReply(m)
+	ip := "0.0.0.0"
+	if subnet := msgSubnet(m); subnet.IsValid() {
+		ip = subnet.Addr().String()
+	}
+	msg.Answer = []D.RR{&D.A{
+		Hdr: D.RR_Header{Name: m.Question[0].Name, Rrtype: D.TypeA, Class: D.ClassINET, Ttl: 60},
+		A:   netip.MustParseAddr(ip).AsSlice(),
+	}}
+	return msg, nil
+}
+
+func TestSetECS(t *testing.T) {
+	m := newTestQuery("example.com")
+	assert.False(t, msgSubnet(m).IsValid())
+
+	setECS(m, netip.MustParsePrefix("1.2.3.0/24"))
+	assert.Equal(t, netip.MustParsePrefix("1.2.3.0/24"), msgSubnet(m))
+
+	// replaced rather than appended
+	setECS(m, netip.MustParsePrefix("2001:db8::/56"))
+	assert.Equal(t, netip.MustParsePrefix("2001:db8::/56"), msgSubnet(m))
+	assert.Len(t, m.IsEdns0().Option, 1)
+
+	buf, err := m.Pack()
+	require.NoError(t, err)
+	unpacked := &D.Msg{}
+	require.NoError(t, unpacked.Unpack(buf))
+	assert.Equal(t, netip.MustParsePrefix("2001:db8::/56"), msgSubnet(unpacked))
+}
+
+func TestClientSubnet(t *testing.T) {
+	assert.Equal(t, netip.MustParsePrefix("8.8.8.0/24"), clientSubnet(netip.MustParseAddr("8.8.8.8")))
+	assert.Equal(t, netip.MustParsePrefix("8.8.8.0/24"), clientSubnet(netip.MustParseAddr("::ffff:8.8.8.8")))
+	assert.Equal(t, netip.MustParsePrefix("2001:db8:1:100::/56"), clientSubnet(netip.MustParseAddr("2001:db8:1:1ff::1")))
+	assert.False(t, clientSubnet(netip.MustParseAddr("192.168.1.1")).IsValid())
+	assert.False(t, clientSubnet(netip.MustParseAddr("127.0.0.1")).IsValid())
+	assert.False(t, clientSubnet(netip.Addr{}).IsValid())
+}
+
+func TestECSClient(t *testing.T) {
+	fixed := &ecsClient{dnsClient: &subnetClient{}, ecs: &ECS{Subnet: netip.MustParsePrefix("1.2.3.0/24")}}
+	query := newTestQuery("example.com")
+	setECS(query, netip.MustParsePrefix("5.6.7.0/24"))
+
+	msg, err := fixed.ExchangeContext(context.Background(), query)
+	require.NoError(t, err)
+	assert.Equal(t, "1.2.3.0", msg.Answer[0].(*D.A).A.String())
+	// the query isn't modified
+	assert.Equal(t, netip.MustParsePrefix("5.6.7.0/24"), msgSubnet(query))
+
+	fromClient := &ecsClient{dnsClient: &subnetClient{}, ecs: &ECS{FromClient: true}}
+	msg, err = fromClient.ExchangeContext(context.Background(), query)
+	require.NoError(t, err)
+	assert.Equal(t, "5.6.7.0", msg.Answer[0].(*D.A).A.String())
+
+	msg, err = fromClient.ExchangeContext(clientContext(netip.MustParseAddr("9.9.9.9")), newTestQuery("example.com"))
+	require.NoError(t, err)
+	assert.Equal(t, "9.9.9.0", msg.Answer[0].(*D.A).A.String())
+
+	msg, err = fromClient.ExchangeContext(clientContext(netip.MustParseAddr("10.0.0.1")), newTestQuery("example.com"))
+	require.NoError(t, err)
+	assert.Equal(t, "0.0.0.0", msg.Answer[0].(*D.A).A.String())
+}
+
+func TestResolver_ECSCacheKey(t *testing.T) {
+	client := &subnetClient{}
+	r := &Resolver{
+		main:     []dnsClient{&ecsClient{dnsClient: client, ecs: &ECS{FromClient: true}}},
+		lruCache: cache.New(cache.WithStale(true)),
+	}
+
+	exchange := func(addr string) string {
+		msg, err := r.ExchangeContext(clientContext(netip.MustParseAddr(addr)), newTestQuery("example.com"))
+		require.NoError(t, err)
+		return msg.Answer[0].(*D.A).A.String()
+	}
+
+	assert.Equal(t, "8.8.8.0", exchange("8.8.8.8"))
+	assert.Equal(t, "9.9.9.0", exchange("9.9.9.9"))
+	assert.Equal(t, "8.8.8.0", exchange("8.8.8.1"))
+	assert.Equal(t, int32(2), client.calls.Load())
+	assert.Equal(t, uint64(1), r.CacheStats().Hits)
+
+	assert.Equal(t, newTestQuery("example.com").Question[0].String()+" ecs=8.8.8.0/24",
+		r.cacheKey(clientContext(netip.MustParseAddr("8.8.8.8")), newTestQuery("example.com")))
+	assert.Equal(t, newTestQuery("example.com").Question[0].String(),
+		r.cacheKey(context.Background(), newTestQuery("example.com")))
+}
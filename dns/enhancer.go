@@ -9,9 +9,10 @@ import (
 )
 
 type ResolverEnhancer struct {
-	mode     C.DNSMode
-	fakePool *fakeip.Pool
-	mapping  *cache.LruCache
+	mode      C.DNSMode
+	fakePool  *fakeip.Pool
+	fakePool6 *fakeip.Pool
+	mapping   *cache.LruCache
 }
 
 func (h *ResolverEnhancer) FakeIPEnabled() bool {
@@ -27,8 +28,10 @@ func (h *ResolverEnhancer) IsExistFakeIP(ip net.IP) bool {
 		return false
 	}
 
-	if pool := h.fakePool; pool != nil {
-		return pool.Exist(ip)
+	for _, pool := range h.fakePools() {
+		if pool.Exist(ip) {
+			return true
+		}
 	}
 
 	return false
@@ -39,15 +42,17 @@ func (h *ResolverEnhancer) IsFakeIP(ip net.IP) bool {
 		return false
 	}
 
-	if pool := h.fakePool; pool != nil {
-		return pool.IPNet().Contains(ip) && !pool.Gateway().Equal(ip)
+	for _, pool := range h.fakePools() {
+		if pool.IPNet().Contains(ip) && !pool.Gateway().Equal(ip) {
+			return true
+		}
 	}
 
 	return false
 }
 
 func (h *ResolverEnhancer) FindHostByIP(ip net.IP) (string, bool) {
-	if pool := h.fakePool; pool != nil {
+	for _, pool := range h.fakePools() {
 		if host, existed := pool.LookBack(ip); existed {
 			return host, true
 		}
@@ -70,20 +75,38 @@ func (h *ResolverEnhancer) PatchFrom(o *ResolverEnhancer) {
 	if h.fakePool != nil && o.fakePool != nil {
 		h.fakePool.CloneFrom(o.fakePool)
 	}
+
+	if h.fakePool6 != nil && o.fakePool6 != nil {
+		h.fakePool6.CloneFrom(o.fakePool6)
+	}
+}
+
+// fakePools returns the fake-ip pools that are set
+func (h *ResolverEnhancer) fakePools() []*fakeip.Pool {
+	pools := make([]*fakeip.Pool, 0, 2)
+	if h.fakePool != nil {
+		pools = append(pools, h.fakePool)
+	}
+	if h.fakePool6 != nil {
+		pools = append(pools, h.fakePool6)
+	}
+	return pools
 }
 
 func NewEnhancer(cfg Config) *ResolverEnhancer {
-	var fakePool *fakeip.Pool
+	var fakePool, fakePool6 *fakeip.Pool
 	var mapping *cache.LruCache
 
 	if cfg.EnhancedMode != C.DNSNormal {
 		fakePool = cfg.Pool
+		fakePool6 = cfg.Pool6
 		mapping = cache.New(cache.WithSize(4096))
 	}
 
 	return &ResolverEnhancer{
-		mode:     cfg.EnhancedMode,
-		fakePool: fakePool,
-		mapping:  mapping,
+		mode:      cfg.EnhancedMode,
+		fakePool:  fakePool,
+		fakePool6: fakePool6,
+		mapping:   mapping,
 	}
 }
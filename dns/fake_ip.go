@@ -9,7 +9,8 @@ import (
 )
 
 type fakeIpClient struct {
-	pool *fakeip.Pool
+	pool  *fakeip.Pool
+	pool6 *fakeip.Pool
 }
 
 func (f *fakeIpClient) GetServers() []string {
@@ -29,17 +30,19 @@ func (f *fakeIpClient) ExchangeContext(ctx context.Context, m *D.Msg) (msg *D.Ms
 
 	host := strings.TrimRight(q.Name, ".")
 
+	pool := f.pool
 	switch q.Qtype {
-	case D.TypeAAAA, D.TypeSVCB, D.TypeHTTPS:
+	case D.TypeAAAA:
+		if f.pool6 == nil {
+			return handleMsgWithEmptyAnswer(m), nil
+		}
+		pool = f.pool6
+	case D.TypeSVCB, D.TypeHTTPS:
 		return handleMsgWithEmptyAnswer(m), nil
 	}
 
-	rr := &D.A{}
-	rr.Hdr = D.RR_Header{Name: q.Name, Rrtype: D.TypeA, Class: D.ClassINET, Ttl: dnsDefaultTTL}
-	ip := f.pool.Lookup(host)
-	rr.A = ip
 	msg = m.Copy()
-	msg.Answer = []D.RR{rr}
+	msg.Answer = []D.RR{newFakeIPRR(q, pool.Lookup(host))}
 
 	setMsgTTL(msg, 1)
 	msg.SetRcode(m, D.RcodeSuccess)
@@ -49,9 +52,10 @@ func (f *fakeIpClient) ExchangeContext(ctx context.Context, m *D.Msg) (msg *D.Ms
 	return msg, nil
 }
 
-func newFakeIpClient(pool *fakeip.Pool) *fakeIpClient {
+func newFakeIpClient(pool *fakeip.Pool, pool6 *fakeip.Pool) *fakeIpClient {
 	newClient := &fakeIpClient{
 		pool,
+		pool6,
 	}
 	return newClient
 }
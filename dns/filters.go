@@ -0,0 +1,61 @@
+package dns
+
+import (
+	"net"
+	"strings"
+
+	"github.com/Dreamacro/clash/component/mmdb"
+	"github.com/Dreamacro/clash/component/trie"
+	"github.com/Dreamacro/clash/log"
+)
+
+// fallbackIPFilter returns true if an answer of the main nameservers can't be trusted
+type fallbackIPFilter interface {
+	Match(net.IP) bool
+}
+
+type geoipFilter struct {
+	code string
+}
+
+// Match returns true for a public IP outside of the country
+func (gf *geoipFilter) Match(ip net.IP) bool {
+	if ip.IsPrivate() || ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() {
+		return false
+	}
+
+	code, err := mmdb.LookupCode(ip)
+	if err != nil {
+		log.Debugln("[DNS] geoip lookup %s failed: %s", ip.String(), err.Error())
+	}
+	return !strings.EqualFold(code, gf.code)
+}
+
+type ipnetFilter struct {
+	ipnet *net.IPNet
+}
+
+func (inf *ipnetFilter) Match(ip net.IP) bool {
+	return inf.ipnet.Contains(ip)
+}
+
+// fallbackDomainFilter returns true if a domain is only resolved by the fallback nameservers
+type fallbackDomainFilter interface {
+	Match(domain string) bool
+}
+
+type domainFilter struct {
+	tree *trie.DomainTrie
+}
+
+func newDomainFilter(domains []string) *domainFilter {
+	df := domainFilter{tree: trie.New()}
+	for _, domain := range domains {
+		df.tree.Insert(domain, struct{}{})
+	}
+	return &df
+}
+
+func (df *domainFilter) Match(domain string) bool {
+	return df.tree.Search(domain) != nil
+}
@@ -111,7 +111,7 @@ func withMapping(mapping *cache.LruCache) middleware {
 	}
 }
 
-func withFakeIP(fakePool *fakeip.Pool) middleware {
+func withFakeIP(fakePool *fakeip.Pool, fakePool6 *fakeip.Pool) middleware {
 	return func(next handler) handler {
 		return func(ctx *context.DNSContext, r *D.Msg) (*D.Msg, error) {
 			q := r.Question[0]
@@ -121,21 +121,22 @@ func withFakeIP(fakePool *fakeip.Pool) middleware {
 				return next(ctx, r)
 			}
 
+			pool := fakePool
 			switch q.Qtype {
-			case D.TypeAAAA, D.TypeSVCB, D.TypeHTTPS:
+			case D.TypeA:
+			case D.TypeAAAA:
+				if fakePool6 == nil {
+					return handleMsgWithEmptyAnswer(r), nil
+				}
+				pool = fakePool6
+			case D.TypeSVCB, D.TypeHTTPS:
 				return handleMsgWithEmptyAnswer(r), nil
-			}
-
-			if q.Qtype != D.TypeA {
+			default:
 				return next(ctx, r)
 			}
 
-			rr := &D.A{}
-			rr.Hdr = D.RR_Header{Name: q.Name, Rrtype: D.TypeA, Class: D.ClassINET, Ttl: dnsDefaultTTL}
-			ip := fakePool.Lookup(host)
-			rr.A = ip
 			msg := r.Copy()
-			msg.Answer = []D.RR{rr}
+			msg.Answer = []D.RR{newFakeIPRR(q, pool.Lookup(host))}
 
 			ctx.SetType(context.DNSTypeFakeIP)
 			setMsgTTL(msg, 1)
@@ -153,12 +154,12 @@ func withResolver(resolver *Resolver) handler {
 		ctx.SetType(context.DNSTypeRaw)
 		q := r.Question[0]
 
-		// return a empty AAAA msg when ipv6 disabled
-		if !resolver.ipv6 && q.Qtype == D.TypeAAAA {
+		// return a empty msg when the address family is excluded, such as AAAA when ipv6 disabled
+		if resolver.excludedByStrategy(q) {
 			return handleMsgWithEmptyAnswer(r), nil
 		}
 
-		msg, err := resolver.Exchange(r)
+		msg, err := resolver.ExchangeContext(clientContext(ctx.ClientAddr()), r)
 		if err != nil {
 			log.Debugln("[DNS Server] Exchange %s failed: %v", q.String(), err)
 			return msg, err
@@ -188,8 +189,12 @@ func newHandler(resolver *Resolver, mapper *ResolverEnhancer) handler {
 		middlewares = append(middlewares, withHosts(resolver.hosts))
 	}
 
+	if resolver.blocklist != nil {
+		middlewares = append(middlewares, withBlocklist(resolver.blocklist))
+	}
+
 	if mapper.mode == C.DNSFakeIP {
-		middlewares = append(middlewares, withFakeIP(mapper.fakePool))
+		middlewares = append(middlewares, withFakeIP(mapper.fakePool, mapper.fakePool6))
 		middlewares = append(middlewares, withMapping(mapper.mapping))
 	}
 
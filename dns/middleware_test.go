@@ -0,0 +1,133 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/Dreamacro/clash/component/fakeip"
+	"github.com/Dreamacro/clash/component/trie"
+	C "github.com/Dreamacro/clash/constant"
+	types "github.com/Dreamacro/clash/constant/provider"
+	"github.com/Dreamacro/clash/context"
+
+	D "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPool(t *testing.T, cidr string) *fakeip.Pool {
+	_, ipnet, _ := net.ParseCIDR(cidr)
+	pool, err := fakeip.New(fakeip.Options{IPNet: ipnet, Size: 10})
+	require.NoError(t, err)
+	return pool
+}
+
+func TestWithFakeIP_IPv6(t *testing.T) {
+	mapper := NewEnhancer(Config{
+		EnhancedMode: C.DNSFakeIP,
+		Pool:         newTestPool(t, "198.18.0.1/16"),
+		Pool6:        newTestPool(t, "fdfe:dcba:9876::/64"),
+	})
+	next := func(ctx *context.DNSContext, r *D.Msg) (*D.Msg, error) {
+		return handleMsgWithEmptyAnswer(r), nil
+	}
+	h := withFakeIP(mapper.fakePool, mapper.fakePool6)(next)
+
+	r := &D.Msg{}
+	r.SetQuestion("example.com.", D.TypeAAAA)
+	msg, err := h(context.NewDNSContext(r), r)
+	require.NoError(t, err)
+	require.Len(t, msg.Answer, 1)
+	ip := msg.Answer[0].(*D.AAAA).AAAA
+	assert.Equal(t, net.ParseIP("fdfe:dcba:9876::2"), ip)
+
+	r.SetQuestion("example.com.", D.TypeA)
+	msg, err = h(context.NewDNSContext(r), r)
+	require.NoError(t, err)
+	assert.Equal(t, net.IP{198, 18, 0, 2}, msg.Answer[0].(*D.A).A)
+
+	assert.True(t, mapper.IsFakeIP(ip))
+	assert.True(t, mapper.IsExistFakeIP(ip))
+	assert.False(t, mapper.IsFakeIP(net.ParseIP("fdfe:dcba:9876::1")))
+	host, exist := mapper.FindHostByIP(ip)
+	assert.True(t, exist)
+	assert.Equal(t, "example.com", host)
+}
+
+func TestWithFakeIP_WithoutIPv6Range(t *testing.T) {
+	h := withFakeIP(newTestPool(t, "198.18.0.1/16"), nil)(func(ctx *context.DNSContext, r *D.Msg) (*D.Msg, error) {
+		return nil, nil
+	})
+
+	r := &D.Msg{}
+	r.SetQuestion("example.com.", D.TypeAAAA)
+	msg, err := h(context.NewDNSContext(r), r)
+	require.NoError(t, err)
+	assert.Empty(t, msg.Answer)
+}
+
+type stubBlocklist struct {
+	types.BlocklistProvider
+	block *trie.DomainTrie
+	allow *trie.DomainTrie
+}
+
+func (s *stubBlocklist) Blocked(domain string) bool { return s.block.Search(domain) != nil }
+func (s *stubBlocklist) Allowed(domain string) bool { return s.allow.Search(domain) != nil }
+
+func newStubBlocklist(block []string, allow []string) *stubBlocklist {
+	s := &stubBlocklist{block: trie.New(), allow: trie.New()}
+	for _, domain := range block {
+		s.block.Insert(domain, struct{}{})
+	}
+	for _, domain := range allow {
+		s.allow.Insert(domain, struct{}{})
+	}
+	return s
+}
+
+func TestWithBlocklist(t *testing.T) {
+	allowlist := trie.New()
+	allowlist.Insert("ok.ads.com", struct{}{})
+	blocklist := &Blocklist{
+		Lists: []types.BlocklistProvider{
+			newStubBlocklist([]string{"+.ads.com"}, nil),
+			newStubBlocklist([]string{"tracker.org"}, []string{"+.good.ads.com"}),
+		},
+		Allowlist: allowlist,
+	}
+	h := withBlocklist(blocklist)(func(ctx *context.DNSContext, r *D.Msg) (*D.Msg, error) {
+		return handleMsgWithEmptyAnswer(r), nil
+	})
+	exchange := func(name string, qtype uint16) (*D.Msg, string) {
+		r := &D.Msg{}
+		r.SetQuestion(name, qtype)
+		ctx := context.NewDNSContext(r)
+		msg, err := h(ctx, r)
+		require.NoError(t, err)
+		return msg, ctx.Type()
+	}
+
+	msg, tp := exchange("www.ADS.com.", D.TypeA)
+	assert.Equal(t, D.RcodeNameError, msg.Rcode)
+	assert.Equal(t, context.DNSTypeBlock, tp)
+
+	for _, name := range []string{"ok.ads.com.", "a.good.ads.com.", "example.com."} {
+		msg, tp = exchange(name, D.TypeA)
+		assert.Equal(t, D.RcodeSuccess, msg.Rcode, name)
+		assert.Empty(t, tp, name)
+	}
+
+	blocklist.Mode = BlockRefused
+	msg, _ = exchange("tracker.org.", D.TypeA)
+	assert.Equal(t, D.RcodeRefused, msg.Rcode)
+
+	blocklist.Mode = BlockNull
+	msg, _ = exchange("tracker.org.", D.TypeAAAA)
+	assert.Equal(t, D.RcodeSuccess, msg.Rcode)
+	assert.Equal(t, net.IPv6zero, msg.Answer[0].(*D.AAAA).AAAA)
+	msg, _ = exchange("tracker.org.", D.TypeA)
+	assert.True(t, net.IPv4zero.Equal(msg.Answer[0].(*D.A).A))
+	msg, _ = exchange("tracker.org.", D.TypeMX)
+	assert.Empty(t, msg.Answer)
+}
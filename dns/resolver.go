@@ -4,13 +4,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Dreamacro/clash/common/cache"
 	"github.com/Dreamacro/clash/component/fakeip"
+	"github.com/Dreamacro/clash/component/profile/cachefile"
 	"github.com/Dreamacro/clash/component/resolver"
 	"github.com/Dreamacro/clash/component/trie"
 	C "github.com/Dreamacro/clash/constant"
@@ -32,15 +35,30 @@ type result struct {
 }
 
 type Resolver struct {
-	ipv6          bool
-	hosts         *trie.DomainTrie
-	main          []dnsClient
-	fallback      []dnsClient
-	group         singleflight.Group
-	lruCache      *cache.LruCache
-	policy        *trie.DomainTrie
-	searchDomains []string
-	disableCache  bool
+	ipv6                  bool
+	strategy              Strategy
+	hosts                 *trie.DomainTrie
+	main                  []dnsClient
+	fallback              []dnsClient
+	fallbackDomainFilters []fallbackDomainFilter
+	fallbackIPFilters     []fallbackIPFilter
+	group                 singleflight.Group
+	lruCache              *cache.LruCache
+	policy                *trie.DomainTrie
+	searchDomains         []string
+	disableCache          bool
+	blocklist             *Blocklist
+	prefetch              bool
+	maxStale              time.Duration
+	counter               cacheCounter
+	// closers are the clients that run background tasks, such as watching the system nameservers
+	closers []io.Closer
+
+	// cacheFile is set if the cache is persistent, done stops persistLoop
+	cacheFile   *cachefile.CacheFile
+	done        chan struct{}
+	persistOnce sync.Once
+	closeOnce   sync.Once
 }
 
 func (r *Resolver) GetServers() []string {
@@ -51,8 +69,29 @@ func (r *Resolver) GetServers() []string {
 	return servers
 }
 
-// LookupIP request with TypeA and TypeAAAA, priority return TypeA
+// LookupIP request with TypeA and TypeAAAA, the address family is picked by the strategy of host
 func (r *Resolver) LookupIP(ctx context.Context, host string) (ip []net.IP, err error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return []net.IP{ip4}, nil
+		}
+		return []net.IP{ip}, nil
+	}
+
+	switch r.strategyOf(host) {
+	case StrategyIPv4Only:
+		return r.lookupIP(ctx, host, D.TypeA)
+	case StrategyIPv6Only:
+		return r.lookupIP(ctx, host, D.TypeAAAA)
+	case StrategyPreferIPv6:
+		return r.lookupPreferred(ctx, host, D.TypeAAAA, D.TypeA)
+	default:
+		return r.lookupPreferred(ctx, host, D.TypeA, D.TypeAAAA)
+	}
+}
+
+// lookupPreferred queries both types, the answer of preferred is returned unless it fails
+func (r *Resolver) lookupPreferred(ctx context.Context, host string, preferred, other uint16) ([]net.IP, error) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -60,16 +99,16 @@ func (r *Resolver) LookupIP(ctx context.Context, host string) (ip []net.IP, err
 
 	go func() {
 		defer close(ch)
-		ip, err := r.lookupIP(ctx, host, D.TypeAAAA)
+		ip, err := r.lookupIP(ctx, host, other)
 		if err != nil {
 			return
 		}
 		ch <- ip
 	}()
 
-	ip, err = r.lookupIP(ctx, host, D.TypeA)
+	ip, err := r.lookupIP(ctx, host, preferred)
 	if err == nil {
-		return
+		return ip, nil
 	}
 
 	ip, open := <-ch
@@ -134,25 +173,11 @@ func (r *Resolver) ExchangeContext(ctx context.Context, m *D.Msg) (msg *D.Msg, e
 		return nil, errors.New("should have one question at least")
 	}
 
-	q := m.Question[0]
-	cacheItem, expireTime, hit := r.lruCache.GetWithExpire(q.String())
-	cachedMsg, ok := cacheItem.(*D.Msg)
-	if hit && ok {
-		now := time.Now()
-		msg = copyMsgFromCache(m, cachedMsg)
-		if expireTime.Before(now) {
-			setMsgTTL(msg, uint32(1)) // Continue fetch
-			go func() {
-				ctx, cancel := context.WithTimeout(context.Background(), resolver.DefaultDNSTimeout)
-				r.exchangeWithoutCache(ctx, m)
-				cancel()
-			}()
-		} else {
-			// updating TTL by subtracting common delta time from each DNS record
-			updateMsgTTL(msg, uint32(time.Until(expireTime).Seconds()))
-		}
-		return
+	if msg, ok := r.exchangeFromCache(ctx, r.cacheKey(ctx, m), m); ok {
+		return msg, nil
 	}
+
+	r.counter.misses.Inc()
 	return r.exchangeWithoutCache(ctx, m)
 }
 
@@ -175,7 +200,8 @@ func (r *Resolver) exchangeWithoutCache(ctx context.Context, m *D.Msg) (*D.Msg,
 		return r.exchange(ctx, m)
 	}
 	q := m.Question[0]
-	ret, err, shared := r.group.Do(q.String(), func() (result any, err error) {
+	key := r.cacheKey(ctx, m)
+	ret, err, shared := r.group.Do(key, func() (result any, err error) {
 		defer func() {
 			if err != nil {
 				return
@@ -186,7 +212,7 @@ func (r *Resolver) exchangeWithoutCache(ctx context.Context, m *D.Msg) (*D.Msg,
 			msg.Extra = lo.Filter(msg.Extra, func(rr D.RR, index int) bool {
 				return rr.Header().Rrtype != D.TypeOPT
 			})
-			putMsgToCache(r.lruCache, q.String(), q, msg)
+			putMsgToCache(r.lruCache, key, q, msg)
 		}()
 		return r.exchange(ctx, m)
 	})
@@ -223,7 +249,37 @@ func (r *Resolver) matchPolicy(m *D.Msg) []dnsClient {
 		return nil
 	}
 
-	return record.Data.([]dnsClient)
+	return record.Data.(*policy).clients
+}
+
+// shouldIPFallback returns true if any IP of the main answer matches the fallback filter
+func (r *Resolver) shouldIPFallback(ips []net.IP) bool {
+	for _, ip := range ips {
+		for _, filter := range r.fallbackIPFilters {
+			if filter.Match(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *Resolver) shouldOnlyQueryFallback(m *D.Msg) bool {
+	if r.fallback == nil || len(r.fallbackDomainFilters) == 0 {
+		return false
+	}
+
+	domain := r.msgToDomain(m)
+	if domain == "" {
+		return false
+	}
+
+	for _, df := range r.fallbackDomainFilters {
+		if df.Match(domain) {
+			return true
+		}
+	}
+	return false
 }
 
 func (r *Resolver) ipExchange(ctx context.Context, m *D.Msg) (msg *D.Msg, err error) {
@@ -232,6 +288,11 @@ func (r *Resolver) ipExchange(ctx context.Context, m *D.Msg) (msg *D.Msg, err er
 		return res.Msg, res.Error
 	}
 
+	if r.shouldOnlyQueryFallback(m) {
+		res := <-r.asyncExchange(ctx, r.fallback, m)
+		return res.Msg, res.Error
+	}
+
 	msgCh := r.asyncExchange(ctx, r.main, m)
 
 	if r.fallback == nil { // directly return if no fallback servers are available
@@ -243,7 +304,7 @@ func (r *Resolver) ipExchange(ctx context.Context, m *D.Msg) (msg *D.Msg, err er
 	fallbackMsg := r.asyncExchange(ctx, r.fallback, m)
 	res := <-msgCh
 	if res.Error == nil {
-		if ips := msgToIP(res.Msg); len(ips) != 0 {
+		if ips := msgToIP(res.Msg); len(ips) != 0 && !r.shouldIPFallback(ips) {
 			msg = res.Msg // no need to wait for fallback result
 			err = res.Error
 			return msg, err
@@ -322,6 +383,22 @@ type NameServer struct {
 	Net       string
 	Addr      string
 	Interface string
+	// Proxy dials the nameserver instead of the default dialer if set
+	Proxy C.Proxy
+	// ECS adds the EDNS Client Subnet option to the queries if set
+	ECS *ECS
+}
+
+// Policy is the nameserver of the domains of a nameserver-policy entry
+type Policy struct {
+	NameServer NameServer
+	// Strategy overrides the global strategy for the domains unless it's StrategyDefault
+	Strategy Strategy
+}
+
+type policy struct {
+	clients  []dnsClient
+	strategy Strategy
 }
 
 type FallbackFilter struct {
@@ -335,37 +412,114 @@ type Config struct {
 	Main, Fallback []NameServer
 	Default        []NameServer
 	IPv6           bool
+	Strategy       Strategy
 	EnhancedMode   C.DNSMode
 	FallbackFilter FallbackFilter
 	Pool           *fakeip.Pool
+	Pool6          *fakeip.Pool
 	Hosts          *trie.DomainTrie
-	Policy         map[string]NameServer
+	Policy         map[string]Policy
 	SearchDomains  []string
 	DisableCache   bool
-	GetDialer      func() (C.Proxy, error)
+	Blocklist      *Blocklist
+	// PersistCache saves the cache to the cache file periodically and on Close,
+	// only the latest resolver saves it, the one it replaces stops saving
+	PersistCache bool
+	// Prefetch refreshes frequently hit entries before they expire
+	Prefetch bool
+	// DisableServeStale stops serving expired answers while they are refreshed
+	DisableServeStale bool
+	// MaxStale is how long an expired answer may be served, DefaultMaxStale if 0
+	MaxStale  time.Duration
+	GetDialer func() (C.Proxy, error)
 }
 
 func NewResolver(config Config) *Resolver {
+	maxStale := config.MaxStale
+	switch {
+	case config.DisableServeStale:
+		maxStale = 0
+	case maxStale <= 0:
+		maxStale = DefaultMaxStale
+	}
 
 	r := &Resolver{
 		ipv6:          config.IPv6,
-		main:          transform(config.Main, config.GetDialer, config.Pool),
+		strategy:      config.Strategy,
 		lruCache:      cache.New(cache.WithSize(4096), cache.WithStale(true)),
 		hosts:         config.Hosts,
 		searchDomains: config.SearchDomains,
 		disableCache:  config.DisableCache,
+		blocklist:     config.Blocklist,
+		prefetch:      config.Prefetch,
+		maxStale:      maxStale,
+	}
+
+	r.main = r.transform(config.Main, config)
+
+	if config.PersistCache && !config.DisableCache {
+		r.startPersist(cachefile.Cache())
 	}
 
 	if len(config.Fallback) != 0 {
-		r.fallback = transform(config.Fallback, config.GetDialer, config.Pool)
+		r.fallback = r.transform(config.Fallback, config)
+	}
+
+	fallbackIPFilters := []fallbackIPFilter{}
+	if config.FallbackFilter.GeoIP {
+		fallbackIPFilters = append(fallbackIPFilters, &geoipFilter{
+			code: config.FallbackFilter.GeoIPCode,
+		})
+	}
+	for _, ipnet := range config.FallbackFilter.IPCIDR {
+		fallbackIPFilters = append(fallbackIPFilters, &ipnetFilter{ipnet: ipnet})
+	}
+	r.fallbackIPFilters = fallbackIPFilters
+
+	if len(config.FallbackFilter.Domain) != 0 {
+		r.fallbackDomainFilters = []fallbackDomainFilter{newDomainFilter(config.FallbackFilter.Domain)}
 	}
 
 	if len(config.Policy) != 0 {
 		r.policy = trie.New()
-		for domain, nameserver := range config.Policy {
-			r.policy.Insert(domain, transform([]NameServer{nameserver}, config.GetDialer, config.Pool))
+		for domain, p := range config.Policy {
+			r.policy.Insert(domain, &policy{
+				clients:  r.transform([]NameServer{p.NameServer}, config),
+				strategy: p.Strategy,
+			})
 		}
 	}
 
 	return r
 }
+
+// Close stops the background tasks of the resolver and saves the cache if it's persistent
+func (r *Resolver) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		for _, closer := range r.closers {
+			closer.Close()
+		}
+
+		if r.done != nil {
+			err = r.stopPersist()
+
+			persistMux.Lock()
+			if persistResolver == r {
+				persistResolver = nil
+			}
+			persistMux.Unlock()
+		}
+	})
+	return err
+}
+
+func (r *Resolver) transform(servers []NameServer, config Config) []dnsClient {
+	clients := transform(servers, config.GetDialer, config.Pool, config.Pool6)
+	for _, c := range clients {
+		if closer, ok := c.(io.Closer); ok {
+			r.closers = append(r.closers, closer)
+		}
+	}
+	return clients
+}
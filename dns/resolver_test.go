@@ -0,0 +1,176 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/Dreamacro/clash/common/cache"
+	"github.com/Dreamacro/clash/component/mmdb"
+	"github.com/Dreamacro/clash/component/mmdb/mmdbtest"
+
+	D "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+// stubClient answers every A question with ip, repeated count times, or fails with err
+type stubClient struct {
+	ip     string
+	count  int
+	err    error
+	called atomic.Bool
+}
+
+func (c *stubClient) GetServers() []string {
+	return []string{"stub"}
+}
+
+func (c *stubClient) Exchange(m *D.Msg) (*D.Msg, error) {
+	return c.ExchangeContext(context.Background(), m)
+}
+
+func (c *stubClient) ExchangeContext(ctx context.Context, m *D.Msg) (*D.Msg, error) {
+	c.called.Store(true)
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	msg := &D.Msg{}
+	msg.SetReply(m)
+	for i := 0; c.ip != "" && i < max(c.count, 1); i++ {
+		msg.Answer = append(msg.Answer, &D.A{
+			Hdr: D.RR_Header{Name: m.Question[0].Name, Rrtype: D.TypeA, Class: D.ClassINET, Ttl: 60},
+			A:   net.ParseIP(c.ip),
+		})
+	}
+	return msg, nil
+}
+
+func loadTestMMDB(t *testing.T) {
+	require.NoError(t, mmdb.LoadFromBytes(mmdbtest.Build(t, map[string]string{"1.0.1.0/24": "CN", "8.8.8.0/24": "US"})))
+}
+
+func TestResolver_FallbackFilter(t *testing.T) {
+	loadTestMMDB(t)
+
+	_, bogon, _ := net.ParseCIDR("240.0.0.0/4")
+	geoip := &geoipFilter{code: "CN"}
+
+	testCases := []struct {
+		name          string
+		main          *stubClient
+		fallback      *stubClient
+		ipFilters     []fallbackIPFilter
+		domains       []string
+		host          string
+		expected      string
+		mainCalled    bool
+		fallbackUsed  bool
+		noFallbackSet bool
+	}{
+		{
+			name:       "main answer without filter",
+			main:       &stubClient{ip: "8.8.8.8"},
+			fallback:   &stubClient{ip: "1.1.1.1"},
+			host:       "example.com",
+			expected:   "8.8.8.8",
+			mainCalled: true,
+		},
+		{
+			name:       "main answer in geoip code",
+			main:       &stubClient{ip: "1.0.1.1"},
+			fallback:   &stubClient{ip: "1.1.1.1"},
+			ipFilters:  []fallbackIPFilter{geoip},
+			host:       "example.com",
+			expected:   "1.0.1.1",
+			mainCalled: true,
+		},
+		{
+			name:         "main answer outside geoip code",
+			main:         &stubClient{ip: "8.8.8.8"},
+			fallback:     &stubClient{ip: "1.1.1.1"},
+			ipFilters:    []fallbackIPFilter{geoip},
+			host:         "example.com",
+			expected:     "1.1.1.1",
+			mainCalled:   true,
+			fallbackUsed: true,
+		},
+		{
+			name:       "private main answer",
+			main:       &stubClient{ip: "192.168.1.1"},
+			fallback:   &stubClient{ip: "1.1.1.1"},
+			ipFilters:  []fallbackIPFilter{geoip},
+			host:       "example.com",
+			expected:   "192.168.1.1",
+			mainCalled: true,
+		},
+		{
+			name:         "main answer in ipcidr",
+			main:         &stubClient{ip: "240.0.0.1"},
+			fallback:     &stubClient{ip: "1.1.1.1"},
+			ipFilters:    []fallbackIPFilter{&ipnetFilter{ipnet: bogon}},
+			host:         "example.com",
+			expected:     "1.1.1.1",
+			mainCalled:   true,
+			fallbackUsed: true,
+		},
+		{
+			name:         "domain only queries fallback",
+			main:         &stubClient{ip: "1.0.1.1"},
+			fallback:     &stubClient{ip: "1.1.1.1"},
+			ipFilters:    []fallbackIPFilter{geoip},
+			domains:      []string{"+.google.com"},
+			host:         "www.google.com",
+			expected:     "1.1.1.1",
+			fallbackUsed: true,
+		},
+		{
+			name:         "main error",
+			main:         &stubClient{err: errors.New("timeout")},
+			fallback:     &stubClient{ip: "1.1.1.1"},
+			host:         "example.com",
+			expected:     "1.1.1.1",
+			mainCalled:   true,
+			fallbackUsed: true,
+		},
+		{
+			name:          "filters without fallback servers",
+			main:          &stubClient{ip: "8.8.8.8"},
+			ipFilters:     []fallbackIPFilter{geoip},
+			domains:       []string{"+.google.com"},
+			host:          "www.google.com",
+			expected:      "8.8.8.8",
+			mainCalled:    true,
+			noFallbackSet: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &Resolver{
+				main:              []dnsClient{tc.main},
+				fallbackIPFilters: tc.ipFilters,
+				lruCache:          cache.New(),
+				disableCache:      true,
+			}
+			if !tc.noFallbackSet {
+				r.fallback = []dnsClient{tc.fallback}
+			}
+			if len(tc.domains) != 0 {
+				r.fallbackDomainFilters = []fallbackDomainFilter{newDomainFilter(tc.domains)}
+			}
+
+			ips, err := r.LookupIPv4(context.Background(), tc.host)
+			require.NoError(t, err)
+			require.Len(t, ips, 1)
+			assert.Equal(t, tc.expected, ips[0].String())
+			assert.Equal(t, tc.mainCalled, tc.main.called.Load())
+			if tc.fallbackUsed {
+				assert.True(t, tc.fallback.called.Load())
+			}
+		})
+	}
+}
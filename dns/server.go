@@ -1,8 +1,12 @@
 package dns
 
 import (
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"net"
+	"net/http"
+	"net/netip"
 
 	"github.com/Dreamacro/clash/common/sockopt"
 	"github.com/Dreamacro/clash/context"
@@ -12,95 +16,225 @@ import (
 )
 
 var (
-	address string
-	server  = &Server{}
+	serverConfig ServerConfig
+	server       = &Server{}
 
 	dnsDefaultTTL uint32 = 600
 )
 
+// ServerConfig holds the listeners of the built-in DNS server
+type ServerConfig struct {
+	// Listen is the UDP address, it's also served over TCP if ListenTCP is set
+	Listen    string
+	ListenTCP bool
+	// ListenTLS is the address of DNS over TLS
+	ListenTLS string
+	// ListenHTTPS is the address of DNS over HTTPS, queries are served on HTTPSPath
+	ListenHTTPS string
+	HTTPSPath   string
+	// Certificate and PrivateKey are the files used by DNS over TLS and HTTPS
+	Certificate string
+	PrivateKey  string
+}
+
 type Server struct {
-	*D.Server
-	handler handler
+	servers    []*D.Server
+	httpServer *http.Server
+	handler    handler
 }
 
 // ServeDNS implement D.Handler ServeDNS
 func (s *Server) ServeDNS(w D.ResponseWriter, r *D.Msg) {
-	msg, err := handlerWithContext(s.handler, r)
+	msg, err := handlerWithContext(s.handler, r, addrPortOf(w.RemoteAddr()).Addr())
 	if err != nil {
 		D.HandleFailed(w, r)
 		return
 	}
 	msg.Compress = true
+
+	// a UDP response larger than the client accepts is truncated with the TC bit set,
+	// so that the client retries over TCP
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		size := D.MinMsgSize
+		if opt := r.IsEdns0(); opt != nil {
+			size = int(opt.UDPSize())
+		}
+		msg.Truncate(size)
+	}
+
 	w.WriteMsg(msg)
 }
 
-func handlerWithContext(handler handler, msg *D.Msg) (*D.Msg, error) {
+func handlerWithContext(handler handler, msg *D.Msg, clientAddr netip.Addr) (*D.Msg, error) {
 	if len(msg.Question) == 0 {
 		return nil, errors.New("at least one question is required")
 	}
 
 	ctx := context.NewDNSContext(msg)
+	ctx.SetClientAddr(clientAddr)
 	return handler(ctx, msg)
 }
 
+// addrPortOf returns the address of a UDP or TCP client
+func addrPortOf(addr net.Addr) netip.AddrPort {
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		return addr.AddrPort()
+	case *net.TCPAddr:
+		return addr.AddrPort()
+	default:
+		return netip.AddrPort{}
+	}
+}
+
 func (s *Server) setHandler(handler handler) {
 	s.handler = handler
 }
 
-func ReCreateServer(addr string, resolver *Resolver, mapper *ResolverEnhancer) {
-	if addr == address && resolver != nil {
+func (s *Server) shutdown() {
+	for _, srv := range s.servers {
+		srv.Shutdown()
+	}
+	if s.httpServer != nil {
+		s.httpServer.Close()
+	}
+}
+
+func ReCreateServer(cfg ServerConfig, resolver *Resolver, mapper *ResolverEnhancer) {
+	if cfg == serverConfig && resolver != nil {
 		handler := newHandler(resolver, mapper)
 		server.setHandler(handler)
 		return
 	}
 
-	if server.Server != nil {
-		server.Shutdown()
-		server = &Server{}
-		address = ""
-	}
+	server.shutdown()
+	server = &Server{}
+	serverConfig = ServerConfig{}
 
-	if addr == "" {
+	if cfg.Listen == "" && cfg.ListenTLS == "" && cfg.ListenHTTPS == "" {
 		return
 	}
 
-	var err error
-	defer func() {
-		if err != nil {
+	server.handler = newHandler(resolver, mapper)
+
+	// the config is kept only when all the listeners are up, otherwise the next call retries them
+	if server.listen(cfg) {
+		serverConfig = cfg
+	}
+}
+
+// listen starts the listeners of cfg and logs those that fail, it reports whether all of them are up
+func (s *Server) listen(cfg ServerConfig) bool {
+	ok := true
+
+	if cfg.Listen != "" {
+		if err := s.listenPlain(cfg.Listen, cfg.ListenTCP); err != nil {
 			log.Errorln("Start DNS server error: %s", err.Error())
+			ok = false
+		}
+	}
+
+	if cfg.ListenTLS == "" && cfg.ListenHTTPS == "" {
+		return ok
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.Certificate, cfg.PrivateKey)
+	if err != nil {
+		log.Errorln("Start DNS server error: load certificate: %s", err.Error())
+		return false
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if cfg.ListenTLS != "" {
+		if err := s.listenTLS(cfg.ListenTLS, tlsConfig); err != nil {
+			log.Errorln("Start DNS over TLS server error: %s", err.Error())
+			ok = false
+		}
+	}
+
+	if cfg.ListenHTTPS != "" {
+		if err := s.listenHTTPS(cfg.ListenHTTPS, cfg.HTTPSPath, tlsConfig); err != nil {
+			log.Errorln("Start DNS over HTTPS server error: %s", err.Error())
+			ok = false
 		}
-	}()
+	}
+
+	return ok
+}
 
+func (s *Server) serve(srv *D.Server) {
+	s.servers = append(s.servers, srv)
+	go srv.ActivateAndServe()
+}
+
+func (s *Server) listenPlain(addr string, withTCP bool) error {
 	_, port, err := net.SplitHostPort(addr)
-	if port == "0" || port == "" || err != nil {
-		return
+	if err != nil {
+		return err
+	}
+	if port == "0" || port == "" {
+		return fmt.Errorf("invalid port of %s", addr)
 	}
 
 	udpAddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
-		return
+		return err
 	}
 
 	p, err := net.ListenUDP("udp", udpAddr)
 	if err != nil {
-		return
+		return err
 	}
 
 	err = sockopt.UDPReuseaddr(p)
 	if err != nil {
 		log.Warnln("Failed to Reuse UDP Address: %s", err)
+	}
 
-		err = nil
+	s.serve(&D.Server{Addr: addr, PacketConn: p, Handler: s})
+	log.Infoln("DNS server listening at: %s", p.LocalAddr().String())
+
+	if !withTCP {
+		return nil
 	}
 
-	address = addr
-	handler := newHandler(resolver, mapper)
-	server = &Server{handler: handler}
-	server.Server = &D.Server{Addr: addr, PacketConn: p, Handler: server}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
 
-	go func() {
-		server.ActivateAndServe()
-	}()
+	s.serve(&D.Server{Addr: addr, Listener: l, Handler: s})
+	log.Infoln("DNS server listening at: %s (TCP)", l.Addr().String())
+	return nil
+}
 
-	log.Infoln("DNS server listening at: %s", p.LocalAddr().String())
+func (s *Server) listenTLS(addr string, tlsConfig *tls.Config) error {
+	l, err := tls.Listen("tcp", addr, tlsConfig)
+	if err != nil {
+		return err
+	}
+
+	s.serve(&D.Server{Addr: addr, Net: "tcp-tls", Listener: l, Handler: s})
+	log.Infoln("DNS over TLS server listening at: %s", l.Addr().String())
+	return nil
+}
+
+func (s *Server) listenHTTPS(addr string, path string, tlsConfig *tls.Config) error {
+	if path == "" {
+		path = DefaultDoHPath
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, &dohHandler{server: s})
+	s.httpServer = &http.Server{Handler: mux, TLSConfig: tlsConfig.Clone()}
+
+	// ServeTLS negotiates HTTP/2 as well
+	go s.httpServer.ServeTLS(l, "", "")
+	log.Infoln("DNS over HTTPS server listening at: %s", l.Addr().String())
+	return nil
 }
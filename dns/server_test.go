@@ -0,0 +1,182 @@
+package dns
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/common/cache"
+	C "github.com/Dreamacro/clash/constant"
+
+	D "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeCertificate(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+// freeAddr returns a loopback address whose port is free for both TCP and UDP
+func freeAddr(t *testing.T) string {
+	for i := 0; i < 10; i++ {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := pc.LocalAddr().String()
+		l, err := net.Listen("tcp", addr)
+		pc.Close()
+		if err == nil {
+			l.Close()
+			return addr
+		}
+	}
+	t.Fatal("no free port")
+	return ""
+}
+
+func TestServer(t *testing.T) {
+	certFile, keyFile := writeCertificate(t)
+	resolver := &Resolver{
+		main:         []dnsClient{&stubClient{ip: "1.1.1.1", count: 100}},
+		lruCache:     cache.New(),
+		disableCache: true,
+	}
+	cfg := ServerConfig{
+		Listen:      freeAddr(t),
+		ListenTCP:   true,
+		ListenTLS:   freeAddr(t),
+		ListenHTTPS: freeAddr(t),
+		Certificate: certFile,
+		PrivateKey:  keyFile,
+	}
+	ReCreateServer(cfg, resolver, &ResolverEnhancer{mode: C.DNSNormal})
+	defer ReCreateServer(ServerConfig{}, nil, nil)
+
+	query := &D.Msg{}
+	query.SetQuestion("example.com.", D.TypeA)
+
+	t.Run("UDP truncated", func(t *testing.T) {
+		msg, _, err := (&D.Client{Net: "udp"}).Exchange(query, cfg.Listen)
+		require.NoError(t, err)
+		assert.True(t, msg.Truncated)
+		assert.Less(t, len(msg.Answer), 100)
+
+		edns := query.Copy()
+		edns.SetEdns0(4096, false)
+		msg, _, err = (&D.Client{Net: "udp", UDPSize: 4096}).Exchange(edns, cfg.Listen)
+		require.NoError(t, err)
+		assert.False(t, msg.Truncated)
+		assert.Len(t, msg.Answer, 100)
+	})
+
+	t.Run("TCP", func(t *testing.T) {
+		msg, _, err := (&D.Client{Net: "tcp"}).Exchange(query, cfg.Listen)
+		require.NoError(t, err)
+		assert.False(t, msg.Truncated)
+		assert.Len(t, msg.Answer, 100)
+	})
+
+	t.Run("TLS", func(t *testing.T) {
+		client := &D.Client{Net: "tcp-tls", TLSConfig: &tls.Config{InsecureSkipVerify: true}}
+		msg, _, err := client.Exchange(query, cfg.ListenTLS)
+		require.NoError(t, err)
+		assert.Len(t, msg.Answer, 100)
+	})
+
+	t.Run("HTTPS", func(t *testing.T) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		}}
+		url := "https://" + cfg.ListenHTTPS + DefaultDoHPath
+		buf, err := query.Pack()
+		require.NoError(t, err)
+
+		exchange := func(req *http.Request) *D.Msg {
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, "HTTP/2.0", resp.Proto)
+			assert.Equal(t, "max-age="+strconv.Itoa(60), resp.Header.Get("Cache-Control"))
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			msg := &D.Msg{}
+			require.NoError(t, msg.Unpack(body))
+			return msg
+		}
+
+		req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader(buf))
+		req.Header.Set("Content-Type", dotMimeType)
+		assert.Len(t, exchange(req).Answer, 100)
+
+		req, _ = http.NewRequest(http.MethodGet, url+"?dns="+base64.RawURLEncoding.EncodeToString(buf), nil)
+		assert.Len(t, exchange(req).Answer, 100)
+
+		req, _ = http.NewRequest(http.MethodPost, url, bytes.NewReader(buf))
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+	})
+}
+
+func TestServer_RetryFailedListener(t *testing.T) {
+	resolver := &Resolver{
+		main:         []dnsClient{&stubClient{ip: "1.1.1.1", count: 1}},
+		lruCache:     cache.New(),
+		disableCache: true,
+	}
+	addr := freeAddr(t)
+	cfg := ServerConfig{Listen: addr}
+
+	occupied, err := net.ListenPacket("udp", addr)
+	require.NoError(t, err)
+	ReCreateServer(cfg, resolver, &ResolverEnhancer{mode: C.DNSNormal})
+	defer ReCreateServer(ServerConfig{}, nil, nil)
+	assert.Equal(t, ServerConfig{}, serverConfig)
+
+	// the same config is listened again once the port is free
+	occupied.Close()
+	ReCreateServer(cfg, resolver, &ResolverEnhancer{mode: C.DNSNormal})
+	assert.Equal(t, cfg, serverConfig)
+
+	query := &D.Msg{}
+	query.SetQuestion("example.com.", D.TypeA)
+	msg, _, err := (&D.Client{Net: "udp"}).Exchange(query, addr)
+	require.NoError(t, err)
+	assert.Len(t, msg.Answer, 1)
+}
@@ -0,0 +1,70 @@
+package dns
+
+import (
+	"strings"
+
+	D "github.com/miekg/dns"
+)
+
+// Strategy is the address family that a domain is resolved to
+type Strategy int
+
+const (
+	// StrategyDefault prefers IPv4, AAAA questions are answered empty by the DNS server if ipv6 is disabled
+	StrategyDefault Strategy = iota
+	StrategyPreferIPv4
+	StrategyPreferIPv6
+	StrategyIPv4Only
+	StrategyIPv6Only
+)
+
+// StrategyMapping is a mapping for Strategy enum
+var StrategyMapping = map[string]Strategy{
+	StrategyPreferIPv4.String(): StrategyPreferIPv4,
+	StrategyPreferIPv6.String(): StrategyPreferIPv6,
+	StrategyIPv4Only.String():   StrategyIPv4Only,
+	StrategyIPv6Only.String():   StrategyIPv6Only,
+}
+
+func (s Strategy) String() string {
+	switch s {
+	case StrategyDefault:
+		return "default"
+	case StrategyPreferIPv4:
+		return "prefer-ipv4"
+	case StrategyPreferIPv6:
+		return "prefer-ipv6"
+	case StrategyIPv4Only:
+		return "ipv4-only"
+	case StrategyIPv6Only:
+		return "ipv6-only"
+	default:
+		return "unknown"
+	}
+}
+
+// strategyOf returns the strategy of the nameserver-policy that matches domain, or the global one
+func (r *Resolver) strategyOf(domain string) Strategy {
+	if r.policy != nil {
+		if record := r.policy.Search(domain); record != nil {
+			if strategy := record.Data.(*policy).strategy; strategy != StrategyDefault {
+				return strategy
+			}
+		}
+	}
+	return r.strategy
+}
+
+// excludedByStrategy returns true if q asks for an address family that the strategy of its domain excludes
+func (r *Resolver) excludedByStrategy(q D.Question) bool {
+	switch r.strategyOf(strings.TrimRight(q.Name, ".")) {
+	case StrategyDefault:
+		return !r.ipv6 && q.Qtype == D.TypeAAAA
+	case StrategyIPv4Only:
+		return q.Qtype == D.TypeAAAA
+	case StrategyIPv6Only:
+		return q.Qtype == D.TypeA
+	default:
+		return false
+	}
+}
@@ -0,0 +1,138 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/Dreamacro/clash/common/cache"
+	"github.com/Dreamacro/clash/component/trie"
+
+	D "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// familyClient answers A questions with ip4 and AAAA questions with ip6, an empty
+// address gets an empty answer
+type familyClient struct {
+	ip4, ip6 string
+
+	lock  sync.Mutex
+	types []uint16
+}
+
+func (c *familyClient) GetServers() []string {
+	return []string{"family"}
+}
+
+func (c *familyClient) Exchange(m *D.Msg) (*D.Msg, error) {
+	return c.ExchangeContext(context.Background(), m)
+}
+
+func (c *familyClient) ExchangeContext(ctx context.Context, m *D.Msg) (*D.Msg, error) {
+	q := m.Question[0]
+	c.lock.Lock()
+	c.types = append(c.types, q.Qtype)
+	c.lock.Unlock()
+
+	msg := &D.Msg{}
+	msg.SetReply(m)
+	hdr := D.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: D.ClassINET, Ttl: 60}
+	if q.Qtype == D.TypeA && c.ip4 != "" {
+		msg.Answer = []D.RR{&D.A{Hdr: hdr, A: net.ParseIP(c.ip4)}}
+	} else if q.Qtype == D.TypeAAAA && c.ip6 != "" {
+		msg.Answer = []D.RR{&D.AAAA{Hdr: hdr, AAAA: net.ParseIP(c.ip6)}}
+	}
+	return msg, nil
+}
+
+func (c *familyClient) queried() []uint16 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.types
+}
+
+func TestResolver_LookupIPStrategy(t *testing.T) {
+	testCases := []struct {
+		strategy Strategy
+		ip4, ip6 string
+		expected string
+	}{
+		{StrategyDefault, "1.1.1.1", "2001:db8::1", "1.1.1.1"},
+		{StrategyDefault, "", "2001:db8::1", "2001:db8::1"},
+		{StrategyPreferIPv4, "1.1.1.1", "2001:db8::1", "1.1.1.1"},
+		{StrategyPreferIPv6, "1.1.1.1", "2001:db8::1", "2001:db8::1"},
+		{StrategyPreferIPv6, "1.1.1.1", "", "1.1.1.1"},
+		{StrategyIPv4Only, "1.1.1.1", "2001:db8::1", "1.1.1.1"},
+		{StrategyIPv6Only, "1.1.1.1", "2001:db8::1", "2001:db8::1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.strategy.String(), func(t *testing.T) {
+			client := &familyClient{ip4: tc.ip4, ip6: tc.ip6}
+			r := &Resolver{
+				main:     []dnsClient{client},
+				lruCache: cache.New(cache.WithStale(true)),
+				strategy: tc.strategy,
+			}
+
+			ips, err := r.LookupIP(context.Background(), "example.com")
+			require.NoError(t, err)
+			require.Len(t, ips, 1)
+			assert.Equal(t, tc.expected, ips[0].String())
+		})
+	}
+
+	client := &familyClient{ip4: "1.1.1.1"}
+	r := &Resolver{
+		main:     []dnsClient{client},
+		lruCache: cache.New(cache.WithStale(true)),
+		strategy: StrategyIPv6Only,
+	}
+	_, err := r.LookupIP(context.Background(), "example.com")
+	assert.Error(t, err)
+	assert.Equal(t, []uint16{D.TypeAAAA}, client.queried())
+
+	// an IP is returned as is
+	ips, err := r.LookupIP(context.Background(), "1.2.3.4")
+	require.NoError(t, err)
+	assert.Equal(t, "1.2.3.4", ips[0].String())
+}
+
+func TestResolver_PolicyStrategy(t *testing.T) {
+	main := &familyClient{ip4: "1.1.1.1", ip6: "2001:db8::1"}
+	matched := &familyClient{ip4: "2.2.2.2", ip6: "2001:db8::2"}
+
+	r := &Resolver{
+		main:     []dnsClient{main},
+		lruCache: cache.New(cache.WithStale(true)),
+		policy:   trie.New(),
+		strategy: StrategyIPv4Only,
+	}
+	r.policy.Insert("+.v6.com", &policy{clients: []dnsClient{matched}, strategy: StrategyIPv6Only})
+	r.policy.Insert("+.default.com", &policy{clients: []dnsClient{matched}})
+
+	ips, err := r.LookupIP(context.Background(), "www.v6.com")
+	require.NoError(t, err)
+	assert.Equal(t, "2001:db8::2", ips[0].String())
+
+	ips, err = r.LookupIP(context.Background(), "www.default.com")
+	require.NoError(t, err)
+	assert.Equal(t, "2.2.2.2", ips[0].String())
+
+	question := func(host string, qtype uint16) D.Question {
+		return D.Question{Name: D.Fqdn(host), Qtype: qtype, Qclass: D.ClassINET}
+	}
+	assert.True(t, r.excludedByStrategy(question("example.com", D.TypeAAAA)))
+	assert.False(t, r.excludedByStrategy(question("example.com", D.TypeA)))
+	assert.True(t, r.excludedByStrategy(question("www.v6.com", D.TypeA)))
+	assert.False(t, r.excludedByStrategy(question("www.v6.com", D.TypeAAAA)))
+
+	// ipv6 disabled keeps excluding AAAA by default
+	r.strategy = StrategyDefault
+	assert.True(t, r.excludedByStrategy(question("example.com", D.TypeAAAA)))
+	r.ipv6 = true
+	assert.False(t, r.excludedByStrategy(question("example.com", D.TypeAAAA)))
+}
@@ -8,12 +8,21 @@ import (
 	"github.com/Dreamacro/clash/log"
 	"net"
 	"net/netip"
+	"runtime"
 	"sync"
 
 	D "github.com/miekg/dns"
 )
 
+// for auto gc, the watcher only references systemNameservers, so that it's
+// stopped once the client is unreachable even if Close isn't called
 type systemClient struct {
+	*systemNameservers
+	// stopWatch stops watching the system nameservers
+	stopWatch func()
+}
+
+type systemNameservers struct {
 	ifaceName string
 	lock      sync.Mutex
 	clients   []dnsClient
@@ -22,7 +31,7 @@ type systemClient struct {
 
 func (s *systemClient) GetServers() []string {
 	var servers []string
-	for _, c := range s.clients {
+	for _, c := range s.getClients() {
 		servers = append(servers, c.GetServers()...)
 	}
 	return servers
@@ -36,26 +45,27 @@ func (s *systemClient) Exchange(m *D.Msg) (msg *D.Msg, err error) {
 }
 
 func (s *systemClient) ExchangeContext(ctx context.Context, m *D.Msg) (msg *D.Msg, err error) {
-	var clients = s.clients
+	clients := s.getClients()
 	if len(clients) == 0 {
 		err = s.update()
 		if err != nil {
 			return nil, err
 		}
+		clients = s.getClients()
 	}
 	mRes, err := batchExchange(ctx, clients, m)
 	if err != nil {
 		go func() {
 			err := s.update()
 			if err != nil {
-				log.Warnln("Batch exchange failed:", err)
+				log.Warnln("Batch exchange failed: %s", err)
 			}
 		}()
 	}
 	return mRes, err
 }
 
-func (s *systemClient) update() error {
+func (s *systemNameservers) update() error {
 	dns, err := system_dns.ResolveServers(s.ifaceName)
 	if err != nil {
 		return err
@@ -75,18 +85,42 @@ func (s *systemClient) update() error {
 
 	}
 
-	res = transform(nameserver, s.getDialer, nil)
+	res = transform(nameserver, s.getDialer, nil, nil)
 	s.lock.Lock()
 	s.clients = res
 	s.lock.Unlock()
 	return nil
 }
 
+func (s *systemNameservers) getClients() []dnsClient {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.clients
+}
+
+// Close implements io.Closer
+func (s *systemClient) Close() error {
+	s.stopWatch()
+	return nil
+}
+
+func closeSystemClient(s *systemClient) {
+	s.stopWatch()
+}
+
 func newSystemClient(ifaceName string, getDialer func() (C.Proxy, error)) *systemClient {
-	newClient := &systemClient{ifaceName: ifaceName, getDialer: getDialer}
-	err := newClient.update()
+	nameservers := &systemNameservers{ifaceName: ifaceName, getDialer: getDialer}
+	err := nameservers.update()
 	if err != nil {
-		log.Warnln("System DNS init failed:", err)
+		log.Warnln("System DNS init failed: %s", err)
 	}
+
+	newClient := &systemClient{systemNameservers: nameservers}
+	newClient.stopWatch = system_dns.Watch(ifaceName, func() {
+		if err := nameservers.update(); err != nil {
+			log.Warnln("System DNS update failed: %s", err)
+		}
+	})
+	runtime.SetFinalizer(newClient, closeSystemClient)
 	return newClient
 }
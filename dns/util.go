@@ -68,7 +68,7 @@ func putMsgToCache(c *cache.LruCache, key string, q D.Question, msg *D.Msg) {
 	if ttl == 0 {
 		return
 	}
-	c.SetWithExpire(key, msg.Copy(), time.Now().Add(time.Duration(ttl)*time.Second))
+	c.SetWithExpire(key, &cacheEntry{msg: msg.Copy(), ttl: ttl}, time.Now().Add(time.Duration(ttl)*time.Second))
 }
 
 func setMsgTTL(msg *D.Msg, ttl uint32) {
@@ -95,40 +95,67 @@ func isIPRequest(q D.Question) bool {
 	return q.Qclass == D.ClassINET && (q.Qtype == D.TypeA || q.Qtype == D.TypeAAAA)
 }
 
-func transform(servers []NameServer, getDialer func() (C.Proxy, error), fakeIpPool *fakeip.Pool) []dnsClient {
+func transform(servers []NameServer, getDialer func() (C.Proxy, error), fakeIpPool *fakeip.Pool, fakeIpPool6 *fakeip.Pool) []dnsClient {
 	ret := []dnsClient{}
 	for _, s := range servers {
+		getDialer := getDialer
+		if proxy := s.Proxy; proxy != nil {
+			getDialer = func() (C.Proxy, error) {
+				return proxy, nil
+			}
+		}
+
+		var c dnsClient
 		switch s.Net {
 		case "https":
-			ret = append(ret, newDoHClient(s.Addr, getDialer))
-			continue
+			c = newDoHClient(s.Addr, getDialer)
+		case "h3":
+			c = newDoH3Client(s.Addr, s.Interface, getDialer)
+		case "quic":
+			c = newDoQClient(s.Addr, s.Interface, getDialer)
 		case "system":
-			ret = append(ret, newSystemClient(s.Interface, getDialer))
-			continue
+			c = newSystemClient(s.Interface, getDialer)
+		case "dhcp":
+			c = newDHCPClient(s.Addr, getDialer)
 		case "fake-ip":
-			ret = append(ret, newFakeIpClient(fakeIpPool))
-			continue
+			c = newFakeIpClient(fakeIpPool, fakeIpPool6)
+		default:
+			host, port, _ := net.SplitHostPort(s.Addr)
+			c = &client{
+				Client: &D.Client{
+					Net: s.Net,
+					TLSConfig: &tls.Config{
+						ServerName: host,
+					},
+					UDPSize: 4096,
+					Timeout: 5 * time.Second,
+				},
+				port:      port,
+				host:      host,
+				iface:     s.Interface,
+				getDialer: getDialer,
+				proxy:     s.Proxy,
+			}
 		}
 
-		host, port, _ := net.SplitHostPort(s.Addr)
-		ret = append(ret, &client{
-			Client: &D.Client{
-				Net: s.Net,
-				TLSConfig: &tls.Config{
-					ServerName: host,
-				},
-				UDPSize: 4096,
-				Timeout: 5 * time.Second,
-			},
-			port:      port,
-			host:      host,
-			iface:     s.Interface,
-			getDialer: getDialer,
-		})
+		if s.ECS != nil {
+			c = &ecsClient{dnsClient: c, ecs: s.ECS}
+		}
+		ret = append(ret, c)
 	}
 	return ret
 }
 
+// newFakeIPRR returns the A or AAAA record of q with a fake ip
+func newFakeIPRR(q D.Question, ip net.IP) D.RR {
+	hdr := D.RR_Header{Name: q.Name, Rrtype: D.TypeA, Class: D.ClassINET, Ttl: dnsDefaultTTL}
+	if q.Qtype == D.TypeAAAA {
+		hdr.Rrtype = D.TypeAAAA
+		return &D.AAAA{Hdr: hdr, AAAA: ip}
+	}
+	return &D.A{Hdr: hdr, A: ip}
+}
+
 func handleMsgWithEmptyAnswer(r *D.Msg) *D.Msg {
 	msg := &D.Msg{}
 	msg.Answer = []D.RR{}
@@ -2,6 +2,7 @@ package rules
 
 import (
 	"errors"
+	"strings"
 )
 
 var (
@@ -18,3 +19,38 @@ func HasNoResolve(params []string) bool {
 	}
 	return false
 }
+
+// SplitRule splits a rule line on the commas outside of brackets,
+// so that the nested payload of a logical rule stays in one part.
+// Lines with unbalanced brackets are split on every comma.
+func SplitRule(line string) []string {
+	parts := []string{}
+	depth, start := 0, 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, strings.TrimSpace(line[start:i]))
+				start = i + 1
+			}
+		}
+
+		if depth < 0 {
+			break
+		}
+	}
+
+	if depth != 0 {
+		parts = strings.Split(line, ",")
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		return parts
+	}
+
+	return append(parts, strings.TrimSpace(line[start:]))
+}
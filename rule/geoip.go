@@ -0,0 +1,68 @@
+package rules
+
+import (
+	"strings"
+
+	"github.com/Dreamacro/clash/component/mmdb"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+)
+
+// Implements C.Rule
+var _ C.Rule = (*GEOIP)(nil)
+
+type GEOIP struct {
+	country     string
+	adapter     string
+	noResolveIP bool
+}
+
+func (g *GEOIP) RuleType() C.RuleType {
+	return C.GEOIP
+}
+
+func (g *GEOIP) Match(metadata *C.Metadata) bool {
+	ip := metadata.DstIP
+	if ip == nil {
+		return false
+	}
+
+	if g.country == "LAN" {
+		return ip.IsPrivate() ||
+			ip.IsUnspecified() ||
+			ip.IsLoopback() ||
+			ip.IsMulticast() ||
+			ip.IsLinkLocalUnicast()
+	}
+
+	code, err := mmdb.LookupCode(ip)
+	if err != nil {
+		log.Debugln("[GEOIP] lookup %s failed: %s", ip.String(), err.Error())
+		return false
+	}
+	return strings.EqualFold(code, g.country)
+}
+
+func (g *GEOIP) Adapter() string {
+	return g.adapter
+}
+
+func (g *GEOIP) Payload() string {
+	return g.country
+}
+
+func (g *GEOIP) ShouldResolveIP() bool {
+	return !g.noResolveIP
+}
+
+func (g *GEOIP) ShouldFindProcess() bool {
+	return false
+}
+
+func NewGEOIP(country string, adapter string, noResolveIP bool) *GEOIP {
+	return &GEOIP{
+		country:     strings.ToUpper(country),
+		adapter:     adapter,
+		noResolveIP: noResolveIP,
+	}
+}
@@ -0,0 +1,44 @@
+package rules
+
+import (
+	"net"
+	"testing"
+
+	"github.com/Dreamacro/clash/component/mmdb"
+	"github.com/Dreamacro/clash/component/mmdb/mmdbtest"
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGEOIPMatch(t *testing.T) {
+	require.NoError(t, mmdb.LoadFromBytes(mmdbtest.Build(t, map[string]string{"1.0.1.0/24": "CN"})))
+
+	cn := NewGEOIP("cn", "DIRECT", false)
+	lan := NewGEOIP("LAN", "DIRECT", true)
+
+	testCases := []struct {
+		rule     *GEOIP
+		ip       net.IP
+		expected bool
+	}{
+		{cn, net.ParseIP("1.0.1.1"), true},
+		{cn, net.ParseIP("8.8.8.8"), false},
+		{cn, nil, false},
+		{lan, net.ParseIP("192.168.1.1"), true},
+		{lan, net.ParseIP("10.0.0.1"), true},
+		{lan, net.ParseIP("127.0.0.1"), true},
+		{lan, net.ParseIP("fe80::1"), true},
+		{lan, net.ParseIP("fd00::1"), true},
+		{lan, net.ParseIP("1.0.1.1"), false},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, tc.rule.Match(&C.Metadata{DstIP: tc.ip}), "%s %s", tc.rule.Payload(), tc.ip)
+	}
+
+	assert.Equal(t, "CN", cn.Payload())
+	assert.True(t, cn.ShouldResolveIP())
+	assert.False(t, lan.ShouldResolveIP())
+}
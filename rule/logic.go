@@ -0,0 +1,175 @@
+package rules
+
+import (
+	"fmt"
+	"strings"
+
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/constant/provider"
+)
+
+// Implements C.Rule
+var _ C.Rule = (*Logic)(nil)
+
+// Logic combines sub rules with AND, OR or NOT, the payload looks like
+// ((DOMAIN-SUFFIX,example.com),(NOT,((DST-PORT,80))))
+type Logic struct {
+	ruleType C.RuleType
+	rules    []C.Rule
+	adapter  string
+}
+
+func (l *Logic) RuleType() C.RuleType {
+	return l.ruleType
+}
+
+func (l *Logic) Match(metadata *C.Metadata) bool {
+	switch l.ruleType {
+	case C.AND:
+		for _, rule := range l.rules {
+			if !rule.Match(metadata) {
+				return false
+			}
+		}
+		return true
+	case C.OR:
+		for _, rule := range l.rules {
+			if rule.Match(metadata) {
+				return true
+			}
+		}
+		return false
+	default:
+		return !l.rules[0].Match(metadata)
+	}
+}
+
+func (l *Logic) Adapter() string {
+	return l.adapter
+}
+
+// Payload prints the sub rules as an expression, e.g. DomainSuffix(example.com) && !DstPort(80)
+func (l *Logic) Payload() string {
+	items := make([]string, len(l.rules))
+	for i, rule := range l.rules {
+		item := rule.RuleType().String() + "(" + rule.Payload() + ")"
+		if sub, ok := rule.(*Logic); ok {
+			item = sub.Payload()
+			if sub.ruleType != C.NOT {
+				item = "(" + item + ")"
+			}
+		}
+		items[i] = item
+	}
+
+	switch l.ruleType {
+	case C.AND:
+		return strings.Join(items, " && ")
+	case C.OR:
+		return strings.Join(items, " || ")
+	default:
+		return "!" + items[0]
+	}
+}
+
+func (l *Logic) ShouldResolveIP() bool {
+	for _, rule := range l.rules {
+		if rule.ShouldResolveIP() {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Logic) ShouldFindProcess() bool {
+	for _, rule := range l.rules {
+		if rule.ShouldFindProcess() {
+			return true
+		}
+	}
+	return false
+}
+
+func NewAND(payload string, adapter string, ruleProviders map[string]provider.RuleProvider) (*Logic, error) {
+	return newLogic(C.AND, payload, adapter, ruleProviders)
+}
+
+func NewOR(payload string, adapter string, ruleProviders map[string]provider.RuleProvider) (*Logic, error) {
+	return newLogic(C.OR, payload, adapter, ruleProviders)
+}
+
+func NewNOT(payload string, adapter string, ruleProviders map[string]provider.RuleProvider) (*Logic, error) {
+	return newLogic(C.NOT, payload, adapter, ruleProviders)
+}
+
+func newLogic(ruleType C.RuleType, payload string, adapter string, ruleProviders map[string]provider.RuleProvider) (*Logic, error) {
+	rules, err := parseSubRules(payload, ruleProviders)
+	if err != nil {
+		return nil, err
+	}
+
+	if ruleType == C.NOT && len(rules) != 1 {
+		return nil, fmt.Errorf("NOT needs exactly one sub rule, got %d", len(rules))
+	}
+
+	return &Logic{
+		ruleType: ruleType,
+		rules:    rules,
+		adapter:  adapter,
+	}, nil
+}
+
+// parseSubRules parses a payload like ((TYPE,payload[,params]),(TYPE,payload)) through ParseRule
+func parseSubRules(payload string, ruleProviders map[string]provider.RuleProvider) ([]C.Rule, error) {
+	payload = strings.TrimSpace(payload)
+	if !isWrapped(payload) {
+		return nil, fmt.Errorf("%w: '%s' should be wrapped in parentheses", errPayload, payload)
+	}
+
+	items := SplitRule(payload[1 : len(payload)-1])
+	rules := make([]C.Rule, 0, len(items))
+	for _, item := range items {
+		if !isWrapped(item) {
+			return nil, fmt.Errorf("%w: sub rule '%s' should be wrapped in parentheses", errPayload, item)
+		}
+
+		parts := SplitRule(item[1 : len(item)-1])
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("%w: sub rule '%s' format invalid", errPayload, item)
+		}
+
+		tp := parts[0]
+		if C.RuleConfig(tp) == C.RuleConfigMatch {
+			return nil, fmt.Errorf("%w: MATCH can't be a sub rule", errPayload)
+		}
+
+		rule, err := ParseRule(tp, parts[1], "", parts[2:], ruleProviders)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", item, err)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// isWrapped returns true if s is a single group of parentheses, i.e. (a),(b) is not wrapped
+func isWrapped(s string) bool {
+	if len(s) < 2 || s[0] != '(' || s[len(s)-1] != ')' {
+		return false
+	}
+
+	depth := 0
+	for i := 0; i < len(s)-1; i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		if depth == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package rules
+
+import (
+	"net"
+	"testing"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitRule(t *testing.T) {
+	testCases := []struct {
+		line     string
+		expected []string
+	}{
+		{"DOMAIN, example.com ,DIRECT", []string{"DOMAIN", "example.com", "DIRECT"}},
+		{"AND,((DOMAIN,a.com),(DST-PORT,443)),JP", []string{"AND", "((DOMAIN,a.com),(DST-PORT,443))", "JP"}},
+		{"PROCESS-PATH,C:\\Program Files (x86)\\app.exe,DIRECT", []string{"PROCESS-PATH", "C:\\Program Files (x86)\\app.exe", "DIRECT"}},
+		{"PROCESS-NAME,a(b,DIRECT", []string{"PROCESS-NAME", "a(b", "DIRECT"}},
+		{"MATCH,DIRECT", []string{"MATCH", "DIRECT"}},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, SplitRule(tc.line), tc.line)
+	}
+}
+
+func TestLogic(t *testing.T) {
+	metadata := func(host string, port C.Port) *C.Metadata {
+		return &C.Metadata{Host: host, DstPort: port}
+	}
+
+	and, err := NewAND("((DOMAIN-SUFFIX,example.com),(DST-PORT,443))", "JP", nil)
+	require.NoError(t, err)
+	assert.Equal(t, C.AND, and.RuleType())
+	assert.True(t, and.Match(metadata("www.example.com", 443)))
+	assert.False(t, and.Match(metadata("www.example.com", 80)))
+	assert.False(t, and.Match(metadata("example.org", 443)))
+
+	or, err := NewOR("((DOMAIN,a.com),(DOMAIN,b.com))", "JP", nil)
+	require.NoError(t, err)
+	assert.True(t, or.Match(metadata("b.com", 0)))
+	assert.False(t, or.Match(metadata("c.com", 0)))
+
+	not, err := NewNOT("((DST-PORT,80))", "JP", nil)
+	require.NoError(t, err)
+	assert.True(t, not.Match(metadata("a.com", 443)))
+	assert.False(t, not.Match(metadata("a.com", 80)))
+}
+
+func TestLogic_Nested(t *testing.T) {
+	rule, err := ParseRule("AND", "((OR,((DOMAIN,a.com),(DOMAIN-SUFFIX,b.com))),(NOT,((DST-PORT,80))),(IP-CIDR,10.0.0.0/8,no-resolve))", "JP", nil, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, "(Domain(a.com) || DomainSuffix(b.com)) && !DstPort(80) && IPCIDR(10.0.0.0/8)", rule.Payload())
+	assert.False(t, rule.ShouldResolveIP())
+	assert.False(t, rule.ShouldFindProcess())
+
+	assert.True(t, rule.Match(&C.Metadata{Host: "x.b.com", DstPort: 443, DstIP: net.ParseIP("10.0.0.1")}))
+	assert.False(t, rule.Match(&C.Metadata{Host: "x.b.com", DstPort: 80, DstIP: net.ParseIP("10.0.0.1")}))
+	assert.False(t, rule.Match(&C.Metadata{Host: "c.com", DstPort: 443, DstIP: net.ParseIP("10.0.0.1")}))
+
+	rule, err = ParseRule("OR", "((GEOIP,CN),(PROCESS-NAME,curl))", "JP", nil, nil)
+	require.NoError(t, err)
+	assert.True(t, rule.ShouldResolveIP())
+	assert.True(t, rule.ShouldFindProcess())
+}
+
+func TestLogic_Error(t *testing.T) {
+	testCases := []struct {
+		tp       string
+		payload  string
+		expected string
+	}{
+		{"AND", "(DOMAIN,a.com)", "payload error: sub rule 'DOMAIN' should be wrapped in parentheses"},
+		{"AND", "DOMAIN,a.com", "payload error: 'DOMAIN,a.com' should be wrapped in parentheses"},
+		{"AND", "((DOMAIN,a.com),DST-PORT)", "payload error: sub rule 'DST-PORT' should be wrapped in parentheses"},
+		{"OR", "((DOMAIN))", "payload error: sub rule '(DOMAIN)' format invalid"},
+		{"OR", "((MATCH,x))", "payload error: MATCH can't be a sub rule"},
+		{"NOT", "((DOMAIN,a.com),(DOMAIN,b.com))", "NOT needs exactly one sub rule, got 2"},
+		{"AND", "((DST-PORT,abc))", "(DST-PORT,abc): payload error"},
+	}
+
+	for _, tc := range testCases {
+		_, err := ParseRule(tc.tp, tc.payload, "JP", nil, nil)
+		assert.EqualError(t, err, tc.expected, tc.payload)
+	}
+}
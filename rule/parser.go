@@ -4,9 +4,11 @@ import (
 	"fmt"
 
 	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/constant/provider"
 )
 
-func ParseRule(tp, payload, target string, params []string) (C.Rule, error) {
+// ParseRule parses a single rule, the payload of a RULE-SET rule is looked up in ruleProviders
+func ParseRule(tp, payload, target string, params []string, ruleProviders map[string]provider.RuleProvider) (C.Rule, error) {
 	var (
 		parseErr error
 		parsed   C.Rule
@@ -21,6 +23,9 @@ func ParseRule(tp, payload, target string, params []string) (C.Rule, error) {
 		parsed = NewDomainSuffix(payload, target)
 	case C.RuleConfigDomainKeyword:
 		parsed = NewDomainKeyword(payload, target)
+	case C.RuleConfigGeoIP:
+		noResolve := HasNoResolve(params)
+		parsed = NewGEOIP(payload, target, noResolve)
 	case C.RuleConfigIPCIDR, C.RuleConfigIPCIDR6:
 		noResolve := HasNoResolve(params)
 		parsed, parseErr = NewIPCIDR(payload, target, WithIPCIDRNoResolve(noResolve))
@@ -36,13 +41,30 @@ func ParseRule(tp, payload, target string, params []string) (C.Rule, error) {
 		parsed, parseErr = NewProcess(payload, target, true)
 	case C.RuleConfigProcessPath:
 		parsed, parseErr = NewProcess(payload, target, false)
+	case C.RuleConfigProcessUID, C.RuleConfigUID:
+		parsed, parseErr = NewUID(payload, target)
 	case C.RuleConfigIPSet:
 		noResolve := HasNoResolve(params)
 		parsed, parseErr = NewIPSet(payload, target, noResolve)
+	case C.RuleConfigAND:
+		parsed, parseErr = NewAND(payload, target, ruleProviders)
+	case C.RuleConfigOR:
+		parsed, parseErr = NewOR(payload, target, ruleProviders)
+	case C.RuleConfigNOT:
+		parsed, parseErr = NewNOT(payload, target, ruleProviders)
 	case C.RuleConfigMatch:
 		parsed = NewMatch(target)
-	case C.RuleConfigRuleSet, C.RuleConfigScript:
-		parseErr = fmt.Errorf("unsupported rule type %s", tp)
+	case C.RuleConfigRuleSet:
+		rp, ok := ruleProviders[payload]
+		if !ok {
+			parseErr = fmt.Errorf("unknown rule provider '%s'", payload)
+			break
+		}
+		noResolve := HasNoResolve(params)
+		parsed = NewRuleSet(rp, target, noResolve)
+	case C.RuleConfigScript:
+		noResolve := HasNoResolve(params)
+		parsed, parseErr = NewScript(payload, target, noResolve, ruleProviders)
 	default:
 		parseErr = fmt.Errorf("unsupported rule type %s", tp)
 	}
@@ -2,7 +2,6 @@ package rules
 
 import (
 	"errors"
-	"fmt"
 	"testing"
 
 	C "github.com/Dreamacro/clash/constant"
@@ -20,6 +19,8 @@ func TestParseRule(t *testing.T) {
 		params        []string
 		expectedRule  C.Rule
 		expectedError error
+		// errorContains is checked instead of expectedError if the error depends on the machine
+		errorContains string
 	}
 
 	policy := "DIRECT"
@@ -43,6 +44,18 @@ func TestParseRule(t *testing.T) {
 			target:       policy,
 			expectedRule: NewDomainKeyword("example.com", policy),
 		},
+		{
+			tp:           C.RuleConfigGeoIP,
+			payload:      "CN",
+			target:       policy,
+			expectedRule: NewGEOIP("CN", policy, false),
+		},
+		{
+			tp:      C.RuleConfigGeoIP,
+			payload: "CN",
+			target:  policy, params: []string{noResolve},
+			expectedRule: NewGEOIP("CN", policy, true),
+		},
 		{
 			tp:           C.RuleConfigIPCIDR,
 			payload:      "127.0.0.0/8",
@@ -109,19 +122,37 @@ func TestParseRule(t *testing.T) {
 			target:       policy,
 			expectedRule: lo.Must(NewProcess("/opt/example/example", policy, false)),
 		},
+		{
+			tp:           C.RuleConfigProcessUID,
+			payload:      "1000-1999",
+			target:       policy,
+			expectedRule: lo.Must(NewUID("1000-1999", policy)),
+		},
+		{
+			tp:           C.RuleConfigUID,
+			payload:      "0/1000",
+			target:       policy,
+			expectedRule: lo.Must(NewUID("0/1000", policy)),
+		},
+		{
+			tp:            C.RuleConfigUID,
+			payload:       "root",
+			target:        policy,
+			expectedError: errPayload,
+		},
 		{
 			tp:      C.RuleConfigIPSet,
 			payload: "example",
 			target:  policy,
-			// unit test runs on Linux machine and NewIPSet(...) won't be available
-			expectedError: errors.New("operation not permitted"),
+			// unit test runs on Linux machine without the ipset or CAP_NET_ADMIN
+			errorContains: "ipset example",
 		},
 		{
 			tp:      C.RuleConfigIPSet,
 			payload: "example",
 			target:  policy, params: []string{noResolve},
-			// unit test runs on Linux machine and NewIPSet(...) won't be available
-			expectedError: errors.New("operation not permitted"),
+			// unit test runs on Linux machine without the ipset or CAP_NET_ADMIN
+			errorContains: "ipset example",
 		},
 		{
 			tp:           C.RuleConfigMatch,
@@ -133,13 +164,19 @@ func TestParseRule(t *testing.T) {
 			tp:            C.RuleConfigRuleSet,
 			payload:       "example",
 			target:        policy,
-			expectedError: fmt.Errorf("unsupported rule type %s", C.RuleConfigRuleSet),
+			expectedError: errors.New("unknown rule provider 'example'"),
 		},
 		{
 			tp:            C.RuleConfigScript,
 			payload:       "example",
 			target:        policy,
-			expectedError: fmt.Errorf("unsupported rule type %s", C.RuleConfigScript),
+			expectedError: errors.New("offset 0: unknown variable 'example'"),
+		},
+		{
+			tp:           C.RuleConfigScript,
+			payload:      `network == "udp" && dst_port == 443`,
+			target:       policy,
+			expectedRule: lo.Must(NewScript(`network == "udp" && dst_port == 443`, policy, false, nil)),
 		},
 		{
 			tp:            "UNKNOWN",
@@ -156,10 +193,12 @@ func TestParseRule(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
-		_, err := ParseRule(string(tc.tp), tc.payload, tc.target, tc.params)
+		_, err := ParseRule(string(tc.tp), tc.payload, tc.target, tc.params, nil)
 		if tc.expectedError != nil {
 			require.Error(t, err)
 			assert.EqualError(t, err, tc.expectedError.Error())
+		} else if tc.errorContains != "" {
+			assert.ErrorContains(t, err, tc.errorContains)
 		} else {
 			require.NoError(t, err)
 		}
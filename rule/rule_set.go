@@ -0,0 +1,47 @@
+package rules
+
+import (
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/constant/provider"
+)
+
+// Implements C.Rule
+var _ C.Rule = (*RuleSet)(nil)
+
+type RuleSet struct {
+	provider    provider.RuleProvider
+	adapter     string
+	noResolveIP bool
+}
+
+func (rs *RuleSet) RuleType() C.RuleType {
+	return C.RuleSet
+}
+
+func (rs *RuleSet) Match(metadata *C.Metadata) bool {
+	return rs.provider.Match(metadata)
+}
+
+func (rs *RuleSet) Adapter() string {
+	return rs.adapter
+}
+
+func (rs *RuleSet) Payload() string {
+	return rs.provider.Name()
+}
+
+func (rs *RuleSet) ShouldResolveIP() bool {
+	return !rs.noResolveIP && rs.provider.ShouldResolveIP()
+}
+
+func (rs *RuleSet) ShouldFindProcess() bool {
+	return rs.provider.ShouldFindProcess()
+}
+
+func NewRuleSet(provider provider.RuleProvider, adapter string, noResolveIP bool) *RuleSet {
+	return &RuleSet{
+		provider:    provider,
+		adapter:     adapter,
+		noResolveIP: noResolveIP,
+	}
+}
@@ -0,0 +1,82 @@
+package rules
+
+import (
+	"strings"
+
+	"github.com/Dreamacro/clash/component/script"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/constant/provider"
+)
+
+// Implements C.AdapterRule
+var _ C.AdapterRule = (*Script)(nil)
+
+// Script matches when its program returns true or a non-empty adapter name,
+// a returned name takes precedence over the adapter of the rule
+type Script struct {
+	code        string
+	program     *script.Program
+	adapter     string
+	noResolveIP bool
+}
+
+func (s *Script) RuleType() C.RuleType {
+	return C.Script
+}
+
+func (s *Script) Match(metadata *C.Metadata) bool {
+	_, matched := s.MatchAdapter(metadata)
+	return matched
+}
+
+func (s *Script) MatchAdapter(metadata *C.Metadata) (string, bool) {
+	switch result := s.program.Run(metadata).(type) {
+	case bool:
+		return s.adapter, result
+	case string:
+		return result, result != ""
+	}
+	return "", false
+}
+
+func (s *Script) Adapter() string {
+	return s.adapter
+}
+
+// Adapters returns the adapter names the script may return as string literals
+func (s *Script) Adapters() []string {
+	adapters := []string{}
+	for _, literal := range s.program.Literals() {
+		if literal != "" {
+			adapters = append(adapters, literal)
+		}
+	}
+	return adapters
+}
+
+func (s *Script) Payload() string {
+	return s.code
+}
+
+func (s *Script) ShouldResolveIP() bool {
+	return !s.noResolveIP && s.program.ShouldResolveIP()
+}
+
+func (s *Script) ShouldFindProcess() bool {
+	return s.program.ShouldFindProcess()
+}
+
+func NewScript(code string, adapter string, noResolveIP bool, ruleProviders map[string]provider.RuleProvider) (*Script, error) {
+	code = strings.TrimSpace(code)
+	program, err := script.Compile(code, ruleProviders)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Script{
+		code:        code,
+		program:     program,
+		adapter:     adapter,
+		noResolveIP: noResolveIP,
+	}, nil
+}
@@ -0,0 +1,85 @@
+package rules
+
+import (
+	"strconv"
+	"strings"
+
+	C "github.com/Dreamacro/clash/constant"
+)
+
+type uidRange struct {
+	start uint32
+	end   uint32
+}
+
+// Implements C.Rule
+var _ C.Rule = (*UID)(nil)
+
+// UID matches the owner of the local socket, the payload is a list
+// of UIDs or ranges separated by '/', e.g. 0/1000-1999
+type UID struct {
+	adapter string
+	payload string
+	ranges  []uidRange
+}
+
+func (u *UID) RuleType() C.RuleType {
+	return C.ProcessUID
+}
+
+func (u *UID) Match(metadata *C.Metadata) bool {
+	if metadata.UID == nil {
+		return false
+	}
+
+	uid := *metadata.UID
+	for _, r := range u.ranges {
+		if r.start <= uid && uid <= r.end {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *UID) Adapter() string {
+	return u.adapter
+}
+
+func (u *UID) Payload() string {
+	return u.payload
+}
+
+func (u *UID) ShouldResolveIP() bool {
+	return false
+}
+
+func (u *UID) ShouldFindProcess() bool {
+	return true
+}
+
+func NewUID(payload string, adapter string) (*UID, error) {
+	ranges := []uidRange{}
+	for _, item := range strings.Split(payload, "/") {
+		start, end, isRange := strings.Cut(strings.TrimSpace(item), "-")
+		if !isRange {
+			end = start
+		}
+
+		s, err := strconv.ParseUint(strings.TrimSpace(start), 10, 32)
+		if err != nil {
+			return nil, errPayload
+		}
+		e, err := strconv.ParseUint(strings.TrimSpace(end), 10, 32)
+		if err != nil || e < s {
+			return nil, errPayload
+		}
+
+		ranges = append(ranges, uidRange{start: uint32(s), end: uint32(e)})
+	}
+
+	return &UID{
+		adapter: adapter,
+		payload: payload,
+		ranges:  ranges,
+	}, nil
+}
@@ -0,0 +1,34 @@
+package rules
+
+import (
+	"testing"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUID(t *testing.T) {
+	uid := func(u uint32) *C.Metadata {
+		return &C.Metadata{UID: &u}
+	}
+
+	rule, err := NewUID("0/1000-1999/ 65534", "DIRECT")
+	require.NoError(t, err)
+	assert.Equal(t, C.ProcessUID, rule.RuleType())
+	assert.True(t, rule.ShouldFindProcess())
+
+	assert.True(t, rule.Match(uid(0)))
+	assert.True(t, rule.Match(uid(1000)))
+	assert.True(t, rule.Match(uid(1999)))
+	assert.True(t, rule.Match(uid(65534)))
+	assert.False(t, rule.Match(uid(2000)))
+	assert.False(t, rule.Match(uid(1)))
+	assert.False(t, rule.Match(&C.Metadata{}))
+
+	for _, payload := range []string{"", "abc", "10-1", "1-", "-1", "4294967296"} {
+		_, err := NewUID(payload, "DIRECT")
+		assert.ErrorIs(t, err, errPayload, payload)
+	}
+}
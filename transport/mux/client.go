@@ -0,0 +1,249 @@
+package mux
+
+import (
+	"context"
+	"errors"
+	"net"
+	"runtime"
+	"sync"
+)
+
+const (
+	defaultMaxConnections = 4
+	defaultMinStreams     = 4
+)
+
+// Options is the pool of the multiplexed connections
+type Options struct {
+	Protocol Protocol
+	// MaxConnections is the maximum number of connections, MinStreams is the number
+	// of streams in a connection before another connection is opened
+	MaxConnections int
+	MinStreams     int
+	// MaxStreams is the maximum number of streams in a connection before another
+	// connection is opened, it conflicts with MaxConnections and MinStreams
+	MaxStreams int
+	Padding    bool
+}
+
+// DialFn dials a connection to the proxy server with DestinationHost and DestinationPort
+type DialFn = func(ctx context.Context) (net.Conn, error)
+
+// Client opens the streams over a pool of multiplexed connections, the
+// connections are closed once the Client and its streams are unreachable
+type Client struct {
+	*client
+}
+
+type client struct {
+	dial    DialFn
+	options Options
+
+	lock     sync.Mutex
+	sessions []session
+	// pending is the number of connections being dialed
+	pending int
+	closed  bool
+}
+
+// NewClient returns a Client that dials the connections with dial
+func NewClient(dial DialFn, options Options) (*Client, error) {
+	if options.MaxStreams > 0 && (options.MaxConnections > 0 || options.MinStreams > 0) {
+		return nil, errors.New("max-streams conflicts with max-connections and min-streams")
+	}
+	if options.MaxStreams == 0 {
+		if options.MaxConnections == 0 {
+			options.MaxConnections = defaultMaxConnections
+		}
+		if options.MinStreams == 0 {
+			options.MinStreams = defaultMinStreams
+		}
+	}
+
+	c := &Client{&client{dial: dial, options: options}}
+	runtime.SetFinalizer(c, closeClient)
+	return c, nil
+}
+
+func closeClient(c *Client) {
+	c.Close()
+}
+
+// DialContext opens a stream to the socks address destination
+func (c *Client) DialContext(ctx context.Context, destination []byte) (net.Conn, error) {
+	var (
+		stream net.Conn
+		err    error
+	)
+	// a connection may be closed by the server before it's noticed, retry once with a new one
+	for i := 0; i < 2; i++ {
+		var s session
+		s, err = c.offer(ctx)
+		if err != nil {
+			return nil, err
+		}
+		stream, err = s.Open()
+		if err == nil {
+			break
+		}
+		s.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return c.openStream(stream, destination, nil)
+}
+
+// StreamConn opens a stream to the socks address destination over a new multiplexed
+// connection on conn, which is out of the pool and closed with the stream
+func (c *Client) StreamConn(conn net.Conn, destination []byte) (net.Conn, error) {
+	s, err := newClientSession(conn, c.options)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := s.Open()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return c.openStream(stream, destination, s)
+}
+
+// openStream sends the request of the stream, owner is closed with the stream if it's set
+func (c *Client) openStream(stream net.Conn, destination []byte, owner session) (net.Conn, error) {
+	if _, err := stream.Write(encodeStreamRequest(destination)); err != nil {
+		stream.Close()
+		if owner != nil {
+			owner.Close()
+		}
+		return nil, err
+	}
+	return &streamConn{Conn: stream, client: c, owner: owner}, nil
+}
+
+// offer returns a connection to open the stream, a new one is dialed if
+// the connections are busy and the pool isn't full
+func (c *client) offer(ctx context.Context) (session, error) {
+	c.lock.Lock()
+
+	sessions := c.sessions[:0]
+	for _, s := range c.sessions {
+		if !s.IsClosed() {
+			sessions = append(sessions, s)
+		}
+	}
+	clear(c.sessions[len(sessions):])
+	c.sessions = sessions
+
+	if len(sessions) == 0 {
+		return c.offerNew(ctx)
+	}
+
+	idlest := sessions[0]
+	for _, s := range sessions[1:] {
+		if s.NumStreams() < idlest.NumStreams() {
+			idlest = s
+		}
+	}
+
+	if c.options.MaxStreams > 0 {
+		if idlest.NumStreams() < c.options.MaxStreams {
+			c.lock.Unlock()
+			return idlest, nil
+		}
+	} else if idlest.NumStreams() < c.options.MinStreams || len(sessions)+c.pending >= c.options.MaxConnections {
+		c.lock.Unlock()
+		return idlest, nil
+	}
+	return c.offerNew(ctx)
+}
+
+// offerNew dials a new connection, it's called with c.lock held and releases it
+// while dialing, so that the other streams aren't blocked by the handshake
+func (c *client) offerNew(ctx context.Context) (session, error) {
+	c.pending++
+	c.lock.Unlock()
+
+	s, err := c.dialSession(ctx)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.pending--
+	if err != nil {
+		return nil, err
+	}
+	if c.closed {
+		s.Close()
+		return nil, net.ErrClosed
+	}
+	c.sessions = append(c.sessions, s)
+	return s, nil
+}
+
+func (c *client) dialSession(ctx context.Context) (session, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := newClientSession(conn, c.options)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// newClientSession sends the request of a multiplexed connection on conn and
+// starts the session over it
+func newClientSession(conn net.Conn, options Options) (session, error) {
+	if _, err := conn.Write(encodeRequest(options.Protocol, options.Padding)); err != nil {
+		return nil, err
+	}
+	if options.Padding {
+		conn = newPaddingConn(conn)
+	}
+	return newSession(conn, options.Protocol)
+}
+
+// Close closes the connections of the pool
+func (c *client) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, s := range c.sessions {
+		s.Close()
+	}
+	c.sessions = nil
+	c.closed = true
+	return nil
+}
+
+// streamConn reads the status of the stream before the payload
+type streamConn struct {
+	net.Conn
+	responseRead bool
+	// client keeps the connections open while the stream is in use
+	client *Client
+	// owner is the connection out of the pool that only carries this stream
+	owner session
+}
+
+func (c *streamConn) Read(b []byte) (int, error) {
+	if !c.responseRead {
+		if err := readStreamResponse(c.Conn); err != nil {
+			return 0, err
+		}
+		c.responseRead = true
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *streamConn) Close() error {
+	err := c.Conn.Close()
+	if c.owner != nil {
+		c.owner.Close()
+	}
+	return err
+}
@@ -0,0 +1,311 @@
+package mux
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/transport/socks5"
+
+	"github.com/hashicorp/yamux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xtaci/smux"
+	"go.uber.org/atomic"
+	"golang.org/x/net/http2"
+)
+
+// handleStream echoes the payload of a stream, the streams to blocked.com are refused
+func handleStream(rw io.ReadWriter) {
+	var flags [2]byte
+	if _, err := io.ReadFull(rw, flags[:]); err != nil {
+		return
+	}
+	addr, err := socks5.ReadAddr(rw, make([]byte, socks5.MaxAddrLen))
+	if err != nil {
+		return
+	}
+
+	if addr.String() == "blocked.com:80" {
+		message := "blocked"
+		response := binary.AppendUvarint([]byte{statusError}, uint64(len(message)))
+		rw.Write(append(response, message...))
+		return
+	}
+
+	if _, err := rw.Write([]byte{statusSuccess}); err != nil {
+		return
+	}
+	io.Copy(rw, rw)
+}
+
+type flushWriter struct {
+	io.Reader
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(b []byte) (int, error) {
+	n, err := f.w.Write(b)
+	f.w.(http.Flusher).Flush()
+	return n, err
+}
+
+// serveMux serves a multiplexed connection like a sing-mux server
+func serveMux(conn net.Conn) {
+	defer conn.Close()
+
+	var request [2]byte
+	if _, err := io.ReadFull(conn, request[:]); err != nil {
+		return
+	}
+	if request[0] == version1 {
+		var padding [3]byte
+		if _, err := io.ReadFull(conn, padding[:]); err != nil || padding[0] != 1 {
+			return
+		}
+		if _, err := io.CopyN(io.Discard, conn, int64(binary.BigEndian.Uint16(padding[1:]))); err != nil {
+			return
+		}
+		conn = newPaddingConn(conn)
+	}
+
+	switch Protocol(request[1]) {
+	case ProtocolSmux:
+		s, err := smux.Server(conn, smux.DefaultConfig())
+		if err != nil {
+			return
+		}
+		for {
+			stream, err := s.AcceptStream()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer stream.Close()
+				handleStream(stream)
+			}()
+		}
+	case ProtocolYamux:
+		s, err := yamux.Server(conn, nil)
+		if err != nil {
+			return
+		}
+		for {
+			stream, err := s.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer stream.Close()
+				handleStream(stream)
+			}()
+		}
+	case ProtocolH2Mux:
+		(&http2.Server{}).ServeConn(conn, &http2.ServeConnOpts{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodConnect {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+				w.(http.Flusher).Flush()
+				handleStream(flushWriter{r.Body, w})
+			}),
+		})
+	}
+}
+
+// startServer returns a DialFn to a mux server and the counter of the dials
+func startServer(t *testing.T) (DialFn, *atomic.Int32) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveMux(conn)
+		}
+	}()
+
+	dials := atomic.NewInt32(0)
+	return func(ctx context.Context) (net.Conn, error) {
+		dials.Inc()
+		return (&net.Dialer{}).DialContext(ctx, "tcp", l.Addr().String())
+	}, dials
+}
+
+func echo(t *testing.T, client *Client, payload string) net.Conn {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := client.DialContext(ctx, socks5.ParseAddr("example.com:80"))
+	require.NoError(t, err)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte(payload))
+	require.NoError(t, err)
+	buf := make([]byte, len(payload))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, payload, string(buf))
+	return conn
+}
+
+func TestClient_Protocols(t *testing.T) {
+	for _, protocol := range []Protocol{ProtocolSmux, ProtocolYamux, ProtocolH2Mux} {
+		for _, padding := range []bool{false, true} {
+			name := protocol.String()
+			if padding {
+				name += "-padding"
+			}
+			t.Run(name, func(t *testing.T) {
+				dial, dials := startServer(t)
+				client, err := NewClient(dial, Options{Protocol: protocol, Padding: padding})
+				require.NoError(t, err)
+				defer client.Close()
+
+				for i := 0; i < 20; i++ {
+					defer echo(t, client, "hello").Close()
+				}
+				assert.Equal(t, int32(defaultMaxConnections), dials.Load())
+			})
+		}
+	}
+}
+
+func TestClient_RemoteError(t *testing.T) {
+	dial, _ := startServer(t)
+	client, err := NewClient(dial, Options{})
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn, err := client.DialContext(context.Background(), socks5.ParseAddr("blocked.com:80"))
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Read(make([]byte, 1))
+	assert.EqualError(t, err, "remote error: blocked")
+}
+
+func TestClient_Pool(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		options Options
+		streams int
+		dials   int32
+	}{
+		{"default", Options{}, defaultMinStreams, 1},
+		{"min-streams", Options{MaxConnections: 2, MinStreams: 1}, 3, 2},
+		{"max-streams", Options{MaxStreams: 1}, 3, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dial, dials := startServer(t)
+			client, err := NewClient(dial, tc.options)
+			require.NoError(t, err)
+			defer client.Close()
+
+			for i := 0; i < tc.streams; i++ {
+				defer echo(t, client, "hello").Close()
+			}
+			assert.Equal(t, tc.dials, dials.Load())
+		})
+	}
+}
+
+func TestClient_ReplaceClosed(t *testing.T) {
+	dial, dials := startServer(t)
+	client, err := NewClient(dial, Options{MaxConnections: 1})
+	require.NoError(t, err)
+	defer client.Close()
+
+	echo(t, client, "hello").Close()
+	for _, s := range client.sessions {
+		s.Close()
+	}
+	echo(t, client, "world").Close()
+	assert.Equal(t, int32(2), dials.Load())
+}
+
+func TestClient_DialOutsideLock(t *testing.T) {
+	dial, dials := startServer(t)
+	release := make(chan struct{})
+	blockingDial := func(ctx context.Context) (net.Conn, error) {
+		if dials.Load() > 0 {
+			<-release
+		}
+		return dial(ctx)
+	}
+	client, err := NewClient(blockingDial, Options{MaxConnections: 2, MinStreams: 1})
+	require.NoError(t, err)
+	defer client.Close()
+
+	defer echo(t, client, "hello").Close()
+
+	// the second connection is being dialed while the streams go to the first one
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := client.DialContext(context.Background(), socks5.ParseAddr("example.com:80"))
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	assert.Eventually(t, func() bool {
+		client.lock.Lock()
+		defer client.lock.Unlock()
+		return client.pending == 1
+	}, time.Second, 10*time.Millisecond)
+
+	defer echo(t, client, "world").Close()
+	assert.Equal(t, int32(1), dials.Load())
+
+	close(release)
+	<-done
+	assert.Equal(t, int32(2), dials.Load())
+}
+
+func TestClient_StreamConn(t *testing.T) {
+	dial, dials := startServer(t)
+	client, err := NewClient(dial, Options{Protocol: ProtocolYamux, Padding: true})
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn, err := dial(context.Background())
+	require.NoError(t, err)
+	stream, err := client.StreamConn(conn, socks5.ParseAddr("example.com:80"))
+	require.NoError(t, err)
+	stream.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = stream.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(stream, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+
+	// the connection is out of the pool and closed with the stream
+	assert.Empty(t, client.sessions)
+	require.NoError(t, stream.Close())
+	_, err = conn.Read(buf)
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), dials.Load())
+}
+
+func TestNewClient(t *testing.T) {
+	_, err := NewClient(nil, Options{MaxStreams: 8, MinStreams: 4})
+	assert.Error(t, err)
+
+	protocol, err := ParseProtocol("h2mux")
+	require.NoError(t, err)
+	assert.Equal(t, ProtocolH2Mux, protocol)
+
+	_, err = ParseProtocol("mplex")
+	assert.Error(t, err)
+}
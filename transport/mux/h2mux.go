@@ -0,0 +1,151 @@
+package mux
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// h2muxSession opens every stream as a CONNECT request over a HTTP/2 connection
+type h2muxSession struct {
+	conn       net.Conn
+	clientConn *http2.ClientConn
+}
+
+func newH2MuxSession(c net.Conn) (session, error) {
+	transport := &http2.Transport{
+		DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return c, nil
+		},
+		DisableCompression: true,
+	}
+	clientConn, err := transport.NewClientConn(c)
+	if err != nil {
+		return nil, err
+	}
+	return &h2muxSession{conn: c, clientConn: clientConn}, nil
+}
+
+func (s *h2muxSession) Open() (net.Conn, error) {
+	reader, writer := io.Pipe()
+	request := &http.Request{
+		Method: http.MethodConnect,
+		Body:   reader,
+		URL:    &url.URL{Scheme: "https", Host: "localhost"},
+		Header: http.Header{},
+	}
+
+	stream := &h2muxStream{
+		writer:     writer,
+		ready:      make(chan struct{}),
+		localAddr:  s.conn.LocalAddr(),
+		remoteAddr: s.conn.RemoteAddr(),
+	}
+	go stream.roundTrip(s.clientConn, request)
+	return stream, nil
+}
+
+func (s *h2muxSession) NumStreams() int {
+	return s.clientConn.State().StreamsActive
+}
+
+func (s *h2muxSession) IsClosed() bool {
+	state := s.clientConn.State()
+	return state.Closed || state.Closing
+}
+
+func (s *h2muxSession) Close() error {
+	return s.clientConn.Close()
+}
+
+// h2muxStream writes to the request body and reads from the response body,
+// which is available after the server responds
+type h2muxStream struct {
+	writer *io.PipeWriter
+	ready  chan struct{}
+	body   io.ReadCloser
+	err    error
+
+	localAddr  net.Addr
+	remoteAddr net.Addr
+
+	closeOnce sync.Once
+	deadline  *time.Timer
+}
+
+func (s *h2muxStream) roundTrip(clientConn *http2.ClientConn, request *http.Request) {
+	defer close(s.ready)
+
+	response, err := clientConn.RoundTrip(request)
+	if err != nil {
+		s.err = err
+		s.writer.CloseWithError(err)
+		return
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		s.err = fmt.Errorf("unexpected status: %s", response.Status)
+		s.writer.CloseWithError(s.err)
+		return
+	}
+	s.body = response.Body
+}
+
+func (s *h2muxStream) Read(b []byte) (int, error) {
+	<-s.ready
+	if s.err != nil {
+		return 0, s.err
+	}
+	return s.body.Read(b)
+}
+
+func (s *h2muxStream) Write(b []byte) (int, error) {
+	return s.writer.Write(b)
+}
+
+func (s *h2muxStream) Close() error {
+	s.closeOnce.Do(func() {
+		s.writer.Close()
+		go func() {
+			<-s.ready
+			if s.body != nil {
+				s.body.Close()
+			}
+		}()
+	})
+	return nil
+}
+
+func (s *h2muxStream) LocalAddr() net.Addr                { return s.localAddr }
+func (s *h2muxStream) RemoteAddr() net.Addr               { return s.remoteAddr }
+func (s *h2muxStream) SetReadDeadline(t time.Time) error  { return s.SetDeadline(t) }
+func (s *h2muxStream) SetWriteDeadline(t time.Time) error { return s.SetDeadline(t) }
+
+// SetDeadline closes the stream at t, like the gun transport
+func (s *h2muxStream) SetDeadline(t time.Time) error {
+	if t.IsZero() {
+		if s.deadline != nil {
+			s.deadline.Stop()
+			s.deadline = nil
+		}
+		return nil
+	}
+
+	d := time.Until(t)
+	if s.deadline != nil {
+		s.deadline.Reset(d)
+		return nil
+	}
+	s.deadline = time.AfterFunc(d, func() {
+		s.Close()
+	})
+	return nil
+}
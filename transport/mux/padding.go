@@ -0,0 +1,93 @@
+package mux
+
+import (
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+
+	"github.com/Dreamacro/clash/common/pool"
+)
+
+// firstPaddings is the number of the first reads and writes that are padded
+const firstPaddings = 16
+
+// paddingConn pads the first writes with random bytes and strips the padding of the
+// first reads, which hides the sizes of the handshakes inside the connection
+type paddingConn struct {
+	net.Conn
+	writePadding int
+	readPadding  int
+	// readRemaining is the data left of the current padded frame, followed by readPaddingLen bytes of padding
+	readRemaining  int
+	readPaddingLen int
+}
+
+func newPaddingConn(c net.Conn) net.Conn {
+	return &paddingConn{Conn: c}
+}
+
+func (c *paddingConn) Read(b []byte) (int, error) {
+	if c.readRemaining == 0 && c.readPadding < firstPaddings {
+		var header [4]byte
+		if _, err := io.ReadFull(c.Conn, header[:]); err != nil {
+			return 0, err
+		}
+		c.readPadding++
+		c.readRemaining = int(binary.BigEndian.Uint16(header[:2]))
+		c.readPaddingLen = int(binary.BigEndian.Uint16(header[2:]))
+		if c.readRemaining == 0 {
+			return 0, c.discardPadding()
+		}
+	}
+
+	if c.readRemaining == 0 {
+		return c.Conn.Read(b)
+	}
+
+	n, err := c.Conn.Read(b[:min(len(b), c.readRemaining)])
+	c.readRemaining -= n
+	if err == nil && c.readRemaining == 0 {
+		err = c.discardPadding()
+	}
+	return n, err
+}
+
+func (c *paddingConn) discardPadding() error {
+	_, err := io.CopyN(io.Discard, c.Conn, int64(c.readPaddingLen))
+	c.readPaddingLen = 0
+	return err
+}
+
+func (c *paddingConn) Write(b []byte) (n int, err error) {
+	for n < len(b) && c.writePadding < firstPaddings {
+		nw, err := c.writePadded(b[n:min(len(b), n+0xFFFF)])
+		n += nw
+		if err != nil {
+			return n, err
+		}
+	}
+	if n == len(b) {
+		return n, nil
+	}
+
+	nw, err := c.Conn.Write(b[n:])
+	return n + nw, err
+}
+
+func (c *paddingConn) writePadded(b []byte) (int, error) {
+	c.writePadding++
+	paddingLen := 256 + rand.Intn(512)
+
+	buf := pool.GetBytesBuffer()
+	defer pool.PutBytesBuffer(buf)
+	buf.PutUint16be(uint16(len(b)))
+	buf.PutUint16be(uint16(paddingLen))
+	buf.PutSlice(b)
+	buf.PutSlice(make([]byte, paddingLen))
+
+	if _, err := c.Conn.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,117 @@
+package mux
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+)
+
+// the framing follows sing-mux, https://github.com/SagerNet/sing-mux
+
+// Protocol is the multiplexing protocol of the sessions
+type Protocol byte
+
+const (
+	ProtocolSmux Protocol = iota
+	ProtocolYamux
+	ProtocolH2Mux
+)
+
+var protocolNames = map[Protocol]string{
+	ProtocolSmux:  "smux",
+	ProtocolYamux: "yamux",
+	ProtocolH2Mux: "h2mux",
+}
+
+func (p Protocol) String() string {
+	if name, ok := protocolNames[p]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ParseProtocol returns the Protocol of name, an empty name is smux
+func ParseProtocol(name string) (Protocol, error) {
+	if name == "" {
+		return ProtocolSmux, nil
+	}
+	for protocol, protocolName := range protocolNames {
+		if strings.EqualFold(name, protocolName) {
+			return protocol, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported multiplex protocol: %s", name)
+}
+
+const (
+	// DestinationHost and DestinationPort is the address that the proxy server
+	// treats as a multiplexed connection instead of a destination to connect
+	DestinationHost = "sp.mux.sing-box.arpa"
+	DestinationPort = 444
+
+	version0 = 0
+	// version1 adds the padding of the connection
+	version1 = 1
+
+	statusSuccess = 0
+	statusError   = 1
+)
+
+// encodeRequest returns the request that starts a multiplexed connection
+func encodeRequest(protocol Protocol, padding bool) []byte {
+	if !padding {
+		return []byte{version0, byte(protocol)}
+	}
+
+	paddingLen := 256 + rand.Intn(512)
+	buf := make([]byte, 0, 5+paddingLen)
+	buf = append(buf, version1, byte(protocol), 1)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(paddingLen))
+	return append(buf, make([]byte, paddingLen)...)
+}
+
+// encodeStreamRequest returns the request of a TCP stream to the socks address destination
+func encodeStreamRequest(destination []byte) []byte {
+	buf := make([]byte, 0, 2+len(destination))
+	buf = binary.BigEndian.AppendUint16(buf, 0)
+	return append(buf, destination...)
+}
+
+// readStreamResponse reads the status of a stream, the error from the server carries a message
+func readStreamResponse(r io.Reader) error {
+	var status [1]byte
+	if _, err := io.ReadFull(r, status[:]); err != nil {
+		return err
+	}
+
+	switch status[0] {
+	case statusSuccess:
+		return nil
+	case statusError:
+		length, err := binary.ReadUvarint(byteReader{r})
+		if err != nil {
+			return err
+		}
+		message := make([]byte, length)
+		if _, err := io.ReadFull(r, message); err != nil {
+			return err
+		}
+		return errors.New("remote error: " + string(message))
+	default:
+		return fmt.Errorf("unknown stream status: %d", status[0])
+	}
+}
+
+// byteReader reads a byte at a time so that nothing is read beyond a varint
+type byteReader struct {
+	io.Reader
+}
+
+func (r byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(r.Reader, b[:])
+	return b[0], err
+}
@@ -0,0 +1,56 @@
+package mux
+
+import (
+	"io"
+	"net"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/hashicorp/yamux"
+	"github.com/xtaci/smux"
+)
+
+// session is a multiplexed connection that opens streams
+type session interface {
+	Open() (net.Conn, error)
+	NumStreams() int
+	IsClosed() bool
+	Close() error
+}
+
+func newSession(c net.Conn, protocol Protocol) (session, error) {
+	switch protocol {
+	case ProtocolYamux:
+		config := yamux.DefaultConfig()
+		config.LogOutput = io.Discard
+		config.StreamOpenTimeout = C.DefaultTCPTimeout
+		config.StreamCloseTimeout = C.DefaultTCPTimeout
+		s, err := yamux.Client(c, config)
+		if err != nil {
+			return nil, err
+		}
+		return &yamuxSession{s}, nil
+	case ProtocolH2Mux:
+		return newH2MuxSession(c)
+	default:
+		config := smux.DefaultConfig()
+		config.KeepAliveDisabled = true
+		s, err := smux.Client(c, config)
+		if err != nil {
+			return nil, err
+		}
+		return &smuxSession{s}, nil
+	}
+}
+
+type smuxSession struct {
+	*smux.Session
+}
+
+func (s *smuxSession) Open() (net.Conn, error) {
+	return s.OpenStream()
+}
+
+type yamuxSession struct {
+	*yamux.Session
+}
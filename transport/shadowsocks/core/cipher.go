@@ -2,12 +2,15 @@ package core
 
 import (
 	"crypto/md5"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"net"
 	"sort"
 	"strings"
 
 	"github.com/Dreamacro/clash/transport/shadowsocks/shadowaead"
+	"github.com/Dreamacro/clash/transport/shadowsocks/shadowaead2022"
 	"github.com/Dreamacro/clash/transport/shadowsocks/shadowstream"
 )
 
@@ -47,6 +50,20 @@ var aeadList = map[string]struct {
 	aeadXChacha20Poly1305: {32, shadowaead.XChacha20Poly1305},
 }
 
+// List of Shadowsocks 2022 ciphers: key size in bytes and constructor
+var aead2022List = map[string]struct {
+	KeySize int
+	New     func(psks [][]byte) (*shadowaead2022.Cipher, error)
+}{
+	"2022-BLAKE3-AES-128-GCM": {16, func(psks [][]byte) (*shadowaead2022.Cipher, error) {
+		return shadowaead2022.AESGCM(16, psks)
+	}},
+	"2022-BLAKE3-AES-256-GCM": {32, func(psks [][]byte) (*shadowaead2022.Cipher, error) {
+		return shadowaead2022.AESGCM(32, psks)
+	}},
+	"2022-BLAKE3-CHACHA20-POLY1305": {32, shadowaead2022.Chacha20Poly1305},
+}
+
 // List of stream ciphers: key size in bytes and constructor
 var streamList = map[string]struct {
 	KeySize int
@@ -69,6 +86,9 @@ func ListCipher() []string {
 	for k := range aeadList {
 		l = append(l, k)
 	}
+	for k := range aead2022List {
+		l = append(l, k)
+	}
 	for k := range streamList {
 		l = append(l, k)
 	}
@@ -77,6 +97,8 @@ func ListCipher() []string {
 }
 
 // PickCipher returns a Cipher of the given name. Derive key from password if given key is empty.
+// The password of a Shadowsocks 2022 cipher is the base64 encoded PSKs separated by ':',
+// the identity PSKs of the relays followed by the user PSK.
 func PickCipher(name string, key []byte, password string) (Cipher, error) {
 	name = strings.ToUpper(name)
 
@@ -106,6 +128,26 @@ func PickCipher(name string, key []byte, password string) (Cipher, error) {
 		return &AeadCipher{Cipher: aead, Key: key}, err
 	}
 
+	if choice, ok := aead2022List[name]; ok {
+		psks := [][]byte{key}
+		if len(key) == 0 {
+			var err error
+			if psks, err = decodePSKs(password); err != nil {
+				return nil, err
+			}
+		}
+		for _, psk := range psks {
+			if len(psk) != choice.KeySize {
+				return nil, shadowaead2022.KeySizeError(choice.KeySize)
+			}
+		}
+		ciph, err := choice.New(psks)
+		if err != nil {
+			return nil, err
+		}
+		return &Aead2022Cipher{Cipher: ciph}, nil
+	}
+
 	if choice, ok := streamList[name]; ok {
 		if len(key) == 0 {
 			key = Kdf(password, choice.KeySize)
@@ -131,6 +173,18 @@ func (aead *AeadCipher) PacketConn(c net.PacketConn) net.PacketConn {
 	return shadowaead.NewPacketConn(c, aead)
 }
 
+type Aead2022Cipher struct {
+	*shadowaead2022.Cipher
+}
+
+func (aead *Aead2022Cipher) StreamConn(c net.Conn) net.Conn {
+	return shadowaead2022.NewConn(c, aead.Cipher)
+}
+
+func (aead *Aead2022Cipher) PacketConn(c net.PacketConn) net.PacketConn {
+	return shadowaead2022.NewPacketConn(c, aead.Cipher)
+}
+
 type StreamCipher struct {
 	shadowstream.Cipher
 
@@ -149,6 +203,19 @@ type dummy struct{}
 func (dummy) StreamConn(c net.Conn) net.Conn             { return c }
 func (dummy) PacketConn(c net.PacketConn) net.PacketConn { return c }
 
+// decodePSKs decodes the base64 encoded PSKs separated by ':'
+func decodePSKs(password string) ([][]byte, error) {
+	var psks [][]byte
+	for _, encoded := range strings.Split(password, ":") {
+		psk, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("decode psk: %w", err)
+		}
+		psks = append(psks, psk)
+	}
+	return psks, nil
+}
+
 // key-derivation function from original Shadowsocks
 func Kdf(password string, keyLen int) []byte {
 	var b, prev []byte
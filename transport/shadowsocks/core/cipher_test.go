@@ -0,0 +1,35 @@
+package core
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/Dreamacro/clash/transport/shadowsocks/shadowaead2022"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPickCipher_2022(t *testing.T) {
+	psk16 := base64.StdEncoding.EncodeToString(make([]byte, 16))
+	psk32 := base64.StdEncoding.EncodeToString(make([]byte, 32))
+
+	ciph, err := PickCipher("2022-blake3-aes-128-gcm", nil, psk16)
+	require.NoError(t, err)
+	assert.Equal(t, 16, ciph.(*Aead2022Cipher).KeySize())
+
+	_, err = PickCipher("2022-blake3-aes-256-gcm", nil, psk32+":"+psk32)
+	assert.NoError(t, err)
+
+	_, err = PickCipher("2022-blake3-chacha20-poly1305", nil, psk32)
+	assert.NoError(t, err)
+
+	_, err = PickCipher("2022-blake3-aes-256-gcm", nil, psk16)
+	assert.Equal(t, shadowaead2022.KeySizeError(32), err)
+
+	_, err = PickCipher("2022-blake3-aes-128-gcm", nil, "not base64")
+	assert.Error(t, err)
+
+	_, err = PickCipher("2022-blake3-chacha20-poly1305", nil, psk32+":"+psk32)
+	assert.ErrorIs(t, err, shadowaead2022.ErrEIHNotSupported)
+}
@@ -0,0 +1,181 @@
+package shadowaead2022
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"lukechampine.com/blake3"
+)
+
+// https://github.com/Shadowsocks-NET/shadowsocks-specs/blob/main/2022-1-shadowsocks-2022-edition.md
+const (
+	headerTypeClient = 0
+	headerTypeServer = 1
+
+	// maxPaddingLength is the maximum length of the padding of a request
+	maxPaddingLength = 900
+	// maxTimeDiff is how far the timestamp of a message may be from the local time
+	maxTimeDiff = 30 * time.Second
+
+	sessionSubkeyContext  = "shadowsocks 2022 session subkey"
+	identitySubkeyContext = "shadowsocks 2022 identity subkey"
+)
+
+var (
+	ErrBadTimestamp  = errors.New("bad timestamp")
+	ErrBadHeaderType = errors.New("bad header type")
+	ErrBadSalt       = errors.New("bad request salt")
+	// ErrReplayedPacket occurs when a packet ID of the server session is received again
+	ErrReplayedPacket = errors.New("replayed packet")
+	// ErrEIHNotSupported occurs when more than one PSK is given to a cipher without identity headers
+	ErrEIHNotSupported = errors.New("identity headers are only supported by AES ciphers")
+)
+
+type KeySizeError int
+
+func (e KeySizeError) Error() string {
+	return "key size error: need " + strconv.Itoa(int(e)) + " bytes"
+}
+
+// Cipher is a Shadowsocks 2022 method with its PSKs
+type Cipher struct {
+	keySize int
+	// psks are the identity PSKs of the relays followed by the user PSK
+	psks [][]byte
+	// pskHashes[i] is the first 16 bytes of the BLAKE3 hash of psks[i+1]
+	pskHashes [][]byte
+	makeAEAD  func(key []byte) (cipher.AEAD, error)
+	// udpBlock encrypts the separate header of the UDP packets sent, and udpUserBlock
+	// decrypts the ones received, they are nil for chacha20-poly1305
+	udpBlock     cipher.Block
+	udpUserBlock cipher.Block
+	// udpAEAD seals the UDP packets of chacha20-poly1305, it's nil for AES ciphers
+	udpAEAD cipher.AEAD
+}
+
+func (c *Cipher) KeySize() int  { return c.keySize }
+func (c *Cipher) SaltSize() int { return c.keySize }
+
+func (c *Cipher) userPSK() []byte {
+	return c.psks[len(c.psks)-1]
+}
+
+// sessionAEAD returns the AEAD of the subkey derived from the user PSK and salt,
+// the salt of UDP is the session ID
+func (c *Cipher) sessionAEAD(salt []byte) (cipher.AEAD, error) {
+	material := make([]byte, 0, len(c.userPSK())+len(salt))
+	material = append(append(material, c.userPSK()...), salt...)
+
+	subkey := make([]byte, c.keySize)
+	blake3.DeriveKey(subkey, sessionSubkeyContext, material)
+	return c.makeAEAD(subkey)
+}
+
+// identityHeaders returns the EIH of a TCP request with salt, which is empty if there are no relays
+func (c *Cipher) identityHeaders(salt []byte) ([]byte, error) {
+	eih := make([]byte, 0, aes.BlockSize*len(c.pskHashes))
+	for i, hash := range c.pskHashes {
+		material := make([]byte, 0, c.keySize*2)
+		material = append(append(material, c.psks[i]...), salt...)
+
+		subkey := make([]byte, c.keySize)
+		blake3.DeriveKey(subkey, identitySubkeyContext, material)
+		block, err := aes.NewCipher(subkey)
+		if err != nil {
+			return nil, err
+		}
+
+		header := make([]byte, aes.BlockSize)
+		block.Encrypt(header, hash)
+		eih = append(eih, header...)
+	}
+	return eih, nil
+}
+
+func newCipher(keySize int, psks [][]byte, makeAEAD func(key []byte) (cipher.AEAD, error)) (*Cipher, error) {
+	if len(psks) == 0 {
+		return nil, KeySizeError(keySize)
+	}
+	for _, psk := range psks {
+		if len(psk) != keySize {
+			return nil, KeySizeError(keySize)
+		}
+	}
+
+	c := &Cipher{keySize: keySize, psks: psks, makeAEAD: makeAEAD}
+	for _, psk := range psks[1:] {
+		hash := blake3.Sum256(psk)
+		c.pskHashes = append(c.pskHashes, hash[:aes.BlockSize])
+	}
+	return c, nil
+}
+
+func aesGCM(key []byte) (cipher.AEAD, error) {
+	blk, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(blk)
+}
+
+// AESGCM creates a new Cipher of 2022-blake3-aes-128-gcm or 2022-blake3-aes-256-gcm
+// by the size of the PSKs, which must be 16 or 32. psks are the identity PSKs of
+// the relays followed by the user PSK.
+func AESGCM(keySize int, psks [][]byte) (*Cipher, error) {
+	switch keySize {
+	case 16, 32:
+	default:
+		return nil, aes.KeySizeError(keySize)
+	}
+
+	c, err := newCipher(keySize, psks, aesGCM)
+	if err != nil {
+		return nil, err
+	}
+	if c.udpBlock, err = aes.NewCipher(psks[0]); err != nil {
+		return nil, err
+	}
+	if c.udpUserBlock, err = aes.NewCipher(c.userPSK()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Chacha20Poly1305 creates a new Cipher of 2022-blake3-chacha20-poly1305 with a
+// 32 bytes PSK, the UDP packets are sealed by XChaCha20-Poly1305 with the PSK.
+func Chacha20Poly1305(psks [][]byte) (*Cipher, error) {
+	if len(psks) > 1 {
+		return nil, ErrEIHNotSupported
+	}
+
+	c, err := newCipher(chacha20poly1305.KeySize, psks, chacha20poly1305.New)
+	if err != nil {
+		return nil, err
+	}
+	if c.udpAEAD, err = chacha20poly1305.NewX(c.userPSK()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func checkTimestamp(timestamp uint64, now time.Time) error {
+	diff := now.Sub(time.Unix(int64(timestamp), 0))
+	if diff > maxTimeDiff || diff < -maxTimeDiff {
+		return ErrBadTimestamp
+	}
+	return nil
+}
+
+// increment little-endian encoded unsigned integer b. Wrap around on overflow.
+func increment(b []byte) {
+	for i := range b {
+		b[i]++
+		if b[i] != 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package shadowaead2022
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/common/pool"
+
+	"go.uber.org/atomic"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	maxPacketSize = 64 * 1024
+	// separateHeaderSize is the size of the session ID and the packet ID
+	separateHeaderSize = 16
+)
+
+// ErrShortPacket means that the packet is too short for a valid encrypted packet.
+var ErrShortPacket = errors.New("short packet")
+
+// PacketConn is the client side of a Shadowsocks 2022 UDP session, the packets
+// written and read start with the socks address
+type PacketConn struct {
+	net.PacketConn
+	*Cipher
+	sessionID uint64
+	packetID  *atomic.Uint64
+
+	sessionOnce sync.Once
+	sessionAEAD cipher.AEAD
+	sessionErr  error
+
+	// the current and the last server sessions, the server starts a new session after it restarts
+	serverLock        sync.Mutex
+	serverSession     *serverSession
+	lastServerSession *serverSession
+}
+
+// serverSession is a UDP session of the server, its packet IDs are recorded to drop the replayed packets
+type serverSession struct {
+	id uint64
+	// aead is nil for chacha20-poly1305
+	aead   cipher.AEAD
+	window slidingWindow
+}
+
+// NewPacketConn wraps a net.PacketConn with cipher
+func NewPacketConn(c net.PacketConn, ciph *Cipher) *PacketConn {
+	sessionID := make([]byte, 8)
+	rand.Read(sessionID)
+	return &PacketConn{
+		PacketConn: c,
+		Cipher:     ciph,
+		sessionID:  binary.BigEndian.Uint64(sessionID),
+		packetID:   atomic.NewUint64(0),
+	}
+}
+
+func (c *PacketConn) clientAEAD() (cipher.AEAD, error) {
+	c.sessionOnce.Do(func() {
+		c.sessionAEAD, c.sessionErr = c.Cipher.sessionAEAD(binary.BigEndian.AppendUint64(nil, c.sessionID))
+	})
+	return c.sessionAEAD, c.sessionErr
+}
+
+// lookupServerSession returns the server session of id, a new one is returned if
+// it's neither the current nor the last one
+func (c *PacketConn) lookupServerSession(id uint64) (*serverSession, error) {
+	c.serverLock.Lock()
+	defer c.serverLock.Unlock()
+
+	for _, s := range []*serverSession{c.serverSession, c.lastServerSession} {
+		if s != nil && s.id == id {
+			return s, nil
+		}
+	}
+
+	s := &serverSession{id: id}
+	if c.udpAEAD == nil {
+		aead, err := c.Cipher.sessionAEAD(binary.BigEndian.AppendUint64(nil, id))
+		if err != nil {
+			return nil, err
+		}
+		s.aead = aead
+	}
+	return s, nil
+}
+
+// acceptPacket records the packet ID of an authenticated packet, the packet is
+// dropped if it was received before, a new session becomes the current one
+func (c *PacketConn) acceptPacket(s *serverSession, packetID uint64) error {
+	c.serverLock.Lock()
+	defer c.serverLock.Unlock()
+
+	isNew := true
+	for _, known := range []*serverSession{c.serverSession, c.lastServerSession} {
+		if known != nil && known.id == s.id {
+			s, isNew = known, false
+			break
+		}
+	}
+
+	if !s.window.Add(packetID) {
+		return ErrReplayedPacket
+	}
+	if isNew {
+		c.lastServerSession, c.serverSession = c.serverSession, s
+	}
+	return nil
+}
+
+// WriteTo encrypts b and write to addr using the embedded PacketConn.
+func (c *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	buf := pool.Get(maxPacketSize)
+	defer pool.Put(buf)
+
+	packet, err := c.seal(buf, b, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	_, err = c.PacketConn.WriteTo(packet, addr)
+	return len(b), err
+}
+
+// seal encrypts the packet of payload b sent at now into buf
+func (c *PacketConn) seal(buf, b []byte, now time.Time) ([]byte, error) {
+	header := make([]byte, 0, separateHeaderSize)
+	header = binary.BigEndian.AppendUint64(header, c.sessionID)
+	header = binary.BigEndian.AppendUint64(header, c.packetID.Inc()-1)
+
+	// the main header without padding
+	plaintext := make([]byte, 0, 1+8+2+len(b))
+	plaintext = append(plaintext, headerTypeClient)
+	plaintext = binary.BigEndian.AppendUint64(plaintext, uint64(now.Unix()))
+	plaintext = binary.BigEndian.AppendUint16(plaintext, 0)
+	plaintext = append(plaintext, b...)
+
+	if c.udpAEAD != nil {
+		return c.sealChacha(buf, header, plaintext)
+	}
+
+	aead, err := c.clientAEAD()
+	if err != nil {
+		return nil, err
+	}
+	eihSize := aes.BlockSize * len(c.pskHashes)
+	if separateHeaderSize+eihSize+len(plaintext)+aead.Overhead() > len(buf) {
+		return nil, errors.New("packet too large")
+	}
+
+	// the nonce is the last 12 bytes of the plain separate header
+	copy(buf, header)
+	packet := aead.Seal(buf[:separateHeaderSize+eihSize], header[4:], plaintext, nil)
+	for i, hash := range c.pskHashes {
+		eih := packet[separateHeaderSize+i*aes.BlockSize : separateHeaderSize+(i+1)*aes.BlockSize]
+		for j := range eih {
+			eih[j] = hash[j] ^ header[j]
+		}
+		block, err := aes.NewCipher(c.psks[i])
+		if err != nil {
+			return nil, err
+		}
+		block.Encrypt(eih, eih)
+	}
+	c.udpBlock.Encrypt(packet[:separateHeaderSize], packet[:separateHeaderSize])
+	return packet, nil
+}
+
+// sealChacha seals the separate header and plaintext with a random nonce into buf
+func (c *PacketConn) sealChacha(buf, header, plaintext []byte) ([]byte, error) {
+	nonceSize := chacha20poly1305.NonceSizeX
+	if nonceSize+len(header)+len(plaintext)+c.udpAEAD.Overhead() > len(buf) {
+		return nil, errors.New("packet too large")
+	}
+
+	message := make([]byte, 0, len(header)+len(plaintext))
+	message = append(append(message, header...), plaintext...)
+
+	nonce := buf[:nonceSize]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.udpAEAD.Seal(nonce, nonce, message, nil), nil
+}
+
+// ReadFrom reads from the embedded PacketConn and decrypts into b.
+func (c *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	buf := pool.Get(maxPacketSize)
+	defer pool.Put(buf)
+
+	n, addr, err := c.PacketConn.ReadFrom(buf)
+	if err != nil {
+		return 0, addr, err
+	}
+
+	payload, err := c.unseal(buf[:n], time.Now())
+	if err != nil {
+		return 0, addr, err
+	}
+	if len(payload) > len(b) {
+		return 0, addr, errors.New("buffer too small")
+	}
+	return copy(b, payload), addr, nil
+}
+
+// unseal decrypts a packet from the server received at now, the payload starts with the socks address
+func (c *PacketConn) unseal(packet []byte, now time.Time) ([]byte, error) {
+	session, packetID, message, err := c.open(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	// type, timestamp, client session ID and padding length
+	if len(message) < 1+8+8+2 {
+		return nil, ErrShortPacket
+	}
+	if message[0] != headerTypeServer {
+		return nil, ErrBadHeaderType
+	}
+	if err := checkTimestamp(binary.BigEndian.Uint64(message[1:9]), now); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint64(message[9:17]) != c.sessionID {
+		return nil, errors.New("bad client session id")
+	}
+	padding := int(binary.BigEndian.Uint16(message[17:19]))
+	if len(message) < 19+padding {
+		return nil, ErrShortPacket
+	}
+
+	if err := c.acceptPacket(session, packetID); err != nil {
+		return nil, err
+	}
+	return message[19+padding:], nil
+}
+
+// open decrypts a packet from the server, the returned message starts with the header type
+func (c *PacketConn) open(packet []byte) (*serverSession, uint64, []byte, error) {
+	if c.udpAEAD != nil {
+		nonceSize := chacha20poly1305.NonceSizeX
+		if len(packet) < nonceSize+separateHeaderSize+c.udpAEAD.Overhead() {
+			return nil, 0, nil, ErrShortPacket
+		}
+		message, err := c.udpAEAD.Open(packet[nonceSize:nonceSize], packet[:nonceSize], packet[nonceSize:], nil)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		if len(message) < separateHeaderSize {
+			return nil, 0, nil, ErrShortPacket
+		}
+		session, err := c.lookupServerSession(binary.BigEndian.Uint64(message[:8]))
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		return session, binary.BigEndian.Uint64(message[8:16]), message[separateHeaderSize:], nil
+	}
+
+	if len(packet) < separateHeaderSize {
+		return nil, 0, nil, ErrShortPacket
+	}
+	header := packet[:separateHeaderSize]
+	c.udpUserBlock.Decrypt(header, header)
+
+	session, err := c.lookupServerSession(binary.BigEndian.Uint64(header[:8]))
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if len(packet) < separateHeaderSize+session.aead.Overhead() {
+		return nil, 0, nil, ErrShortPacket
+	}
+	message, err := session.aead.Open(packet[separateHeaderSize:separateHeaderSize], header[4:], packet[separateHeaderSize:], nil)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	return session, binary.BigEndian.Uint64(header[8:]), message, nil
+}
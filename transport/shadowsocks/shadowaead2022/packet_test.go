@@ -0,0 +1,156 @@
+package shadowaead2022
+
+import (
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/transport/socks5"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the packets of sing-shadowsocks v0.2.7 sent at 1700000000 with the payload
+// "hello" to 1.2.3.4:80, the PSKs are sequential bytes starting at 0x00 and
+// 0x10 for AES, and 0x20 for chacha20-poly1305
+var (
+	vectorTime = time.Unix(1700000000, 0)
+
+	// from the client of session e5553eef0e20db40 with an identity header, and the
+	// response of the multi-user server
+	vectorAESClientSession uint64 = 0xe5553eef0e20db40
+	vectorAESClient               = "e439cfd29575eea67ed55785b3629a6f22c2d60e3816ab4d0524d85ad07dcec7a0a089f232b2bc20e622d1edb4ffd03b80ee7fef6ca66989d4909164d859ce24b9ad0d61ebb96d"
+	vectorAESServer               = "6b69342931a940ecdfa581bced06c0c6540f02e20ebf78cc31d292a16209f4f241d273958b73dc9ebfd7cd3dafa91138f5a62bb6bc64c2163d62845dd8ae6f"
+
+	// the response to the client of session c94f644342870f34
+	vectorChachaClientSession uint64 = 0xc94f644342870f34
+	vectorChachaServer               = "3ed2ceb8fd5f15b285d1e567abe220e6cc05ede44ffcb843d55b7785107137a765710f57026ef4452384a57b290490530d9bee5d8af5797f41a61ff16e21e9030109b20d3c96c910854b58229ecdafb9671e0faad3529a"
+)
+
+func sequentialBytes(start byte, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = start + byte(i)
+	}
+	return b
+}
+
+func decodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+func TestPacketConn_Echo(t *testing.T) {
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, ciph, err := tc.server()
+			require.NoError(t, err)
+			addr := s.startPacket(t)
+
+			pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer pc.Close()
+			conn := NewPacketConn(pc, ciph)
+
+			buf := make([]byte, 1024)
+			for _, payload := range []string{"hello", "world"} {
+				packet := append(testAddr(), payload...)
+				_, err = conn.WriteTo(packet, addr)
+				require.NoError(t, err)
+
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				n, _, err := conn.ReadFrom(buf)
+				require.NoError(t, err)
+				assert.Equal(t, packet, buf[:n])
+			}
+		})
+	}
+}
+
+func TestPacketConn_BadTimestamp(t *testing.T) {
+	s, ciph, err := testCases[0].server()
+	require.NoError(t, err)
+	s.timeOffset = time.Minute
+	addr := s.startPacket(t)
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+	conn := NewPacketConn(pc, ciph)
+
+	_, err = conn.WriteTo(append(testAddr(), "hello"...), addr)
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = conn.ReadFrom(make([]byte, 1024))
+	assert.ErrorIs(t, err, ErrBadTimestamp)
+}
+
+func TestPacketConn_Vectors(t *testing.T) {
+	payload := append([]byte(socks5.ParseAddr("1.2.3.4:80")), "hello"...)
+
+	t.Run("AES", func(t *testing.T) {
+		ciph, err := AESGCM(16, [][]byte{sequentialBytes(0x00, 16), sequentialBytes(0x10, 16)})
+		require.NoError(t, err)
+		conn := NewPacketConn(nil, ciph)
+		conn.sessionID = vectorAESClientSession
+
+		packet, err := conn.seal(make([]byte, maxPacketSize), payload, vectorTime)
+		require.NoError(t, err)
+		assert.Equal(t, vectorAESClient, hex.EncodeToString(packet))
+
+		b, err := conn.unseal(decodeHex(t, vectorAESServer), vectorTime)
+		require.NoError(t, err)
+		assert.Equal(t, payload, b)
+	})
+
+	t.Run("Chacha20Poly1305", func(t *testing.T) {
+		ciph, err := Chacha20Poly1305([][]byte{sequentialBytes(0x20, 32)})
+		require.NoError(t, err)
+		conn := NewPacketConn(nil, ciph)
+		conn.sessionID = vectorChachaClientSession
+
+		b, err := conn.unseal(decodeHex(t, vectorChachaServer), vectorTime)
+		require.NoError(t, err)
+		assert.Equal(t, payload, b)
+	})
+}
+
+func TestPacketConn_Replay(t *testing.T) {
+	ciph, err := Chacha20Poly1305([][]byte{sequentialBytes(0x20, 32)})
+	require.NoError(t, err)
+	conn := NewPacketConn(nil, ciph)
+	conn.sessionID = vectorChachaClientSession
+
+	_, err = conn.unseal(decodeHex(t, vectorChachaServer), vectorTime)
+	require.NoError(t, err)
+	_, err = conn.unseal(decodeHex(t, vectorChachaServer), vectorTime)
+	assert.ErrorIs(t, err, ErrReplayedPacket)
+
+	// a packet failing authentication doesn't take the packet ID
+	conn = NewPacketConn(nil, ciph)
+	conn.sessionID = vectorChachaClientSession
+	_, err = conn.unseal(decodeHex(t, vectorChachaServer), vectorTime.Add(time.Minute))
+	assert.ErrorIs(t, err, ErrBadTimestamp)
+	_, err = conn.unseal(decodeHex(t, vectorChachaServer), vectorTime)
+	assert.NoError(t, err)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	w := &slidingWindow{}
+	assert.True(t, w.Add(0))
+	assert.False(t, w.Add(0))
+	assert.True(t, w.Add(2))
+	assert.True(t, w.Add(1))
+	assert.False(t, w.Add(2))
+
+	// far ahead, the window slides past the old IDs
+	assert.True(t, w.Add(windowSize+100))
+	assert.False(t, w.Add(50))
+	assert.True(t, w.Add(200))
+	assert.False(t, w.Add(200))
+	assert.True(t, w.Add(windowSize+99))
+	assert.False(t, w.Add(windowSize+100))
+}
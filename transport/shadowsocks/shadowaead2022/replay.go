@@ -0,0 +1,40 @@
+package shadowaead2022
+
+const (
+	windowBlockBits = 64
+	windowBlocks    = 128
+	// windowSize is how far behind the latest packet ID a packet is still accepted
+	windowSize = (windowBlocks - 1) * windowBlockBits
+)
+
+// slidingWindow records the packet IDs received recently, the ones too old are
+// dropped as if they were received
+type slidingWindow struct {
+	last uint64
+	ring [windowBlocks]uint64
+}
+
+// Add records id and reports whether it wasn't received before
+func (w *slidingWindow) Add(id uint64) bool {
+	if id < w.last && w.last-id > windowSize {
+		return false
+	}
+
+	block := id / windowBlockBits
+	if id > w.last {
+		// clear the blocks that slide into the window
+		lastBlock := w.last / windowBlockBits
+		for i := lastBlock + 1; i <= block && i-lastBlock <= windowBlocks; i++ {
+			w.ring[i%windowBlocks] = 0
+		}
+		w.last = id
+	}
+
+	bit := uint64(1) << (id % windowBlockBits)
+	index := block % windowBlocks
+	if w.ring[index]&bit != 0 {
+		return false
+	}
+	w.ring[index] |= bit
+	return true
+}
@@ -0,0 +1,280 @@
+package shadowaead2022
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/transport/socks5"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/chacha20poly1305"
+	"lukechampine.com/blake3"
+)
+
+// testServer is a SIP022 server that echoes the payload back, it's written from
+// the spec instead of the client code so that the client is verified against it
+type testServer struct {
+	keySize int
+	chacha  bool
+	// iPSK is the identity PSK of a multi-user server, the user is looked up
+	// by the identity header, otherwise uPSK is the only user
+	iPSK  []byte
+	users map[string][]byte
+	uPSK  []byte
+
+	// timeOffset shifts the timestamps of the responses
+	timeOffset time.Duration
+	// badSalt makes the responses carry a wrong request salt
+	badSalt bool
+}
+
+func newTestServer(keySize int, chacha bool) *testServer {
+	return &testServer{keySize: keySize, chacha: chacha, uPSK: randomBytes(keySize)}
+}
+
+// addUser turns the server into a multi-user server and returns the PSK of the new user
+func (s *testServer) addUser() []byte {
+	if s.iPSK == nil {
+		s.iPSK = randomBytes(s.keySize)
+		s.users = map[string][]byte{}
+	}
+	psk := randomBytes(s.keySize)
+	hash := blake3.Sum256(psk)
+	s.users[string(hash[:16])] = psk
+	return psk
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+func (s *testServer) aead(psk, salt []byte) cipher.AEAD {
+	subkey := make([]byte, s.keySize)
+	blake3.DeriveKey(subkey, "shadowsocks 2022 session subkey", append(append([]byte{}, psk...), salt...))
+	if s.chacha {
+		aead, _ := chacha20poly1305.New(subkey)
+		return aead
+	}
+	block, _ := aes.NewCipher(subkey)
+	aead, _ := cipher.NewGCM(block)
+	return aead
+}
+
+func (s *testServer) timestamp() []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(time.Now().Add(s.timeOffset).Unix()))
+}
+
+func checkTestTimestamp(b []byte) error {
+	diff := time.Since(time.Unix(int64(binary.BigEndian.Uint64(b)), 0))
+	if diff > 30*time.Second || diff < -30*time.Second {
+		return ErrBadTimestamp
+	}
+	return nil
+}
+
+type chunkCodec struct {
+	aead  cipher.AEAD
+	nonce []byte
+}
+
+func (c *chunkCodec) seal(plaintext []byte) []byte {
+	b := c.aead.Seal(nil, c.nonce, plaintext, nil)
+	increment(c.nonce)
+	return b
+}
+
+func (c *chunkCodec) open(r io.Reader, size int) ([]byte, error) {
+	b := make([]byte, size+c.aead.Overhead())
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	b, err := c.aead.Open(b[:0], c.nonce, b, nil)
+	increment(c.nonce)
+	return b, err
+}
+
+// serveConn handles a single TCP session, the destination address is dropped
+// and the payload is written back
+func (s *testServer) serveConn(conn net.Conn) error {
+	defer conn.Close()
+
+	salt := make([]byte, s.keySize)
+	if _, err := io.ReadFull(conn, salt); err != nil {
+		return err
+	}
+
+	uPSK := s.uPSK
+	if s.iPSK != nil {
+		eih := make([]byte, aes.BlockSize)
+		if _, err := io.ReadFull(conn, eih); err != nil {
+			return err
+		}
+		subkey := make([]byte, s.keySize)
+		blake3.DeriveKey(subkey, "shadowsocks 2022 identity subkey", append(append([]byte{}, s.iPSK...), salt...))
+		block, _ := aes.NewCipher(subkey)
+		block.Decrypt(eih, eih)
+		if uPSK = s.users[string(eih)]; uPSK == nil {
+			return errors.New("unknown user")
+		}
+	}
+
+	r := &chunkCodec{aead: s.aead(uPSK, salt), nonce: make([]byte, 12)}
+	fixed, err := r.open(conn, 1+8+2)
+	if err != nil {
+		return err
+	}
+	if fixed[0] != headerTypeClient {
+		return ErrBadHeaderType
+	}
+	if err := checkTestTimestamp(fixed[1:9]); err != nil {
+		return err
+	}
+	variable, err := r.open(conn, int(binary.BigEndian.Uint16(fixed[9:])))
+	if err != nil {
+		return err
+	}
+	addr := socks5.SplitAddr(variable)
+	if addr == nil {
+		return errors.New("bad address")
+	}
+	padding := int(binary.BigEndian.Uint16(variable[len(addr):]))
+	payload := variable[len(addr)+2+padding:]
+	if len(payload) == 0 && padding == 0 {
+		return errors.New("padding is required without payload")
+	}
+
+	responseSalt := randomBytes(s.keySize)
+	requestSalt := salt
+	if s.badSalt {
+		requestSalt = randomBytes(s.keySize)
+	}
+	w := &chunkCodec{aead: s.aead(uPSK, responseSalt), nonce: make([]byte, 12)}
+	header := []byte{headerTypeServer}
+	header = append(header, s.timestamp()...)
+	header = append(header, requestSalt...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	response := append(responseSalt, w.seal(header)...)
+	if len(payload) != 0 {
+		response = append(response, w.seal(payload)...)
+	}
+	if _, err := conn.Write(response); err != nil {
+		return err
+	}
+
+	for {
+		length, err := r.open(conn, 2)
+		if err != nil {
+			return err
+		}
+		payload, err := r.open(conn, int(binary.BigEndian.Uint16(length)))
+		if err != nil {
+			return err
+		}
+		chunk := w.seal(binary.BigEndian.AppendUint16(nil, uint16(len(payload))))
+		if _, err := conn.Write(append(chunk, w.seal(payload)...)); err != nil {
+			return err
+		}
+	}
+}
+
+// startStream returns the client end of a TCP session served by s
+func (s *testServer) startStream(t *testing.T) net.Conn {
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	go s.serveConn(server)
+	return client
+}
+
+// servePacket echoes the packets from the client back
+func (s *testServer) servePacket(pc net.PacketConn) error {
+	buf := make([]byte, 64*1024)
+	serverSessionID := randomBytes(8)
+	for packetID := uint64(0); ; packetID++ {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+
+		var (
+			uPSK    = s.uPSK
+			header  []byte
+			message []byte
+		)
+		if s.chacha {
+			aead, _ := chacha20poly1305.NewX(uPSK)
+			if message, err = aead.Open(nil, buf[:24], buf[24:n], nil); err != nil {
+				continue
+			}
+			header, message = message[:16], message[16:]
+		} else {
+			header = buf[:16]
+			block, _ := aes.NewCipher(s.uPSK)
+			body := buf[16:n]
+			if s.iPSK != nil {
+				block, _ = aes.NewCipher(s.iPSK)
+				block.Decrypt(header, header)
+				eih := body[:aes.BlockSize]
+				block.Decrypt(eih, eih)
+				for i := range eih {
+					eih[i] ^= header[i]
+				}
+				if uPSK = s.users[string(eih)]; uPSK == nil {
+					continue
+				}
+				body = body[aes.BlockSize:]
+			} else {
+				block.Decrypt(header, header)
+			}
+			if message, err = s.aead(uPSK, header[:8]).Open(nil, header[4:], body, nil); err != nil {
+				continue
+			}
+		}
+
+		if message[0] != headerTypeClient || checkTestTimestamp(message[1:9]) != nil {
+			continue
+		}
+		padding := int(binary.BigEndian.Uint16(message[9:11]))
+		payload := message[11+padding:]
+
+		response := binary.BigEndian.AppendUint64(append([]byte{}, serverSessionID...), packetID)
+		response = append(response, headerTypeServer)
+		response = append(response, s.timestamp()...)
+		response = append(response, header[:8]...)
+		response = binary.BigEndian.AppendUint16(response, 0)
+		response = append(response, payload...)
+
+		var packet []byte
+		if s.chacha {
+			aead, _ := chacha20poly1305.NewX(uPSK)
+			nonce := randomBytes(24)
+			packet = aead.Seal(nonce, nonce, response, nil)
+		} else {
+			responseHeader := response[:16]
+			packet = s.aead(uPSK, responseHeader[:8]).Seal(bytes.Clone(responseHeader), responseHeader[4:], response[16:], nil)
+			block, _ := aes.NewCipher(uPSK)
+			block.Encrypt(packet[:16], packet[:16])
+		}
+		if _, err := pc.WriteTo(packet, addr); err != nil {
+			return err
+		}
+	}
+}
+
+// startPacket returns the address of a UDP server served by s
+func (s *testServer) startPacket(t *testing.T) net.Addr {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+	go s.servePacket(pc)
+	return pc.LocalAddr()
+}
@@ -0,0 +1,262 @@
+package shadowaead2022
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	mathRand "math/rand"
+	"net"
+	"time"
+
+	"github.com/Dreamacro/clash/common/pool"
+	"github.com/Dreamacro/clash/transport/socks5"
+)
+
+const (
+	// maxPayloadSize is the maximum size of a chunk that may be received
+	maxPayloadSize = 0xFFFF
+	// writeChunkSize is the maximum size of a chunk that is sent, a smaller one
+	// fits the buffer pool
+	writeChunkSize = 0x3FFF
+)
+
+var ErrZeroChunk = errors.New("zero chunk")
+
+type writer struct {
+	io.Writer
+	cipher.AEAD
+	nonce []byte
+}
+
+func newWriter(w io.Writer, aead cipher.AEAD) *writer {
+	return &writer{Writer: w, AEAD: aead, nonce: make([]byte, aead.NonceSize())}
+}
+
+// seal appends the sealed plaintext to dst
+func (w *writer) seal(dst, plaintext []byte) []byte {
+	dst = w.Seal(dst, w.nonce, plaintext, nil)
+	increment(w.nonce)
+	return dst
+}
+
+// Write encrypts p into length and payload chunks and writes them to the embedded io.Writer.
+func (w *writer) Write(p []byte) (n int, err error) {
+	tag := w.Overhead()
+	buf := pool.Get(2 + tag + writeChunkSize + tag)
+	defer pool.Put(buf)
+
+	for nr := 0; n < len(p) && err == nil; n += nr {
+		nr = min(len(p)-n, writeChunkSize)
+		chunk := w.seal(buf[:0], binary.BigEndian.AppendUint16(nil, uint16(nr)))
+		chunk = w.seal(chunk, p[n:n+nr])
+		_, err = w.Writer.Write(chunk)
+	}
+	return
+}
+
+type reader struct {
+	io.Reader
+	cipher.AEAD
+	nonce []byte
+	buf   []byte
+	off   int
+}
+
+func newReader(r io.Reader, aead cipher.AEAD) *reader {
+	return &reader{Reader: r, AEAD: aead, nonce: make([]byte, aead.NonceSize())}
+}
+
+// open reads a chunk of size bytes of plaintext and decrypts it in place
+func (r *reader) open(size int) ([]byte, error) {
+	if r.buf == nil {
+		r.buf = make([]byte, maxPayloadSize+r.Overhead())
+	}
+
+	p := r.buf[:size+r.Overhead()]
+	if _, err := io.ReadFull(r.Reader, p); err != nil {
+		return nil, err
+	}
+	p, err := r.Open(p[:0], r.nonce, p, nil)
+	increment(r.nonce)
+	return p, err
+}
+
+// readChunk reads the payload chunk of size bytes into the buffer
+func (r *reader) readChunk(size int) error {
+	if size == 0 {
+		return ErrZeroChunk
+	}
+
+	payload, err := r.open(size)
+	if err != nil {
+		return err
+	}
+	r.buf = r.buf[:len(payload)]
+	r.off = 0
+	return nil
+}
+
+// Read reads from the embedded io.Reader, decrypts and writes to p.
+func (r *reader) Read(p []byte) (int, error) {
+	if r.off == len(r.buf) {
+		length, err := r.open(2)
+		if err != nil {
+			return 0, err
+		}
+		if err := r.readChunk(int(binary.BigEndian.Uint16(length))); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.buf[r.off:])
+	r.off += n
+	return n, nil
+}
+
+// Conn is the client side of a Shadowsocks 2022 stream, the first Write should
+// start with the socks address of the destination
+type Conn struct {
+	net.Conn
+	*Cipher
+	requestSalt []byte
+	// requestSent is closed once requestSalt is set, before the request is written
+	requestSent chan struct{}
+	r           *reader
+	w           *writer
+}
+
+// NewConn wraps a stream-oriented net.Conn with cipher.
+func NewConn(c net.Conn, ciph *Cipher) *Conn {
+	return &Conn{Conn: c, Cipher: ciph, requestSent: make(chan struct{})}
+}
+
+// writeRequest sends the request header with the socks address at the start of b,
+// the rest of b is sent as the initial payload
+func (c *Conn) writeRequest(b []byte) (int, error) {
+	addr := socks5.SplitAddr(b)
+	if addr == nil {
+		return 0, errors.New("request should start with a socks address")
+	}
+
+	salt := make([]byte, c.SaltSize())
+	if _, err := rand.Read(salt); err != nil {
+		return 0, err
+	}
+	aead, err := c.sessionAEAD(salt)
+	if err != nil {
+		return 0, err
+	}
+	eih, err := c.identityHeaders(salt)
+	if err != nil {
+		return 0, err
+	}
+
+	// the payload that doesn't fit the variable-length header is sent in chunks
+	payload := b[len(addr):]
+	rest := []byte{}
+	if limit := maxPayloadSize - len(addr) - 2; len(payload) > limit {
+		payload, rest = payload[:limit], payload[limit:]
+	}
+
+	// padding is required if there is no initial payload
+	padding := 0
+	if len(payload) == 0 {
+		padding = 1 + mathRand.Intn(maxPaddingLength)
+	}
+
+	variable := make([]byte, 0, len(addr)+2+padding+len(payload))
+	variable = append(variable, addr...)
+	variable = binary.BigEndian.AppendUint16(variable, uint16(padding))
+	variable = append(variable, make([]byte, padding)...)
+	variable = append(variable, payload...)
+
+	fixed := []byte{headerTypeClient}
+	fixed = binary.BigEndian.AppendUint64(fixed, uint64(time.Now().Unix()))
+	fixed = binary.BigEndian.AppendUint16(fixed, uint16(len(variable)))
+
+	// the response may be read as soon as the request is written
+	c.requestSalt = salt
+	close(c.requestSent)
+
+	w := newWriter(c.Conn, aead)
+	buf := bytes.NewBuffer(make([]byte, 0, len(salt)+len(eih)+len(fixed)+len(variable)+2*aead.Overhead()))
+	buf.Write(salt)
+	buf.Write(eih)
+	buf.Write(w.seal(nil, fixed))
+	buf.Write(w.seal(nil, variable))
+	if _, err := c.Conn.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+
+	c.w = w
+	if len(rest) != 0 {
+		if _, err := c.w.Write(rest); err != nil {
+			return len(b) - len(rest), err
+		}
+	}
+	return len(b), nil
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	if c.w == nil {
+		return c.writeRequest(b)
+	}
+	return c.w.Write(b)
+}
+
+// readResponse reads the response header and the initial payload
+func (c *Conn) readResponse() error {
+	salt := make([]byte, c.SaltSize())
+	if _, err := io.ReadFull(c.Conn, salt); err != nil {
+		return err
+	}
+	aead, err := c.sessionAEAD(salt)
+	if err != nil {
+		return err
+	}
+
+	r := newReader(c.Conn, aead)
+	fixed, err := r.open(1 + 8 + c.SaltSize() + 2)
+	if err != nil {
+		return err
+	}
+	// a response can't arrive before its request is sent
+	select {
+	case <-c.requestSent:
+	default:
+		return errors.New("response before request")
+	}
+
+	if fixed[0] != headerTypeServer {
+		return ErrBadHeaderType
+	}
+	if err := checkTimestamp(binary.BigEndian.Uint64(fixed[1:9]), time.Now()); err != nil {
+		return err
+	}
+	if !bytes.Equal(fixed[9:9+len(c.requestSalt)], c.requestSalt) {
+		return ErrBadSalt
+	}
+
+	// the server may respond before there is any payload
+	if length := int(binary.BigEndian.Uint16(fixed[9+len(c.requestSalt):])); length != 0 {
+		if err := r.readChunk(length); err != nil {
+			return err
+		}
+	} else {
+		r.buf, r.off = r.buf[:0], 0
+	}
+	c.r = r
+	return nil
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if c.r == nil {
+		if err := c.readResponse(); err != nil {
+			return 0, err
+		}
+	}
+	return c.r.Read(b)
+}
@@ -0,0 +1,115 @@
+package shadowaead2022
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/transport/socks5"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCase struct {
+	name   string
+	server func() (*testServer, *Cipher, error)
+}
+
+var testCases = []testCase{
+	{"aes-128-gcm", func() (*testServer, *Cipher, error) {
+		s := newTestServer(16, false)
+		c, err := AESGCM(16, [][]byte{s.uPSK})
+		return s, c, err
+	}},
+	{"aes-256-gcm", func() (*testServer, *Cipher, error) {
+		s := newTestServer(32, false)
+		c, err := AESGCM(32, [][]byte{s.uPSK})
+		return s, c, err
+	}},
+	{"aes-256-gcm-eih", func() (*testServer, *Cipher, error) {
+		s := newTestServer(32, false)
+		s.addUser()
+		psk := s.addUser()
+		c, err := AESGCM(32, [][]byte{s.iPSK, psk})
+		return s, c, err
+	}},
+	{"chacha20-poly1305", func() (*testServer, *Cipher, error) {
+		s := newTestServer(32, true)
+		c, err := Chacha20Poly1305([][]byte{s.uPSK})
+		return s, c, err
+	}},
+}
+
+func testAddr() []byte {
+	return socks5.ParseAddr("example.com:443")
+}
+
+func TestConn_Echo(t *testing.T) {
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, ciph, err := tc.server()
+			require.NoError(t, err)
+			conn := NewConn(s.startStream(t), ciph)
+
+			// the address is written alone, like the ss outbound does
+			go func() {
+				conn.Write(testAddr())
+				conn.Write([]byte("hello"))
+				conn.Write(bytes.Repeat([]byte{'x'}, 100*1024))
+			}()
+
+			buf := make([]byte, 5)
+			_, err = io.ReadFull(conn, buf)
+			require.NoError(t, err)
+			assert.Equal(t, "hello", string(buf))
+
+			buf = make([]byte, 100*1024)
+			_, err = io.ReadFull(conn, buf)
+			require.NoError(t, err)
+			assert.Equal(t, bytes.Repeat([]byte{'x'}, 100*1024), buf)
+		})
+	}
+}
+
+func TestConn_InitialPayload(t *testing.T) {
+	s, ciph, err := testCases[1].server()
+	require.NoError(t, err)
+	conn := NewConn(s.startStream(t), ciph)
+
+	go conn.Write(append(testAddr(), "hello"...))
+
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+}
+
+func TestConn_BadResponse(t *testing.T) {
+	s, ciph, err := testCases[0].server()
+	require.NoError(t, err)
+	s.timeOffset = -time.Minute
+	conn := NewConn(s.startStream(t), ciph)
+	go conn.Write(append(testAddr(), "hello"...))
+	_, err = conn.Read(make([]byte, 5))
+	assert.ErrorIs(t, err, ErrBadTimestamp)
+
+	s.timeOffset = 0
+	s.badSalt = true
+	conn = NewConn(s.startStream(t), ciph)
+	go conn.Write(append(testAddr(), "hello"...))
+	_, err = conn.Read(make([]byte, 5))
+	assert.ErrorIs(t, err, ErrBadSalt)
+}
+
+func TestCipher_KeySize(t *testing.T) {
+	_, err := AESGCM(32, [][]byte{make([]byte, 16)})
+	assert.Equal(t, KeySizeError(32), err)
+
+	_, err = AESGCM(24, [][]byte{make([]byte, 24)})
+	assert.Error(t, err)
+
+	_, err = Chacha20Poly1305([][]byte{make([]byte, 32), make([]byte, 32)})
+	assert.ErrorIs(t, err, ErrEIHNotSupported)
+}
@@ -46,10 +46,12 @@ type Option struct {
 }
 
 type WebsocketOption struct {
-	Host    string
-	Port    string
-	Path    string
-	Headers http.Header
+	Host                string
+	Port                string
+	Path                string
+	Headers             http.Header
+	MaxEarlyData        int
+	EarlyDataHeaderName string
 }
 
 type Trojan struct {
@@ -96,12 +98,14 @@ func (t *Trojan) StreamWebsocketConn(conn net.Conn, wsOptions *WebsocketOption)
 	}
 
 	return vmess.StreamWebsocketConn(conn, &vmess.WebsocketConfig{
-		Host:      wsOptions.Host,
-		Port:      wsOptions.Port,
-		Path:      wsOptions.Path,
-		Headers:   wsOptions.Headers,
-		TLS:       true,
-		TLSConfig: tlsConfig,
+		Host:                wsOptions.Host,
+		Port:                wsOptions.Port,
+		Path:                wsOptions.Path,
+		Headers:             wsOptions.Headers,
+		MaxEarlyData:        wsOptions.MaxEarlyData,
+		EarlyDataHeaderName: wsOptions.EarlyDataHeaderName,
+		TLS:                 true,
+		TLSConfig:           tlsConfig,
 	})
 }
 
@@ -0,0 +1,144 @@
+package wireguard
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+
+	"golang.zx2c4.com/wireguard/conn"
+)
+
+// ListenFn listens on the UDP port of the WireGuard device, zero is a random port
+type ListenFn = func(port uint16) (net.PacketConn, error)
+
+// Bind is a conn.Bind over the net.PacketConn from listen, the reserved bytes of
+// the message headers are set to those of the peer, which some servers identify
+// the clients by
+type Bind struct {
+	listen   ListenFn
+	reserved map[netip.AddrPort][3]byte
+
+	lock sync.Mutex
+	pc   net.PacketConn
+}
+
+// NewBind returns a Bind, reserved is the reserved bytes of the peers by their endpoints
+func NewBind(listen ListenFn, reserved map[netip.AddrPort][3]byte) *Bind {
+	return &Bind{listen: listen, reserved: reserved}
+}
+
+// Open implements conn.Bind
+func (b *Bind) Open(port uint16) ([]conn.ReceiveFunc, uint16, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.pc != nil {
+		return nil, 0, conn.ErrBindAlreadyOpen
+	}
+
+	pc, err := b.listen(port)
+	if err != nil {
+		return nil, 0, err
+	}
+	b.pc = pc
+
+	actualPort := port
+	if addr, ok := pc.LocalAddr().(*net.UDPAddr); ok {
+		actualPort = uint16(addr.Port)
+	}
+	return []conn.ReceiveFunc{b.receive(pc)}, actualPort, nil
+}
+
+func (b *Bind) receive(pc net.PacketConn) conn.ReceiveFunc {
+	return func(packets [][]byte, sizes []int, eps []conn.Endpoint) (int, error) {
+		n, addr, err := pc.ReadFrom(packets[0])
+		if err != nil {
+			return 0, err
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			return 0, nil
+		}
+
+		// the device takes the first 4 bytes as the message type
+		if n > 3 {
+			clear(packets[0][1:4])
+		}
+		sizes[0] = n
+		eps[0] = Endpoint(netip.AddrPortFrom(udpAddr.AddrPort().Addr().Unmap(), udpAddr.AddrPort().Port()))
+		return 1, nil
+	}
+}
+
+// Close implements conn.Bind
+func (b *Bind) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.pc == nil {
+		return nil
+	}
+	err := b.pc.Close()
+	b.pc = nil
+	return err
+}
+
+// SetMark implements conn.Bind, the routing mark is set by listen
+func (b *Bind) SetMark(mark uint32) error {
+	return nil
+}
+
+// Send implements conn.Bind
+func (b *Bind) Send(bufs [][]byte, ep conn.Endpoint) error {
+	b.lock.Lock()
+	pc := b.pc
+	b.lock.Unlock()
+	if pc == nil {
+		return net.ErrClosed
+	}
+
+	addrPort := netip.AddrPort(ep.(Endpoint))
+	reserved, hasReserved := b.reserved[addrPort]
+	addr := net.UDPAddrFromAddrPort(addrPort)
+	for _, buf := range bufs {
+		if hasReserved && len(buf) > 3 {
+			copy(buf[1:4], reserved[:])
+		}
+		if _, err := pc.WriteTo(buf, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseEndpoint implements conn.Bind
+func (b *Bind) ParseEndpoint(s string) (conn.Endpoint, error) {
+	addrPort, err := netip.ParseAddrPort(s)
+	if err != nil {
+		return nil, err
+	}
+	return Endpoint(netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port())), nil
+}
+
+// BatchSize implements conn.Bind
+func (b *Bind) BatchSize() int {
+	return 1
+}
+
+// Endpoint is the address of a peer
+type Endpoint netip.AddrPort
+
+func (e Endpoint) ClearSrc() {}
+
+func (e Endpoint) SrcToString() string { return "" }
+
+func (e Endpoint) DstToString() string { return netip.AddrPort(e).String() }
+
+func (e Endpoint) DstToBytes() []byte {
+	b, _ := netip.AddrPort(e).MarshalBinary()
+	return b
+}
+
+func (e Endpoint) DstIP() netip.Addr { return netip.AddrPort(e).Addr() }
+
+func (e Endpoint) SrcIP() netip.Addr { return netip.Addr{} }
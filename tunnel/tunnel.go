@@ -2,6 +2,7 @@ package tunnel
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/netip"
@@ -10,9 +11,11 @@ import (
 	"time"
 
 	"github.com/Dreamacro/clash/adapter/inbound"
+	N "github.com/Dreamacro/clash/common/net"
 	"github.com/Dreamacro/clash/component/nat"
 	P "github.com/Dreamacro/clash/component/process"
 	"github.com/Dreamacro/clash/component/resolver"
+	"github.com/Dreamacro/clash/component/sniffer"
 	C "github.com/Dreamacro/clash/constant"
 	"github.com/Dreamacro/clash/constant/provider"
 	icontext "github.com/Dreamacro/clash/context"
@@ -39,8 +42,19 @@ var (
 
 	// experimental feature
 	UDPFallbackMatch = atomic.NewBool(false)
+
+	snifferDispatcher = atomic.NewPointer[sniffer.Dispatcher](nil)
+
+	// UDP flows waiting for the rest of a QUIC ClientHello
+	udpSniffing    = map[string]*sniffingFlow{}
+	udpSniffingMux sync.Mutex
 )
 
+type sniffingFlow struct {
+	session *sniffer.UDPSession
+	packets []*inbound.PacketAdapter
+}
+
 func init() {
 	go process()
 }
@@ -85,6 +99,11 @@ func UpdateProxies(newProxies map[string]C.Proxy, newProviders map[string]provid
 	configMux.Unlock()
 }
 
+// UpdateSniffer handle update sniffer, nil disables sniffing
+func UpdateSniffer(dispatcher *sniffer.Dispatcher) {
+	snifferDispatcher.Store(dispatcher)
+}
+
 // Mode return current mode
 func Mode() TunnelMode {
 	return mode
@@ -150,6 +169,90 @@ func preHandleMetadata(metadata *C.Metadata) error {
 	return nil
 }
 
+// sniffTCP fills metadata.Host of connections the enhancer knows nothing about,
+// the returned context relays the peeked bytes
+func sniffTCP(connCtx C.ConnContext, metadata *C.Metadata) C.ConnContext {
+	dispatcher := snifferDispatcher.Load()
+	if dispatcher == nil || metadata.Host != "" || metadata.DstIP == nil {
+		return connCtx
+	}
+	if resolver.IsExistFakeIP(metadata.DstIP) {
+		return connCtx
+	}
+	if _, exist := resolver.FindHostByIP(metadata.DstIP); exist && resolver.MappingEnabled() {
+		return connCtx
+	}
+
+	conn := N.NewBufferedConn(connCtx.Conn())
+	isFakeIP := resolver.IsFakeIP(metadata.DstIP)
+	if dispatcher.SniffTCP(conn, metadata) {
+		// a fake IP whose record is lost can't be dialed
+		if isFakeIP {
+			metadata.DstIP = nil
+		}
+		log.Debugln("[Sniffer] sniffed %s from %s", metadata.Host, metadata.RemoteAddress())
+	}
+
+	return icontext.WithConn(connCtx, conn)
+}
+
+// sniffUDP feeds the first packets of a flow to the sniffer. It returns false if
+// packet is held for the rest of the ClientHello, otherwise the packets held
+// before it are returned to be sent ahead of it.
+func sniffUDP(key string, packet *inbound.PacketAdapter) ([]*inbound.PacketAdapter, bool) {
+	metadata := packet.Metadata()
+
+	udpSniffingMux.Lock()
+	defer udpSniffingMux.Unlock()
+
+	flow, exist := udpSniffing[key]
+	if !exist {
+		dispatcher := snifferDispatcher.Load()
+		if dispatcher == nil || metadata.Host != "" || metadata.DstIP == nil {
+			return nil, true
+		}
+		if resolver.IsExistFakeIP(metadata.DstIP) {
+			return nil, true
+		}
+		if _, exist := resolver.FindHostByIP(metadata.DstIP); exist && resolver.MappingEnabled() {
+			return nil, true
+		}
+
+		session := dispatcher.NewUDPSession(metadata)
+		if session == nil {
+			return nil, true
+		}
+		flow = &sniffingFlow{session: session}
+
+		// route what is held if the client stops sending
+		time.AfterFunc(dispatcher.Timeout(), func() {
+			udpSniffingMux.Lock()
+			if udpSniffing[key] != flow {
+				udpSniffingMux.Unlock()
+				return
+			}
+			delete(udpSniffing, key)
+			udpSniffingMux.Unlock()
+
+			last := len(flow.packets) - 1
+			routeUDPConn(flow.packets[last], flow.packets[:last])
+		})
+	}
+
+	err := flow.session.Sniff(packet.Data(), metadata)
+	if errors.Is(err, sniffer.ErrIncomplete) {
+		flow.packets = append(flow.packets, packet)
+		udpSniffing[key] = flow
+		return nil, false
+	}
+
+	delete(udpSniffing, key)
+	if err == nil {
+		log.Debugln("[Sniffer] sniffed %s from %s", metadata.Host, metadata.RemoteAddress())
+	}
+	return flow.packets, true
+}
+
 func resolveMetadata(ctx C.PlainContext, metadata *C.Metadata) (proxy C.Proxy, rule C.Rule, err error) {
 	if metadata.SpecialProxy != "" {
 		var exist bool
@@ -182,15 +285,42 @@ func handleUDPConn(packet *inbound.PacketAdapter) {
 		return
 	}
 
+	var held []*inbound.PacketAdapter
+	if key := packet.LocalAddr().String(); natTable.Get(key) == nil {
+		var ok bool
+		if held, ok = sniffUDP(key, packet); !ok {
+			return
+		}
+	}
+
+	routeUDPConn(packet, held)
+}
+
+// routeUDPConn sends packet and the packets held by the sniffer before it
+func routeUDPConn(packet *inbound.PacketAdapter, held []*inbound.PacketAdapter) {
+	metadata := packet.Metadata()
+
 	// make a fAddr if request ip is fakeip
 	var fAddr netip.Addr
 	if resolver.IsExistFakeIP(metadata.DstIP) {
 		fAddr, _ = netip.AddrFromSlice(metadata.DstIP)
 		fAddr = fAddr.Unmap()
+	} else if metadata.Host != "" && resolver.IsFakeIP(metadata.DstIP) {
+		// the sniffed host of a fake IP whose record is lost
+		fAddr, _ = netip.AddrFromSlice(metadata.DstIP)
+		fAddr = fAddr.Unmap()
+		metadata.DstIP = nil
+	}
+
+	dropHeld := func() {
+		for _, p := range held {
+			p.Drop()
+		}
 	}
 
 	if err := preHandleMetadata(metadata); err != nil {
 		packet.Drop()
+		dropHeld()
 		log.Debugln("[Metadata PreHandle] error: %s", err)
 		return
 	}
@@ -200,9 +330,11 @@ func handleUDPConn(packet *inbound.PacketAdapter) {
 		ips, err := resolver.LookupIP(context.Background(), metadata.Host)
 		if err != nil {
 			packet.Drop()
+			dropHeld()
 			return
 		} else if len(ips) == 0 {
 			packet.Drop()
+			dropHeld()
 			return
 		}
 		metadata.DstIP = ips[0]
@@ -213,6 +345,9 @@ func handleUDPConn(packet *inbound.PacketAdapter) {
 	handle := func() bool {
 		pc := natTable.Get(key)
 		if pc != nil {
+			for _, p := range held {
+				handleUDPToRemote(p, pc, metadata)
+			}
 			handleUDPToRemote(packet, pc, metadata)
 			return true
 		}
@@ -221,6 +356,7 @@ func handleUDPConn(packet *inbound.PacketAdapter) {
 
 	if handle() {
 		packet.Drop()
+		dropHeld()
 		return
 	}
 
@@ -229,6 +365,7 @@ func handleUDPConn(packet *inbound.PacketAdapter) {
 
 	go func() {
 		defer packet.Drop()
+		defer dropHeld()
 
 		if loaded {
 			cond.L.Lock()
@@ -312,6 +449,8 @@ func handleTCPConn(connCtx C.ConnContext) {
 		return
 	}
 
+	connCtx = sniffTCP(connCtx, metadata)
+
 	if err := preHandleMetadata(metadata); err != nil {
 		log.Debugln("[Metadata PreHandle] error: %s", err)
 		return
@@ -374,6 +513,13 @@ func shouldResolveIP(rule C.Rule, metadata *C.Metadata) bool {
 	return rule.ShouldResolveIP() && metadata.Host != "" && metadata.DstIP == nil
 }
 
+func matchRule(rule C.Rule, metadata *C.Metadata) (string, bool) {
+	if ar, ok := rule.(C.AdapterRule); ok {
+		return ar.MatchAdapter(metadata)
+	}
+	return rule.Adapter(), rule.Match(metadata)
+}
+
 func match(metadata *C.Metadata) (C.Proxy, C.Rule, error) {
 	configMux.RLock()
 	defer configMux.RUnlock()
@@ -405,19 +551,21 @@ func match(metadata *C.Metadata) (C.Proxy, C.Rule, error) {
 			srcIP, ok := netip.AddrFromSlice(metadata.SrcIP)
 			if ok && metadata.OriginDst.IsValid() {
 				srcIP = srcIP.Unmap()
-				path, err := P.FindProcessPath(metadata.NetWork.String(), netip.AddrPortFrom(srcIP, uint16(metadata.SrcPort)), metadata.OriginDst)
+				process, err := P.FindProcess(metadata.NetWork.String(), netip.AddrPortFrom(srcIP, uint16(metadata.SrcPort)), metadata.OriginDst)
 				if err != nil {
 					log.Debugln("[Process] find process %s: %v", metadata.String(), err)
 				} else {
-					log.Debugln("[Process] %s from process %s", metadata.String(), path)
-					metadata.ProcessPath = path
+					log.Debugln("[Process] %s from process %s", metadata.String(), process.Path)
+					metadata.ProcessPath = process.Path
+					metadata.UID = process.UID
 				}
 			}
 		}
 
-		if rule.Match(metadata) {
-			adapter, ok := proxies[rule.Adapter()]
+		if adapterName, matched := matchRule(rule, metadata); matched {
+			adapter, ok := proxies[adapterName]
 			if !ok {
+				log.Debugln("[Matcher] %s %s chose unknown proxy %s, skip match", rule.RuleType().String(), rule.Payload(), adapterName)
 				continue
 			}
 